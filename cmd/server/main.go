@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,6 +17,7 @@ import (
 	"eosrift.com/eosrift/internal/auth"
 	"eosrift.com/eosrift/internal/logging"
 	"eosrift.com/eosrift/internal/server"
+	"golang.org/x/crypto/ssh"
 )
 
 func main() {
@@ -29,6 +31,8 @@ func main() {
 			os.Exit(runReserveCmd(logger, os.Args[2:], os.Stdout, os.Stderr))
 		case "tcp-reserve", "tcp-reservations":
 			os.Exit(runTCPReserveCmd(logger, os.Args[2:], os.Stdout, os.Stderr))
+		case "ssh-key", "ssh-keys":
+			os.Exit(runSSHKeyCmd(logger, os.Args[2:], os.Stdout, os.Stderr))
 		}
 	}
 
@@ -38,6 +42,17 @@ func main() {
 	}
 
 	cfg := server.ConfigFromEnv()
+	if err := server.ValidateHTTPRouting(cfg.HTTPRouting); err != nil {
+		fatal(logger, "config", logging.F("err", err))
+	}
+	if err := server.ValidateAdminDomain(cfg); err != nil {
+		fatal(logger, "config", logging.F("err", err))
+	}
+	edgePolicy, err := server.EdgePolicyFromEnv()
+	if err != nil {
+		fatal(logger, "edge policy", logging.F("err", err))
+	}
+	cfg.EdgePolicy = edgePolicy
 	if cfg.DBPath == "" {
 		cfg.DBPath = getenv("EOSRIFT_DB_PATH", "/data/eosrift.db")
 	}
@@ -57,9 +72,54 @@ func main() {
 		}
 	}
 
+	if cfg.AccessLog == server.AccessLogFile && cfg.AccessLogPath == "" {
+		cfg.AccessLogPath = getenv("EOSRIFT_ACCESS_LOG_PATH", "/data/access.log")
+	}
+	accessLog, accessLogFile, err := server.OpenAccessLog(cfg, os.Stdout)
+	if err != nil {
+		fatal(logger, "access log", logging.F("err", err))
+	}
+	if accessLogFile != nil {
+		defer accessLogFile.Close()
+	}
+
+	tracer, err := server.OpenTracer(cfg)
+	if err != nil {
+		fatal(logger, "tracing", logging.F("err", err))
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = tracer.Shutdown(shutdownCtx)
+	}()
+
+	app := server.New(cfg, server.Dependencies{TokenValidator: store, TokenResolver: store, Reservations: store, AdminStore: store, BrowserWarnings: store, TokenClasses: store, SSHKeys: store, Logger: logger, AccessLog: accessLog, Tracer: tracer})
+
+	if cfg.SSHAddr != "" {
+		if cfg.SSHHostKeyPath == "" {
+			cfg.SSHHostKeyPath = getenv("EOSRIFT_SSH_HOST_KEY_PATH", "/data/ssh_host_ed25519_key")
+		}
+		hostKey, err := server.LoadOrCreateSSHHostKey(cfg.SSHHostKeyPath)
+		if err != nil {
+			fatal(logger, "ssh host key", logging.F("err", err))
+		}
+		sshLn, err := net.Listen("tcp", cfg.SSHAddr)
+		if err != nil {
+			fatal(logger, "ssh listen", logging.F("err", err))
+		}
+
+		logger.Info("ssh gateway listening", logging.F("addr", cfg.SSHAddr), logging.F("fingerprint", ssh.FingerprintSHA256(hostKey.PublicKey())))
+
+		go func() {
+			if err := app.ServeSSH(ctx, sshLn, hostKey); err != nil {
+				logger.Error("ssh gateway error", logging.F("err", err))
+			}
+		}()
+	}
+
 	srv := &http.Server{
 		Addr:              addr,
-		Handler:           server.NewHandler(cfg, server.Dependencies{TokenValidator: store, TokenResolver: store, Reservations: store, AdminStore: store, Logger: logger}),
+		Handler:           app.Handler(),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
@@ -92,6 +152,8 @@ func runTokenCmd(logger logging.Logger, args []string, stdout, stderr io.Writer)
 		return runTokenListCmd(logger, args[1:], stdout, stderr)
 	case "revoke":
 		return runTokenRevokeCmd(logger, args[1:], stdout, stderr)
+	case "class":
+		return runTokenClassCmd(logger, args[1:], stdout, stderr)
 	default:
 		tokenUsage(stderr)
 		return 2
@@ -105,6 +167,7 @@ func tokenUsage(w io.Writer) {
 	fmt.Fprintln(w, "  create   create a new authtoken")
 	fmt.Fprintln(w, "  list     list authtokens")
 	fmt.Fprintln(w, "  revoke   revoke an authtoken by id")
+	fmt.Fprintln(w, "  class    set or clear the class of an authtoken")
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "env:")
 	fmt.Fprintln(w, "  EOSRIFT_DB_PATH  sqlite db path (default: /data/eosrift.db)")
@@ -115,9 +178,13 @@ func runTokenCreateCmd(logger logging.Logger, args []string, stdout, stderr io.W
 	fs.SetOutput(stderr)
 	dbPath := fs.String("db", getenv("EOSRIFT_DB_PATH", "/data/eosrift.db"), "SQLite DB path")
 	label := fs.String("label", "", "Token label")
+	class := fs.String("class", "", "Token class (selects per-class limits such as EOSRIFT_MAX_TUNNEL_LIFETIME_BY_CLASS)")
 	if err := fs.Parse(args); err != nil {
 		return 2
 	}
+	if _, err := auth.NormalizeTokenClass(*class); err != nil {
+		return adminError(logger, stderr, "invalid class", logging.F("err", err))
+	}
 
 	ctx := context.Background()
 	store, err := auth.Open(ctx, *dbPath)
@@ -130,11 +197,19 @@ func runTokenCreateCmd(logger logging.Logger, args []string, stdout, stderr io.W
 	if err != nil {
 		return adminError(logger, stderr, "create token", logging.F("err", err))
 	}
+	if *class != "" {
+		if err := store.SetTokenClass(ctx, rec.ID, *class); err != nil {
+			return adminError(logger, stderr, "set token class", logging.F("err", err))
+		}
+	}
 
 	fmt.Fprintf(stdout, "id: %d\n", rec.ID)
 	if rec.Label != "" {
 		fmt.Fprintf(stdout, "label: %s\n", rec.Label)
 	}
+	if *class != "" {
+		fmt.Fprintf(stdout, "class: %s\n", strings.ToLower(strings.TrimSpace(*class)))
+	}
 	fmt.Fprintf(stdout, "token: %s\n", token)
 	return 0
 }
@@ -173,7 +248,11 @@ func runTokenListCmd(logger logging.Logger, args []string, stdout, stderr io.Wri
 		if label == "" {
 			label = "-"
 		}
-		fmt.Fprintf(stdout, "%d\t%s\t%s\t%s\n", t.ID, t.Prefix, label, status)
+		class := t.Class
+		if class == "" {
+			class = "-"
+		}
+		fmt.Fprintf(stdout, "%d\t%s\t%s\t%s\t%s\n", t.ID, t.Prefix, label, class, status)
 	}
 	return 0
 }
@@ -211,6 +290,47 @@ func runTokenRevokeCmd(logger logging.Logger, args []string, stdout, stderr io.W
 	return 0
 }
 
+func runTokenClassCmd(logger logging.Logger, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("token class", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dbPath := fs.String("db", getenv("EOSRIFT_DB_PATH", "/data/eosrift.db"), "SQLite DB path")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintln(stderr, "usage: eosrift-server token class [--db path] <id> [class]  (omit class to clear it)")
+		return 2
+	}
+
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil || id <= 0 {
+		return adminError(logger, stderr, "invalid id", logging.F("id", fs.Arg(0)))
+	}
+
+	ctx := context.Background()
+	store, err := auth.Open(ctx, *dbPath)
+	if err != nil {
+		return adminError(logger, stderr, "open db", logging.F("err", err))
+	}
+	defer store.Close()
+
+	if err := store.SetTokenClass(ctx, id, fs.Arg(1)); err != nil {
+		return adminError(logger, stderr, "set token class", logging.F("err", err))
+	}
+
+	class, err := store.TokenClass(ctx, id)
+	if err != nil {
+		return adminError(logger, stderr, "get token class", logging.F("err", err))
+	}
+	if class == "" {
+		fmt.Fprintf(stdout, "cleared class of %d\n", id)
+	} else {
+		fmt.Fprintf(stdout, "%d: %s\n", id, class)
+	}
+	return 0
+}
+
 func getenv(key, fallback string) string {
 	v := strings.TrimSpace(os.Getenv(key))
 	if v == "" {
@@ -471,6 +591,133 @@ func runTCPReserveRemoveCmd(logger logging.Logger, args []string, stdout, stderr
 	return 0
 }
 
+func runSSHKeyCmd(logger logging.Logger, args []string, stdout, stderr io.Writer) int {
+	if len(args) < 1 {
+		sshKeyUsage(stderr)
+		return 2
+	}
+
+	switch args[0] {
+	case "add":
+		return runSSHKeyAddCmd(logger, args[1:], stdout, stderr)
+	case "list":
+		return runSSHKeyListCmd(logger, args[1:], stdout, stderr)
+	case "remove", "rm", "delete":
+		return runSSHKeyRemoveCmd(logger, args[1:], stdout, stderr)
+	default:
+		sshKeyUsage(stderr)
+		return 2
+	}
+}
+
+func sshKeyUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: eosrift-server ssh-key <command> [args]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "commands:")
+	fmt.Fprintln(w, "  add      authorize an SSH public key for a token id")
+	fmt.Fprintln(w, "  list     list authorized SSH keys")
+	fmt.Fprintln(w, "  remove   remove an SSH key by fingerprint")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "env:")
+	fmt.Fprintln(w, "  EOSRIFT_DB_PATH  sqlite db path (default: /data/eosrift.db)")
+}
+
+func runSSHKeyAddCmd(logger logging.Logger, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ssh-key add", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dbPath := fs.String("db", getenv("EOSRIFT_DB_PATH", "/data/eosrift.db"), "SQLite DB path")
+	tokenID := fs.Int64("token-id", 0, "Token id to bind the key to")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *tokenID <= 0 || fs.NArg() < 1 {
+		fmt.Fprintln(stderr, "usage: eosrift-server ssh-key add --token-id <id> [--db path] <public-key-file | authorized_keys line>")
+		return 2
+	}
+
+	keyLine := strings.Join(fs.Args(), " ")
+	if fs.NArg() == 1 {
+		if b, err := os.ReadFile(fs.Arg(0)); err == nil {
+			keyLine = string(b)
+		}
+	}
+
+	ctx := context.Background()
+	store, err := auth.Open(ctx, *dbPath)
+	if err != nil {
+		return adminError(logger, stderr, "open db", logging.F("err", err))
+	}
+	defer store.Close()
+
+	key, err := store.AddSSHKey(ctx, *tokenID, keyLine)
+	if err != nil {
+		return adminError(logger, stderr, "add ssh key", logging.F("err", err))
+	}
+
+	fmt.Fprintf(stdout, "added %s\n", key.Fingerprint)
+	return 0
+}
+
+func runSSHKeyListCmd(logger logging.Logger, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ssh-key list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dbPath := fs.String("db", getenv("EOSRIFT_DB_PATH", "/data/eosrift.db"), "SQLite DB path")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx := context.Background()
+	store, err := auth.Open(ctx, *dbPath)
+	if err != nil {
+		return adminError(logger, stderr, "open db", logging.F("err", err))
+	}
+	defer store.Close()
+
+	list, err := store.ListSSHKeys(ctx)
+	if err != nil {
+		return adminError(logger, stderr, "list ssh keys", logging.F("err", err))
+	}
+
+	if len(list) == 0 {
+		fmt.Fprintln(stdout, "no ssh keys")
+		return 0
+	}
+
+	for _, k := range list {
+		fmt.Fprintf(stdout, "%s\t%d\t%s\t%s\n", k.Fingerprint, k.TokenID, k.TokenPrefix, k.Comment)
+	}
+	return 0
+}
+
+func runSSHKeyRemoveCmd(logger logging.Logger, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ssh-key remove", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dbPath := fs.String("db", getenv("EOSRIFT_DB_PATH", "/data/eosrift.db"), "SQLite DB path")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: eosrift-server ssh-key remove [--db path] <fingerprint>")
+		return 2
+	}
+
+	ctx := context.Background()
+	store, err := auth.Open(ctx, *dbPath)
+	if err != nil {
+		return adminError(logger, stderr, "open db", logging.F("err", err))
+	}
+	defer store.Close()
+
+	if err := store.RemoveSSHKey(ctx, fs.Arg(0)); err != nil {
+		return adminError(logger, stderr, "remove ssh key", logging.F("err", err))
+	}
+
+	fmt.Fprintf(stdout, "removed %s\n", fs.Arg(0))
+	return 0
+}
+
 func newLogger() logging.Logger {
 	level, _ := logging.ParseLevel(os.Getenv("EOSRIFT_LOG_LEVEL"))
 	format, _ := logging.ParseFormat(os.Getenv("EOSRIFT_LOG_FORMAT"))
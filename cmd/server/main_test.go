@@ -3,12 +3,16 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 
 	"eosrift.com/eosrift/internal/auth"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestRunTokenCmd_NoArgs_ShowsUsage(t *testing.T) {
@@ -204,6 +208,73 @@ func TestRunTCPReserveCmd_AddListRemove(t *testing.T) {
 	}
 }
 
+func TestRunSSHKeyCmd_AddListRemove(t *testing.T) {
+	t.Parallel()
+
+	dbPath := filepath.Join(t.TempDir(), "eosrift.db")
+
+	var createOut bytes.Buffer
+	if code := runTokenCmd(nil, []string{"create", "--db", dbPath}, &createOut, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("create token failed")
+	}
+	tokenID, _ := parseTokenCreateOutput(t, createOut.String())
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("signer: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519.pub")
+	if err := os.WriteFile(keyPath, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	var stdout, stderr bytes.Buffer
+	code := runSSHKeyCmd(nil, []string{"add", "--db", dbPath, "--token-id", strconv.FormatInt(tokenID, 10), keyPath}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("ssh-key add code = %d, want 0 (stderr=%q)", code, stderr.String())
+	}
+	if got, want := strings.TrimSpace(stdout.String()), "added "+fingerprint; got != want {
+		t.Fatalf("add output = %q, want %q", got, want)
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	code = runSSHKeyCmd(nil, []string{"list", "--db", dbPath}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("ssh-key list code = %d, want 0 (stderr=%q)", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), fingerprint+"\t") {
+		t.Fatalf("list output missing fingerprint: %q", stdout.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	code = runSSHKeyCmd(nil, []string{"remove", "--db", dbPath, fingerprint}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("ssh-key remove code = %d, want 0 (stderr=%q)", code, stderr.String())
+	}
+
+	ctx := context.Background()
+	store, err := auth.Open(ctx, dbPath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	_, ok, err := store.SSHKeyTokenID(ctx, fingerprint)
+	if err != nil {
+		t.Fatalf("SSHKeyTokenID: %v", err)
+	}
+	if ok {
+		t.Fatalf("ssh key still exists, want removed")
+	}
+}
+
 func parseTokenCreateOutput(t *testing.T, out string) (int64, string) {
 	t.Helper()
 
@@ -24,6 +24,7 @@ export default defineConfig({
           { text: "Client CLI", link: "/client-cli" },
           { text: "Configuration", link: "/configuration" },
           { text: "Named Tunnels", link: "/named-tunnels" },
+          { text: "Traffic Policy", link: "/traffic-policy" },
           { text: "Inspector", link: "/inspector" }
         ]
       },
@@ -33,6 +34,9 @@ export default defineConfig({
           { text: "eosrift http", link: "/command-http" },
           { text: "eosrift tcp", link: "/command-tcp" },
           { text: "eosrift tls", link: "/command-tls" },
+          { text: "eosrift visit", link: "/command-visit" },
+          { text: "eosrift connect", link: "/command-connect" },
+          { text: "eosrift share", link: "/command-share" },
           { text: "eosrift start", link: "/command-start" },
           { text: "eosrift config", link: "/command-config" }
         ]
@@ -41,6 +45,8 @@ export default defineConfig({
         text: "Operations",
         items: [
           { text: "Server Admin", link: "/server-admin" },
+          { text: "Edge Policy", link: "/edge-policy" },
+          { text: "SSH Gateway", link: "/ssh-gateway" },
           { text: "Same-IP with nginx", link: "/same-ip-nginx" }
         ]
       }
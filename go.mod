@@ -5,8 +5,11 @@ go 1.23.0
 toolchain go1.23.12
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/hashicorp/yamux v0.1.2
+	github.com/klauspost/compress v1.18.0
 	github.com/mattn/go-isatty v0.0.20
+	golang.org/x/crypto v0.40.0
 	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.38.2
 	nhooyr.io/websocket v1.8.17
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// BrowserWarningExemption is a token or reserved subdomain whose tunnels
+// skip the browser warning interstitial. Exactly one of TokenID or
+// Subdomain is set.
+type BrowserWarningExemption struct {
+	TokenID int64
+	// TokenPrefix is a short display-safe token prefix (token exemptions).
+	TokenPrefix string
+
+	Subdomain string
+
+	CreatedAt time.Time
+}
+
+// SetBrowserWarningTokenExempt adds or removes a token exemption.
+func (s *Store) SetBrowserWarningTokenExempt(ctx context.Context, tokenID int64, exempt bool) error {
+	if s == nil || s.db == nil {
+		return errors.New("nil store")
+	}
+	if tokenID <= 0 {
+		return errors.New("invalid token id")
+	}
+
+	if !exempt {
+		_, err := s.db.ExecContext(ctx, `
+			DELETE FROM browser_warning_exempt_tokens
+			WHERE token_id = ?
+		`, tokenID)
+		return err
+	}
+
+	var one int
+	err := s.db.QueryRowContext(ctx, `SELECT 1 FROM authtokens WHERE id = ?`, tokenID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("unknown token id")
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO browser_warning_exempt_tokens (token_id, created_at)
+		VALUES (?, ?)
+	`, tokenID, time.Now().UTC().Unix())
+	return err
+}
+
+// SetBrowserWarningSubdomainExempt adds or removes an exemption for a
+// reserved subdomain. Exemptions go away when the reservation does.
+func (s *Store) SetBrowserWarningSubdomainExempt(ctx context.Context, subdomain string, exempt bool) error {
+	if s == nil || s.db == nil {
+		return errors.New("nil store")
+	}
+
+	norm, err := normalizeSubdomain(subdomain)
+	if err != nil {
+		return err
+	}
+
+	if !exempt {
+		_, err := s.db.ExecContext(ctx, `
+			DELETE FROM browser_warning_exempt_subdomains
+			WHERE subdomain = ?
+		`, norm)
+		return err
+	}
+
+	if _, reserved, err := s.ReservedSubdomainTokenID(ctx, norm); err != nil {
+		return err
+	} else if !reserved {
+		return errors.New("subdomain is not reserved")
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO browser_warning_exempt_subdomains (subdomain, created_at)
+		VALUES (?, ?)
+	`, norm, time.Now().UTC().Unix())
+	return err
+}
+
+func (s *Store) ListBrowserWarningExemptions(ctx context.Context) ([]BrowserWarningExemption, error) {
+	if s == nil || s.db == nil {
+		return nil, errors.New("nil store")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.token_id, t.token_prefix, '', e.created_at
+		FROM browser_warning_exempt_tokens e
+		JOIN authtokens t ON t.id = e.token_id
+		UNION ALL
+		SELECT 0, '', subdomain, created_at
+		FROM browser_warning_exempt_subdomains
+		ORDER BY 3 ASC, 1 ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []BrowserWarningExemption
+	for rows.Next() {
+		var (
+			rec       BrowserWarningExemption
+			createdAt int64
+		)
+		if err := rows.Scan(&rec.TokenID, &rec.TokenPrefix, &rec.Subdomain, &createdAt); err != nil {
+			return nil, err
+		}
+		rec.CreatedAt = time.Unix(createdAt, 0).UTC()
+		out = append(out, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// BrowserWarningExempt reports whether tunnels of tokenID, or the tunnel
+// at subdomain, skip the browser warning.
+func (s *Store) BrowserWarningExempt(ctx context.Context, tokenID int64, subdomain string) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, errors.New("nil store")
+	}
+
+	norm, err := normalizeSubdomain(subdomain)
+	if err != nil {
+		norm = ""
+	}
+
+	var one int
+	err = s.db.QueryRowContext(ctx, `
+		SELECT 1 FROM browser_warning_exempt_tokens WHERE token_id = ?
+		UNION ALL
+		SELECT 1 FROM browser_warning_exempt_subdomains WHERE subdomain = ?
+		LIMIT 1
+	`, tokenID, norm).Scan(&one)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_BrowserWarningExemptions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, err := Open(ctx, filepath.Join(t.TempDir(), "eosrift.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	owner, _, err := s.CreateToken(ctx, "owner")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	other, _, err := s.CreateToken(ctx, "other")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if err := s.ReserveSubdomain(ctx, other.ID, "docs"); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+
+	exempt := func(tokenID int64, sub string) bool {
+		t.Helper()
+		ok, err := s.BrowserWarningExempt(ctx, tokenID, sub)
+		if err != nil {
+			t.Fatalf("exempt: %v", err)
+		}
+		return ok
+	}
+
+	if exempt(owner.ID, "abc") || exempt(other.ID, "docs") {
+		t.Fatalf("exempt before any exemption")
+	}
+
+	if err := s.SetBrowserWarningTokenExempt(ctx, owner.ID, true); err != nil {
+		t.Fatalf("exempt token: %v", err)
+	}
+	if err := s.SetBrowserWarningTokenExempt(ctx, owner.ID, true); err != nil {
+		t.Fatalf("exempt token twice: %v", err)
+	}
+	if err := s.SetBrowserWarningSubdomainExempt(ctx, "Docs", true); err != nil {
+		t.Fatalf("exempt subdomain: %v", err)
+	}
+	if err := s.SetBrowserWarningSubdomainExempt(ctx, "free", true); err == nil {
+		t.Fatalf("expected error for unreserved subdomain")
+	}
+	if err := s.SetBrowserWarningTokenExempt(ctx, 9999, true); err == nil {
+		t.Fatalf("expected error for unknown token")
+	}
+
+	if !exempt(owner.ID, "abc") || !exempt(other.ID, "docs") || exempt(other.ID, "abc") {
+		t.Fatalf("unexpected exemption state")
+	}
+
+	list, err := s.ListBrowserWarningExemptions(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list) != 2 || list[0].TokenID != owner.ID || list[0].TokenPrefix == "" || list[1].Subdomain != "docs" {
+		t.Fatalf("list = %+v", list)
+	}
+
+	if err := s.UnreserveSubdomain(ctx, "docs"); err != nil {
+		t.Fatalf("unreserve: %v", err)
+	}
+	if err := s.SetBrowserWarningTokenExempt(ctx, owner.ID, false); err != nil {
+		t.Fatalf("unexempt token: %v", err)
+	}
+	if exempt(owner.ID, "abc") || exempt(other.ID, "docs") {
+		t.Fatalf("exemptions survived removal")
+	}
+}
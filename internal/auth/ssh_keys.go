@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type SSHKey struct {
+	Fingerprint string
+	TokenID     int64
+	// TokenPrefix is a short display-safe token prefix.
+	TokenPrefix string
+
+	// PublicKey is the key in authorized_keys format (without comment).
+	PublicKey string
+	Comment   string
+
+	CreatedAt time.Time
+}
+
+// AddSSHKey registers an authorized_keys-style public key for a token so the
+// SSH gateway can authenticate it without a password.
+func (s *Store) AddSSHKey(ctx context.Context, tokenID int64, authorizedKey string) (SSHKey, error) {
+	var rec SSHKey
+
+	if s == nil || s.db == nil {
+		return rec, errors.New("nil store")
+	}
+	if tokenID <= 0 {
+		return rec, errors.New("invalid token id")
+	}
+
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(authorizedKey)))
+	if err != nil {
+		return rec, errors.New("invalid public key")
+	}
+
+	rec = SSHKey{
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		TokenID:     tokenID,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Comment:     strings.TrimSpace(comment),
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO ssh_keys (fingerprint, token_id, public_key, comment, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, rec.Fingerprint, rec.TokenID, rec.PublicKey, rec.Comment, rec.CreatedAt.Unix())
+	if err != nil {
+		return SSHKey{}, err
+	}
+
+	return rec, nil
+}
+
+func (s *Store) RemoveSSHKey(ctx context.Context, fingerprint string) error {
+	if s == nil || s.db == nil {
+		return errors.New("nil store")
+	}
+
+	fingerprint = strings.TrimSpace(fingerprint)
+	if fingerprint == "" {
+		return errors.New("invalid fingerprint")
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM ssh_keys
+		WHERE fingerprint = ?
+	`, fingerprint)
+	return err
+}
+
+func (s *Store) ListSSHKeys(ctx context.Context) ([]SSHKey, error) {
+	if s == nil || s.db == nil {
+		return nil, errors.New("nil store")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT k.fingerprint, k.token_id, t.token_prefix, k.public_key, k.comment, k.created_at
+		FROM ssh_keys k
+		JOIN authtokens t ON t.id = k.token_id
+		ORDER BY k.created_at ASC, k.fingerprint ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SSHKey
+	for rows.Next() {
+		var (
+			rec       SSHKey
+			createdAt int64
+		)
+		if err := rows.Scan(&rec.Fingerprint, &rec.TokenID, &rec.TokenPrefix, &rec.PublicKey, &rec.Comment, &createdAt); err != nil {
+			return nil, err
+		}
+		rec.CreatedAt = time.Unix(createdAt, 0).UTC()
+		out = append(out, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// SSHKeyTokenID returns the (non-revoked) token id a public key fingerprint is
+// registered to.
+func (s *Store) SSHKeyTokenID(ctx context.Context, fingerprint string) (int64, bool, error) {
+	if s == nil || s.db == nil {
+		return 0, false, errors.New("nil store")
+	}
+
+	fingerprint = strings.TrimSpace(fingerprint)
+	if fingerprint == "" {
+		return 0, false, nil
+	}
+
+	var tokenID int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT k.token_id
+		FROM ssh_keys k
+		JOIN authtokens t ON t.id = k.token_id
+		WHERE k.fingerprint = ? AND t.revoked_at IS NULL
+		LIMIT 1
+	`, fingerprint).Scan(&tokenID)
+	if err == nil {
+		return tokenID, true, nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return 0, false, err
+}
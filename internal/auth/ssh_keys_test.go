@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testAuthorizedKey(t *testing.T) (string, string) {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("ssh public key: %v", err)
+	}
+	line := string(ssh.MarshalAuthorizedKey(sshPub))
+	return line[:len(line)-1] + " laptop", ssh.FingerprintSHA256(sshPub)
+}
+
+func TestStore_SSHKey_Lifecycle(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := Open(ctx, filepath.Join(dir, "eosrift.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	rec, _, err := s.CreateToken(ctx, "owner")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	line, fp := testAuthorizedKey(t)
+
+	key, err := s.AddSSHKey(ctx, rec.ID, line)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if key.Fingerprint != fp {
+		t.Fatalf("fingerprint = %q, want %q", key.Fingerprint, fp)
+	}
+	if key.Comment != "laptop" {
+		t.Fatalf("comment = %q, want %q", key.Comment, "laptop")
+	}
+
+	if _, err := s.AddSSHKey(ctx, rec.ID, line); err == nil {
+		t.Fatalf("expected duplicate key error")
+	}
+
+	list, err := s.ListSSHKeys(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list) != 1 || list[0].Fingerprint != fp || list[0].TokenID != rec.ID {
+		t.Fatalf("list = %+v, want one key for token %d", list, rec.ID)
+	}
+
+	gotID, ok, err := s.SSHKeyTokenID(ctx, fp)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if !ok || gotID != rec.ID {
+		t.Fatalf("lookup = (%d, %v), want (%d, true)", gotID, ok, rec.ID)
+	}
+
+	if err := s.RemoveSSHKey(ctx, fp); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	_, ok, err = s.SSHKeyTokenID(ctx, fp)
+	if err != nil {
+		t.Fatalf("lookup after delete: %v", err)
+	}
+	if ok {
+		t.Fatalf("lookup ok = true after delete, want false")
+	}
+}
+
+func TestStore_SSHKey_RevokedTokenRejected(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := Open(ctx, filepath.Join(dir, "eosrift.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	rec, _, err := s.CreateToken(ctx, "owner")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	line, fp := testAuthorizedKey(t)
+	if _, err := s.AddSSHKey(ctx, rec.ID, line); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	if err := s.RevokeToken(ctx, rec.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	_, ok, err := s.SSHKeyTokenID(ctx, fp)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if ok {
+		t.Fatalf("lookup ok = true for revoked token, want false")
+	}
+}
+
+func TestStore_AddSSHKey_InvalidKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	s, err := Open(ctx, ":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	rec, _, err := s.CreateToken(ctx, "owner")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	if _, err := s.AddSSHKey(ctx, rec.ID, "not a key"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -20,6 +20,9 @@ type Token struct {
 	Label  string
 	Prefix string
 
+	// Class is the token's class (see SetTokenClass); empty when unset.
+	Class string
+
 	CreatedAt time.Time
 	RevokedAt *time.Time
 }
@@ -128,9 +131,10 @@ func (s *Store) ListTokens(ctx context.Context) ([]Token, error) {
 	}
 
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, label, token_prefix, created_at, revoked_at
-		FROM authtokens
-		ORDER BY id ASC
+		SELECT t.id, t.label, t.token_prefix, COALESCE(c.class, ''), t.created_at, t.revoked_at
+		FROM authtokens t
+		LEFT JOIN token_classes c ON c.token_id = t.id
+		ORDER BY t.id ASC
 	`)
 	if err != nil {
 		return nil, err
@@ -145,7 +149,7 @@ func (s *Store) ListTokens(ctx context.Context) ([]Token, error) {
 			revokedAt sql.NullInt64
 		)
 
-		if err := rows.Scan(&rec.ID, &rec.Label, &rec.Prefix, &createdAt, &revokedAt); err != nil {
+		if err := rows.Scan(&rec.ID, &rec.Label, &rec.Prefix, &rec.Class, &createdAt, &revokedAt); err != nil {
 			return nil, err
 		}
 
@@ -299,6 +303,56 @@ func (s *Store) ensureSchema(ctx context.Context) error {
 		return err
 	}
 
+	if _, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS ssh_keys (
+			fingerprint TEXT PRIMARY KEY,
+			token_id INTEGER NOT NULL,
+			public_key TEXT NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			FOREIGN KEY(token_id) REFERENCES authtokens(id) ON DELETE CASCADE
+		);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS ssh_keys_token_id ON ssh_keys(token_id);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS browser_warning_exempt_tokens (
+			token_id INTEGER PRIMARY KEY,
+			created_at INTEGER NOT NULL,
+			FOREIGN KEY(token_id) REFERENCES authtokens(id) ON DELETE CASCADE
+		);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS browser_warning_exempt_subdomains (
+			subdomain TEXT PRIMARY KEY,
+			created_at INTEGER NOT NULL,
+			FOREIGN KEY(subdomain) REFERENCES reserved_subdomains(subdomain) ON DELETE CASCADE
+		);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS token_classes (
+			token_id INTEGER PRIMARY KEY,
+			class TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			FOREIGN KEY(token_id) REFERENCES authtokens(id) ON DELETE CASCADE
+		);
+	`); err != nil {
+		return err
+	}
+
 	return nil
 }
 
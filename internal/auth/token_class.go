@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// NormalizeTokenClass lowercases a token class name and checks it is 1-32
+// characters of a-z, 0-9, '-' or '_'. The empty string means no class.
+func NormalizeTokenClass(class string) (string, error) {
+	class = strings.ToLower(strings.TrimSpace(class))
+	if len(class) > 32 {
+		return "", errors.New("token class too long")
+	}
+	for i := 0; i < len(class); i++ {
+		c := class[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return "", errors.New("invalid token class")
+		}
+	}
+	return class, nil
+}
+
+// SetTokenClass assigns a class to a token; an empty class removes it.
+// Operators use classes to set per-class limits such as the maximum tunnel
+// lifetime.
+func (s *Store) SetTokenClass(ctx context.Context, tokenID int64, class string) error {
+	if s == nil || s.db == nil {
+		return errors.New("nil store")
+	}
+	if tokenID <= 0 {
+		return errors.New("invalid token id")
+	}
+
+	norm, err := NormalizeTokenClass(class)
+	if err != nil {
+		return err
+	}
+
+	if norm == "" {
+		_, err := s.db.ExecContext(ctx, `
+			DELETE FROM token_classes
+			WHERE token_id = ?
+		`, tokenID)
+		return err
+	}
+
+	var one int
+	err = s.db.QueryRowContext(ctx, `SELECT 1 FROM authtokens WHERE id = ?`, tokenID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("unknown token id")
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO token_classes (token_id, class, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(token_id) DO UPDATE SET class = excluded.class
+	`, tokenID, norm, time.Now().UTC().Unix())
+	return err
+}
+
+// TokenClass returns the class of a token, or "" when it has none.
+func (s *Store) TokenClass(ctx context.Context, tokenID int64) (string, error) {
+	if s == nil || s.db == nil {
+		return "", errors.New("nil store")
+	}
+
+	var class string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT class
+		FROM token_classes
+		WHERE token_id = ?
+	`, tokenID).Scan(&class)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return class, err
+}
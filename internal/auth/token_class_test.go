@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_TokenClass(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s, err := Open(ctx, filepath.Join(t.TempDir(), "eosrift.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	tok, _, err := s.CreateToken(ctx, "demo")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	class := func() string {
+		t.Helper()
+		c, err := s.TokenClass(ctx, tok.ID)
+		if err != nil {
+			t.Fatalf("class: %v", err)
+		}
+		return c
+	}
+
+	if c := class(); c != "" {
+		t.Fatalf("class = %q, want none", c)
+	}
+	if err := s.SetTokenClass(ctx, tok.ID, " Demo "); err != nil {
+		t.Fatalf("set class: %v", err)
+	}
+	if err := s.SetTokenClass(ctx, tok.ID, "partner_1"); err != nil {
+		t.Fatalf("replace class: %v", err)
+	}
+	if c := class(); c != "partner_1" {
+		t.Fatalf("class = %q, want partner_1", c)
+	}
+
+	tokens, err := s.ListTokens(ctx)
+	if err != nil || len(tokens) != 1 || tokens[0].Class != "partner_1" {
+		t.Fatalf("list = %+v, %v", tokens, err)
+	}
+
+	if err := s.SetTokenClass(ctx, tok.ID, ""); err != nil {
+		t.Fatalf("clear class: %v", err)
+	}
+	if c := class(); c != "" {
+		t.Fatalf("class = %q after clear", c)
+	}
+
+	if err := s.SetTokenClass(ctx, tok.ID+1, "demo"); err == nil {
+		t.Fatalf("expected error for unknown token")
+	}
+	if err := s.SetTokenClass(ctx, tok.ID, "no spaces"); err == nil {
+		t.Fatalf("expected error for invalid class")
+	}
+}
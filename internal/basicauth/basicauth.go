@@ -0,0 +1,329 @@
+// Package basicauth handles hashed HTTP basic auth credentials for tunnels:
+// parsing "user:pass" and htpasswd entries, hashing on the client and
+// verifying (with per-IP failure throttling) at the edge.
+package basicauth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"eosrift.com/eosrift/internal/control"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// MaxUsers bounds the credentials one tunnel may carry.
+	MaxUsers = 256
+
+	maxUserBytes = 256
+	maxHashBytes = 512
+
+	// maxBcryptCost bounds the work one login attempt can cost the edge;
+	// htpasswd -B defaults to 5 and bcrypt.DefaultCost is 10.
+	maxBcryptCost = 14
+
+	maxHtpasswdBytes = 256 * 1024
+
+	// verifiedCacheSize bounds remembered successful logins per tunnel.
+	// Browsers resend credentials on every request and bcrypt is slow by
+	// design, so successes are cached by a digest of user and password.
+	verifiedCacheSize = 1024
+)
+
+// IsHash reports whether s looks like a supported password hash (bcrypt
+// "$2a$"/"$2b$"/"$2y$" or argon2id in PHC form).
+func IsHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$") ||
+		strings.HasPrefix(s, "$argon2id$")
+}
+
+// looksLikeHash catches other htpasswd formats ("$apr1$...", "{SHA}...") so
+// they are reported as unsupported rather than used as a literal password.
+func looksLikeHash(s string) bool {
+	if strings.HasPrefix(s, "{SHA}") {
+		return true
+	}
+	id, _, ok := strings.Cut(strings.TrimPrefix(s, "$"), "$")
+	if !strings.HasPrefix(s, "$") || !ok || id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash returns a bcrypt hash of password.
+func Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ParseCredential parses "user:password" or "user:<hash>". Plaintext
+// passwords are hashed so only the hash leaves the client.
+func ParseCredential(raw string) (control.BasicAuthUser, error) {
+	user, secret, ok := strings.Cut(strings.TrimSpace(raw), ":")
+	if !ok || user == "" || secret == "" {
+		return control.BasicAuthUser{}, errors.New("basic auth must be in the form user:pass or user:hash")
+	}
+	if IsHash(secret) || looksLikeHash(secret) {
+		return ValidateUser(control.BasicAuthUser{User: user, Hash: secret})
+	}
+	if err := validateUserName(user); err != nil {
+		return control.BasicAuthUser{}, err
+	}
+	hash, err := Hash(secret)
+	if err != nil {
+		return control.BasicAuthUser{}, err
+	}
+	return control.BasicAuthUser{User: user, Hash: hash}, nil
+}
+
+// ValidateUser checks a user name and that Hash is a supported hash.
+func ValidateUser(u control.BasicAuthUser) (control.BasicAuthUser, error) {
+	if err := validateUserName(u.User); err != nil {
+		return control.BasicAuthUser{}, err
+	}
+	if len(u.Hash) > maxHashBytes {
+		return control.BasicAuthUser{}, fmt.Errorf("basic auth user %q: hash too long", u.User)
+	}
+	switch {
+	case strings.HasPrefix(u.Hash, "$argon2id$"):
+		if _, err := parseArgon2id(u.Hash); err != nil {
+			return control.BasicAuthUser{}, fmt.Errorf("basic auth user %q: %v", u.User, err)
+		}
+	case IsHash(u.Hash):
+		cost, err := bcrypt.Cost([]byte(u.Hash))
+		if err != nil {
+			return control.BasicAuthUser{}, fmt.Errorf("basic auth user %q: invalid bcrypt hash", u.User)
+		}
+		if cost > maxBcryptCost {
+			return control.BasicAuthUser{}, fmt.Errorf("basic auth user %q: bcrypt cost %d exceeds the maximum of %d", u.User, cost, maxBcryptCost)
+		}
+	default:
+		return control.BasicAuthUser{}, fmt.Errorf("basic auth user %q: unsupported hash (use bcrypt, e.g. htpasswd -B, or argon2id)", u.User)
+	}
+	return u, nil
+}
+
+// ValidateUsers validates a credential list and rejects duplicate users.
+func ValidateUsers(users []control.BasicAuthUser) ([]control.BasicAuthUser, error) {
+	if len(users) > MaxUsers {
+		return nil, errors.New("too many basic auth users")
+	}
+	seen := make(map[string]bool, len(users))
+	out := make([]control.BasicAuthUser, 0, len(users))
+	for _, u := range users {
+		v, err := ValidateUser(u)
+		if err != nil {
+			return nil, err
+		}
+		if seen[v.User] {
+			return nil, fmt.Errorf("basic auth user %q: duplicate", v.User)
+		}
+		seen[v.User] = true
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func validateUserName(user string) error {
+	if user == "" || len(user) > maxUserBytes || strings.ContainsAny(user, ":\r\n\x00") {
+		return fmt.Errorf("invalid basic auth user %q", user)
+	}
+	return nil
+}
+
+// LoadHtpasswd reads "user:hash" lines from an htpasswd file. Blank lines and
+// lines starting with # are ignored.
+func LoadHtpasswd(path string) ([]control.BasicAuthUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHtpasswd(io.LimitReader(f, maxHtpasswdBytes))
+}
+
+// ParseHtpasswd parses htpasswd content; see LoadHtpasswd.
+func ParseHtpasswd(r io.Reader) ([]control.BasicAuthUser, error) {
+	var users []control.BasicAuthUser
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("htpasswd line %d: want user:hash", n)
+		}
+		u, err := ValidateUser(control.BasicAuthUser{User: user, Hash: hash})
+		if err != nil {
+			return nil, fmt.Errorf("htpasswd line %d: %v", n, err)
+		}
+		users = append(users, u)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return ValidateUsers(users)
+}
+
+// Verifier checks basic auth credentials against a set of hashed users. It
+// is safe for concurrent use.
+type Verifier struct {
+	users map[string]string // user -> hash
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]struct{}
+}
+
+// NewVerifier builds a verifier from validated users. It returns nil when
+// users is empty.
+func NewVerifier(users []control.BasicAuthUser) *Verifier {
+	if len(users) == 0 {
+		return nil
+	}
+	v := &Verifier{
+		users:    make(map[string]string, len(users)),
+		verified: make(map[[sha256.Size]byte]struct{}),
+	}
+	for _, u := range users {
+		v.users[u.User] = u.Hash
+	}
+	return v
+}
+
+// dummyHash keeps unknown users from being distinguishable by timing. It is
+// generated on the first failed lookup rather than at startup.
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// compareDummyHash spends about as long as checking a real user's password.
+func compareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		h, err := bcrypt.GenerateFromPassword([]byte("eosrift"), bcrypt.DefaultCost)
+		if err != nil {
+			return
+		}
+		dummyHash = h
+	})
+	if dummyHash != nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+	}
+}
+
+// Check reports whether user and password match a configured user.
+func (v *Verifier) Check(user, password string) bool {
+	hash, ok := v.users[user]
+
+	digest := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	if ok {
+		v.mu.Lock()
+		_, hit := v.verified[digest]
+		v.mu.Unlock()
+		if hit {
+			return true
+		}
+	}
+
+	if !ok {
+		compareDummyHash(password)
+		return false
+	}
+	if !verifyHash(hash, password) {
+		return false
+	}
+
+	v.mu.Lock()
+	if len(v.verified) >= verifiedCacheSize {
+		clear(v.verified)
+	}
+	v.verified[digest] = struct{}{}
+	v.mu.Unlock()
+	return true
+}
+
+func verifyHash(hash, password string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		p, err := parseArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		got := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+		return subtle.ConstantTimeCompare(got, p.key) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2id parses "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>" with
+// unpadded base64 salt and key.
+func parseArgon2id(s string) (argon2Params, error) {
+	var p argon2Params
+
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != "v=19" {
+		return p, errors.New("invalid argon2id hash")
+	}
+	for _, kv := range strings.Split(parts[3], ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return p, errors.New("invalid argon2id parameters")
+		}
+		switch k {
+		case "m":
+			p.memory = uint32(n)
+		case "t":
+			p.time = uint32(n)
+		case "p":
+			if n > 255 {
+				return p, errors.New("invalid argon2id parameters")
+			}
+			p.threads = uint8(n)
+		default:
+			return p, errors.New("invalid argon2id parameters")
+		}
+	}
+	// Bound the work a single verification may demand (64 MiB, 10 passes).
+	if p.memory == 0 || p.memory > 64*1024 || p.time == 0 || p.time > 10 || p.threads == 0 {
+		return p, errors.New("argon2id parameters out of range")
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(p.salt) < 8 {
+		return p, errors.New("invalid argon2id salt")
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) < 16 || len(p.key) > 128 {
+		return p, errors.New("invalid argon2id key")
+	}
+	return p, nil
+}
@@ -0,0 +1,163 @@
+package basicauth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+	"golang.org/x/crypto/argon2"
+)
+
+func argon2idHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 8*1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestParseCredential_HashesPlaintext(t *testing.T) {
+	t.Parallel()
+
+	u, err := ParseCredential("alice:secret")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if u.User != "alice" || !strings.HasPrefix(u.Hash, "$2a$") || strings.Contains(u.Hash, "secret") {
+		t.Fatalf("user = %+v", u)
+	}
+	if !NewVerifier([]control.BasicAuthUser{u}).Check("alice", "secret") {
+		t.Fatalf("hashed credential does not verify")
+	}
+}
+
+func TestParseCredential_Errors(t *testing.T) {
+	t.Parallel()
+
+	for _, raw := range []string{
+		"alice",
+		":pass",
+		"alice:",
+		"alice:$apr1$abc$def",
+		"alice:$2y$10$short",
+		"alice:$argon2id$v=19$m=999999,t=1,p=1$MDEyMzQ1Njc4OWFiY2RlZg$MDEyMzQ1Njc4OWFiY2RlZg",
+	} {
+		if _, err := ParseCredential(raw); err == nil {
+			t.Fatalf("ParseCredential(%q) succeeded", raw)
+		}
+	}
+}
+
+func TestVerifier_BcryptAndArgon2id(t *testing.T) {
+	t.Parallel()
+
+	bob, err := Hash("hunter2")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	users, err := ValidateUsers([]control.BasicAuthUser{
+		{User: "bob", Hash: bob},
+		{User: "carol", Hash: argon2idHash("s3cret")},
+	})
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	v := NewVerifier(users)
+
+	cases := []struct {
+		user, pass string
+		want       bool
+	}{
+		{"bob", "hunter2", true},
+		{"bob", "hunter2", true}, // cached
+		{"bob", "wrong", false},
+		{"carol", "s3cret", true},
+		{"carol", "hunter2", false},
+		{"mallory", "hunter2", false},
+	}
+	for _, tc := range cases {
+		if got := v.Check(tc.user, tc.pass); got != tc.want {
+			t.Fatalf("Check(%q, %q) = %v, want %v", tc.user, tc.pass, got, tc.want)
+		}
+	}
+}
+
+func TestValidateUser_BcryptCost(t *testing.T) {
+	t.Parallel()
+
+	// bcrypt.Cost only reads the header, so the hashes need not be real.
+	const rest = "$abcdefghijklmnopqrstuvabcdefghijklmnopqrstuvwxyz01234"
+	for _, tc := range []struct {
+		cost string
+		ok   bool
+	}{
+		{cost: "10", ok: true},
+		{cost: "14", ok: true},
+		{cost: "15", ok: false},
+		{cost: "31", ok: false},
+	} {
+		_, err := ValidateUser(control.BasicAuthUser{User: "alice", Hash: "$2y$" + tc.cost + rest})
+		if got := err == nil; got != tc.ok {
+			t.Fatalf("cost %s: err = %v, want ok=%v", tc.cost, err, tc.ok)
+		}
+	}
+}
+
+func TestValidateUsers_RejectsDuplicates(t *testing.T) {
+	t.Parallel()
+
+	h := argon2idHash("x")
+	if _, err := ValidateUsers([]control.BasicAuthUser{{User: "a", Hash: h}, {User: "a", Hash: h}}); err == nil {
+		t.Fatalf("expected duplicate error")
+	}
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	t.Parallel()
+
+	h := argon2idHash("pw")
+	users, err := ParseHtpasswd(strings.NewReader("# users\n\nalice:" + h + "\n  bob:" + h + "  \n"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(users) != 2 || users[0].User != "alice" || users[1].User != "bob" {
+		t.Fatalf("users = %+v", users)
+	}
+
+	_, err = ParseHtpasswd(strings.NewReader("alice:" + h + "\nbob:{SHA}abc\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("err = %v, want line 2 error", err)
+	}
+}
+
+func TestFailureThrottle(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	th := NewFailureThrottle(3, time.Minute, func() time.Time { return now })
+
+	a := netip.MustParseAddr("203.0.113.1")
+	b := netip.MustParseAddr("203.0.113.2")
+
+	for i := 0; i < 3; i++ {
+		if blocked, _ := th.Blocked(a); blocked {
+			t.Fatalf("blocked after %d failures", i)
+		}
+		th.Fail(a)
+	}
+	blocked, retry := th.Blocked(a)
+	if !blocked || retry <= 0 || retry > time.Minute {
+		t.Fatalf("Blocked = %v, %v; want true, (0,1m]", blocked, retry)
+	}
+	if blocked, _ := th.Blocked(b); blocked {
+		t.Fatalf("other IP blocked")
+	}
+
+	now = now.Add(time.Minute)
+	if blocked, _ := th.Blocked(a); blocked {
+		t.Fatalf("still blocked after window")
+	}
+}
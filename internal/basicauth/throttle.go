@@ -0,0 +1,95 @@
+package basicauth
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxFailures failed logins per client IP are allowed within
+	// DefaultFailureWindow before further attempts are refused.
+	DefaultMaxFailures   = 10
+	DefaultFailureWindow = time.Minute
+
+	maxThrottleKeys = 100_000
+)
+
+// FailureThrottle counts failed logins per client IP in fixed windows.
+type FailureThrottle struct {
+	mu sync.Mutex
+
+	max    int
+	window time.Duration
+	now    func() time.Time
+
+	entries   map[netip.Addr]*failureEntry
+	lastSweep time.Time
+}
+
+type failureEntry struct {
+	count int
+	start time.Time
+}
+
+func NewFailureThrottle(max int, window time.Duration, now func() time.Time) *FailureThrottle {
+	if now == nil {
+		now = time.Now
+	}
+	return &FailureThrottle{
+		max:       max,
+		window:    window,
+		now:       now,
+		entries:   make(map[netip.Addr]*failureEntry),
+		lastSweep: now(),
+	}
+}
+
+// Blocked reports whether ip has used up its failures for the current window
+// and, if so, how long until it may try again.
+func (t *FailureThrottle) Blocked(ip netip.Addr) (bool, time.Duration) {
+	if t == nil {
+		return false, 0
+	}
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[ip.Unmap()]
+	if !ok || now.Sub(e.start) >= t.window || e.count < t.max {
+		return false, 0
+	}
+	return true, t.window - now.Sub(e.start)
+}
+
+// Fail records a failed login from ip.
+func (t *FailureThrottle) Fail(ip netip.Addr) {
+	if t == nil {
+		return
+	}
+	now := t.now()
+	ip = ip.Unmap()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastSweep) >= t.window || len(t.entries) >= maxThrottleKeys {
+		for k, e := range t.entries {
+			if now.Sub(e.start) >= t.window {
+				delete(t.entries, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	e, ok := t.entries[ip]
+	if !ok || now.Sub(e.start) >= t.window {
+		if !ok && len(t.entries) >= maxThrottleKeys {
+			return
+		}
+		e = &failureEntry{start: now}
+		t.entries[ip] = e
+	}
+	e.count++
+}
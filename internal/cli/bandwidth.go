@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// parseBandwidthFlags builds per-tunnel bandwidth limits from
+// --bandwidth-in, --bandwidth-out and --bandwidth-burst; nil when none is
+// set.
+func parseBandwidthFlags(in, out, burst string) (*control.BandwidthLimits, error) {
+	var b control.BandwidthLimits
+	for _, f := range []struct {
+		flag  string
+		value string
+		parse func(string) (int64, error)
+		dst   *int64
+	}{
+		{"--bandwidth-in", in, control.ParseByteRate, &b.InBytesPerSecond},
+		{"--bandwidth-out", out, control.ParseByteRate, &b.OutBytesPerSecond},
+		{"--bandwidth-burst", burst, control.ParseByteSize, &b.BurstBytes},
+	} {
+		if f.value == "" {
+			continue
+		}
+		n, err := f.parse(f.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.flag, err)
+		}
+		*f.dst = n
+	}
+	if b.BurstBytes != 0 && b.InBytesPerSecond == 0 && b.OutBytesPerSecond == 0 {
+		return nil, errors.New("--bandwidth-burst needs --bandwidth-in or --bandwidth-out")
+	}
+	return control.ValidateBandwidthLimits(&b)
+}
@@ -0,0 +1,29 @@
+package cli
+
+import "testing"
+
+func TestParseBandwidthFlags(t *testing.T) {
+	t.Parallel()
+
+	if b, err := parseBandwidthFlags("", "", ""); b != nil || err != nil {
+		t.Fatalf("no flags: got %#v, %v", b, err)
+	}
+	for _, bad := range [][3]string{
+		{"fast", "", ""},
+		{"", "100", ""},
+		{"", "", "1MB"},
+		{"1MB", "", "lots"},
+	} {
+		if _, err := parseBandwidthFlags(bad[0], bad[1], bad[2]); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+
+	b, err := parseBandwidthFlags("512KiB/s", "5MB", "1MiB")
+	if err != nil {
+		t.Fatalf("parseBandwidthFlags: %v", err)
+	}
+	if b.InBytesPerSecond != 512<<10 || b.OutBytesPerSecond != 5_000_000 || b.BurstBytes != 1<<20 {
+		t.Fatalf("got %#v", b)
+	}
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"eosrift.com/eosrift/internal/basicauth"
+	"eosrift.com/eosrift/internal/config"
+	"eosrift.com/eosrift/internal/control"
+)
+
+// resolveBasicAuthUsers turns "user:pass" / "user:<hash>" values and an
+// optional htpasswd file into hashed credentials. Plaintext passwords are
+// bcrypt-hashed here so they never leave the client.
+func resolveBasicAuthUsers(field string, values []string, file string) ([]control.BasicAuthUser, error) {
+	var users []control.BasicAuthUser
+	for _, raw := range values {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if !strings.Contains(raw, ":") {
+			return nil, fmt.Errorf("%s must be in the form user:pass (or user:<bcrypt|argon2id hash>)", field)
+		}
+		u, err := basicauth.ParseCredential(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		users = append(users, u)
+	}
+
+	if file = strings.TrimSpace(file); file != "" {
+		fromFile, err := basicauth.LoadHtpasswd(file)
+		if err != nil {
+			return nil, fmt.Errorf("basic auth file %s: %w", file, err)
+		}
+		users = append(users, fromFile...)
+	}
+
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return basicauth.ValidateUsers(users)
+}
+
+// configBasicAuthValues returns a tunnel's basic_auth followed by its
+// basic_auth_users.
+func configBasicAuthValues(t config.Tunnel) []string {
+	values := append([]string(nil), t.BasicAuthUsers...)
+	if v := strings.TrimSpace(t.BasicAuth); v != "" {
+		values = append([]string{v}, values...)
+	}
+	return values
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"eosrift.com/eosrift/internal/basicauth"
+)
+
+func TestResolveBasicAuthUsers(t *testing.T) {
+	t.Parallel()
+
+	hash, err := basicauth.Hash("from-file")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(file, []byte("# team\ncarol:"+hash+"\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	users, err := resolveBasicAuthUsers("--basic-auth", []string{"alice:plain", "bob:" + hash}, file)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if len(users) != 3 || users[0].User != "alice" || users[1].User != "bob" || users[2].User != "carol" {
+		t.Fatalf("users = %+v", users)
+	}
+	if strings.Contains(users[0].Hash, "plain") || !basicauth.NewVerifier(users).Check("alice", "plain") {
+		t.Fatalf("plaintext password was not hashed: %q", users[0].Hash)
+	}
+	if users[1].Hash != hash {
+		t.Fatalf("pre-hashed credential changed: %q", users[1].Hash)
+	}
+
+	if _, err := resolveBasicAuthUsers("--basic-auth", []string{"alice:a", "alice:b"}, ""); err == nil {
+		t.Fatalf("expected duplicate user error")
+	}
+	if _, err := resolveBasicAuthUsers("--basic-auth", []string{"alice:$apr1$x$y"}, ""); err == nil {
+		t.Fatalf("expected unsupported hash error")
+	}
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// parseCacheFlags builds an edge cache policy from --cache and the
+// --cache-* flags; nil unless --cache is set.
+func parseCacheFlags(enabled bool, maxSize string, ttl time.Duration) (*control.CachePolicy, error) {
+	if !enabled {
+		if maxSize != "" || ttl != 0 {
+			return nil, errors.New("cache: --cache is required")
+		}
+		return nil, nil
+	}
+
+	p := &control.CachePolicy{}
+	if maxSize != "" {
+		n, err := control.ParseByteSize(maxSize)
+		if err != nil {
+			return nil, fmt.Errorf("--cache-max-size: %v", err)
+		}
+		p.MaxBytes = n
+	}
+	if ttl < 0 || ttl%time.Second != 0 {
+		return nil, errors.New("cache: --cache-ttl must be a whole number of seconds")
+	}
+	p.DefaultTTLSeconds = int(ttl / time.Second)
+	return control.ValidateCachePolicy(p)
+}
@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCacheFlags(t *testing.T) {
+	t.Parallel()
+
+	if p, err := parseCacheFlags(false, "", 0); p != nil || err != nil {
+		t.Fatalf("no flags: got %#v, %v", p, err)
+	}
+	if _, err := parseCacheFlags(false, "10MB", 0); err == nil {
+		t.Fatalf("expected error for --cache-max-size without --cache")
+	}
+	if _, err := parseCacheFlags(true, "", 1500*time.Millisecond); err == nil {
+		t.Fatalf("expected error for fractional --cache-ttl")
+	}
+	if _, err := parseCacheFlags(true, "", 48*time.Hour); err == nil {
+		t.Fatalf("expected error for --cache-ttl over a day")
+	}
+
+	p, err := parseCacheFlags(true, "32MiB", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("parseCacheFlags: %v", err)
+	}
+	if p.MaxBytes != 32<<20 || p.DefaultTTLSeconds != 300 {
+		t.Fatalf("got %#v", p)
+	}
+}
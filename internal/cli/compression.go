@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// parseCompressionFlags builds a compression policy from --compress and the
+// --compress-* flags; nil unless --compress is set.
+func parseCompressionFlags(enabled bool, encodings, types []string, minSize string) (*control.CompressionPolicy, error) {
+	if !enabled {
+		if len(encodings) > 0 || len(types) > 0 || minSize != "" {
+			return nil, errors.New("compression: --compress is required")
+		}
+		return nil, nil
+	}
+
+	p := &control.CompressionPolicy{Encodings: encodings, ContentTypes: types}
+	if minSize != "" {
+		n, err := control.ParseByteSize(minSize)
+		if err != nil {
+			return nil, fmt.Errorf("--compress-min-size: %v", err)
+		}
+		p.MinSize = n
+	}
+	return control.ValidateCompressionPolicy(p)
+}
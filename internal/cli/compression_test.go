@@ -0,0 +1,25 @@
+package cli
+
+import "testing"
+
+func TestParseCompressionFlags(t *testing.T) {
+	t.Parallel()
+
+	if p, err := parseCompressionFlags(false, nil, nil, ""); p != nil || err != nil {
+		t.Fatalf("no flags: got %#v, %v", p, err)
+	}
+	if _, err := parseCompressionFlags(false, []string{"gzip"}, nil, ""); err == nil {
+		t.Fatalf("expected error for --compress-encoding without --compress")
+	}
+	if _, err := parseCompressionFlags(true, nil, nil, "lots"); err == nil {
+		t.Fatalf("expected error for invalid --compress-min-size")
+	}
+
+	p, err := parseCompressionFlags(true, []string{"br", "GZIP"}, []string{"text/html"}, "2KiB")
+	if err != nil {
+		t.Fatalf("parseCompressionFlags: %v", err)
+	}
+	if len(p.Encodings) != 2 || p.Encodings[1] != "gzip" || p.ContentTypes[0] != "text/html" || p.MinSize != 2048 {
+		t.Fatalf("got %#v", p)
+	}
+}
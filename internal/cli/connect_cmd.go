@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"eosrift.com/eosrift/internal/client"
+	"eosrift.com/eosrift/internal/config"
+)
+
+func runConnect(ctx context.Context, args []string, configPath string, stdout, stderr io.Writer) int {
+	cfg, _, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	serverDefault := resolveServerAddrDefault(cfg)
+
+	fs := flag.NewFlagSet("connect", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	serverAddr := fs.String("server", serverDefault, "Server address (https://host, http://host:port, or ws(s)://host/control)")
+	local := fs.String("local", "127.0.0.1:0", "Local listen address (port or host:port)")
+	help := fs.Bool("help", false, "Show help")
+	fs.BoolVar(help, "h", false, "Show help")
+
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "usage: eosrift connect [flags] <tcp://host:port>")
+		fs.PrintDefaults()
+	}
+
+	if err := parseInterspersedFlags(fs, args); err != nil {
+		return 2
+	}
+	if *help {
+		fs.SetOutput(stdout)
+		fs.Usage()
+		return 0
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	target := fs.Arg(0)
+	port, err := parseTCPTarget(target)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+
+	localAddr := strings.TrimSpace(*local)
+	if !strings.Contains(localAddr, ":") {
+		localAddr = "127.0.0.1:" + localAddr
+	}
+
+	controlURL, err := config.ControlURLFromServerAddr(*serverAddr)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	bridgeURL, err := client.TCPBridgeURL(controlURL, port)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	connector, err := client.StartTCPConnector(ctx, bridgeURL, localAddr)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	defer connector.Close()
+
+	printSession(stdout, sessionOutput{
+		Version:        version,
+		Status:         "online",
+		ForwardingFrom: "tcp://" + displayHostPort(connector.LocalAddr()),
+		ForwardingTo:   fmt.Sprintf("tcp://%s:%d", controlHost(controlURL), port),
+	})
+
+	if err := connector.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+		if ctx.Err() != nil {
+			return 0
+		}
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	return 0
+}
+
+// parseTCPTarget returns the remote port of a TCP tunnel address
+// (tcp://host:port or host:port). The bridge is always reached through the
+// configured server, so the host is not used.
+func parseTCPTarget(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if scheme, rest, ok := strings.Cut(s, "://"); ok {
+		if !strings.EqualFold(scheme, "tcp") {
+			return 0, fmt.Errorf("invalid tcp address %q: scheme must be tcp", s)
+		}
+		s = rest
+	}
+
+	_, portStr, err := net.SplitHostPort(strings.TrimSuffix(s, "/"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid tcp address %q: %v", s, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid tcp address %q: invalid port", s)
+	}
+	return port, nil
+}
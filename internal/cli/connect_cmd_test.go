@@ -0,0 +1,31 @@
+package cli
+
+import "testing"
+
+func TestParseTCPTarget(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "tcp://eosrift.com:20001", want: 20001},
+		{in: "TCP://eosrift.com:20001/", want: 20001},
+		{in: "eosrift.com:20001", want: 20001},
+		{in: "[::1]:20001", want: 20001},
+		{in: "https://eosrift.com:443", wantErr: true},
+		{in: "tcp://eosrift.com", wantErr: true},
+		{in: "tcp://eosrift.com:0", wantErr: true},
+		{in: "tcp://eosrift.com:70000", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTCPTarget(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseTCPTarget(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("parseTCPTarget(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"errors"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// parseCORSFlags builds a CORS policy from --cors-* flags; nil when no
+// origin is given.
+func parseCORSFlags(origins, methods, headers, expose []string, credentials bool, maxAge time.Duration) (*control.CORSPolicy, error) {
+	if len(origins) == 0 {
+		if len(methods) > 0 || len(headers) > 0 || len(expose) > 0 || credentials || maxAge != 0 {
+			return nil, errors.New("cors: --cors-origin is required")
+		}
+		return nil, nil
+	}
+	if maxAge < 0 || maxAge%time.Second != 0 {
+		return nil, errors.New("cors: --cors-max-age must be a whole number of seconds")
+	}
+	return control.ValidateCORSPolicy(&control.CORSPolicy{
+		AllowOrigins:     origins,
+		AllowMethods:     methods,
+		AllowHeaders:     headers,
+		ExposeHeaders:    expose,
+		AllowCredentials: credentials,
+		MaxAge:           int(maxAge / time.Second),
+	})
+}
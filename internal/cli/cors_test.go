@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCORSFlags(t *testing.T) {
+	t.Parallel()
+
+	if p, err := parseCORSFlags(nil, nil, nil, nil, false, 0); p != nil || err != nil {
+		t.Fatalf("no flags: got %#v, %v", p, err)
+	}
+	if _, err := parseCORSFlags(nil, []string{"GET"}, nil, nil, false, 0); err == nil {
+		t.Fatalf("expected error for --cors-method without --cors-origin")
+	}
+	if _, err := parseCORSFlags([]string{"https://app.test"}, nil, nil, nil, false, 1500*time.Millisecond); err == nil {
+		t.Fatalf("expected error for fractional --cors-max-age")
+	}
+
+	p, err := parseCORSFlags([]string{"https://App.test"}, []string{"get", "put"}, []string{"x-api-key"}, nil, true, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("parseCORSFlags: %v", err)
+	}
+	if p.AllowOrigins[0] != "https://app.test" || p.AllowMethods[1] != "PUT" || p.AllowHeaders[0] != "X-Api-Key" {
+		t.Fatalf("got %#v", p)
+	}
+	if !p.AllowCredentials || p.MaxAge != 600 {
+		t.Fatalf("got %#v", p)
+	}
+}
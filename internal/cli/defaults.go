@@ -1,9 +1,12 @@
 package cli
 
 import (
+	"context"
 	"strings"
+	"time"
 
 	"eosrift.com/eosrift/internal/config"
+	"eosrift.com/eosrift/internal/tracing"
 )
 
 func resolveServerAddrDefault(cfg config.File) string {
@@ -50,6 +53,35 @@ func resolveInspectAddrDefault(cfg config.File) string {
 	return inspectAddrDefault
 }
 
+func resolveOTLPEndpoint(cfg config.File) string {
+	endpoint := getenv("EOSRIFT_OTLP_ENDPOINT", "")
+	if endpoint == "" {
+		endpoint = cfg.OTLPEndpoint
+	}
+	return strings.TrimSpace(endpoint)
+}
+
+// openTracer returns the agent's tracer, or nil when no OTLP endpoint is
+// configured. The returned func flushes pending spans.
+func openTracer(endpoint string) (*tracing.Tracer, func(), error) {
+	if endpoint == "" {
+		return nil, func() {}, nil
+	}
+	tracer, err := tracing.New(tracing.Options{
+		Endpoint:    endpoint,
+		ServiceName: "eosrift-agent",
+		SampleRatio: 1,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tracer, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = tracer.Shutdown(ctx)
+	}, nil
+}
+
 func resolveHostHeaderDefault(cfg config.File) string {
 	hostHeaderDefault := cfg.HostHeader
 	if strings.TrimSpace(hostHeaderDefault) == "" {
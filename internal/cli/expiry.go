@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"eosrift.com/eosrift/internal/client"
+	"eosrift.com/eosrift/internal/control"
+)
+
+// parseExpiry turns expires (a duration) or until (a time) into an
+// absolute expiry; zero when neither is set. It backs both the
+// --expires/--until flags and the config keys of the same name.
+//
+// until accepts RFC 3339, "2006-01-02 15:04" or "15:04"; the last two are
+// local time, and a bare clock time means its next occurrence.
+func parseExpiry(expires, until string, now time.Time) (time.Time, error) {
+	expires = strings.TrimSpace(expires)
+	until = strings.TrimSpace(until)
+
+	switch {
+	case expires != "" && until != "":
+		return time.Time{}, errors.New("expires and until are mutually exclusive")
+	case expires != "":
+		d, err := time.ParseDuration(expires)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("expires: invalid duration %q", expires)
+		}
+		if d <= 0 {
+			return time.Time{}, errors.New("expires must be > 0")
+		}
+		return now.Add(d), nil
+	case until != "":
+		t, err := parseUntil(until, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !t.After(now) {
+			return time.Time{}, fmt.Errorf("until %q is in the past", until)
+		}
+		return t, nil
+	default:
+		return time.Time{}, nil
+	}
+}
+
+func parseUntil(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, now.Location()); err == nil {
+		return t, nil
+	}
+	if c, err := time.Parse("15:04", s); err == nil {
+		t := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), 0, 0, now.Location())
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("until: invalid time %q (want RFC 3339, \"2006-01-02 15:04\" or \"15:04\")", s)
+}
+
+// tunnelExpired reports whether Wait ended because the tunnel reached the
+// expiry it asked for; that is a normal exit, not an error.
+func tunnelExpired(err error) bool {
+	var closed *client.TunnelClosedError
+	return errors.As(err, &closed) && closed.Reason == control.TunnelClosedExpired
+}
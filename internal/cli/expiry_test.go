@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	t.Parallel()
+
+	loc := time.FixedZone("test", 2*60*60)
+	now := time.Date(2026, 3, 10, 15, 30, 0, 0, loc)
+
+	if got, err := parseExpiry("", "", now); err != nil || !got.IsZero() {
+		t.Fatalf("no flags: got %v, %v", got, err)
+	}
+
+	for _, tc := range []struct {
+		expires, until string
+	}{
+		{"2h", "18:00"},
+		{"soon", ""},
+		{"0s", ""},
+		{"-1h", ""},
+		{"", "tomorrow"},
+		{"", "2026-03-10T12:00:00Z"},
+		{"", "2026-03-10 15:30"},
+	} {
+		if _, err := parseExpiry(tc.expires, tc.until, now); err == nil {
+			t.Fatalf("expected error for expires=%q until=%q", tc.expires, tc.until)
+		}
+	}
+
+	for _, tc := range []struct {
+		expires, until string
+		want           time.Time
+	}{
+		{"2h", "", now.Add(2 * time.Hour)},
+		{"", "2026-03-11T09:00:00Z", time.Date(2026, 3, 11, 9, 0, 0, 0, time.UTC)},
+		{"", "2026-03-12 08:15", time.Date(2026, 3, 12, 8, 15, 0, 0, loc)},
+		{"", "18:00", time.Date(2026, 3, 10, 18, 0, 0, 0, loc)},
+		{"", "09:00", time.Date(2026, 3, 11, 9, 0, 0, 0, loc)},
+	} {
+		got, err := parseExpiry(tc.expires, tc.until, now)
+		if err != nil {
+			t.Fatalf("expires=%q until=%q: %v", tc.expires, tc.until, err)
+		}
+		if !got.Equal(tc.want) {
+			t.Fatalf("expires=%q until=%q: got %v, want %v", tc.expires, tc.until, got, tc.want)
+		}
+	}
+}
@@ -63,6 +63,9 @@ func parseHeaderKV(field string, raw string) (client.HeaderKV, error) {
 	if err != nil {
 		return client.HeaderKV{}, err
 	}
+	if err := control.ValidateHeaderTemplate(field, normValue); err != nil {
+		return client.HeaderKV{}, err
+	}
 
 	return client.HeaderKV{
 		Name:  normName,
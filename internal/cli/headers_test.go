@@ -50,6 +50,25 @@ func TestParseHeaderKV(t *testing.T) {
 			t.Fatalf("err = nil, want non-nil")
 		}
 	})
+	t.Run("accepts template variables", func(t *testing.T) {
+		t.Parallel()
+
+		kv, err := parseHeaderKV("request_header_add", "X-User: ${basic_auth_user}")
+		if err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+		if kv.Value != "${basic_auth_user}" {
+			t.Fatalf("value = %q, want %q", kv.Value, "${basic_auth_user}")
+		}
+	})
+
+	t.Run("rejects unknown template variable", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := parseHeaderKV("request_header_add", "X-User: ${password}"); err == nil {
+			t.Fatalf("err = nil, want non-nil")
+		}
+	})
 }
 
 func TestParseHeaderRemoveList(t *testing.T) {
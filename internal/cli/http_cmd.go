@@ -38,17 +38,25 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 	authtoken := fs.String("authtoken", authtokenDefault, "Auth token")
 	subdomain := fs.String("subdomain", "", "Reserved subdomain to request (requires server-side reservation)")
 	domain := fs.String("domain", "", "Domain to request (must be under the server tunnel domain; auto-reserved on first use)")
-	basicAuth := fs.String("basic-auth", "", "Require HTTP basic auth on the public URL (user:pass)")
+	var basicAuth stringListFlag
+	fs.Var(&basicAuth, "basic-auth", "Require HTTP basic auth on the public URL (repeatable, user:pass or user:<bcrypt|argon2id hash>)")
+	basicAuthFile := fs.String("basic-auth-file", "", "Require HTTP basic auth using users from an htpasswd file (bcrypt or argon2id hashes)")
 	var allowCIDR stringSliceFlag
 	fs.Var(&allowCIDR, "allow-cidr", "Allow client IPs matching CIDR or IP (repeatable)")
 	var denyCIDR stringSliceFlag
 	fs.Var(&denyCIDR, "deny-cidr", "Deny client IPs matching CIDR or IP (repeatable)")
+	rateLimit := fs.String("rate-limit", "", "Limit requests per visitor, e.g. 100/m (per client IP unless --rate-limit-header is set)")
+	rateLimitHeader := fs.String("rate-limit-header", "", "Key the rate limit by this request header (e.g. X-API-Key) instead of client IP")
 	var allowMethod stringSliceFlag
 	fs.Var(&allowMethod, "allow-method", "Allow HTTP method(s) (repeatable)")
 	var allowPath stringSliceFlag
 	fs.Var(&allowPath, "allow-path", "Allow exact request path(s) (repeatable, must start with /)")
 	var allowPathPrefix stringSliceFlag
 	fs.Var(&allowPathPrefix, "allow-path-prefix", "Allow request path prefix(es) (repeatable, must start with /)")
+	var redirect stringListFlag
+	fs.Var(&redirect, "redirect", "Redirect matching paths at the edge (repeatable, \"<regex> <target> [status]\")")
+	var rewrite stringListFlag
+	fs.Var(&rewrite, "rewrite", "Rewrite matching paths before proxying (repeatable, \"<regex> <target>\")")
 	var requestHeaderAdd stringListFlag
 	fs.Var(&requestHeaderAdd, "request-header-add", "Add/override a request header (repeatable, \"Name: value\")")
 	var requestHeaderRemove stringListFlag
@@ -57,6 +65,45 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 	fs.Var(&responseHeaderAdd, "response-header-add", "Add/override a response header (repeatable, \"Name: value\")")
 	var responseHeaderRemove stringListFlag
 	fs.Var(&responseHeaderRemove, "response-header-remove", "Remove a response header (repeatable, \"Name\")")
+	jwtJWKSURL := fs.String("jwt-jwks-url", "", "Require a JWT bearer token verified against this https JWKS URL")
+	jwtJWKSFile := fs.String("jwt-jwks-file", "", "Require a JWT bearer token verified against keys from this JWKS file")
+	jwtIssuer := fs.String("jwt-issuer", "", "Required JWT iss claim")
+	var jwtAudience stringListFlag
+	fs.Var(&jwtAudience, "jwt-audience", "Accepted JWT aud value (repeatable)")
+	var jwtClaimHeader stringListFlag
+	fs.Var(&jwtClaimHeader, "jwt-claim-header", "Forward a JWT claim upstream as a header (repeatable, \"claim:Header-Name\")")
+	var corsOrigin stringListFlag
+	fs.Var(&corsOrigin, "cors-origin", "Answer CORS preflights at the edge for this origin (repeatable, \"https://app.example.com\", \"https://*.example.com\" or \"*\")")
+	var corsMethod stringListFlag
+	fs.Var(&corsMethod, "cors-method", "CORS allowed method (repeatable; default GET, HEAD, POST, PUT, PATCH, DELETE)")
+	var corsHeader stringListFlag
+	fs.Var(&corsHeader, "cors-header", "CORS allowed request header (repeatable; default: whatever the preflight asks for)")
+	var corsExposeHeader stringListFlag
+	fs.Var(&corsExposeHeader, "cors-expose-header", "Response header scripts may read (repeatable)")
+	corsCredentials := fs.Bool("cors-credentials", false, "Allow CORS requests with cookies or auth (not with --cors-origin '*')")
+	corsMaxAge := fs.Duration("cors-max-age", 0, "How long browsers may cache a CORS preflight (e.g. 10m, max 24h)")
+	compress := fs.Bool("compress", false, "Compress responses at the edge for clients that accept gzip, br or zstd")
+	var compressEncoding stringListFlag
+	fs.Var(&compressEncoding, "compress-encoding", "Offer only this encoding: zstd, br or gzip (repeatable; most preferred first)")
+	var compressType stringListFlag
+	fs.Var(&compressType, "compress-type", "Compress this content type, e.g. text/* or application/*+json (repeatable; replaces the defaults)")
+	compressMinSize := fs.String("compress-min-size", "", "Smallest response body to compress (default 1KiB)")
+	cache := fs.Bool("cache", false, "Cache GET responses at the server edge (honours Cache-Control, ETag and Vary)")
+	cacheMaxSize := fs.String("cache-max-size", "", "Edge cache size (e.g. 32MB; default and maximum set by the server)")
+	cacheTTL := fs.Duration("cache-ttl", 0, "Cache responses without max-age or Expires this long (e.g. 5m)")
+	maxRequestBody := fs.String("max-request-body", "", "Reject larger request bodies with 413 (e.g. 10MB)")
+	responseHeaderTimeout := fs.Duration("response-header-timeout", 0, "Return 504 when the upstream takes longer to start responding (e.g. 30s)")
+	requestTimeout := fs.Duration("request-timeout", 0, "Abort requests that take longer in total (e.g. 5m; WebSockets exempt)")
+	webSocketIdleTimeout := fs.Duration("websocket-idle-timeout", 0, "Close WebSocket connections idle this long (e.g. 10m)")
+	mirror := fs.String("mirror", "", "Copy every request to this tunnel id (same authtoken); its responses are discarded")
+	mirrorMaxBody := fs.String("mirror-max-body", "", "Largest request body to mirror (default 1MiB, max 10MiB)")
+	expires := fs.String("expires", "", "Close the tunnel after this long (e.g. 2h)")
+	until := fs.String("until", "", "Close the tunnel at this time (RFC 3339, \"2006-01-02 15:04\" or \"15:04\")")
+	bandwidthIn := fs.String("bandwidth-in", "", "Pace traffic from visitors to the upstream (e.g. 1MB/s)")
+	bandwidthOut := fs.String("bandwidth-out", "", "Pace traffic from the upstream to visitors (e.g. 5MB/s)")
+	bandwidthBurst := fs.String("bandwidth-burst", "", "Bytes allowed at full speed before pacing starts (default: one second's worth)")
+	shareLinks := fs.Bool("share-links", false, "Require a signed share link (minted with eosrift share) or basic auth to visit the public URL")
+	trafficPolicyFile := fs.String("traffic-policy-file", "", "Traffic policy rules to evaluate at the server edge (YAML or JSON file)")
 	hostHeader := fs.String("host-header", hostHeaderDefault, "Host header mode: preserve (default), rewrite, or a literal value")
 	upstreamTLSSkipVerify := fs.Bool("upstream-tls-skip-verify", false, "Disable certificate verification for HTTPS upstreams")
 	inspectEnabled := fs.Bool("inspect", inspectDefault, "Enable local inspector")
@@ -76,9 +123,22 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 		fmt.Fprintln(out, "  eosrift http 3000 --domain demo.tunnel.eosrift.com")
 		fmt.Fprintln(out, "  eosrift http 3000 --subdomain demo")
 		fmt.Fprintln(out, "  eosrift http 3000 --basic-auth user:pass")
+		fmt.Fprintln(out, "  eosrift http 3000 --basic-auth-file .htpasswd")
+		fmt.Fprintln(out, "  eosrift http 3000 --share-links")
+		fmt.Fprintln(out, "  eosrift http 3000 --jwt-jwks-url https://issuer.example/.well-known/jwks.json --jwt-audience api --jwt-claim-header sub:X-User")
+		fmt.Fprintln(out, "  eosrift http 3000 --cors-origin https://app.example.com --cors-credentials")
+		fmt.Fprintln(out, "  eosrift http 3000 --compress")
+		fmt.Fprintln(out, "  eosrift http 3000 --cache --cache-ttl 5m")
+		fmt.Fprintln(out, "  eosrift http 3000 --max-request-body 10MB --request-timeout 5m")
+		fmt.Fprintln(out, "  eosrift http 3000 --subdomain demo --mirror demo-branch")
+		fmt.Fprintln(out, "  eosrift http 3000 --expires 2h")
+		fmt.Fprintln(out, "  eosrift http 3000 --bandwidth-out 5MB/s")
 		fmt.Fprintln(out, "  eosrift http 3000 --allow-cidr 203.0.113.0/24")
 		fmt.Fprintln(out, "  eosrift http 3000 --allow-method GET --allow-path /healthz")
+		fmt.Fprintln(out, "  eosrift http 3000 --rate-limit 100/m")
+		fmt.Fprintln(out, "  eosrift http 3000 --rewrite '^/v1/(.*)$ /api/v1/$1' --redirect '^/old$ /new 301'")
 		fmt.Fprintln(out, "  eosrift http 3000 --request-header-add \"X-API-Key: secret\"")
+		fmt.Fprintln(out, "  eosrift http 3000 --traffic-policy-file policy.yml")
 		fmt.Fprintln(out, "  eosrift http 3000 --host-header=rewrite")
 		fmt.Fprintln(out, "  eosrift http https://127.0.0.1:8443 --upstream-tls-skip-verify")
 	}
@@ -100,8 +160,53 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 		fmt.Fprintln(stderr, "error: only one of --subdomain or --domain may be set")
 		return 2
 	}
-	if strings.TrimSpace(*basicAuth) != "" && !strings.Contains(*basicAuth, ":") {
-		fmt.Fprintln(stderr, "error: --basic-auth must be in the form user:pass")
+	basicAuthUsers, err := resolveBasicAuthUsers("--basic-auth", []string(basicAuth), *basicAuthFile)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	jwtAuth, err := parseJWTFlags(*jwtJWKSURL, *jwtJWKSFile, *jwtIssuer, []string(jwtAudience), []string(jwtClaimHeader))
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	if jwtAuth != nil && len(basicAuthUsers) > 0 {
+		fmt.Fprintln(stderr, "error: --jwt-* cannot be combined with --basic-auth")
+		return 2
+	}
+	cors, err := parseCORSFlags([]string(corsOrigin), []string(corsMethod), []string(corsHeader), []string(corsExposeHeader), *corsCredentials, *corsMaxAge)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	compression, err := parseCompressionFlags(*compress, []string(compressEncoding), []string(compressType), *compressMinSize)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	cachePolicy, err := parseCacheFlags(*cache, *cacheMaxSize, *cacheTTL)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	limits, err := parseLimitFlags(*maxRequestBody, *responseHeaderTimeout, *requestTimeout, *webSocketIdleTimeout)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	mirrorPolicy, err := parseMirrorFlags(*mirror, *mirrorMaxBody)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	expiresAt, err := parseExpiry(*expires, *until, time.Now())
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	bandwidth, err := parseBandwidthFlags(*bandwidthIn, *bandwidthOut, *bandwidthBurst)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
 		return 2
 	}
 	if err := validateCIDRs("allow_cidr", []string(allowCIDR)); err != nil {
@@ -113,6 +218,23 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 		return 2
 	}
 
+	parsedRateLimit, parsedRateLimitHeader, err := control.ParseRateLimit(*rateLimit, *rateLimitHeader)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+
+	parsedRedirects, err := parseRedirectFlags("redirect", []string(redirect))
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	parsedRewrites, err := parseRewriteFlags("rewrite", []string(rewrite))
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+
 	parsedAllowMethods, err := control.ParseHTTPMethodList("allow_method", []string(allowMethod), 0)
 	if err != nil {
 		fmt.Fprintln(stderr, "error:", err)
@@ -150,6 +272,12 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 		return 2
 	}
 
+	trafficPolicy, err := resolveTrafficPolicy(nil, *trafficPolicyFile)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+
 	upstreamScheme, localAddr, err := parseHTTPUpstreamTarget(fs.Arg(0))
 	if err != nil {
 		fmt.Fprintln(stderr, "error:", err)
@@ -167,24 +295,46 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 		store = inspect.NewStore(inspect.StoreConfig{MaxEntries: 200})
 	}
 
+	tracer, stopTracer, err := openTracer(resolveOTLPEndpoint(cfg))
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	defer stopTracer()
+
 	tunnel, err := client.StartHTTPTunnelWithOptions(ctx, controlURL, localAddr, client.HTTPTunnelOptions{
 		Authtoken:             *authtoken,
 		Subdomain:             *subdomain,
 		Domain:                *domain,
-		BasicAuth:             *basicAuth,
+		BasicAuthUsers:        basicAuthUsers,
+		ShareLinks:            *shareLinks,
+		JWT:                   jwtAuth,
+		CORS:                  cors,
+		Compression:           compression,
+		Cache:                 cachePolicy,
+		Limits:                limits,
+		Mirror:                mirrorPolicy,
+		Bandwidth:             bandwidth,
+		ExpiresAt:             expiresAt,
 		AllowMethods:          parsedAllowMethods,
 		AllowPaths:            parsedAllowPaths,
 		AllowPathPrefixes:     parsedAllowPathPrefixes,
 		AllowCIDRs:            []string(allowCIDR),
 		DenyCIDRs:             []string(denyCIDR),
+		RateLimit:             parsedRateLimit,
+		RateLimitHeader:       parsedRateLimitHeader,
+		Redirects:             parsedRedirects,
+		Rewrites:              parsedRewrites,
 		RequestHeaderAdd:      parsedRequestHeaderAdd,
 		RequestHeaderRemove:   parsedRequestHeaderRemove,
 		ResponseHeaderAdd:     parsedResponseHeaderAdd,
 		ResponseHeaderRemove:  parsedResponseHeaderRemove,
+		TrafficPolicy:         trafficPolicy,
 		HostHeader:            *hostHeader,
 		UpstreamScheme:        upstreamScheme,
 		UpstreamTLSSkipVerify: *upstreamTLSSkipVerify,
 		Inspector:             store,
+		Tracer:                tracer,
 	})
 	if err != nil {
 		fmt.Fprintln(stderr, "error:", err)
@@ -192,6 +342,9 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 	}
 	defer tunnel.Close()
 
+	var shares shareTargets
+	shares.Set("", tunnel.ID, tunnel)
+
 	inspectorURL := ""
 	if store != nil {
 		ln, err := listenTCPWithPortFallback(*inspectAddr, 5000)
@@ -245,6 +398,7 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 
 						return inspect.ReplayResult{StatusCode: resp.StatusCode}, nil
 					},
+					Share: shares.ShareFunc(),
 				}),
 			}
 
@@ -264,6 +418,7 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 		Status:         "online",
 		ForwardingFrom: tunnel.URL,
 		ForwardingTo:   displayHostPort(localAddr),
+		ExpiresAt:      tunnel.ExpiresAt,
 		Inspector:      inspectorURL,
 	})
 
@@ -271,6 +426,10 @@ func runHTTP(ctx context.Context, args []string, configPath string, stdout, stde
 		if ctx.Err() != nil {
 			return 0
 		}
+		if tunnelExpired(err) {
+			fmt.Fprintln(stderr, "Tunnel expired; closed by the server.")
+			return 0
+		}
 		fmt.Fprintln(stderr, "error:", err)
 		return 1
 	}
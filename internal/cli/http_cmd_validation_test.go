@@ -166,3 +166,49 @@ func TestRun_TCP_NoArgs_IsUsageError(t *testing.T) {
 		t.Fatalf("stderr missing usage: %q", stderr.String())
 	}
 }
+
+func TestRun_HTTP_InvalidRateLimit_IsUsageError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eosrift.yml")
+
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), []string{
+		"--config", path,
+		"http",
+		"3000",
+		"--rate-limit", "100/fortnight",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("code = %d, want %d (stderr=%q)", code, 2, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("stdout not empty: %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "invalid rate_limit") {
+		t.Fatalf("stderr missing rate_limit error: %q", stderr.String())
+	}
+}
+
+func TestRun_HTTP_CORSWildcardWithCredentials_IsUsageError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eosrift.yml")
+
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), []string{
+		"--config", path,
+		"http",
+		"3000",
+		"--cors-origin", "*",
+		"--cors-credentials",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("code = %d, want %d (stderr=%q)", code, 2, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "cannot be combined with allow_credentials") {
+		t.Fatalf("stderr missing cors error: %q", stderr.String())
+	}
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"eosrift.com/eosrift/internal/config"
+	"eosrift.com/eosrift/internal/control"
+	"eosrift.com/eosrift/internal/jwtauth"
+)
+
+const maxJWKSFileBytes = 32 * 1024
+
+// resolveJWTAuth loads an optional JWKS file into a and validates the result
+// locally (including parsing inline keys) before dialing the server.
+func resolveJWTAuth(a *control.JWTAuth, jwksFile string) (*control.JWTAuth, error) {
+	if jwksFile = strings.TrimSpace(jwksFile); jwksFile != "" {
+		if a == nil {
+			a = &control.JWTAuth{}
+		}
+		b, err := readJWKSFile(jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		withFile := *a
+		withFile.JWKS = string(b)
+		a = &withFile
+	}
+	if a == nil {
+		return nil, nil
+	}
+
+	out, err := control.ValidateJWTAuth(a)
+	if err != nil {
+		return nil, err
+	}
+	if out.JWKS != "" {
+		if _, err := jwtauth.ParseJWKS([]byte(out.JWKS)); err != nil {
+			return nil, fmt.Errorf("jwt: %v", err)
+		}
+	}
+	return out, nil
+}
+
+func readJWKSFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, maxJWKSFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxJWKSFileBytes {
+		return nil, errors.New("jwt: jwks file too large")
+	}
+	return b, nil
+}
+
+// parseJWTFlags builds a JWT auth block from --jwt-* flags; nil when none is
+// set.
+func parseJWTFlags(jwksURL, jwksFile, issuer string, audience, claimHeaders []string) (*control.JWTAuth, error) {
+	if strings.TrimSpace(jwksURL) == "" && strings.TrimSpace(jwksFile) == "" {
+		if strings.TrimSpace(issuer) != "" || len(audience) > 0 || len(claimHeaders) > 0 {
+			return nil, errors.New("jwt: --jwt-jwks-url or --jwt-jwks-file is required")
+		}
+		return nil, nil
+	}
+
+	a := &control.JWTAuth{
+		JWKSURL:  jwksURL,
+		Issuer:   issuer,
+		Audience: audience,
+	}
+	for _, raw := range claimHeaders {
+		ch, err := control.ParseJWTClaimHeader(raw)
+		if err != nil {
+			return nil, err
+		}
+		a.ClaimHeaders = append(a.ClaimHeaders, ch)
+	}
+	return resolveJWTAuth(a, jwksFile)
+}
+
+// resolveConfigJWTAuth resolves a tunnel's jwt config block.
+func resolveConfigJWTAuth(a *config.JWTAuth) (*control.JWTAuth, error) {
+	if a == nil {
+		return nil, nil
+	}
+	base := a.JWTAuth
+	return resolveJWTAuth(&base, a.JWKSFile)
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"eosrift.com/eosrift/internal/config"
+	"eosrift.com/eosrift/internal/control"
+)
+
+func writeTestJWKS(t *testing.T) string {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("keygen: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	doc := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","x":%q}]}`, base64.RawURLEncoding.EncodeToString(pub))
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return path
+}
+
+func TestParseJWTFlags(t *testing.T) {
+	t.Parallel()
+
+	if a, err := parseJWTFlags("", "", "", nil, nil); a != nil || err != nil {
+		t.Fatalf("no flags: got %#v, %v", a, err)
+	}
+	if _, err := parseJWTFlags("", "", "https://issuer.test", nil, nil); err == nil {
+		t.Fatalf("expected error for --jwt-issuer without keys")
+	}
+
+	a, err := parseJWTFlags("https://issuer.test/jwks.json", "", "https://issuer.test", []string{"api"}, []string{"sub:x-user"})
+	if err != nil {
+		t.Fatalf("parseJWTFlags: %v", err)
+	}
+	if a.JWKSURL != "https://issuer.test/jwks.json" || a.Issuer != "https://issuer.test" || len(a.Audience) != 1 {
+		t.Fatalf("got %#v", a)
+	}
+	if len(a.ClaimHeaders) != 1 || a.ClaimHeaders[0] != (control.JWTClaimHeader{Claim: "sub", Header: "X-User"}) {
+		t.Fatalf("claim headers = %#v", a.ClaimHeaders)
+	}
+
+	file := writeTestJWKS(t)
+	a, err = parseJWTFlags("", file, "", nil, nil)
+	if err != nil {
+		t.Fatalf("parseJWTFlags(file): %v", err)
+	}
+	if a.JWKS == "" || a.JWKSURL != "" {
+		t.Fatalf("got %#v", a)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := parseJWTFlags("", bad, "", nil, nil); err == nil {
+		t.Fatalf("expected error for jwks without signing keys")
+	}
+}
+
+func TestResolveConfigJWTAuth(t *testing.T) {
+	t.Parallel()
+
+	file := writeTestJWKS(t)
+	a, err := resolveConfigJWTAuth(&config.JWTAuth{
+		JWTAuth:  control.JWTAuth{Audience: []string{"api"}},
+		JWKSFile: file,
+	})
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if a.JWKS == "" || len(a.Audience) != 1 {
+		t.Fatalf("got %#v", a)
+	}
+
+	if a, err := resolveConfigJWTAuth(nil); a != nil || err != nil {
+		t.Fatalf("nil: got %#v, %v", a, err)
+	}
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// parseLimitFlags builds per-tunnel request limits from --max-request-body
+// and the timeout flags; nil when none is set.
+func parseLimitFlags(maxBody string, responseHeaderTimeout, requestTimeout, webSocketIdleTimeout time.Duration) (*control.HTTPLimits, error) {
+	var l control.HTTPLimits
+	if maxBody != "" {
+		n, err := control.ParseByteSize(maxBody)
+		if err != nil {
+			return nil, fmt.Errorf("--max-request-body: %v", err)
+		}
+		l.MaxRequestBodyBytes = n
+	}
+
+	for _, f := range []struct {
+		flag  string
+		value time.Duration
+		dst   *int
+	}{
+		{"--response-header-timeout", responseHeaderTimeout, &l.ResponseHeaderTimeoutSeconds},
+		{"--request-timeout", requestTimeout, &l.RequestTimeoutSeconds},
+		{"--websocket-idle-timeout", webSocketIdleTimeout, &l.WebSocketIdleTimeoutSeconds},
+	} {
+		if f.value < 0 || f.value%time.Second != 0 {
+			return nil, fmt.Errorf("%s must be a whole number of seconds", f.flag)
+		}
+		*f.dst = int(f.value / time.Second)
+	}
+	return control.ValidateHTTPLimits(&l)
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+func TestParseLimitFlags(t *testing.T) {
+	t.Parallel()
+
+	if l, err := parseLimitFlags("", 0, 0, 0); l != nil || err != nil {
+		t.Fatalf("no flags: got %#v, %v", l, err)
+	}
+	for _, bad := range []struct {
+		body           string
+		header, ws     time.Duration
+		requestTimeout time.Duration
+	}{
+		{body: "10XB"},
+		{header: 1500 * time.Millisecond},
+		{requestTimeout: -time.Second},
+		{ws: 48 * time.Hour},
+	} {
+		if _, err := parseLimitFlags(bad.body, bad.header, bad.requestTimeout, bad.ws); err == nil {
+			t.Errorf("parseLimitFlags(%+v) = nil error", bad)
+		}
+	}
+
+	l, err := parseLimitFlags("10MB", 30*time.Second, 5*time.Minute, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("parseLimitFlags: %v", err)
+	}
+	want := control.HTTPLimits{
+		MaxRequestBodyBytes:          10_000_000,
+		ResponseHeaderTimeoutSeconds: 30,
+		RequestTimeoutSeconds:        300,
+		WebSocketIdleTimeoutSeconds:  600,
+	}
+	if *l != want {
+		t.Fatalf("got %+v, want %+v", *l, want)
+	}
+}
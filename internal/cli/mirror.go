@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// parseMirrorFlags builds a mirror policy from --mirror and
+// --mirror-max-body; nil unless --mirror is set.
+func parseMirrorFlags(tunnel, maxBody string) (*control.MirrorPolicy, error) {
+	if strings.TrimSpace(tunnel) == "" {
+		if maxBody != "" {
+			return nil, errors.New("mirror: --mirror is required")
+		}
+		return nil, nil
+	}
+
+	p := &control.MirrorPolicy{Tunnel: tunnel}
+	if maxBody != "" {
+		n, err := control.ParseByteSize(maxBody)
+		if err != nil {
+			return nil, fmt.Errorf("--mirror-max-body: %v", err)
+		}
+		p.MaxBodyBytes = n
+	}
+	return control.ValidateMirrorPolicy(p)
+}
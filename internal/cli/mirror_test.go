@@ -0,0 +1,28 @@
+package cli
+
+import "testing"
+
+func TestParseMirrorFlags(t *testing.T) {
+	t.Parallel()
+
+	if p, err := parseMirrorFlags("", ""); p != nil || err != nil {
+		t.Fatalf("no flags: got %#v, %v", p, err)
+	}
+	if _, err := parseMirrorFlags("", "1MB"); err == nil {
+		t.Fatalf("expected error for --mirror-max-body without --mirror")
+	}
+	if _, err := parseMirrorFlags("demo.branch", ""); err == nil {
+		t.Fatalf("expected error for an invalid tunnel id")
+	}
+	if _, err := parseMirrorFlags("demo-branch", "20MiB"); err == nil {
+		t.Fatalf("expected error for --mirror-max-body over 10MiB")
+	}
+
+	p, err := parseMirrorFlags("Demo-Branch", "256KiB")
+	if err != nil {
+		t.Fatalf("parseMirrorFlags: %v", err)
+	}
+	if p.Tunnel != "demo-branch" || p.MaxBodyBytes != 256<<10 {
+		t.Fatalf("got %#v", p)
+	}
+}
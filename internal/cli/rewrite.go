@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// parseRewriteFlags parses --rewrite values of the form "<regex> <target>".
+func parseRewriteFlags(field string, values []string) ([]control.RewriteRule, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]control.RewriteRule, 0, len(values))
+	for _, raw := range values {
+		parts := strings.Fields(raw)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s: %q (want \"<regex> <target>\")", field, raw)
+		}
+		rules = append(rules, control.RewriteRule{From: parts[0], To: parts[1]})
+	}
+	return control.ParseRewriteRules(field, rules, 0)
+}
+
+// parseRedirectFlags parses --redirect values of the form
+// "<regex> <target> [status]".
+func parseRedirectFlags(field string, values []string) ([]control.RedirectRule, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]control.RedirectRule, 0, len(values))
+	for _, raw := range values {
+		parts := strings.Fields(raw)
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("invalid %s: %q (want \"<regex> <target> [status]\")", field, raw)
+		}
+		rule := control.RedirectRule{From: parts[0], To: parts[1]}
+		if len(parts) == 3 {
+			status, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: status %q", field, parts[2])
+			}
+			rule.Status = status
+		}
+		rules = append(rules, rule)
+	}
+	return control.ParseRedirectRules(field, rules, 0)
+}
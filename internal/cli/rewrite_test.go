@@ -0,0 +1,39 @@
+package cli
+
+import "testing"
+
+func TestParseRewriteFlags(t *testing.T) {
+	t.Parallel()
+
+	got, err := parseRewriteFlags("rewrite", []string{"^/v1/(.*)$ /api/v1/$1"})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if len(got) != 1 || got[0].From != "^/v1/(.*)$" || got[0].To != "/api/v1/$1" {
+		t.Fatalf("got %#v", got)
+	}
+
+	for _, bad := range []string{"^/v1/", "^/a /b /c", "( /x", "^/a b"} {
+		if _, err := parseRewriteFlags("rewrite", []string{bad}); err == nil {
+			t.Fatalf("%q: err = nil, want error", bad)
+		}
+	}
+}
+
+func TestParseRedirectFlags(t *testing.T) {
+	t.Parallel()
+
+	got, err := parseRedirectFlags("redirect", []string{"^/old$ /new 301", "^/blog/(.*) https://blog.example.com/$1"})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if len(got) != 2 || got[0].Status != 301 || got[1].Status != 0 || got[1].To != "https://blog.example.com/$1" {
+		t.Fatalf("got %#v", got)
+	}
+
+	for _, bad := range []string{"^/old$", "^/old$ /new abc", "^/old$ /new 200"} {
+		if _, err := parseRedirectFlags("redirect", []string{bad}); err == nil {
+			t.Fatalf("%q: err = nil, want error", bad)
+		}
+	}
+}
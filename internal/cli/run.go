@@ -58,6 +58,12 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 		return runTLS(ctx, rest[1:], *configPath, stdout, stderr)
 	case "start":
 		return runStart(ctx, rest[1:], *configPath, stdout, stderr)
+	case "visit":
+		return runVisit(ctx, rest[1:], *configPath, stdout, stderr)
+	case "connect":
+		return runConnect(ctx, rest[1:], *configPath, stdout, stderr)
+	case "share":
+		return runShare(ctx, rest[1:], *configPath, stdout, stderr)
 	default:
 		fmt.Fprintf(stderr, "unknown command: %s\n\n", rest[0])
 		usage(stderr)
@@ -73,6 +79,9 @@ func usage(w io.Writer) {
 	fmt.Fprintln(w, "  tcp       start a TCP tunnel")
 	fmt.Fprintln(w, "  tls       start a TLS tunnel")
 	fmt.Fprintln(w, "  start     start tunnels from config")
+	fmt.Fprintln(w, "  visit     connect to a secret TCP tunnel")
+	fmt.Fprintln(w, "  connect   reach a TCP tunnel over HTTPS (WebSocket)")
+	fmt.Fprintln(w, "  share     mint an expiring share link for a running HTTP tunnel")
 	fmt.Fprintln(w, "  config    manage client config")
 	fmt.Fprintln(w, "  version   print version information")
 	fmt.Fprintln(w, "  help      show help")
@@ -83,6 +92,7 @@ func usage(w io.Writer) {
 	fmt.Fprintln(w, "  EOSRIFT_CONTROL_URL   legacy: full ws(s) control URL")
 	fmt.Fprintln(w, "  EOSRIFT_AUTHTOKEN     client auth token")
 	fmt.Fprintln(w, "  EOSRIFT_INSPECT_ADDR  local inspector listen address")
+	fmt.Fprintln(w, "  EOSRIFT_OTLP_ENDPOINT OpenTelemetry collector for request traces")
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "examples:")
 	fmt.Fprintln(w, "  eosrift config add-authtoken <token>")
@@ -94,6 +104,10 @@ func usage(w io.Writer) {
 	fmt.Fprintln(w, "  eosrift http 3000 --subdomain demo")
 	fmt.Fprintln(w, "  eosrift tcp  5432 --server https://eosrift.com")
 	fmt.Fprintln(w, "  eosrift tls  443  --server https://eosrift.com")
+	fmt.Fprintln(w, "  eosrift tcp  5432 --secret <key> --name db")
+	fmt.Fprintln(w, "  eosrift visit db --secret <key> --local 127.0.0.1:5432")
+	fmt.Fprintln(w, "  eosrift connect tcp://eosrift.com:20001 --local :15432")
+	fmt.Fprintln(w, "  eosrift share --ttl 24h")
 }
 
 func getenv(key, fallback string) string {
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"time"
 
 	"github.com/mattn/go-isatty"
 )
@@ -17,6 +18,9 @@ type sessionOutput struct {
 	ForwardingFrom string
 	ForwardingTo   string
 
+	// ExpiresAt is when the server closes the tunnel; zero for none.
+	ExpiresAt time.Time
+
 	Inspector string
 }
 
@@ -38,6 +42,9 @@ func printSession(w io.Writer, out sessionOutput) {
 	if out.ForwardingFrom != "" {
 		row("Forwarding", fmt.Sprintf("%s %s %s", st.url(out.ForwardingFrom), st.dim("→"), st.dim(out.ForwardingTo)))
 	}
+	if !out.ExpiresAt.IsZero() {
+		row("Expires", out.ExpiresAt.Format("2006-01-02 15:04:05 MST"))
+	}
 	if out.Inspector != "" {
 		row("Inspector", st.url(out.Inspector))
 	}
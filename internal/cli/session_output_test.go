@@ -3,6 +3,7 @@ package cli
 import (
 	"bytes"
 	"testing"
+	"time"
 )
 
 func TestPrintSession_HTTP_Golden(t *testing.T) {
@@ -32,6 +33,7 @@ func TestPrintSession_TCP_Golden(t *testing.T) {
 		Status:         "online",
 		ForwardingFrom: "tcp://example.com:20001",
 		ForwardingTo:   "localhost:5432",
+		ExpiresAt:      time.Date(2026, 3, 10, 17, 30, 0, 0, time.UTC),
 	})
 
 	want := readGolden(t, "session_tcp.txt")
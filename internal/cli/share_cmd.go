@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"eosrift.com/eosrift/internal/config"
+	"eosrift.com/eosrift/internal/inspect"
+)
+
+func runShare(ctx context.Context, args []string, configPath string, stdout, stderr io.Writer) int {
+	cfg, _, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	fs := flag.NewFlagSet("share", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	ttl := fs.Duration("ttl", 24*time.Hour, "How long the link stays valid (max 720h)")
+	path := fs.String("path", "", "Limit the link to this path and below (e.g. /docs/)")
+	inspectAddr := fs.String("inspect-addr", resolveInspectAddrDefault(cfg), "Inspector address of the running tunnel")
+	help := fs.Bool("help", false, "Show help")
+	fs.BoolVar(help, "h", false, "Show help")
+
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "usage: eosrift share [flags] [<tunnel>]")
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "Mints a signed, expiring link for a running HTTP tunnel started with")
+		fmt.Fprintln(out, "--share-links (or share_links: true). <tunnel> is a named tunnel or a")
+		fmt.Fprintln(out, "tunnel id and may be omitted when only one tunnel is running.")
+		fmt.Fprintln(out, "")
+		fs.PrintDefaults()
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "examples:")
+		fmt.Fprintln(out, "  eosrift share --ttl 24h")
+		fmt.Fprintln(out, "  eosrift share web --ttl 2h --path /docs/")
+	}
+
+	if err := parseInterspersedFlags(fs, args); err != nil {
+		return 2
+	}
+	if *help {
+		fs.SetOutput(stdout)
+		fs.Usage()
+		return 0
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	if *ttl <= 0 {
+		fmt.Fprintln(stderr, "error: --ttl must be positive")
+		return 2
+	}
+	if p := strings.TrimSpace(*path); p != "" && !strings.HasPrefix(p, "/") {
+		fmt.Fprintln(stderr, "error: --path must start with /")
+		return 2
+	}
+
+	u, expires, err := requestShareLink(ctx, *inspectAddr, fs.Arg(0), *ttl, strings.TrimSpace(*path))
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	fmt.Fprintln(stdout, u)
+	fmt.Fprintln(stderr, "expires:", expires.Local().Format(time.RFC1123))
+	return 0
+}
+
+// requestShareLink asks the local inspector of a running eosrift process to
+// mint a share link over its tunnel's control session.
+func requestShareLink(ctx context.Context, inspectAddr, tunnel string, ttl time.Duration, path string) (string, time.Time, error) {
+	host, port, err := net.SplitHostPort(strings.TrimSpace(inspectAddr))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid inspect addr %q: %v", inspectAddr, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"tunnel": tunnel,
+		"ttl":    ttl.String(),
+		"path":   path,
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+net.JoinHostPort(host, port)+"/api/share", bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("no running tunnel inspector at %s (is the inspector enabled?): %v", inspectAddr, err)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+		Error     string    `json:"error"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(b, &out); err != nil {
+		return "", time.Time{}, fmt.Errorf("inspector: %s", strings.TrimSpace(string(b)))
+	}
+	if out.Error != "" {
+		return "", time.Time{}, errors.New(out.Error)
+	}
+	if resp.StatusCode != http.StatusOK || out.URL == "" {
+		return "", time.Time{}, fmt.Errorf("inspector: unexpected status %d", resp.StatusCode)
+	}
+	return out.URL, out.ExpiresAt, nil
+}
+
+// shareLinker is implemented by *client.HTTPTunnel.
+type shareLinker interface {
+	ShareLink(ctx context.Context, ttl time.Duration, path string) (string, time.Time, error)
+}
+
+// shareTargets maps tunnel names and ids to running HTTP tunnels for the
+// inspector's share endpoint.
+type shareTargets struct {
+	mu sync.RWMutex
+	m  map[string]shareLinker
+	n  int
+}
+
+func (t *shareTargets) Set(name, id string, tun shareLinker) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.m == nil {
+		t.m = make(map[string]shareLinker)
+	}
+	if name != "" {
+		t.m[name] = tun
+	}
+	t.m[id] = tun
+	t.n++
+}
+
+func (t *shareTargets) get(key string) (shareLinker, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if key == "" {
+		switch {
+		case t.n == 0:
+			return nil, errors.New("no http tunnels are running")
+		case t.n > 1:
+			return nil, errors.New("several tunnels are running; name one")
+		}
+		for _, tun := range t.m {
+			return tun, nil
+		}
+	}
+	tun, ok := t.m[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown tunnel %q", key)
+	}
+	return tun, nil
+}
+
+func (t *shareTargets) ShareFunc() func(ctx context.Context, req inspect.ShareRequest) (inspect.ShareResult, error) {
+	return func(ctx context.Context, req inspect.ShareRequest) (inspect.ShareResult, error) {
+		tun, err := t.get(req.Tunnel)
+		if err != nil {
+			return inspect.ShareResult{}, err
+		}
+		u, exp, err := tun.ShareLink(ctx, req.TTL, req.Path)
+		if err != nil {
+			return inspect.ShareResult{}, err
+		}
+		return inspect.ShareResult{URL: u, ExpiresAt: exp}, nil
+	}
+}
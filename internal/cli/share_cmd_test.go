@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/inspect"
+)
+
+type fakeShareLinker struct {
+	url string
+}
+
+func (f fakeShareLinker) ShareLink(ctx context.Context, ttl time.Duration, path string) (string, time.Time, error) {
+	return f.url + path + "?ttl=" + ttl.String(), time.Unix(1_700_000_000, 0), nil
+}
+
+func TestShareTargets(t *testing.T) {
+	t.Parallel()
+
+	var targets shareTargets
+	if _, err := targets.get(""); err == nil {
+		t.Fatalf("expected error with no tunnels")
+	}
+
+	targets.Set("web", "abcd1234", fakeShareLinker{url: "https://web"})
+	if _, err := targets.get(""); err != nil {
+		t.Fatalf("single tunnel: %v", err)
+	}
+
+	targets.Set("api", "efgh5678", fakeShareLinker{url: "https://api"})
+	if _, err := targets.get(""); err == nil {
+		t.Fatalf("expected error with several tunnels")
+	}
+	for key, want := range map[string]string{"web": "https://web", "abcd1234": "https://web", "api": "https://api"} {
+		tun, err := targets.get(key)
+		if err != nil || tun.(fakeShareLinker).url != want {
+			t.Fatalf("get(%q) = %v, %v", key, tun, err)
+		}
+	}
+	if _, err := targets.get("nope"); err == nil {
+		t.Fatalf("expected unknown tunnel error")
+	}
+}
+
+func TestRequestShareLink(t *testing.T) {
+	t.Parallel()
+
+	var targets shareTargets
+	targets.Set("web", "abcd1234", fakeShareLinker{url: "https://web"})
+
+	ts := httptest.NewServer(inspect.Handler(inspect.NewStore(inspect.StoreConfig{MaxEntries: 1}), inspect.HandlerOptions{
+		Share: targets.ShareFunc(),
+	}))
+	t.Cleanup(ts.Close)
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	u, exp, err := requestShareLink(context.Background(), addr, "web", 2*time.Hour, "/docs/")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if u != "https://web/docs/?ttl=2h0m0s" || exp.Unix() != 1_700_000_000 {
+		t.Fatalf("got %q, %v", u, exp)
+	}
+
+	if _, _, err := requestShareLink(context.Background(), addr, "nope", time.Hour, ""); err == nil || !strings.Contains(err.Error(), "unknown tunnel") {
+		t.Fatalf("err = %v, want unknown tunnel", err)
+	}
+}
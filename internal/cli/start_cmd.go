@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -18,6 +19,7 @@ import (
 	"eosrift.com/eosrift/internal/config"
 	"eosrift.com/eosrift/internal/control"
 	"eosrift.com/eosrift/internal/inspect"
+	"eosrift.com/eosrift/internal/tracing"
 )
 
 func runStart(ctx context.Context, args []string, configPath string, stdout, stderr io.Writer) int {
@@ -106,6 +108,19 @@ func runStart(ctx context.Context, args []string, configPath string, stdout, std
 			fmt.Fprintln(stderr, "error: unknown tunnel:", name)
 			return 2
 		}
+		if f := strings.TrimSpace(t.TrafficPolicyFile); f != "" && !filepath.IsAbs(f) {
+			t.TrafficPolicyFile = filepath.Join(filepath.Dir(configPath), f)
+		}
+		if f := strings.TrimSpace(t.BasicAuthFile); f != "" && !filepath.IsAbs(f) {
+			t.BasicAuthFile = filepath.Join(filepath.Dir(configPath), f)
+		}
+		if t.JWT != nil {
+			jwt := *t.JWT
+			if f := strings.TrimSpace(jwt.JWKSFile); f != "" && !filepath.IsAbs(f) {
+				jwt.JWKSFile = filepath.Join(filepath.Dir(configPath), f)
+			}
+			t.JWT = &jwt
+		}
 		selected = append(selected, namedTunnel{
 			Name:   name,
 			Tunnel: t,
@@ -134,6 +149,7 @@ func runStart(ctx context.Context, args []string, configPath string, stdout, std
 	}
 
 	replayMap := replayTargets{}
+	var shares shareTargets
 
 	var (
 		store         *inspect.Store
@@ -144,7 +160,10 @@ func runStart(ctx context.Context, args []string, configPath string, stdout, std
 	if needsInspector(selected, inspectorCfg.Enabled) {
 		store = inspect.NewStore(inspect.StoreConfig{MaxEntries: 200})
 
-		u, stop, err := startInspectorServer(ctx, store, inspectorCfg.Addr, replayMap.ReplayFunc())
+		u, stop, err := startInspectorServer(ctx, store, inspectorCfg.Addr, inspect.HandlerOptions{
+			Replay: replayMap.ReplayFunc(),
+			Share:  shares.ShareFunc(),
+		})
 		if err != nil {
 			fmt.Fprintln(stderr, "warning: inspector disabled:", err)
 			store = nil
@@ -157,7 +176,14 @@ func runStart(ctx context.Context, args []string, configPath string, stdout, std
 		defer stopInspector()
 	}
 
-	started, err := startNamedTunnels(ctx, controlURL, *authtoken, defaultHostHeader, selected, inspectorCfg.Enabled, store, &replayMap, *upstreamTLSSkipVerify)
+	tracer, stopTracer, err := openTracer(resolveOTLPEndpoint(cfg))
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	defer stopTracer()
+
+	started, err := startNamedTunnels(ctx, controlURL, *authtoken, defaultHostHeader, selected, inspectorCfg.Enabled, store, tracer, &replayMap, &shares, *upstreamTLSSkipVerify)
 	if err != nil {
 		fmt.Fprintln(stderr, "error:", err)
 		return 1
@@ -207,6 +233,12 @@ func validateNamedTunnels(tunnels []namedTunnel) error {
 		if addr == "" {
 			return fmt.Errorf("tunnel %q: addr is required", t.Name)
 		}
+		if _, err := parseExpiry(t.Tunnel.Expires, t.Tunnel.Until, time.Now()); err != nil {
+			return fmt.Errorf("tunnel %q: %w", t.Name, err)
+		}
+		if _, err := control.ValidateBandwidthLimits(t.Tunnel.Bandwidth); err != nil {
+			return fmt.Errorf("tunnel %q: %w", t.Name, err)
+		}
 
 		switch proto {
 		case "http":
@@ -219,12 +251,44 @@ func validateNamedTunnels(tunnels []namedTunnel) error {
 			if domain != "" && subdomain != "" {
 				return fmt.Errorf("tunnel %q: only one of domain or subdomain may be set", t.Name)
 			}
-			if basicAuth := strings.TrimSpace(t.Tunnel.BasicAuth); basicAuth != "" && !strings.Contains(basicAuth, ":") {
-				return fmt.Errorf("tunnel %q: basic_auth must be in the form user:pass", t.Name)
+			basicAuthUsers, err := resolveBasicAuthUsers("basic_auth", configBasicAuthValues(t.Tunnel), t.Tunnel.BasicAuthFile)
+			if err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			jwtAuth, err := resolveConfigJWTAuth(t.Tunnel.JWT)
+			if err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			if jwtAuth != nil && len(basicAuthUsers) > 0 {
+				return fmt.Errorf("tunnel %q: jwt cannot be combined with basic_auth", t.Name)
+			}
+			if _, err := control.ValidateCORSPolicy(t.Tunnel.CORS); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			if _, err := control.ValidateCompressionPolicy(t.Tunnel.Compression); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			if _, err := control.ValidateCachePolicy(t.Tunnel.Cache); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			if _, err := control.ValidateHTTPLimits(t.Tunnel.Limits); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			if _, err := control.ValidateMirrorPolicy(t.Tunnel.Mirror); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
 			}
 			if _, err := control.ParseHTTPMethodList("allow_method", t.Tunnel.AllowMethod, 0); err != nil {
 				return fmt.Errorf("tunnel %q: %w", t.Name, err)
 			}
+			if _, _, err := control.ParseRateLimit(t.Tunnel.RateLimit, t.Tunnel.RateLimitHeader); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			if _, err := control.ParseRedirectRules("redirect", t.Tunnel.Redirect, 0); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			if _, err := control.ParseRewriteRules("rewrite", t.Tunnel.Rewrite, 0); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
 			if _, err := control.ParsePathList("allow_path", t.Tunnel.AllowPath, 0); err != nil {
 				return fmt.Errorf("tunnel %q: %w", t.Name, err)
 			}
@@ -249,9 +313,15 @@ func validateNamedTunnels(tunnels []namedTunnel) error {
 			if _, err := parseHeaderRemoveList("response_header_remove", t.Tunnel.ResponseHeaderRemove); err != nil {
 				return fmt.Errorf("tunnel %q: %w", t.Name, err)
 			}
+			if _, err := resolveTrafficPolicy(t.Tunnel.TrafficPolicy, t.Tunnel.TrafficPolicyFile); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
 			if t.Tunnel.RemotePort != 0 {
 				return fmt.Errorf("tunnel %q: remote_port is only valid for tcp tunnels", t.Name)
 			}
+			if t.Tunnel.Secret != "" || t.Tunnel.SecretName != "" {
+				return fmt.Errorf("tunnel %q: secret is only valid for tcp tunnels", t.Name)
+			}
 		case "tcp":
 			if _, err := parseTCPUpstreamAddr(addr); err != nil {
 				return fmt.Errorf("tunnel %q: invalid addr %q: %v", t.Name, addr, err)
@@ -259,30 +329,69 @@ func validateNamedTunnels(tunnels []namedTunnel) error {
 			if t.Tunnel.RemotePort < 0 {
 				return fmt.Errorf("tunnel %q: remote_port must be >= 0", t.Name)
 			}
+			if t.Tunnel.Secret != "" && t.Tunnel.RemotePort != 0 {
+				return fmt.Errorf("tunnel %q: remote_port is not valid for secret tunnels", t.Name)
+			}
+			if t.Tunnel.Secret == "" && t.Tunnel.SecretName != "" {
+				return fmt.Errorf("tunnel %q: secret_name requires secret", t.Name)
+			}
+			if t.Tunnel.Secret != "" && (len(t.Tunnel.AllowCIDR) != 0 || len(t.Tunnel.DenyCIDR) != 0) {
+				return fmt.Errorf("tunnel %q: allow_cidr/deny_cidr are not valid for secret tunnels", t.Name)
+			}
+			if err := validateCIDRs("allow_cidr", t.Tunnel.AllowCIDR); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			if err := validateCIDRs("deny_cidr", t.Tunnel.DenyCIDR); err != nil {
+				return fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
 			if strings.TrimSpace(t.Tunnel.Domain) != "" {
 				return fmt.Errorf("tunnel %q: domain is only valid for http tunnels", t.Name)
 			}
 			if strings.TrimSpace(t.Tunnel.Subdomain) != "" {
 				return fmt.Errorf("tunnel %q: subdomain is only valid for http tunnels", t.Name)
 			}
-			if strings.TrimSpace(t.Tunnel.BasicAuth) != "" {
+			if strings.TrimSpace(t.Tunnel.BasicAuth) != "" || len(t.Tunnel.BasicAuthUsers) != 0 || strings.TrimSpace(t.Tunnel.BasicAuthFile) != "" {
 				return fmt.Errorf("tunnel %q: basic_auth is only valid for http tunnels", t.Name)
 			}
+			if t.Tunnel.ShareLinks {
+				return fmt.Errorf("tunnel %q: share_links is only valid for http tunnels", t.Name)
+			}
+			if t.Tunnel.JWT != nil {
+				return fmt.Errorf("tunnel %q: jwt is only valid for http tunnels", t.Name)
+			}
+			if t.Tunnel.CORS != nil {
+				return fmt.Errorf("tunnel %q: cors is only valid for http tunnels", t.Name)
+			}
+			if t.Tunnel.Compression != nil {
+				return fmt.Errorf("tunnel %q: compression is only valid for http tunnels", t.Name)
+			}
+			if t.Tunnel.Cache != nil {
+				return fmt.Errorf("tunnel %q: cache is only valid for http tunnels", t.Name)
+			}
+			if t.Tunnel.Limits != nil {
+				return fmt.Errorf("tunnel %q: limits is only valid for http tunnels", t.Name)
+			}
+			if t.Tunnel.Mirror != nil {
+				return fmt.Errorf("tunnel %q: mirror is only valid for http tunnels", t.Name)
+			}
 			if len(t.Tunnel.AllowMethod) != 0 {
 				return fmt.Errorf("tunnel %q: allow_method is only valid for http tunnels", t.Name)
 			}
+			if strings.TrimSpace(t.Tunnel.RateLimit) != "" || strings.TrimSpace(t.Tunnel.RateLimitHeader) != "" {
+				return fmt.Errorf("tunnel %q: rate_limit is only valid for http tunnels", t.Name)
+			}
+			if len(t.Tunnel.Redirect) != 0 {
+				return fmt.Errorf("tunnel %q: redirect is only valid for http tunnels", t.Name)
+			}
+			if len(t.Tunnel.Rewrite) != 0 {
+				return fmt.Errorf("tunnel %q: rewrite is only valid for http tunnels", t.Name)
+			}
 			if len(t.Tunnel.AllowPath) != 0 {
 				return fmt.Errorf("tunnel %q: allow_path is only valid for http tunnels", t.Name)
 			}
 			if len(t.Tunnel.AllowPathPrefix) != 0 {
 				return fmt.Errorf("tunnel %q: allow_path_prefix is only valid for http tunnels", t.Name)
 			}
-			if len(t.Tunnel.AllowCIDR) != 0 {
-				return fmt.Errorf("tunnel %q: allow_cidr is only valid for http tunnels", t.Name)
-			}
-			if len(t.Tunnel.DenyCIDR) != 0 {
-				return fmt.Errorf("tunnel %q: deny_cidr is only valid for http tunnels", t.Name)
-			}
 			if len(t.Tunnel.RequestHeaderAdd) != 0 {
 				return fmt.Errorf("tunnel %q: request_header_add is only valid for http tunnels", t.Name)
 			}
@@ -298,6 +407,9 @@ func validateNamedTunnels(tunnels []namedTunnel) error {
 			if strings.TrimSpace(t.Tunnel.HostHeader) != "" {
 				return fmt.Errorf("tunnel %q: host_header is only valid for http tunnels", t.Name)
 			}
+			if t.Tunnel.TrafficPolicy != nil || strings.TrimSpace(t.Tunnel.TrafficPolicyFile) != "" {
+				return fmt.Errorf("tunnel %q: traffic_policy is only valid for http tunnels", t.Name)
+			}
 		default:
 			return fmt.Errorf("tunnel %q: unsupported proto %q", t.Name, proto)
 		}
@@ -316,6 +428,7 @@ type startedTunnel struct {
 
 	ForwardingFrom string
 	ForwardingTo   string
+	ExpiresAt      time.Time
 
 	wait  func() error
 	close func() error
@@ -358,6 +471,9 @@ func printStartSession(w io.Writer, out startSessionOutput) {
 		}
 		row("Tunnel", st.dim(t.Name))
 		row("Forwarding", fmt.Sprintf("%s %s %s", st.url(t.ForwardingFrom), st.dim("→"), st.dim(t.ForwardingTo)))
+		if !t.ExpiresAt.IsZero() {
+			row("Expires", t.ExpiresAt.Format("2006-01-02 15:04:05 MST"))
+		}
 	}
 }
 
@@ -378,7 +494,7 @@ func needsInspector(tunnels []namedTunnel, inspectDefault bool) bool {
 	return false
 }
 
-func startInspectorServer(ctx context.Context, store *inspect.Store, addr string, replay func(ctx context.Context, entry inspect.Entry) (inspect.ReplayResult, error)) (string, func(), error) {
+func startInspectorServer(ctx context.Context, store *inspect.Store, addr string, opts inspect.HandlerOptions) (string, func(), error) {
 	ln, err := listenTCPWithPortFallback(addr, 5000)
 	if err != nil {
 		return "", nil, err
@@ -387,9 +503,7 @@ func startInspectorServer(ctx context.Context, store *inspect.Store, addr string
 	inspectorURL := "http://" + displayHostPort(ln.Addr().String())
 
 	srv := &http.Server{
-		Handler: inspect.Handler(store, inspect.HandlerOptions{
-			Replay: replay,
-		}),
+		Handler: inspect.Handler(store, opts),
 	}
 
 	var once sync.Once
@@ -412,7 +526,7 @@ func startInspectorServer(ctx context.Context, store *inspect.Store, addr string
 	return inspectorURL, stop, nil
 }
 
-func startNamedTunnels(ctx context.Context, controlURL, authtoken, defaultHostHeader string, tunnels []namedTunnel, inspectDefault bool, store *inspect.Store, replayMap *replayTargets, upstreamTLSSkipVerify bool) ([]startedTunnel, error) {
+func startNamedTunnels(ctx context.Context, controlURL, authtoken, defaultHostHeader string, tunnels []namedTunnel, inspectDefault bool, store *inspect.Store, tracer *tracing.Tracer, replayMap *replayTargets, shares *shareTargets, upstreamTLSSkipVerify bool) ([]startedTunnel, error) {
 	var started []startedTunnel
 
 	for _, t := range tunnels {
@@ -425,6 +539,14 @@ func startNamedTunnels(ctx context.Context, controlURL, authtoken, defaultHostHe
 		if proto == "" {
 			return nil, fmt.Errorf("tunnel %q: proto is required (http|tcp)", t.Name)
 		}
+		expiresAt, err := parseExpiry(t.Tunnel.Expires, t.Tunnel.Until, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+		}
+		bandwidth, err := control.ValidateBandwidthLimits(t.Tunnel.Bandwidth)
+		if err != nil {
+			return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+		}
 
 		switch proto {
 		case "http":
@@ -463,6 +585,20 @@ func startNamedTunnels(ctx context.Context, controlURL, authtoken, defaultHostHe
 				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
 			}
 
+			rateLimit, rateLimitHeader, err := control.ParseRateLimit(t.Tunnel.RateLimit, t.Tunnel.RateLimitHeader)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+
+			redirects, err := control.ParseRedirectRules("redirect", t.Tunnel.Redirect, 0)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			rewrites, err := control.ParseRewriteRules("rewrite", t.Tunnel.Rewrite, 0)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+
 			allowMethods, err := control.ParseHTTPMethodList("allow_method", t.Tunnel.AllowMethod, 0)
 			if err != nil {
 				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
@@ -476,20 +612,67 @@ func startNamedTunnels(ctx context.Context, controlURL, authtoken, defaultHostHe
 				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
 			}
 
+			trafficPolicy, err := resolveTrafficPolicy(t.Tunnel.TrafficPolicy, t.Tunnel.TrafficPolicyFile)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			basicAuthUsers, err := resolveBasicAuthUsers("basic_auth", configBasicAuthValues(t.Tunnel), t.Tunnel.BasicAuthFile)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			jwtAuth, err := resolveConfigJWTAuth(t.Tunnel.JWT)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			cors, err := control.ValidateCORSPolicy(t.Tunnel.CORS)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			compression, err := control.ValidateCompressionPolicy(t.Tunnel.Compression)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			cache, err := control.ValidateCachePolicy(t.Tunnel.Cache)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			limits, err := control.ValidateHTTPLimits(t.Tunnel.Limits)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+			mirror, err := control.ValidateMirrorPolicy(t.Tunnel.Mirror)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
+			}
+
 			tun, err := client.StartHTTPTunnelWithOptions(ctx, controlURL, localAddr, client.HTTPTunnelOptions{
 				Authtoken:             authtoken,
 				Domain:                strings.TrimSpace(t.Tunnel.Domain),
 				Subdomain:             strings.TrimSpace(t.Tunnel.Subdomain),
-				BasicAuth:             strings.TrimSpace(t.Tunnel.BasicAuth),
+				BasicAuthUsers:        basicAuthUsers,
+				ShareLinks:            t.Tunnel.ShareLinks,
+				JWT:                   jwtAuth,
+				CORS:                  cors,
+				Compression:           compression,
+				Cache:                 cache,
+				Limits:                limits,
+				Mirror:                mirror,
+				Bandwidth:             bandwidth,
+				ExpiresAt:             expiresAt,
 				AllowMethods:          allowMethods,
 				AllowPaths:            allowPaths,
 				AllowPathPrefixes:     allowPathPrefixes,
 				AllowCIDRs:            t.Tunnel.AllowCIDR,
 				DenyCIDRs:             t.Tunnel.DenyCIDR,
+				RateLimit:             rateLimit,
+				RateLimitHeader:       rateLimitHeader,
+				Redirects:             redirects,
+				Rewrites:              rewrites,
 				RequestHeaderAdd:      requestHeaderAdd,
 				RequestHeaderRemove:   requestHeaderRemove,
 				ResponseHeaderAdd:     responseHeaderAdd,
 				ResponseHeaderRemove:  responseHeaderRemove,
+				TrafficPolicy:         trafficPolicy,
 				HostHeader:            hostHeader,
 				UpstreamScheme:        upstreamScheme,
 				UpstreamTLSSkipVerify: upstreamTLSSkipVerify,
@@ -499,6 +682,7 @@ func startNamedTunnels(ctx context.Context, controlURL, authtoken, defaultHostHe
 					}
 					return nil
 				}(),
+				Tracer: tracer,
 			})
 			if err != nil {
 				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
@@ -512,10 +696,15 @@ func startNamedTunnels(ctx context.Context, controlURL, authtoken, defaultHostHe
 				})
 			}
 
+			if shares != nil {
+				shares.Set(t.Name, tun.ID, tun)
+			}
+
 			started = append(started, startedTunnel{
 				Name:           t.Name,
 				ForwardingFrom: tun.URL,
 				ForwardingTo:   displayHostPort(localAddr),
+				ExpiresAt:      tun.ExpiresAt,
 				wait:           tun.Wait,
 				close:          tun.Close,
 			})
@@ -531,16 +720,26 @@ func startNamedTunnels(ctx context.Context, controlURL, authtoken, defaultHostHe
 			tun, err := client.StartTCPTunnelWithOptions(ctx, controlURL, localAddr, client.TCPTunnelOptions{
 				Authtoken:  authtoken,
 				RemotePort: t.Tunnel.RemotePort,
+				Secret:     t.Tunnel.Secret,
+				SecretName: t.Tunnel.SecretName,
+				AllowCIDR:  t.Tunnel.AllowCIDR,
+				DenyCIDR:   t.Tunnel.DenyCIDR,
+				Bandwidth:  bandwidth,
+				ExpiresAt:  expiresAt,
 			})
 			if err != nil {
 				return nil, fmt.Errorf("tunnel %q: %w", t.Name, err)
 			}
 
-			host := controlHost(controlURL)
+			forwardingFrom := fmt.Sprintf("tcp://%s:%d", controlHost(controlURL), tun.RemotePort)
+			if tun.Name != "" {
+				forwardingFrom = "secret://" + tun.Name
+			}
 			started = append(started, startedTunnel{
 				Name:           t.Name,
-				ForwardingFrom: fmt.Sprintf("tcp://%s:%d", host, tun.RemotePort),
+				ForwardingFrom: forwardingFrom,
 				ForwardingTo:   displayHostPort(localAddr),
+				ExpiresAt:      tun.ExpiresAt,
 				wait:           tun.Wait,
 				close:          tun.Close,
 			})
@@ -572,6 +771,10 @@ func waitAll(ctx context.Context, tunnels []startedTunnel) error {
 			}
 			return ctx.Err()
 		case err := <-errCh:
+			// An expired tunnel ends on its own; the others keep running.
+			if tunnelExpired(err) {
+				continue
+			}
 			if firstErr == nil {
 				firstErr = err
 				for _, t := range tunnels {
@@ -162,6 +162,34 @@ func TestRun_Start_HTTPRemotePort_IsError(t *testing.T) {
 	}
 }
 
+func TestRun_Start_TCPSecretWithRemotePort_IsError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eosrift.yml")
+
+	if err := config.Save(path, config.File{
+		Version: 1,
+		Tunnels: map[string]config.Tunnel{
+			"db": {Proto: "tcp", Addr: "5432", RemotePort: 20005, Secret: "s3cret"},
+		},
+	}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	code := Run(ctx, []string{"--config", path, "start", "--inspect=false", "db"}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("code = %d, want %d (stderr=%q)", code, 1, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "not valid for secret tunnels") {
+		t.Fatalf("stderr missing secret error: %q", stderr.String())
+	}
+}
+
 func TestRun_Start_TCPDomain_IsError(t *testing.T) {
 	t.Parallel()
 
@@ -285,3 +313,34 @@ func TestRun_Start_TCPAllowMethod_IsError(t *testing.T) {
 		t.Fatalf("stderr missing allow_method error: %q", stderr.String())
 	}
 }
+
+func TestRun_Start_ExpiresAndUntil_IsError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eosrift.yml")
+
+	if err := config.Save(path, config.File{
+		Version: 1,
+		Tunnels: map[string]config.Tunnel{
+			"db": {Proto: "tcp", Addr: "5432", Expires: "2h", Until: "18:00"},
+		},
+	}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	code := Run(ctx, []string{"--config", path, "start", "--inspect=false", "db"}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("code = %d, want %d (stderr=%q)", code, 1, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("stdout not empty: %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "expires and until are mutually exclusive") {
+		t.Fatalf("stderr missing expires error: %q", stderr.String())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"eosrift.com/eosrift/internal/client"
 	"eosrift.com/eosrift/internal/config"
@@ -28,6 +29,17 @@ func runTCP(ctx context.Context, args []string, configPath string, stdout, stder
 	serverAddr := fs.String("server", serverDefault, "Server address (https://host, http://host:port, or ws(s)://host/control)")
 	authtoken := fs.String("authtoken", authtokenDefault, "Auth token")
 	remotePort := fs.Int("remote-port", 0, "Request a specific remote port (must be within the server's TCP port range)")
+	secret := fs.String("secret", "", "Create a secret tunnel (no public port) reachable only via `eosrift visit` with this key")
+	name := fs.String("name", "", "Secret tunnel name (default: server-assigned; requires --secret)")
+	var allowCIDR stringSliceFlag
+	fs.Var(&allowCIDR, "allow-cidr", "Allow client IPs matching CIDR or IP (repeatable)")
+	var denyCIDR stringSliceFlag
+	fs.Var(&denyCIDR, "deny-cidr", "Deny client IPs matching CIDR or IP (repeatable)")
+	expires := fs.String("expires", "", "Close the tunnel after this long (e.g. 2h)")
+	until := fs.String("until", "", "Close the tunnel at this time (RFC 3339, \"2006-01-02 15:04\" or \"15:04\")")
+	bandwidthIn := fs.String("bandwidth-in", "", "Pace traffic from visitors to the upstream (e.g. 1MB/s)")
+	bandwidthOut := fs.String("bandwidth-out", "", "Pace traffic from the upstream to visitors (e.g. 5MB/s)")
+	bandwidthBurst := fs.String("bandwidth-burst", "", "Bytes allowed at full speed before pacing starts (default: one second's worth)")
 	help := fs.Bool("help", false, "Show help")
 	fs.BoolVar(help, "h", false, "Show help")
 
@@ -50,6 +62,37 @@ func runTCP(ctx context.Context, args []string, configPath string, stdout, stder
 		return 2
 	}
 
+	if *secret == "" && *name != "" {
+		fmt.Fprintln(stderr, "error: --name requires --secret")
+		return 2
+	}
+	if *secret != "" && *remotePort != 0 {
+		fmt.Fprintln(stderr, "error: --remote-port is not valid with --secret")
+		return 2
+	}
+	if *secret != "" && (len(allowCIDR) != 0 || len(denyCIDR) != 0) {
+		fmt.Fprintln(stderr, "error: --allow-cidr/--deny-cidr are not valid with --secret")
+		return 2
+	}
+	if err := validateCIDRs("allow_cidr", []string(allowCIDR)); err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	if err := validateCIDRs("deny_cidr", []string(denyCIDR)); err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	expiresAt, err := parseExpiry(*expires, *until, time.Now())
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	bandwidth, err := parseBandwidthFlags(*bandwidthIn, *bandwidthOut, *bandwidthBurst)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+
 	localAddr := fs.Arg(0)
 	if !strings.Contains(localAddr, ":") {
 		localAddr = "127.0.0.1:" + localAddr
@@ -64,6 +107,12 @@ func runTCP(ctx context.Context, args []string, configPath string, stdout, stder
 	tunnel, err := client.StartTCPTunnelWithOptions(ctx, controlURL, localAddr, client.TCPTunnelOptions{
 		Authtoken:  *authtoken,
 		RemotePort: *remotePort,
+		Secret:     *secret,
+		SecretName: *name,
+		AllowCIDR:  []string(allowCIDR),
+		DenyCIDR:   []string(denyCIDR),
+		Bandwidth:  bandwidth,
+		ExpiresAt:  expiresAt,
 	})
 	if err != nil {
 		fmt.Fprintln(stderr, "error:", err)
@@ -71,18 +120,26 @@ func runTCP(ctx context.Context, args []string, configPath string, stdout, stder
 	}
 	defer tunnel.Close()
 
-	host := controlHost(controlURL)
+	forwardingFrom := fmt.Sprintf("tcp://%s:%d", controlHost(controlURL), tunnel.RemotePort)
+	if tunnel.Name != "" {
+		forwardingFrom = "secret://" + tunnel.Name
+	}
 	printSession(stdout, sessionOutput{
 		Version:        version,
 		Status:         "online",
-		ForwardingFrom: fmt.Sprintf("tcp://%s:%d", host, tunnel.RemotePort),
+		ForwardingFrom: forwardingFrom,
 		ForwardingTo:   displayHostPort(localAddr),
+		ExpiresAt:      tunnel.ExpiresAt,
 	})
 
 	if err := tunnel.Wait(); err != nil && !errors.Is(err, context.Canceled) {
 		if ctx.Err() != nil {
 			return 0
 		}
+		if tunnelExpired(err) {
+			fmt.Fprintln(stderr, "Tunnel expired; closed by the server.")
+			return 0
+		}
 		fmt.Fprintln(stderr, "error:", err)
 		return 1
 	}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"eosrift.com/eosrift/internal/client"
 	"eosrift.com/eosrift/internal/config"
@@ -28,6 +29,11 @@ func runTLS(ctx context.Context, args []string, configPath string, stdout, stder
 	serverAddr := fs.String("server", serverDefault, "Server address (https://host, http://host:port, or ws(s)://host/control)")
 	authtoken := fs.String("authtoken", authtokenDefault, "Auth token")
 	remotePort := fs.Int("remote-port", 0, "Request a specific remote port (must be within the server's TCP port range)")
+	expires := fs.String("expires", "", "Close the tunnel after this long (e.g. 2h)")
+	until := fs.String("until", "", "Close the tunnel at this time (RFC 3339, \"2006-01-02 15:04\" or \"15:04\")")
+	bandwidthIn := fs.String("bandwidth-in", "", "Pace traffic from visitors to the upstream (e.g. 1MB/s)")
+	bandwidthOut := fs.String("bandwidth-out", "", "Pace traffic from the upstream to visitors (e.g. 5MB/s)")
+	bandwidthBurst := fs.String("bandwidth-burst", "", "Bytes allowed at full speed before pacing starts (default: one second's worth)")
 	help := fs.Bool("help", false, "Show help")
 	fs.BoolVar(help, "h", false, "Show help")
 
@@ -50,6 +56,17 @@ func runTLS(ctx context.Context, args []string, configPath string, stdout, stder
 		return 2
 	}
 
+	expiresAt, err := parseExpiry(*expires, *until, time.Now())
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+	bandwidth, err := parseBandwidthFlags(*bandwidthIn, *bandwidthOut, *bandwidthBurst)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 2
+	}
+
 	localAddr := fs.Arg(0)
 	if !strings.Contains(localAddr, ":") {
 		localAddr = "127.0.0.1:" + localAddr
@@ -64,6 +81,8 @@ func runTLS(ctx context.Context, args []string, configPath string, stdout, stder
 	tunnel, err := client.StartTCPTunnelWithOptions(ctx, controlURL, localAddr, client.TCPTunnelOptions{
 		Authtoken:  *authtoken,
 		RemotePort: *remotePort,
+		Bandwidth:  bandwidth,
+		ExpiresAt:  expiresAt,
 	})
 	if err != nil {
 		fmt.Fprintln(stderr, "error:", err)
@@ -77,12 +96,17 @@ func runTLS(ctx context.Context, args []string, configPath string, stdout, stder
 		Status:         "online",
 		ForwardingFrom: fmt.Sprintf("tls://%s:%d", host, tunnel.RemotePort),
 		ForwardingTo:   displayHostPort(localAddr),
+		ExpiresAt:      tunnel.ExpiresAt,
 	})
 
 	if err := tunnel.Wait(); err != nil && !errors.Is(err, context.Canceled) {
 		if ctx.Err() != nil {
 			return 0
 		}
+		if tunnelExpired(err) {
+			fmt.Fprintln(stderr, "Tunnel expired; closed by the server.")
+			return 0
+		}
 		fmt.Fprintln(stderr, "error:", err)
 		return 1
 	}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"eosrift.com/eosrift/internal/control"
+	"eosrift.com/eosrift/internal/policy"
+	"gopkg.in/yaml.v3"
+)
+
+const maxTrafficPolicyFileBytes = 256 * 1024
+
+// loadTrafficPolicyFile reads a traffic policy from a YAML or JSON file.
+func loadTrafficPolicyFile(path string) (*control.TrafficPolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, maxTrafficPolicyFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxTrafficPolicyFileBytes {
+		return nil, fmt.Errorf("traffic policy %s: file too large", path)
+	}
+
+	var p control.TrafficPolicy
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("traffic policy %s: %v", path, err)
+	}
+	return &p, nil
+}
+
+// resolveTrafficPolicy combines an inline policy with one loaded from file
+// (file rules run second) and validates the result locally so mistakes are
+// reported before dialing the server.
+func resolveTrafficPolicy(inline *control.TrafficPolicy, file string) (*control.TrafficPolicy, error) {
+	p := inline
+	if file = strings.TrimSpace(file); file != "" {
+		fromFile, err := loadTrafficPolicyFile(file)
+		if err != nil {
+			return nil, err
+		}
+		p = policy.Merge(inline, fromFile)
+	}
+	if p.IsEmpty() {
+		return nil, nil
+	}
+	if _, err := policy.Compile(p, policy.Options{}); err != nil {
+		return nil, fmt.Errorf("traffic_policy: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+func TestResolveTrafficPolicy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	t.Run("merges inline and yaml file", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(dir, "policy.yml")
+		if err := os.WriteFile(path, []byte(`on_request:
+  - name: maintenance
+    match:
+      path: ["/down"]
+    actions:
+      - type: custom_response
+        status: 503
+        body: back soon
+`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		inline := &control.TrafficPolicy{
+			OnResponse: []control.PolicyRule{{
+				Actions: []control.PolicyAction{{Type: "remove_header", Header: "Server"}},
+			}},
+		}
+		p, err := resolveTrafficPolicy(inline, path)
+		if err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+		if len(p.OnRequest) != 1 || p.OnRequest[0].Name != "maintenance" || len(p.OnResponse) != 1 {
+			t.Fatalf("policy = %#v", p)
+		}
+	})
+
+	t.Run("accepts json file", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(dir, "policy.json")
+		if err := os.WriteFile(path, []byte(`{"on_request":[{"actions":[{"type":"deny","status":451}]}]}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := resolveTrafficPolicy(nil, path)
+		if err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+		if len(p.OnRequest) != 1 || p.OnRequest[0].Actions[0].Status != 451 {
+			t.Fatalf("policy = %#v", p)
+		}
+	})
+
+	t.Run("rejects unknown keys", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(dir, "typo.yml")
+		if err := os.WriteFile(path, []byte("on_requests: []\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := resolveTrafficPolicy(nil, path); err == nil {
+			t.Fatalf("err = nil, want non-nil")
+		}
+	})
+
+	t.Run("rejects invalid policy", func(t *testing.T) {
+		t.Parallel()
+
+		inline := &control.TrafficPolicy{
+			OnRequest: []control.PolicyRule{{Actions: []control.PolicyAction{{Type: "redirect"}}}},
+		}
+		_, err := resolveTrafficPolicy(inline, "")
+		if err == nil || !strings.Contains(err.Error(), "traffic_policy") {
+			t.Fatalf("err = %v, want traffic_policy error", err)
+		}
+	})
+
+	t.Run("empty is nil", func(t *testing.T) {
+		t.Parallel()
+
+		p, err := resolveTrafficPolicy(&control.TrafficPolicy{}, "")
+		if err != nil || p != nil {
+			t.Fatalf("got %#v, %v; want nil, nil", p, err)
+		}
+	})
+}
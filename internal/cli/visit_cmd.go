@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"eosrift.com/eosrift/internal/client"
+	"eosrift.com/eosrift/internal/config"
+)
+
+func runVisit(ctx context.Context, args []string, configPath string, stdout, stderr io.Writer) int {
+	cfg, _, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	serverDefault := resolveServerAddrDefault(cfg)
+	authtokenDefault := resolveAuthtokenDefault(cfg)
+
+	fs := flag.NewFlagSet("visit", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	serverAddr := fs.String("server", serverDefault, "Server address (https://host, http://host:port, or ws(s)://host/control)")
+	authtoken := fs.String("authtoken", authtokenDefault, "Auth token")
+	secret := fs.String("secret", "", "Secret key of the tunnel (required)")
+	local := fs.String("local", "127.0.0.1:0", "Local listen address (port or host:port)")
+	help := fs.Bool("help", false, "Show help")
+	fs.BoolVar(help, "h", false, "Show help")
+
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "usage: eosrift visit [flags] <name>")
+		fs.PrintDefaults()
+	}
+
+	if err := parseInterspersedFlags(fs, args); err != nil {
+		return 2
+	}
+	if *help {
+		fs.SetOutput(stdout)
+		fs.Usage()
+		return 0
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *secret == "" {
+		fmt.Fprintln(stderr, "error: --secret is required")
+		return 2
+	}
+
+	localAddr := strings.TrimSpace(*local)
+	if !strings.Contains(localAddr, ":") {
+		localAddr = "127.0.0.1:" + localAddr
+	}
+
+	controlURL, err := config.ControlURLFromServerAddr(*serverAddr)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	visitor, err := client.StartTCPVisitor(ctx, controlURL, fs.Arg(0), localAddr, client.TCPVisitorOptions{
+		Authtoken: *authtoken,
+		Secret:    *secret,
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	defer visitor.Close()
+
+	printSession(stdout, sessionOutput{
+		Version:        version,
+		Status:         "online",
+		ForwardingFrom: "tcp://" + displayHostPort(visitor.LocalAddr()),
+		ForwardingTo:   "secret://" + visitor.Name,
+	})
+
+	if err := visitor.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+		if ctx.Err() != nil {
+			return 0
+		}
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	return 0
+}
@@ -2,8 +2,11 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"net"
 	"time"
 
+	"eosrift.com/eosrift/internal/control"
 	"eosrift.com/eosrift/internal/mux"
 	"github.com/hashicorp/yamux"
 	"nhooyr.io/websocket"
@@ -79,3 +82,82 @@ func dialControl(ctx context.Context, controlURL string) (*websocket.Conn, *yamu
 
 	return ws, session, nil
 }
+
+// TunnelClosedError is returned by Wait when the server closed the tunnel
+// on its own, e.g. because it expired (see control.TunnelClosedNotice).
+type TunnelClosedError struct {
+	Reason string
+}
+
+func (e *TunnelClosedError) Error() string {
+	switch e.Reason {
+	case control.TunnelClosedExpired:
+		return "tunnel expired"
+	case control.TunnelClosedMaxLifetime:
+		return "tunnel reached the server's maximum lifetime"
+	case control.TunnelClosedIdle:
+		return "tunnel closed by the server after being idle"
+	default:
+		return "tunnel closed by the server"
+	}
+}
+
+// controlNotices is the rest of a create stream once the response has been
+// read; servers that support it send a TunnelClosedNotice there.
+type controlNotices struct {
+	stream net.Conn
+	dec    *json.Decoder
+}
+
+// readCreateResponse reads a create response without waiting for the
+// stream to end, so the stream can carry notices afterwards.
+func readCreateResponse[T any](stream net.Conn) (T, controlNotices, error) {
+	var out T
+	dec := json.NewDecoder(stream)
+	if err := dec.Decode(&out); err != nil {
+		_ = stream.Close()
+		return out, controlNotices{}, err
+	}
+	return out, controlNotices{stream: stream, dec: dec}, nil
+}
+
+// watch waits for a TunnelClosedNotice and passes it to closed as a
+// *TunnelClosedError. It returns without calling closed when the stream
+// ends first, e.g. on reconnect or with servers that close it right away.
+func (n controlNotices) watch(closed func(error)) {
+	if n.stream == nil {
+		return
+	}
+	defer n.stream.Close()
+
+	for {
+		var notice control.TunnelClosedNotice
+		if err := n.dec.Decode(&notice); err != nil {
+			return
+		}
+		if notice.Type == "closed" {
+			closed(&TunnelClosedError{Reason: notice.Reason})
+			return
+		}
+	}
+}
+
+func (n controlNotices) close() {
+	if n.stream != nil {
+		_ = n.stream.Close()
+	}
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func unixTimeOrZero(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
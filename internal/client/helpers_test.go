@@ -42,6 +42,7 @@ func TestIsRetryableTCPControlError(t *testing.T) {
 	}{
 		{"nil", nil, false},
 		{"requested port unavailable", errors.New("requested port unavailable"), true},
+		{"name already in use", errors.New("name already in use"), true},
 		{"too many tunnels", errors.New("too many active tunnels"), true},
 		{"rate limit", errors.New("rate limit exceeded"), true},
 		{"other", errors.New("nope"), false},
@@ -58,6 +59,31 @@ func TestIsRetryableTCPControlError(t *testing.T) {
 	}
 }
 
+func TestIsRetryableVisitControlError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"tunnel not found", errors.New("tunnel not found"), true},
+		{"rate limit", errors.New("rate limit exceeded"), true},
+		{"unauthorized", errors.New("unauthorized"), false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := isRetryableVisitControlError(tc.err); got != tc.want {
+				t.Fatalf("got = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestHostFromURL(t *testing.T) {
 	t.Parallel()
 
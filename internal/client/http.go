@@ -13,6 +13,7 @@ import (
 
 	"eosrift.com/eosrift/internal/control"
 	"eosrift.com/eosrift/internal/inspect"
+	"eosrift.com/eosrift/internal/tracing"
 	"github.com/hashicorp/yamux"
 	"nhooyr.io/websocket"
 )
@@ -27,17 +28,55 @@ type HTTPTunnelOptions struct {
 	Subdomain            string
 	Domain               string
 	BasicAuth            string
+	BasicAuthUsers       []control.BasicAuthUser
 	AllowMethods         []string
 	AllowPaths           []string
 	AllowPathPrefixes    []string
 	AllowCIDRs           []string
 	DenyCIDRs            []string
+	RateLimit            string
+	RateLimitHeader      string
+	Redirects            []control.RedirectRule
+	Rewrites             []control.RewriteRule
 	RequestHeaderAdd     []HeaderKV
 	RequestHeaderRemove  []string
 	ResponseHeaderAdd    []HeaderKV
 	ResponseHeaderRemove []string
 	HostHeader           string
 
+	// TrafficPolicy is evaluated by the server after the edge options above.
+	TrafficPolicy *control.TrafficPolicy
+
+	// ShareLinks makes the public URL require a signed share link (see
+	// ShareLink) unless the visitor passes basic auth.
+	ShareLinks bool
+
+	// JWT requires a valid bearer token on every public request.
+	JWT *control.JWTAuth
+
+	// CORS makes the server answer preflights and set CORS headers.
+	CORS *control.CORSPolicy
+
+	// Compression makes the server compress responses at the edge.
+	Compression *control.CompressionPolicy
+
+	// Cache enables the server's edge cache for GET responses.
+	Cache *control.CachePolicy
+
+	// Limits lowers the server's body size and timeout limits.
+	Limits *control.HTTPLimits
+
+	// Mirror copies requests to a shadow tunnel of the same authtoken.
+	Mirror *control.MirrorPolicy
+
+	// Bandwidth paces the tunnel's traffic at the server, per direction.
+	Bandwidth *control.BandwidthLimits
+
+	// ExpiresAt asks the server to close the tunnel at this time; zero
+	// means no expiry. The server may pick an earlier time (see
+	// HTTPTunnel.ExpiresAt).
+	ExpiresAt time.Time
+
 	// UpstreamScheme is the scheme used when dialing the local upstream.
 	// Supported values: "http" (default) and "https".
 	UpstreamScheme string
@@ -48,6 +87,10 @@ type HTTPTunnelOptions struct {
 
 	Inspector *inspect.Store
 
+	// Tracer records spans for the upstream dial and response of each
+	// request, as children of the server's trace context.
+	Tracer *tracing.Tracer
+
 	// CaptureBytes is the maximum number of bytes to keep for request and response
 	// previews (used by the local inspector). If zero, a sensible default is used.
 	CaptureBytes int
@@ -57,21 +100,45 @@ type HTTPTunnel struct {
 	ID  string
 	URL string
 
+	// PathRouting is set when the server routes tunnels by path
+	// (https://<base>/t/<id>/); URL is then the path-style URL.
+	PathRouting bool
+
+	// ExpiresAt is when the server will close the tunnel; zero when it
+	// has no deadline. Wait then returns a *TunnelClosedError.
+	ExpiresAt time.Time
+
 	localAddr            string
 	controlURL           string
+	requestIDHeader      string
 	authtoken            string
 	subdomain            string
 	domain               string
 	basicAuth            string
+	basicAuthUsers       []control.BasicAuthUser
 	allowMethods         []string
 	allowPaths           []string
 	allowPathPrefixes    []string
 	allowCIDRs           []string
 	denyCIDRs            []string
+	rateLimit            string
+	rateLimitHeader      string
+	redirects            []control.RedirectRule
+	rewrites             []control.RewriteRule
 	requestHeaderAdd     []HeaderKV
 	requestHeaderRemove  []string
 	responseHeaderAdd    []HeaderKV
 	responseHeaderRemove []string
+	trafficPolicy        *control.TrafficPolicy
+	shareLinks           bool
+	jwt                  *control.JWTAuth
+	cors                 *control.CORSPolicy
+	compression          *control.CompressionPolicy
+	cache                *control.CachePolicy
+	limits               *control.HTTPLimits
+	mirror               *control.MirrorPolicy
+	bandwidth            *control.BandwidthLimits
+	expiresAt            int64
 	hostHeader           string
 
 	upstreamScheme        string
@@ -81,12 +148,16 @@ type HTTPTunnel struct {
 	ws        *websocket.Conn
 	session   *yamux.Session
 	inspector *inspect.Store
+	tracer    *tracing.Tracer
 
 	captureBytes int
 
 	closing   atomic.Bool
 	closeOnce sync.Once
 	done      chan error
+
+	// serverClosed is set when the server closed the tunnel on its own.
+	serverClosed atomic.Pointer[TunnelClosedError]
 }
 
 func StartHTTPTunnel(ctx context.Context, controlURL, localAddr string) (*HTTPTunnel, error) {
@@ -108,21 +179,37 @@ func StartHTTPTunnelWithOptions(ctx context.Context, controlURL, localAddr strin
 		return nil, errors.New("unsupported upstream scheme")
 	}
 
-	ws, session, resp, err := createHTTPTunnel(ctx, controlURL, control.CreateHTTPTunnelRequest{
+	ws, session, resp, notices, err := createHTTPTunnel(ctx, controlURL, control.CreateHTTPTunnelRequest{
 		Type:                 "http",
 		Authtoken:            opts.Authtoken,
 		Subdomain:            opts.Subdomain,
 		Domain:               opts.Domain,
 		BasicAuth:            opts.BasicAuth,
+		BasicAuthUsers:       append([]control.BasicAuthUser(nil), opts.BasicAuthUsers...),
 		AllowMethod:          opts.AllowMethods,
 		AllowPath:            opts.AllowPaths,
 		AllowPathPrefix:      opts.AllowPathPrefixes,
 		AllowCIDR:            opts.AllowCIDRs,
 		DenyCIDR:             opts.DenyCIDRs,
+		RateLimit:            opts.RateLimit,
+		RateLimitHeader:      opts.RateLimitHeader,
+		Redirect:             append([]control.RedirectRule(nil), opts.Redirects...),
+		Rewrite:              append([]control.RewriteRule(nil), opts.Rewrites...),
 		RequestHeaderAdd:     toControlHeaderKVs(opts.RequestHeaderAdd),
 		RequestHeaderRemove:  append([]string(nil), opts.RequestHeaderRemove...),
 		ResponseHeaderAdd:    toControlHeaderKVs(opts.ResponseHeaderAdd),
 		ResponseHeaderRemove: append([]string(nil), opts.ResponseHeaderRemove...),
+		TrafficPolicy:        opts.TrafficPolicy,
+		ShareLinks:           opts.ShareLinks,
+		JWT:                  opts.JWT,
+		CORS:                 opts.CORS,
+		Compression:          opts.Compression,
+		Cache:                opts.Cache,
+		Limits:               opts.Limits,
+		Mirror:               opts.Mirror,
+		Bandwidth:            opts.Bandwidth,
+		ExpiresAt:            unixOrZero(opts.ExpiresAt),
+		Notices:              true,
 	})
 	if err != nil {
 		return nil, err
@@ -131,27 +218,46 @@ func StartHTTPTunnelWithOptions(ctx context.Context, controlURL, localAddr strin
 	t := &HTTPTunnel{
 		ID:                    resp.ID,
 		URL:                   resp.URL,
+		PathRouting:           resp.Routing == "path",
+		ExpiresAt:             unixTimeOrZero(resp.ExpiresAt),
 		localAddr:             localAddr,
 		controlURL:            controlURL,
+		requestIDHeader:       resp.RequestIDHeader,
 		authtoken:             opts.Authtoken,
 		subdomain:             opts.Subdomain,
 		domain:                opts.Domain,
 		basicAuth:             opts.BasicAuth,
+		basicAuthUsers:        append([]control.BasicAuthUser(nil), opts.BasicAuthUsers...),
 		allowMethods:          append([]string(nil), opts.AllowMethods...),
 		allowPaths:            append([]string(nil), opts.AllowPaths...),
 		allowPathPrefixes:     append([]string(nil), opts.AllowPathPrefixes...),
 		allowCIDRs:            append([]string(nil), opts.AllowCIDRs...),
 		denyCIDRs:             append([]string(nil), opts.DenyCIDRs...),
+		rateLimit:             opts.RateLimit,
+		rateLimitHeader:       opts.RateLimitHeader,
+		redirects:             append([]control.RedirectRule(nil), opts.Redirects...),
+		rewrites:              append([]control.RewriteRule(nil), opts.Rewrites...),
 		requestHeaderAdd:      append([]HeaderKV(nil), opts.RequestHeaderAdd...),
 		requestHeaderRemove:   append([]string(nil), opts.RequestHeaderRemove...),
 		responseHeaderAdd:     append([]HeaderKV(nil), opts.ResponseHeaderAdd...),
 		responseHeaderRemove:  append([]string(nil), opts.ResponseHeaderRemove...),
+		trafficPolicy:         opts.TrafficPolicy,
+		shareLinks:            opts.ShareLinks,
+		jwt:                   opts.JWT,
+		cors:                  opts.CORS,
+		compression:           opts.Compression,
+		cache:                 opts.Cache,
+		limits:                opts.Limits,
+		mirror:                opts.Mirror,
+		bandwidth:             opts.Bandwidth,
+		expiresAt:             unixOrZero(opts.ExpiresAt),
 		hostHeader:            opts.HostHeader,
 		upstreamScheme:        upstreamScheme,
 		upstreamTLSSkipVerify: opts.UpstreamTLSSkipVerify,
 		ws:                    ws,
 		session:               session,
 		inspector:             opts.Inspector,
+		tracer:                opts.Tracer,
 		captureBytes: func() int {
 			if opts.CaptureBytes > 0 {
 				return opts.CaptureBytes
@@ -166,11 +272,29 @@ func StartHTTPTunnelWithOptions(ctx context.Context, controlURL, localAddr strin
 		_ = t.Close()
 	}()
 
+	go notices.watch(t.closedByServer)
 	go t.acceptStreams(ctx)
 
 	return t, nil
 }
 
+// closedByServer stops the tunnel without reconnecting; Wait returns err.
+func (t *HTTPTunnel) closedByServer(err error) {
+	var closed *TunnelClosedError
+	if errors.As(err, &closed) {
+		t.serverClosed.Store(closed)
+	}
+	_ = t.Close()
+}
+
+// stopErr is what Wait returns after Close: nil, or the server's reason.
+func (t *HTTPTunnel) stopErr() error {
+	if err := t.serverClosed.Load(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (t *HTTPTunnel) Close() error {
 	var closeErr error
 
@@ -206,7 +330,7 @@ func (t *HTTPTunnel) acceptStreams(ctx context.Context) {
 				if ctx.Err() != nil {
 					t.finish(ctx.Err())
 				} else {
-					t.finish(nil)
+					t.finish(t.stopErr())
 				}
 				return
 			}
@@ -233,10 +357,13 @@ func (t *HTTPTunnel) finish(err error) {
 func (t *HTTPTunnel) handleStream(ctx context.Context, stream net.Conn) {
 	defer stream.Close()
 
+	dialStart := time.Now()
 	upstream, err := dialHTTPUpstream(ctx, t.upstreamScheme, t.localAddr, t.upstreamTLSSkipVerify)
 	if err != nil {
+		t.traceDialFailure(stream, dialStart, err)
 		return
 	}
+	dialEnd := time.Now()
 	defer upstream.Close()
 
 	hostHeader := strings.TrimSpace(t.hostHeader)
@@ -247,7 +374,7 @@ func (t *HTTPTunnel) handleStream(ctx context.Context, stream net.Conn) {
 		hostHeader = t.localAddr
 	}
 
-	if t.inspector == nil {
+	if t.inspector == nil && t.tracer == nil {
 		if hostHeader == "" {
 			_ = proxyBidirectional(ctx, upstream, stream)
 		} else {
@@ -273,6 +400,10 @@ func (t *HTTPTunnel) handleStream(ctx context.Context, stream net.Conn) {
 	if !ok {
 		return
 	}
+	traceID := t.traceExchange(s, dialStart, dialEnd, startedAt.Add(duration))
+	if t.inspector == nil {
+		return
+	}
 
 	t.inspector.Add(inspect.Entry{
 		StartedAt:       startedAt,
@@ -286,9 +417,20 @@ func (t *HTTPTunnel) handleStream(ctx context.Context, stream net.Conn) {
 		BytesOut:        bytesOut,
 		RequestHeaders:  s.RequestHeaders,
 		ResponseHeaders: s.ResponseHeaders,
+		RequestID:       s.RequestHeaders.Get(t.requestIDHeaderName()),
+		TraceID:         traceID,
 	})
 }
 
+// requestIDHeaderName is the header the server sends request ids in; older
+// servers don't say and use X-Request-Id, if anything.
+func (t *HTTPTunnel) requestIDHeaderName() string {
+	if t.requestIDHeader == "" {
+		return "X-Request-Id"
+	}
+	return t.requestIDHeader
+}
+
 func dialHTTPUpstream(ctx context.Context, scheme, addr string, tlsSkipVerify bool) (net.Conn, error) {
 	dialer := &net.Dialer{}
 
@@ -320,47 +462,49 @@ func dialHTTPUpstream(ctx context.Context, scheme, addr string, tlsSkipVerify bo
 	return tlsConn, nil
 }
 
-func createHTTPTunnel(ctx context.Context, controlURL string, req control.CreateHTTPTunnelRequest) (*websocket.Conn, *yamux.Session, control.CreateHTTPTunnelResponse, error) {
+func createHTTPTunnel(ctx context.Context, controlURL string, req control.CreateHTTPTunnelRequest) (*websocket.Conn, *yamux.Session, control.CreateHTTPTunnelResponse, controlNotices, error) {
 	var resp control.CreateHTTPTunnelResponse
 
 	ws, session, err := dialControlWithRetry(ctx, controlURL)
 	if err != nil {
-		return nil, nil, resp, err
+		return nil, nil, resp, controlNotices{}, err
 	}
 
 	ctrlStream, err := session.OpenStream()
 	if err != nil {
 		_ = session.Close()
 		_ = ws.Close(websocket.StatusInternalError, "control error")
-		return nil, nil, resp, err
+		return nil, nil, resp, controlNotices{}, err
 	}
 
 	if err := control.WriteJSON(ctrlStream, req); err != nil {
 		_ = ctrlStream.Close()
 		_ = session.Close()
 		_ = ws.Close(websocket.StatusInternalError, "control error")
-		return nil, nil, resp, err
+		return nil, nil, resp, controlNotices{}, err
 	}
 
-	resp, err = readJSONControlResponse[control.CreateHTTPTunnelResponse](ctrlStream)
+	resp, notices, err := readCreateResponse[control.CreateHTTPTunnelResponse](ctrlStream)
 	if err != nil {
 		_ = session.Close()
 		_ = ws.Close(websocket.StatusInternalError, "control error")
-		return nil, nil, resp, err
+		return nil, nil, resp, controlNotices{}, err
 	}
 
 	if resp.Error != "" {
+		notices.close()
 		_ = session.Close()
 		_ = ws.Close(websocket.StatusPolicyViolation, resp.Error)
-		return nil, nil, resp, errors.New(resp.Error)
+		return nil, nil, resp, controlNotices{}, errors.New(resp.Error)
 	}
 	if resp.ID == "" || resp.URL == "" {
+		notices.close()
 		_ = session.Close()
 		_ = ws.Close(websocket.StatusInternalError, "invalid server response")
-		return nil, nil, resp, errors.New("invalid server response")
+		return nil, nil, resp, controlNotices{}, errors.New("invalid server response")
 	}
 
-	return ws, session, resp, nil
+	return ws, session, resp, notices, nil
 }
 
 func (t *HTTPTunnel) conn() (*websocket.Conn, *yamux.Session) {
@@ -375,6 +519,44 @@ func (t *HTTPTunnel) currentSession() *yamux.Session {
 	return t.session
 }
 
+// ShareLink asks the server for a signed link to the tunnel that stays valid
+// for ttl (server default when zero), optionally limited to path and below.
+func (t *HTTPTunnel) ShareLink(ctx context.Context, ttl time.Duration, path string) (string, time.Time, error) {
+	session := t.currentSession()
+	if session == nil {
+		return "", time.Time{}, errors.New("tunnel is not connected")
+	}
+
+	stream, err := session.OpenStream()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	if err := control.WriteJSON(stream, control.ShareLinkRequest{
+		Type:       "share_link",
+		TTLSeconds: int64(ttl / time.Second),
+		Path:       path,
+	}); err != nil {
+		_ = stream.Close()
+		return "", time.Time{}, err
+	}
+
+	resp, err := readJSONControlResponse[control.ShareLinkResponse](stream)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if resp.Error != "" {
+		return "", time.Time{}, errors.New(resp.Error)
+	}
+	if resp.URL == "" {
+		return "", time.Time{}, errors.New("invalid server response")
+	}
+	return resp.URL, time.Unix(resp.ExpiresAt, 0), nil
+}
+
 func (t *HTTPTunnel) setConn(ws *websocket.Conn, session *yamux.Session) (*websocket.Conn, *yamux.Session) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -390,19 +572,40 @@ func (t *HTTPTunnel) controlRequestForReconnect() control.CreateHTTPTunnelReques
 		Subdomain:            t.subdomain,
 		Domain:               t.domain,
 		BasicAuth:            t.basicAuth,
+		BasicAuthUsers:       append([]control.BasicAuthUser(nil), t.basicAuthUsers...),
 		AllowMethod:          append([]string(nil), t.allowMethods...),
 		AllowPath:            append([]string(nil), t.allowPaths...),
 		AllowPathPrefix:      append([]string(nil), t.allowPathPrefixes...),
 		AllowCIDR:            append([]string(nil), t.allowCIDRs...),
 		DenyCIDR:             append([]string(nil), t.denyCIDRs...),
+		RateLimit:            t.rateLimit,
+		RateLimitHeader:      t.rateLimitHeader,
+		Redirect:             append([]control.RedirectRule(nil), t.redirects...),
+		Rewrite:              append([]control.RewriteRule(nil), t.rewrites...),
 		RequestHeaderAdd:     toControlHeaderKVs(t.requestHeaderAdd),
 		RequestHeaderRemove:  append([]string(nil), t.requestHeaderRemove...),
 		ResponseHeaderAdd:    toControlHeaderKVs(t.responseHeaderAdd),
 		ResponseHeaderRemove: append([]string(nil), t.responseHeaderRemove...),
+		TrafficPolicy:        t.trafficPolicy,
+		ShareLinks:           t.shareLinks,
+		JWT:                  t.jwt,
+		CORS:                 t.cors,
+		Compression:          t.compression,
+		Cache:                t.cache,
+		Limits:               t.limits,
+		Mirror:               t.mirror,
+		Bandwidth:            t.bandwidth,
+		ExpiresAt:            t.expiresAt,
+		Notices:              true,
 	}
 
 	if strings.TrimSpace(req.Domain) == "" && strings.TrimSpace(req.Subdomain) == "" {
-		req.Domain = hostFromURL(t.URL)
+		if t.PathRouting {
+			// The path-style URL has no tunnel host; resume by id.
+			req.Subdomain = t.ID
+		} else {
+			req.Domain = hostFromURL(t.URL)
+		}
 	}
 
 	return req
@@ -434,12 +637,16 @@ func (t *HTTPTunnel) reconnect(ctx context.Context) error {
 			}
 			return nil
 		}
+		if t.expiresAt != 0 && time.Now().Unix() >= t.expiresAt {
+			return &TunnelClosedError{Reason: control.TunnelClosedExpired}
+		}
 
 		req := t.controlRequestForReconnect()
 
-		ws, session, resp, err := createHTTPTunnel(ctx, t.controlURL, req)
+		ws, session, resp, notices, err := createHTTPTunnel(ctx, t.controlURL, req)
 		if err == nil {
 			if t.closing.Load() || ctx.Err() != nil {
+				notices.close()
 				_ = session.Close()
 				_ = ws.Close(websocket.StatusNormalClosure, "closed")
 				if ctx.Err() != nil {
@@ -449,6 +656,7 @@ func (t *HTTPTunnel) reconnect(ctx context.Context) error {
 			}
 
 			if resp.ID != t.ID || resp.URL != t.URL {
+				notices.close()
 				_ = session.Close()
 				_ = ws.Close(websocket.StatusInternalError, "resume mismatch")
 				return errors.New("resume mismatch")
@@ -461,6 +669,7 @@ func (t *HTTPTunnel) reconnect(ctx context.Context) error {
 			if oldWS != nil {
 				_ = oldWS.Close(websocket.StatusGoingAway, "reconnected")
 			}
+			go notices.watch(t.closedByServer)
 			return nil
 		}
 
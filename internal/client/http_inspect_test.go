@@ -1,6 +1,17 @@
 package client
 
-import "testing"
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"eosrift.com/eosrift/internal/inspect"
+)
 
 func TestSummarizeHTTPExchange(t *testing.T) {
 	t.Parallel()
@@ -40,3 +51,45 @@ func TestSummarizeHTTPExchange_Invalid(t *testing.T) {
 		t.Fatalf("ok = true, want false")
 	}
 }
+
+func TestHTTPTunnel_InspectorRecordsRequestID(t *testing.T) {
+	t.Parallel()
+
+	var upstreamGot string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamGot = r.Header.Get("X-Correlation-Id")
+	}))
+	defer upstream.Close()
+
+	store := inspect.NewStore(inspect.StoreConfig{})
+	tun := &HTTPTunnel{
+		ID:              "abcd1234",
+		localAddr:       strings.TrimPrefix(upstream.URL, "http://"),
+		upstreamScheme:  "http",
+		requestIDHeader: "X-Correlation-Id",
+		inspector:       store,
+		captureBytes:    4096,
+	}
+
+	edge, agent := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tun.handleStream(context.Background(), agent)
+	}()
+	_, _ = io.WriteString(edge, "GET / HTTP/1.1\r\nHost: abcd1234.tunnel.eosrift.test\r\nX-Correlation-Id: req-42\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(edge), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = edge.Close()
+	<-done
+
+	if upstreamGot != "req-42" {
+		t.Fatalf("upstream request id = %q, want %q", upstreamGot, "req-42")
+	}
+	if entries := store.List(); len(entries) != 1 || entries[0].RequestID != "req-42" {
+		t.Fatalf("inspector entries = %+v, want one with request id req-42", entries)
+	}
+}
@@ -38,6 +38,7 @@ func TestHTTPTunnel_ControlRequestForReconnect(t *testing.T) {
 			{Name: "X-Resp", Value: "ok"},
 		},
 		responseHeaderRemove: []string{"X-Upstream"},
+		expiresAt:            1767225600,
 	}
 
 	got := tun.controlRequestForReconnect()
@@ -66,9 +67,27 @@ func TestHTTPTunnel_ControlRequestForReconnect(t *testing.T) {
 			{Name: "X-Resp", Value: "ok"},
 		},
 		ResponseHeaderRemove: []string{"X-Upstream"},
+		ExpiresAt:            1767225600,
+		Notices:              true,
 	}
 
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("request mismatch\n got: %+v\nwant: %+v", got, want)
 	}
 }
+
+func TestHTTPTunnel_ControlRequestForReconnect_PathRouting(t *testing.T) {
+	t.Parallel()
+
+	tun := &HTTPTunnel{
+		ID:          "abcd1234",
+		URL:         "https://eosrift.test/t/abcd1234/",
+		PathRouting: true,
+		authtoken:   "tok-123",
+	}
+
+	got := tun.controlRequestForReconnect()
+	if got.Subdomain != "abcd1234" || got.Domain != "" {
+		t.Fatalf("subdomain/domain = %q/%q, want abcd1234/empty", got.Subdomain, got.Domain)
+	}
+}
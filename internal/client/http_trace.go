@@ -0,0 +1,86 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"eosrift.com/eosrift/internal/inspect"
+	"eosrift.com/eosrift/internal/tracing"
+)
+
+// traceExchange records the spans of a proxied request once it is done:
+// the agent's handling of the stream, with the upstream dial and the
+// upstream response as children. The server's traceparent, when present,
+// is their parent. It returns the request's trace id ("" when untraced).
+func (t *HTTPTunnel) traceExchange(s httpExchangeSummary, dialStart, dialEnd, end time.Time) string {
+	parent, _ := tracing.Extract(s.RequestHeaders)
+	if t.tracer == nil {
+		return parent.TraceIDString()
+	}
+
+	ctx, span := t.startAgentSpan(parent, dialStart)
+	span.SetAttr("http.request.method", s.Method)
+	span.SetAttr("url.path", inspect.RedactPath(s.Path))
+	span.SetAttr("http.response.status_code", s.StatusCode)
+
+	dial := t.startUpstreamSpan(ctx, "agent.upstream_dial", dialStart)
+	dial.EndAt(dialEnd)
+
+	resp := t.startUpstreamSpan(ctx, "agent.upstream_response", dialEnd)
+	resp.SetAttr("http.response.status_code", s.StatusCode)
+	if s.StatusCode >= http.StatusInternalServerError {
+		resp.SetStatusError(http.StatusText(s.StatusCode))
+		span.SetStatusError(http.StatusText(s.StatusCode))
+	}
+	resp.EndAt(end)
+	span.EndAt(end)
+
+	return span.SpanContext().TraceIDString()
+}
+
+// traceDialFailure records a failed upstream dial. The request has not been
+// read yet at that point, so its head is read (briefly) for the trace
+// context; the server answers the visitor with 502 either way.
+func (t *HTTPTunnel) traceDialFailure(stream net.Conn, dialStart time.Time, dialErr error) {
+	if t.tracer == nil {
+		return
+	}
+	dialEnd := time.Now()
+
+	var parent tracing.SpanContext
+	_ = stream.SetReadDeadline(time.Now().Add(time.Second))
+	req, err := http.ReadRequest(bufio.NewReader(stream))
+	if err == nil {
+		parent, _ = tracing.Extract(req.Header)
+	}
+
+	ctx, span := t.startAgentSpan(parent, dialStart)
+	if req != nil {
+		span.SetAttr("http.request.method", req.Method)
+		span.SetAttr("url.path", inspect.RedactPath(req.URL.RequestURI()))
+	}
+	span.SetError(dialErr)
+
+	dial := t.startUpstreamSpan(ctx, "agent.upstream_dial", dialStart)
+	dial.SetError(dialErr)
+	dial.EndAt(dialEnd)
+	span.EndAt(dialEnd)
+}
+
+func (t *HTTPTunnel) startAgentSpan(parent tracing.SpanContext, start time.Time) (context.Context, *tracing.Span) {
+	ctx, span := t.tracer.Start(tracing.ContextWithRemoteParent(context.Background(), parent), "agent.request", tracing.KindServer)
+	span.SetStartTime(start)
+	span.SetAttr("eosrift.tunnel_id", t.ID)
+	return ctx, span
+}
+
+func (t *HTTPTunnel) startUpstreamSpan(ctx context.Context, name string, start time.Time) *tracing.Span {
+	_, span := t.tracer.Start(ctx, name, tracing.KindClient)
+	span.SetStartTime(start)
+	span.SetAttr("server.address", t.localAddr)
+	span.SetAttr("url.scheme", t.upstreamScheme)
+	return span
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"eosrift.com/eosrift/internal/inspect"
+	"eosrift.com/eosrift/internal/tracing"
+)
+
+type otlpSpanJSON struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Status       *struct {
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+// newTestCollector returns an OTLP/HTTP endpoint and a func returning the
+// spans it received, by name.
+func newTestCollector(t *testing.T) (string, func() map[string]otlpSpanJSON) {
+	t.Helper()
+
+	var (
+		mu    sync.Mutex
+		spans = map[string]otlpSpanJSON{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpanJSON `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL, func() map[string]otlpSpanJSON {
+		mu.Lock()
+		defer mu.Unlock()
+		return spans
+	}
+}
+
+func TestHTTPTunnel_TracesUpstreamExchange(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	endpoint, received := newTestCollector(t)
+	tracer, err := tracing.New(tracing.Options{Endpoint: endpoint, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("tracing.New: %v", err)
+	}
+
+	store := inspect.NewStore(inspect.StoreConfig{})
+	tun := &HTTPTunnel{
+		ID:             "abcd1234",
+		localAddr:      strings.TrimPrefix(upstream.URL, "http://"),
+		upstreamScheme: "http",
+		inspector:      store,
+		tracer:         tracer,
+		captureBytes:   4096,
+	}
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	edge, agent := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tun.handleStream(context.Background(), agent)
+	}()
+
+	_, _ = io.WriteString(edge, "GET /hello HTTP/1.1\r\nHost: abcd1234.tunnel.eosrift.test\r\nTraceparent: "+traceparent+"\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(edge), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = edge.Close()
+	<-done
+
+	entries := store.List()
+	if len(entries) != 1 || entries[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("inspector entries = %+v, want one with the trace id", entries)
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	spans := received()
+	agentSpan, ok := spans["agent.request"]
+	if !ok || agentSpan.ParentSpanID != "00f067aa0ba902b7" || agentSpan.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("agent.request span = %+v (ok=%v)", agentSpan, ok)
+	}
+	for _, name := range []string{"agent.upstream_dial", "agent.upstream_response"} {
+		if s := spans[name]; s.ParentSpanID != agentSpan.SpanID {
+			t.Fatalf("%s parent = %q, want %q", name, s.ParentSpanID, agentSpan.SpanID)
+		}
+	}
+}
+
+func TestHTTPTunnel_TracesDialFailure(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	endpoint, received := newTestCollector(t)
+	tracer, err := tracing.New(tracing.Options{Endpoint: endpoint, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("tracing.New: %v", err)
+	}
+	tun := &HTTPTunnel{ID: "abcd1234", localAddr: addr, upstreamScheme: "http", tracer: tracer}
+
+	edge, agent := net.Pipe()
+	go func() {
+		_, _ = io.WriteString(edge, "GET / HTTP/1.1\r\nHost: x\r\nTraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n\r\n")
+	}()
+	tun.handleStream(context.Background(), agent)
+	_ = edge.Close()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	dial, ok := received()["agent.upstream_dial"]
+	if !ok || dial.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || dial.Status == nil {
+		t.Fatalf("agent.upstream_dial span = %+v (ok=%v), want a failed span in the trace", dial, ok)
+	}
+}
@@ -19,9 +19,22 @@ import (
 type TCPTunnel struct {
 	RemotePort int
 
+	// Name is set for secret tunnels (no public port); visitors connect with
+	// `eosrift visit <name>`.
+	Name string
+
+	// ExpiresAt is when the server will close the tunnel; zero when it
+	// has no deadline. Wait then returns a *TunnelClosedError.
+	ExpiresAt time.Time
+
 	localAddr  string
 	controlURL string
 	authtoken  string
+	secret     string
+	allowCIDR  []string
+	denyCIDR   []string
+	bandwidth  *control.BandwidthLimits
+	expiresAt  int64
 
 	mu      sync.Mutex
 	ws      *websocket.Conn
@@ -30,6 +43,9 @@ type TCPTunnel struct {
 	closing   atomic.Bool
 	closeOnce sync.Once
 	done      chan error
+
+	// serverClosed is set when the server closed the tunnel on its own.
+	serverClosed atomic.Pointer[TunnelClosedError]
 }
 
 func StartTCPTunnel(ctx context.Context, controlURL, localAddr string) (*TCPTunnel, error) {
@@ -39,13 +55,38 @@ func StartTCPTunnel(ctx context.Context, controlURL, localAddr string) (*TCPTunn
 type TCPTunnelOptions struct {
 	Authtoken  string
 	RemotePort int
+
+	// Secret creates a secret tunnel reachable only via `eosrift visit`.
+	// SecretName is optional (server-assigned when empty).
+	Secret     string
+	SecretName string
+
+	// Optional CIDR filtering on the server edge (TCP listener and the
+	// WebSocket bridge). DenyCIDR always takes precedence.
+	AllowCIDR []string
+	DenyCIDR  []string
+
+	// Bandwidth paces the tunnel's traffic at the server, per direction.
+	Bandwidth *control.BandwidthLimits
+
+	// ExpiresAt asks the server to close the tunnel at this time; zero
+	// means no expiry. The server may pick an earlier time (see
+	// TCPTunnel.ExpiresAt).
+	ExpiresAt time.Time
 }
 
 func StartTCPTunnelWithOptions(ctx context.Context, controlURL, localAddr string, opts TCPTunnelOptions) (*TCPTunnel, error) {
-	ws, session, resp, err := createTCPTunnel(ctx, controlURL, control.CreateTCPTunnelRequest{
+	ws, session, resp, notices, err := createTCPTunnel(ctx, controlURL, control.CreateTCPTunnelRequest{
 		Type:       "tcp",
 		Authtoken:  opts.Authtoken,
 		RemotePort: opts.RemotePort,
+		Secret:     opts.Secret,
+		Name:       opts.SecretName,
+		AllowCIDR:  opts.AllowCIDR,
+		DenyCIDR:   opts.DenyCIDR,
+		Bandwidth:  opts.Bandwidth,
+		ExpiresAt:  unixOrZero(opts.ExpiresAt),
+		Notices:    true,
 	})
 	if err != nil {
 		return nil, err
@@ -53,9 +94,16 @@ func StartTCPTunnelWithOptions(ctx context.Context, controlURL, localAddr string
 
 	t := &TCPTunnel{
 		RemotePort: resp.RemotePort,
+		Name:       resp.Name,
+		ExpiresAt:  unixTimeOrZero(resp.ExpiresAt),
 		localAddr:  localAddr,
 		controlURL: controlURL,
 		authtoken:  opts.Authtoken,
+		secret:     opts.Secret,
+		allowCIDR:  opts.AllowCIDR,
+		denyCIDR:   opts.DenyCIDR,
+		bandwidth:  opts.Bandwidth,
+		expiresAt:  unixOrZero(opts.ExpiresAt),
 		ws:         ws,
 		session:    session,
 		done:       make(chan error, 1),
@@ -66,11 +114,29 @@ func StartTCPTunnelWithOptions(ctx context.Context, controlURL, localAddr string
 		_ = t.Close()
 	}()
 
+	go notices.watch(t.closedByServer)
 	go t.acceptStreams(ctx)
 
 	return t, nil
 }
 
+// closedByServer stops the tunnel without reconnecting; Wait returns err.
+func (t *TCPTunnel) closedByServer(err error) {
+	var closed *TunnelClosedError
+	if errors.As(err, &closed) {
+		t.serverClosed.Store(closed)
+	}
+	_ = t.Close()
+}
+
+// stopErr is what Wait returns after Close: nil, or the server's reason.
+func (t *TCPTunnel) stopErr() error {
+	if err := t.serverClosed.Load(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (t *TCPTunnel) Close() error {
 	var closeErr error
 
@@ -106,7 +172,7 @@ func (t *TCPTunnel) acceptStreams(ctx context.Context) {
 				if ctx.Err() != nil {
 					t.finish(ctx.Err())
 				} else {
-					t.finish(nil)
+					t.finish(t.stopErr())
 				}
 				return
 			}
@@ -170,42 +236,43 @@ func (t *TCPTunnel) RemoteAddr(serverHost string) string {
 	return fmt.Sprintf("%s:%d", serverHost, t.RemotePort)
 }
 
-func createTCPTunnel(ctx context.Context, controlURL string, req control.CreateTCPTunnelRequest) (*websocket.Conn, *yamux.Session, control.CreateTCPTunnelResponse, error) {
+func createTCPTunnel(ctx context.Context, controlURL string, req control.CreateTCPTunnelRequest) (*websocket.Conn, *yamux.Session, control.CreateTCPTunnelResponse, controlNotices, error) {
 	var resp control.CreateTCPTunnelResponse
 
 	ws, session, err := dialControlWithRetry(ctx, controlURL)
 	if err != nil {
-		return nil, nil, resp, err
+		return nil, nil, resp, controlNotices{}, err
 	}
 
 	ctrlStream, err := session.OpenStream()
 	if err != nil {
 		_ = session.Close()
 		_ = ws.Close(websocket.StatusInternalError, "control error")
-		return nil, nil, resp, err
+		return nil, nil, resp, controlNotices{}, err
 	}
 
 	if err := control.WriteJSON(ctrlStream, req); err != nil {
 		_ = ctrlStream.Close()
 		_ = session.Close()
 		_ = ws.Close(websocket.StatusInternalError, "control error")
-		return nil, nil, resp, err
+		return nil, nil, resp, controlNotices{}, err
 	}
 
-	resp, err = readJSONControlResponse[control.CreateTCPTunnelResponse](ctrlStream)
+	resp, notices, err := readCreateResponse[control.CreateTCPTunnelResponse](ctrlStream)
 	if err != nil {
 		_ = session.Close()
 		_ = ws.Close(websocket.StatusInternalError, "control error")
-		return nil, nil, resp, err
+		return nil, nil, resp, controlNotices{}, err
 	}
 
 	if resp.Error != "" {
+		notices.close()
 		_ = session.Close()
 		_ = ws.Close(websocket.StatusPolicyViolation, resp.Error)
-		return nil, nil, resp, errors.New(resp.Error)
+		return nil, nil, resp, controlNotices{}, errors.New(resp.Error)
 	}
 
-	return ws, session, resp, nil
+	return ws, session, resp, notices, nil
 }
 
 func (t *TCPTunnel) conn() (*websocket.Conn, *yamux.Session) {
@@ -239,14 +306,25 @@ func (t *TCPTunnel) reconnect(ctx context.Context) error {
 			}
 			return nil
 		}
+		if t.expiresAt != 0 && time.Now().Unix() >= t.expiresAt {
+			return &TunnelClosedError{Reason: control.TunnelClosedExpired}
+		}
 
-		ws, session, resp, err := createTCPTunnel(ctx, t.controlURL, control.CreateTCPTunnelRequest{
+		ws, session, resp, notices, err := createTCPTunnel(ctx, t.controlURL, control.CreateTCPTunnelRequest{
 			Type:       "tcp",
 			Authtoken:  t.authtoken,
 			RemotePort: t.RemotePort,
+			Secret:     t.secret,
+			Name:       t.Name,
+			AllowCIDR:  t.allowCIDR,
+			DenyCIDR:   t.denyCIDR,
+			Bandwidth:  t.bandwidth,
+			ExpiresAt:  t.expiresAt,
+			Notices:    true,
 		})
 		if err == nil {
 			if t.closing.Load() || ctx.Err() != nil {
+				notices.close()
 				_ = session.Close()
 				_ = ws.Close(websocket.StatusNormalClosure, "closed")
 				if ctx.Err() != nil {
@@ -255,7 +333,8 @@ func (t *TCPTunnel) reconnect(ctx context.Context) error {
 				return nil
 			}
 
-			if resp.RemotePort != t.RemotePort {
+			if resp.RemotePort != t.RemotePort || resp.Name != t.Name {
+				notices.close()
 				_ = session.Close()
 				_ = ws.Close(websocket.StatusInternalError, "resume mismatch")
 				return errors.New("resume mismatch")
@@ -268,6 +347,7 @@ func (t *TCPTunnel) reconnect(ctx context.Context) error {
 			if oldWS != nil {
 				_ = oldWS.Close(websocket.StatusGoingAway, "reconnected")
 			}
+			go notices.watch(t.closedByServer)
 			return nil
 		}
 
@@ -296,7 +376,7 @@ func isRetryableTCPControlError(err error) bool {
 		return false
 	}
 	switch strings.ToLower(strings.TrimSpace(err.Error())) {
-	case "requested port unavailable", "too many active tunnels", "rate limit exceeded":
+	case "requested port unavailable", "name already in use", "too many active tunnels", "rate limit exceeded":
 		return true
 	default:
 		return false
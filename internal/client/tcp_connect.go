@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// TCPConnector exposes a public TCP tunnel on a local listener by carrying
+// each local connection over a WebSocket to the server's /tcp/<port> bridge.
+// It is meant for networks that only allow outbound HTTPS.
+type TCPConnector struct {
+	ln        net.Listener
+	bridgeURL string
+
+	closing   atomic.Bool
+	closeOnce sync.Once
+	done      chan error
+}
+
+// TCPBridgeURL derives the WebSocket bridge URL for a TCP tunnel port from
+// a control URL (ws(s)://host[:port]/control).
+func TCPBridgeURL(controlURL string, port int) (string, error) {
+	if port <= 0 || port > 65535 {
+		return "", fmt.Errorf("invalid port: %d", port)
+	}
+
+	u, err := url.Parse(controlURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return "", fmt.Errorf("invalid control url scheme: %q", u.Scheme)
+	}
+
+	u.Path = "/tcp/" + strconv.Itoa(port)
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+func StartTCPConnector(ctx context.Context, bridgeURL, localAddr string) (*TCPConnector, error) {
+	if err := probeTCPBridge(ctx, bridgeURL); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &TCPConnector{
+		ln:        ln,
+		bridgeURL: bridgeURL,
+		done:      make(chan error, 1),
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = c.Close()
+	}()
+
+	go c.acceptLocal(ctx)
+
+	return c, nil
+}
+
+// LocalAddr is the address the connector listens on.
+func (c *TCPConnector) LocalAddr() string {
+	return c.ln.Addr().String()
+}
+
+func (c *TCPConnector) Close() error {
+	var closeErr error
+
+	c.closeOnce.Do(func() {
+		c.closing.Store(true)
+		closeErr = c.ln.Close()
+	})
+
+	return closeErr
+}
+
+func (c *TCPConnector) Wait() error {
+	return <-c.done
+}
+
+func (c *TCPConnector) acceptLocal(ctx context.Context) {
+	for {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			var finishErr error
+			switch {
+			case ctx.Err() != nil:
+				finishErr = ctx.Err()
+			case c.closing.Load():
+			default:
+				finishErr = err
+			}
+			select {
+			case c.done <- finishErr:
+			default:
+			}
+			return
+		}
+
+		go c.handleConn(ctx, conn)
+	}
+}
+
+func (c *TCPConnector) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	ws, _, err := websocket.Dial(dialCtx, c.bridgeURL, &websocket.DialOptions{
+		CompressionMode: websocket.CompressionDisabled,
+	})
+	cancel()
+	if err != nil {
+		return
+	}
+	defer ws.Close(websocket.StatusNormalClosure, "closed")
+
+	remote := websocket.NetConn(ctx, ws, websocket.MessageBinary)
+	defer remote.Close()
+
+	_ = proxyBidirectional(ctx, conn, remote)
+}
+
+// probeTCPBridge checks the tunnel exists (and admits us) before listening,
+// without opening a stream to the agent. The bridge rejects unknown ports and
+// denied clients before the WebSocket upgrade.
+func probeTCPBridge(ctx context.Context, bridgeURL string) error {
+	u, err := url.Parse(bridgeURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return errors.New("tunnel not found")
+	case http.StatusForbidden:
+		return errors.New("forbidden")
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+func TestTCPBridgeURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		controlURL string
+		port       int
+		want       string
+		wantErr    bool
+	}{
+		{"wss://eosrift.com/control", 20001, "wss://eosrift.com/tcp/20001", false},
+		{"ws://127.0.0.1:8080/control", 20001, "ws://127.0.0.1:8080/tcp/20001", false},
+		{"https://eosrift.com", 20001, "", true},
+		{"wss://eosrift.com/control", 0, "", true},
+	}
+	for _, tt := range tests {
+		got, err := TCPBridgeURL(tt.controlURL, tt.port)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("TCPBridgeURL(%q, %d) err = %v, wantErr %v", tt.controlURL, tt.port, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("TCPBridgeURL(%q, %d) = %q, want %q", tt.controlURL, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestTCPConnector(t *testing.T) {
+	t.Parallel()
+
+	// Fake bridge: /tcp/20001 echoes, anything else is an unknown tunnel.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tcp/20001" {
+			http.Error(w, "tunnel not found", http.StatusNotFound)
+			return
+		}
+		ws, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := websocket.NetConn(r.Context(), ws, websocket.MessageBinary)
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	base := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := StartTCPConnector(ctx, base+"/tcp/20002", "127.0.0.1:0"); err == nil || err.Error() != "tunnel not found" {
+		t.Fatalf("err = %v, want tunnel not found", err)
+	}
+
+	connector, err := StartTCPConnector(ctx, base+"/tcp/20001", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer connector.Close()
+
+	c, err := net.Dial("tcp", connector.LocalAddr())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(c, "ping"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("echo = %q, want %q", buf, "ping")
+	}
+
+	_ = connector.Close()
+	if err := connector.Wait(); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+}
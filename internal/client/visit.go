@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+	"github.com/hashicorp/yamux"
+	"nhooyr.io/websocket"
+)
+
+// TCPVisitor exposes a secret TCP tunnel on a local listener. Each local
+// connection becomes a yamux stream on the visitor's own control session,
+// which the server splices to the owning agent.
+type TCPVisitor struct {
+	Name string
+
+	ln         net.Listener
+	controlURL string
+	authtoken  string
+	secret     string
+
+	mu      sync.Mutex
+	ws      *websocket.Conn
+	session *yamux.Session
+
+	closing   atomic.Bool
+	closeOnce sync.Once
+	done      chan error
+}
+
+type TCPVisitorOptions struct {
+	Authtoken string
+	Secret    string
+}
+
+func StartTCPVisitor(ctx context.Context, controlURL, name, localAddr string, opts TCPVisitorOptions) (*TCPVisitor, error) {
+	ws, session, resp, err := createVisitSession(ctx, controlURL, control.VisitTCPTunnelRequest{
+		Type:      "visit",
+		Authtoken: opts.Authtoken,
+		Name:      name,
+		Secret:    opts.Secret,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		_ = session.Close()
+		_ = ws.Close(websocket.StatusNormalClosure, "closed")
+		return nil, err
+	}
+
+	v := &TCPVisitor{
+		Name:       resp.Name,
+		ln:         ln,
+		controlURL: controlURL,
+		authtoken:  opts.Authtoken,
+		secret:     opts.Secret,
+		ws:         ws,
+		session:    session,
+		done:       make(chan error, 1),
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = v.Close()
+	}()
+
+	go v.acceptLocal(ctx)
+	go v.watchSession(ctx)
+
+	return v, nil
+}
+
+// LocalAddr is the address the visitor listens on.
+func (v *TCPVisitor) LocalAddr() string {
+	return v.ln.Addr().String()
+}
+
+func (v *TCPVisitor) Close() error {
+	var closeErr error
+
+	v.closeOnce.Do(func() {
+		v.closing.Store(true)
+		closeErr = v.ln.Close()
+
+		ws, session := v.conn()
+		if session != nil {
+			_ = session.Close()
+		}
+		if ws != nil {
+			_ = ws.Close(websocket.StatusNormalClosure, "closed")
+		}
+	})
+
+	return closeErr
+}
+
+func (v *TCPVisitor) Wait() error {
+	return <-v.done
+}
+
+func (v *TCPVisitor) finish(err error) {
+	select {
+	case v.done <- err:
+	default:
+	}
+}
+
+func (v *TCPVisitor) acceptLocal(ctx context.Context) {
+	for {
+		c, err := v.ln.Accept()
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				v.finish(ctx.Err())
+			case v.closing.Load():
+				v.finish(nil)
+			default:
+				v.finish(err)
+			}
+			return
+		}
+
+		go v.handleConn(ctx, c)
+	}
+}
+
+func (v *TCPVisitor) handleConn(ctx context.Context, c net.Conn) {
+	defer c.Close()
+
+	_, session := v.conn()
+	if session == nil {
+		return
+	}
+
+	stream, err := session.OpenStream()
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+
+	_ = proxyBidirectional(ctx, c, stream)
+}
+
+// watchSession re-establishes the visit session when the control connection
+// drops.
+func (v *TCPVisitor) watchSession(ctx context.Context) {
+	for {
+		_, session := v.conn()
+		if session == nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-session.CloseChan():
+		}
+
+		if v.closing.Load() || ctx.Err() != nil {
+			return
+		}
+
+		if err := v.reconnect(ctx); err != nil {
+			v.finish(err)
+			_ = v.Close()
+			return
+		}
+	}
+}
+
+func (v *TCPVisitor) reconnect(ctx context.Context) error {
+	delay := 250 * time.Millisecond
+	const maxDelay = 5 * time.Second
+
+	for {
+		if v.closing.Load() || ctx.Err() != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return nil
+		}
+
+		ws, session, _, err := createVisitSession(ctx, v.controlURL, control.VisitTCPTunnelRequest{
+			Type:      "visit",
+			Authtoken: v.authtoken,
+			Name:      v.Name,
+			Secret:    v.secret,
+		})
+		if err == nil {
+			if v.closing.Load() || ctx.Err() != nil {
+				_ = session.Close()
+				_ = ws.Close(websocket.StatusNormalClosure, "closed")
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return nil
+			}
+
+			oldWS, oldSession := v.setConn(ws, session)
+			if oldSession != nil {
+				_ = oldSession.Close()
+			}
+			if oldWS != nil {
+				_ = oldWS.Close(websocket.StatusGoingAway, "reconnected")
+			}
+			return nil
+		}
+
+		if isRetryableVisitControlError(err) {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+
+			delay *= 2
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+			continue
+		}
+
+		return err
+	}
+}
+
+// isRetryableVisitControlError also retries "tunnel not found": after a
+// disconnect the owning agent is usually reconnecting too.
+func isRetryableVisitControlError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(err.Error())) {
+	case "tunnel not found", "too many active tunnels", "rate limit exceeded":
+		return true
+	default:
+		return false
+	}
+}
+
+func createVisitSession(ctx context.Context, controlURL string, req control.VisitTCPTunnelRequest) (*websocket.Conn, *yamux.Session, control.VisitTCPTunnelResponse, error) {
+	var resp control.VisitTCPTunnelResponse
+
+	ws, session, err := dialControlWithRetry(ctx, controlURL)
+	if err != nil {
+		return nil, nil, resp, err
+	}
+
+	ctrlStream, err := session.OpenStream()
+	if err != nil {
+		_ = session.Close()
+		_ = ws.Close(websocket.StatusInternalError, "control error")
+		return nil, nil, resp, err
+	}
+
+	if err := control.WriteJSON(ctrlStream, req); err != nil {
+		_ = ctrlStream.Close()
+		_ = session.Close()
+		_ = ws.Close(websocket.StatusInternalError, "control error")
+		return nil, nil, resp, err
+	}
+
+	resp, err = readJSONControlResponse[control.VisitTCPTunnelResponse](ctrlStream)
+	if err != nil {
+		_ = session.Close()
+		_ = ws.Close(websocket.StatusInternalError, "control error")
+		return nil, nil, resp, err
+	}
+
+	if resp.Error != "" {
+		_ = session.Close()
+		_ = ws.Close(websocket.StatusPolicyViolation, resp.Error)
+		return nil, nil, resp, errors.New(resp.Error)
+	}
+
+	return ws, session, resp, nil
+}
+
+func (v *TCPVisitor) conn() (*websocket.Conn, *yamux.Session) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.ws, v.session
+}
+
+func (v *TCPVisitor) setConn(ws *websocket.Conn, session *yamux.Session) (*websocket.Conn, *yamux.Session) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	oldWS, oldSession := v.ws, v.session
+	v.ws, v.session = ws, session
+	return oldWS, oldSession
+}
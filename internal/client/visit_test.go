@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/config"
+	"eosrift.com/eosrift/internal/control"
+	"eosrift.com/eosrift/internal/mux"
+	"github.com/hashicorp/yamux"
+	"nhooyr.io/websocket"
+)
+
+func TestTCPVisitor_SplicesLocalConnections(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reqCh := make(chan control.VisitTCPTunnelRequest, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/control" {
+			http.NotFound(w, r)
+			return
+		}
+
+		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+			CompressionMode: websocket.CompressionDisabled,
+		})
+		if err != nil {
+			return
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "closed")
+
+		netConn := websocket.NetConn(r.Context(), conn, websocket.MessageBinary)
+		session, err := yamux.Server(netConn, mux.QuietYamuxConfig())
+		if err != nil {
+			return
+		}
+		defer session.Close()
+
+		ctrlStream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+
+		var req control.VisitTCPTunnelRequest
+		if err := json.NewDecoder(ctrlStream).Decode(&req); err != nil {
+			return
+		}
+		reqCh <- req
+
+		_ = json.NewEncoder(ctrlStream).Encode(control.VisitTCPTunnelResponse{
+			Type: "visit",
+			Name: "db",
+		})
+		_ = ctrlStream.Close()
+
+		// Stand in for the owning agent: echo every visitor stream.
+		for {
+			st, err := session.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func(st *yamux.Stream) {
+				defer st.Close()
+				_, _ = io.Copy(st, st)
+			}(st)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	controlURL, err := config.ControlURLFromServerAddr(srv.URL)
+	if err != nil {
+		t.Fatalf("control url: %v", err)
+	}
+
+	visitor, err := StartTCPVisitor(ctx, controlURL, "DB", "127.0.0.1:0", TCPVisitorOptions{
+		Authtoken: "tok_123",
+		Secret:    "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("start visitor: %v", err)
+	}
+	defer visitor.Close()
+
+	select {
+	case req := <-reqCh:
+		if req.Type != "visit" || req.Name != "DB" || req.Secret != "s3cret" || req.Authtoken != "tok_123" {
+			t.Fatalf("request = %+v", req)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timeout waiting for request")
+	}
+
+	if visitor.Name != "db" {
+		t.Fatalf("name = %q, want %q", visitor.Name, "db")
+	}
+
+	conn, err := net.DialTimeout("tcp", visitor.LocalAddr(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial visitor: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	if _, err := io.WriteString(conn, "hello"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("echo = %q, want %q", buf, "hello")
+	}
+}
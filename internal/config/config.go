@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"eosrift.com/eosrift/internal/control"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,6 +23,10 @@ type File struct {
 	Inspect     *bool  `yaml:"inspect,omitempty"`
 	InspectAddr string `yaml:"inspect_addr,omitempty"`
 
+	// OTLPEndpoint exports request traces to an OpenTelemetry collector
+	// (OTLP/HTTP, e.g. http://127.0.0.1:4318).
+	OTLPEndpoint string `yaml:"otlp_endpoint,omitempty"`
+
 	Tunnels map[string]Tunnel `yaml:"tunnels,omitempty"`
 }
 
@@ -30,28 +35,86 @@ type Tunnel struct {
 	Addr  string `yaml:"addr,omitempty"`
 
 	// HTTP-only options.
-	Domain               string        `yaml:"domain,omitempty"`
-	Subdomain            string        `yaml:"subdomain,omitempty"`
-	BasicAuth            string        `yaml:"basic_auth,omitempty"`
-	AllowMethod          []string      `yaml:"allow_method,omitempty"`
-	AllowPath            []string      `yaml:"allow_path,omitempty"`
-	AllowPathPrefix      []string      `yaml:"allow_path_prefix,omitempty"`
-	AllowCIDR            []string      `yaml:"allow_cidr,omitempty"`
-	DenyCIDR             []string      `yaml:"deny_cidr,omitempty"`
-	RequestHeaderAdd     HeaderAddList `yaml:"request_header_add,omitempty"`
-	RequestHeaderRemove  []string      `yaml:"request_header_remove,omitempty"`
-	ResponseHeaderAdd    HeaderAddList `yaml:"response_header_add,omitempty"`
-	ResponseHeaderRemove []string      `yaml:"response_header_remove,omitempty"`
-	HostHeader           string        `yaml:"host_header,omitempty"`
+	Domain               string                 `yaml:"domain,omitempty"`
+	Subdomain            string                 `yaml:"subdomain,omitempty"`
+	BasicAuth            string                 `yaml:"basic_auth,omitempty"`
+	AllowMethod          []string               `yaml:"allow_method,omitempty"`
+	AllowPath            []string               `yaml:"allow_path,omitempty"`
+	AllowPathPrefix      []string               `yaml:"allow_path_prefix,omitempty"`
+	AllowCIDR            []string               `yaml:"allow_cidr,omitempty"`
+	DenyCIDR             []string               `yaml:"deny_cidr,omitempty"`
+	RateLimit            string                 `yaml:"rate_limit,omitempty"`
+	RateLimitHeader      string                 `yaml:"rate_limit_header,omitempty"`
+	Redirect             []control.RedirectRule `yaml:"redirect,omitempty"`
+	Rewrite              []control.RewriteRule  `yaml:"rewrite,omitempty"`
+	RequestHeaderAdd     HeaderAddList          `yaml:"request_header_add,omitempty"`
+	RequestHeaderRemove  []string               `yaml:"request_header_remove,omitempty"`
+	ResponseHeaderAdd    HeaderAddList          `yaml:"response_header_add,omitempty"`
+	ResponseHeaderRemove []string               `yaml:"response_header_remove,omitempty"`
+	HostHeader           string                 `yaml:"host_header,omitempty"`
+
+	// BasicAuthUsers lists extra "user:<hash>" (or "user:pass") credentials
+	// and BasicAuthFile loads more from an htpasswd file (relative paths are
+	// resolved against the config file's directory).
+	BasicAuthUsers []string `yaml:"basic_auth_users,omitempty"`
+	BasicAuthFile  string   `yaml:"basic_auth_file,omitempty"`
+
+	// ShareLinks requires a signed share link (`eosrift share`) or basic
+	// auth to visit the tunnel.
+	ShareLinks bool `yaml:"share_links,omitempty"`
+
+	// JWT requires a valid bearer token on every request; see JWTAuth.
+	JWT *JWTAuth `yaml:"jwt,omitempty"`
+
+	// CORS makes the edge answer preflights and set CORS response headers.
+	CORS *control.CORSPolicy `yaml:"cors,omitempty"`
+
+	// Compression makes the server compress responses at the edge.
+	Compression *control.CompressionPolicy `yaml:"compression,omitempty"`
+
+	// Cache enables the server's edge cache for GET responses.
+	Cache *control.CachePolicy `yaml:"cache,omitempty"`
+
+	// Limits lowers the server's request body size and timeout limits.
+	Limits *control.HTTPLimits `yaml:"limits,omitempty"`
+
+	// Mirror copies requests to a shadow tunnel of the same authtoken.
+	Mirror *control.MirrorPolicy `yaml:"mirror,omitempty"`
+
+	// Expires (a duration such as "2h") or Until (a time) makes the server
+	// close the tunnel; counted from when `eosrift start` opens it.
+	Expires string `yaml:"expires,omitempty"`
+	Until   string `yaml:"until,omitempty"`
+
+	// Bandwidth paces the tunnel's traffic at the server, per direction.
+	Bandwidth *control.BandwidthLimits `yaml:"bandwidth,omitempty"`
+
+	// TrafficPolicy is an inline traffic policy. TrafficPolicyFile loads one
+	// from a YAML/JSON file (relative paths are resolved against the config
+	// file's directory); when both are set the file's rules run second.
+	TrafficPolicy     *control.TrafficPolicy `yaml:"traffic_policy,omitempty"`
+	TrafficPolicyFile string                 `yaml:"traffic_policy_file,omitempty"`
 
 	// TCP-only options.
 	RemotePort int `yaml:"remote_port,omitempty"`
+	// Secret makes a TCP tunnel private (reachable via `eosrift visit`).
+	Secret     string `yaml:"secret,omitempty"`
+	SecretName string `yaml:"secret_name,omitempty"`
 
 	// Optional per-tunnel inspector overrides.
 	Inspect     *bool  `yaml:"inspect,omitempty"`
 	InspectAddr string `yaml:"inspect_addr,omitempty"`
 }
 
+// JWTAuth is a tunnel's jwt block: the control-protocol fields plus
+// jwks_file, a local JWKS read by the client (relative paths are resolved
+// against the config file's directory).
+type JWTAuth struct {
+	control.JWTAuth `yaml:",inline"`
+
+	JWKSFile string `yaml:"jwks_file,omitempty"`
+}
+
 func DefaultPath() string {
 	if v := os.Getenv("XDG_CONFIG_HOME"); v != "" {
 		return filepath.Join(v, "eosrift", "eosrift.yml")
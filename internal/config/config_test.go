@@ -156,6 +156,13 @@ tunnels:
       - X-Resp: ok
     response_header_remove:
       - X-Upstream
+    jwt:
+      jwks_url: https://issuer.example.com/jwks.json
+      audience: [api]
+      claim_headers:
+        - claim: sub
+          header: X-User
+      jwks_file: keys.json
   db:
     proto: tcp
     addr: 127.0.0.1:5432
@@ -211,6 +218,12 @@ tunnels:
 	if len(web.ResponseHeaderRemove) != 1 || web.ResponseHeaderRemove[0] != "X-Upstream" {
 		t.Fatalf("web response_header_remove = %#v, want %q", web.ResponseHeaderRemove, "X-Upstream")
 	}
+	if web.JWT == nil || web.JWT.JWKSURL != "https://issuer.example.com/jwks.json" || web.JWT.JWKSFile != "keys.json" {
+		t.Fatalf("web jwt = %+v, want jwks_url and jwks_file set", web.JWT)
+	}
+	if len(web.JWT.Audience) != 1 || len(web.JWT.ClaimHeaders) != 1 || web.JWT.ClaimHeaders[0].Header != "X-User" {
+		t.Fatalf("web jwt = %+v, want audience and claim_headers set", web.JWT)
+	}
 
 	db := cfg.Tunnels["db"]
 	if db.Proto != "tcp" || db.Addr != "127.0.0.1:5432" || db.RemotePort != 20001 {
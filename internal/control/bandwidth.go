@@ -0,0 +1,62 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MinBandwidthBytesPerSecond is the slowest rate a tunnel may ask for
+// (1 KiB/s); anything lower would stall most protocols.
+const MinBandwidthBytesPerSecond = 1 << 10
+
+// BandwidthLimits paces the bytes a tunnel carries, per direction. Zero
+// fields use the server defaults; the server never lets a tunnel exceed its
+// own limits.
+type BandwidthLimits struct {
+	// InBytesPerSecond paces traffic from visitors to the upstream
+	// (request bodies, uploads).
+	InBytesPerSecond int64 `json:"in_bytes_per_second,omitempty" yaml:"in_bytes_per_second,omitempty"`
+
+	// OutBytesPerSecond paces traffic from the upstream to visitors
+	// (responses, downloads).
+	OutBytesPerSecond int64 `json:"out_bytes_per_second,omitempty" yaml:"out_bytes_per_second,omitempty"`
+
+	// BurstBytes is how much may pass at full speed before pacing starts.
+	// Zero means one second's worth of the rate.
+	BurstBytes int64 `json:"burst_bytes,omitempty" yaml:"burst_bytes,omitempty"`
+}
+
+// ValidateBandwidthLimits checks b; it returns nil when b is nil or all
+// zero.
+func ValidateBandwidthLimits(b *BandwidthLimits) (*BandwidthLimits, error) {
+	if b == nil || *b == (BandwidthLimits{}) {
+		return nil, nil
+	}
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"in_bytes_per_second", b.InBytesPerSecond},
+		{"out_bytes_per_second", b.OutBytesPerSecond},
+	} {
+		if f.value < 0 || (f.value > 0 && f.value < MinBandwidthBytesPerSecond) {
+			return nil, fmt.Errorf("invalid %s: must be 0 or at least %d", f.name, MinBandwidthBytesPerSecond)
+		}
+	}
+	if b.BurstBytes < 0 {
+		return nil, errors.New("invalid burst_bytes: must not be negative")
+	}
+	out := *b
+	return &out, nil
+}
+
+// ParseByteRate parses a rate like "512KB", "10MB/s" or "1MiB/s" into bytes
+// per second; see ParseByteSize for the units.
+func ParseByteRate(s string) (int64, error) {
+	n, err := ParseByteSize(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n, nil
+}
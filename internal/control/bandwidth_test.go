@@ -0,0 +1,44 @@
+package control
+
+import "testing"
+
+func TestValidateBandwidthLimits(t *testing.T) {
+	t.Parallel()
+
+	if b, err := ValidateBandwidthLimits(&BandwidthLimits{}); err != nil || b != nil {
+		t.Fatalf("zero limits = %+v, %v; want nil", b, err)
+	}
+	in := &BandwidthLimits{OutBytesPerSecond: 1 << 20, BurstBytes: 256 << 10}
+	if b, err := ValidateBandwidthLimits(in); err != nil || *b != *in {
+		t.Fatalf("ValidateBandwidthLimits = %+v, %v", b, err)
+	}
+	for _, bad := range []BandwidthLimits{
+		{InBytesPerSecond: -1},
+		{OutBytesPerSecond: 100},
+		{BurstBytes: -1},
+	} {
+		bad := bad
+		if _, err := ValidateBandwidthLimits(&bad); err == nil {
+			t.Errorf("ValidateBandwidthLimits(%+v) err = nil, want error", bad)
+		}
+	}
+}
+
+func TestParseByteRate(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]int64{
+		"512KB":    512_000,
+		"10MB/s":   10_000_000,
+		"1 MiB/s ": 1 << 20,
+	} {
+		if got, err := ParseByteRate(in); err != nil || got != want {
+			t.Errorf("ParseByteRate(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "/s", "10Mbit/s", "fast"} {
+		if _, err := ParseByteRate(bad); err == nil {
+			t.Errorf("ParseByteRate(%q) err = nil, want error", bad)
+		}
+	}
+}
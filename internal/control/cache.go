@@ -0,0 +1,38 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxCacheTTLSeconds caps CachePolicy.DefaultTTLSeconds (1 day).
+const MaxCacheTTLSeconds = 86400
+
+// CachePolicy enables the server's edge cache for GET responses of an HTTP
+// tunnel. The cache honours Cache-Control, ETag/Last-Modified and Vary; the
+// operator decides whether it lives in memory or on disk.
+type CachePolicy struct {
+	// MaxBytes caps the cached bodies. Zero uses the server's limit, and
+	// larger values are lowered to it.
+	MaxBytes int64 `json:"max_bytes,omitempty" yaml:"max_bytes,omitempty"`
+
+	// DefaultTTLSeconds keeps responses that carry no freshness information
+	// of their own (no max-age or Expires) for this long. Zero caches them
+	// only for revalidation with ETag or Last-Modified.
+	DefaultTTLSeconds int `json:"default_ttl_seconds,omitempty" yaml:"default_ttl_seconds,omitempty"`
+}
+
+// ValidateCachePolicy checks p and returns a copy; nil when p is nil.
+func ValidateCachePolicy(p *CachePolicy) (*CachePolicy, error) {
+	if p == nil {
+		return nil, nil
+	}
+	if p.MaxBytes < 0 {
+		return nil, errors.New("cache: max_bytes must not be negative")
+	}
+	if p.DefaultTTLSeconds < 0 || p.DefaultTTLSeconds > MaxCacheTTLSeconds {
+		return nil, fmt.Errorf("cache: default_ttl_seconds must be between 0 and %d", MaxCacheTTLSeconds)
+	}
+	out := *p
+	return &out, nil
+}
@@ -0,0 +1,112 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	maxCompressionTypes = 32
+
+	// DefaultCompressionMinSize is the smallest response body the edge
+	// compresses when a policy sets no min_size.
+	DefaultCompressionMinSize = 1024
+)
+
+// CompressionPolicy makes the edge compress proxied responses for clients
+// that accept it. Responses the upstream already encoded, event streams and
+// WebSocket upgrades are never touched.
+type CompressionPolicy struct {
+	// Encodings lists the codings to offer, most preferred first: "zstd",
+	// "br" and/or "gzip". Defaults to all three in that order.
+	Encodings []string `json:"encodings,omitempty" yaml:"encodings,omitempty"`
+
+	// ContentTypes lists compressible media types: "text/html", "text/*" or
+	// "application/*+json". Defaults to DefaultCompressionTypes.
+	ContentTypes []string `json:"content_types,omitempty" yaml:"content_types,omitempty"`
+
+	// MinSize is the smallest body (in bytes) worth compressing. Defaults to
+	// DefaultCompressionMinSize.
+	MinSize int64 `json:"min_size,omitempty" yaml:"min_size,omitempty"`
+}
+
+// CompressionEncodings are the supported codings, in default preference
+// order.
+var CompressionEncodings = []string{"zstd", "br", "gzip"}
+
+// DefaultCompressionTypes are compressed when a policy lists no types.
+var DefaultCompressionTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/javascript",
+	"application/xml",
+	"application/*+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// ValidateCompressionPolicy checks a compression policy and returns it with
+// defaults filled in and media types lowercased.
+func ValidateCompressionPolicy(p *CompressionPolicy) (*CompressionPolicy, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	out := CompressionPolicy{MinSize: p.MinSize}
+	for _, raw := range p.Encodings {
+		enc := strings.ToLower(strings.TrimSpace(raw))
+		if !slices.Contains(CompressionEncodings, enc) {
+			return nil, fmt.Errorf("compression: unsupported encoding %q (want zstd, br or gzip)", raw)
+		}
+		if !slices.Contains(out.Encodings, enc) {
+			out.Encodings = append(out.Encodings, enc)
+		}
+	}
+	if len(out.Encodings) == 0 {
+		out.Encodings = slices.Clone(CompressionEncodings)
+	}
+
+	if len(p.ContentTypes) > maxCompressionTypes {
+		return nil, errors.New("compression: too many content_types entries")
+	}
+	for _, raw := range p.ContentTypes {
+		t, err := normalizeMediaRange(raw)
+		if err != nil {
+			return nil, err
+		}
+		out.ContentTypes = append(out.ContentTypes, t)
+	}
+	if len(out.ContentTypes) == 0 {
+		out.ContentTypes = slices.Clone(DefaultCompressionTypes)
+	}
+
+	if p.MinSize < 0 {
+		return nil, errors.New("compression: min_size must not be negative")
+	}
+	if out.MinSize == 0 {
+		out.MinSize = DefaultCompressionMinSize
+	}
+	return &out, nil
+}
+
+// normalizeMediaRange accepts "type/subtype", "type/*" and
+// "type/*+suffix".
+func normalizeMediaRange(raw string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	typ, sub, ok := strings.Cut(s, "/")
+	invalid := fmt.Errorf("compression: invalid content type %q (want type/subtype, type/* or type/*+suffix)", raw)
+	if !ok || !isValidHeaderToken(typ) || strings.Contains(typ, "*") {
+		return "", invalid
+	}
+	switch rest, wild := strings.CutPrefix(sub, "*"); {
+	case sub == "*":
+	case wild && strings.HasPrefix(rest, "+") && isValidHeaderToken(rest[1:]) && !strings.Contains(rest[1:], "*"):
+	case !wild && isValidHeaderToken(sub) && !strings.Contains(sub, "*"):
+	default:
+		return "", invalid
+	}
+	return typ + "/" + sub, nil
+}
@@ -0,0 +1,50 @@
+package control
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidateCompressionPolicy(t *testing.T) {
+	t.Parallel()
+
+	if got, err := ValidateCompressionPolicy(nil); got != nil || err != nil {
+		t.Fatalf("nil: got %#v, %v", got, err)
+	}
+
+	got, err := ValidateCompressionPolicy(&CompressionPolicy{})
+	if err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	if !slices.Equal(got.Encodings, CompressionEncodings) || !slices.Equal(got.ContentTypes, DefaultCompressionTypes) || got.MinSize != DefaultCompressionMinSize {
+		t.Fatalf("defaults = %#v", got)
+	}
+
+	got, err = ValidateCompressionPolicy(&CompressionPolicy{
+		Encodings:    []string{"GZIP", "br", "gzip"},
+		ContentTypes: []string{" Text/HTML ", "application/*+JSON", "text/*"},
+		MinSize:      10,
+	})
+	if err != nil {
+		t.Fatalf("ValidateCompressionPolicy: %v", err)
+	}
+	if !slices.Equal(got.Encodings, []string{"gzip", "br"}) {
+		t.Fatalf("encodings = %q", got.Encodings)
+	}
+	if !slices.Equal(got.ContentTypes, []string{"text/html", "application/*+json", "text/*"}) {
+		t.Fatalf("content types = %q", got.ContentTypes)
+	}
+
+	for name, p := range map[string]CompressionPolicy{
+		"unknown encoding":   {Encodings: []string{"deflate"}},
+		"any type":           {ContentTypes: []string{"*/*"}},
+		"no subtype":         {ContentTypes: []string{"text"}},
+		"bad wildcard":       {ContentTypes: []string{"text/ht*"}},
+		"negative min size":  {MinSize: -1},
+		"wildcard type only": {ContentTypes: []string{"*/json"}},
+	} {
+		if _, err := ValidateCompressionPolicy(&p); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	maxCORSOrigins     = 32
+	maxCORSMethods     = 16
+	maxCORSHeaders     = 32
+	maxCORSOriginBytes = 256
+
+	// MaxCORSMaxAge caps how long browsers may cache a preflight (1 day;
+	// browsers clamp lower anyway).
+	MaxCORSMaxAge = 86400
+)
+
+// CORSPolicy makes the edge answer CORS preflight requests itself and set
+// Access-Control-* headers on proxied responses, replacing any the upstream
+// sends.
+type CORSPolicy struct {
+	// AllowOrigins lists origins ("https://app.example.com"), wildcard
+	// subdomains ("https://*.example.com") or "*" for any origin.
+	AllowOrigins []string `json:"allow_origins" yaml:"allow_origins"`
+
+	// AllowMethods defaults to GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowMethods []string `json:"allow_methods,omitempty" yaml:"allow_methods,omitempty"`
+
+	// AllowHeaders lists request headers preflights may ask for; when empty,
+	// the requested headers are allowed.
+	AllowHeaders []string `json:"allow_headers,omitempty" yaml:"allow_headers,omitempty"`
+
+	// ExposeHeaders lists response headers scripts may read.
+	ExposeHeaders []string `json:"expose_headers,omitempty" yaml:"expose_headers,omitempty"`
+
+	AllowCredentials bool `json:"allow_credentials,omitempty" yaml:"allow_credentials,omitempty"`
+
+	// MaxAge is how long (in seconds) browsers may cache a preflight.
+	MaxAge int `json:"max_age,omitempty" yaml:"max_age,omitempty"`
+}
+
+// DefaultCORSMethods are allowed when a policy lists no methods.
+var DefaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// ValidateCORSPolicy checks a CORS policy and returns it normalized:
+// origins lowercased, methods uppercased, header names canonicalized.
+func ValidateCORSPolicy(p *CORSPolicy) (*CORSPolicy, error) {
+	if p == nil {
+		return nil, nil
+	}
+	if len(p.AllowOrigins) == 0 {
+		return nil, errors.New("cors: allow_origins is required")
+	}
+	if len(p.AllowOrigins) > maxCORSOrigins {
+		return nil, errors.New("cors: too many allow_origins entries")
+	}
+
+	out := CORSPolicy{AllowCredentials: p.AllowCredentials, MaxAge: p.MaxAge}
+	for _, raw := range p.AllowOrigins {
+		origin, err := normalizeCORSOrigin(raw)
+		if err != nil {
+			return nil, err
+		}
+		if origin == "*" && p.AllowCredentials {
+			return nil, errors.New(`cors: allow_origins "*" cannot be combined with allow_credentials`)
+		}
+		out.AllowOrigins = append(out.AllowOrigins, origin)
+	}
+
+	methods, err := ParseHTTPMethodList("cors allow_methods", p.AllowMethods, maxCORSMethods)
+	if err != nil {
+		return nil, err
+	}
+	out.AllowMethods = methods
+
+	if out.AllowHeaders, err = parseCORSHeaderList("cors allow_headers", p.AllowHeaders); err != nil {
+		return nil, err
+	}
+	if out.ExposeHeaders, err = parseCORSHeaderList("cors expose_headers", p.ExposeHeaders); err != nil {
+		return nil, err
+	}
+
+	if p.MaxAge < 0 || p.MaxAge > MaxCORSMaxAge {
+		return nil, fmt.Errorf("cors: max_age must be between 0 and %d seconds", MaxCORSMaxAge)
+	}
+	return &out, nil
+}
+
+// normalizeCORSOrigin accepts "*", "scheme://host[:port]" and
+// "scheme://*.domain[:port]".
+func normalizeCORSOrigin(raw string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	if s == "*" {
+		return s, nil
+	}
+
+	invalid := fmt.Errorf("cors: invalid origin %q (want scheme://host[:port])", raw)
+	if s == "" || len(s) > maxCORSOriginBytes {
+		return "", invalid
+	}
+	scheme, host, ok := strings.Cut(s, "://")
+	if !ok || (scheme != "http" && scheme != "https") || host == "" {
+		return "", invalid
+	}
+
+	check := host
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		check = rest
+	}
+	u, err := url.Parse(scheme + "://" + check)
+	if err != nil || u.Host != check || u.Hostname() == "" || strings.Contains(u.Hostname(), "*") {
+		return "", invalid
+	}
+	return scheme + "://" + host, nil
+}
+
+func parseCORSHeaderList(field string, values []string) ([]string, error) {
+	if len(values) > maxCORSHeaders {
+		return nil, fmt.Errorf("invalid %s: too many entries", field)
+	}
+	var out []string
+	for _, raw := range values {
+		s := strings.TrimSpace(raw)
+		if s == "" || !isValidHeaderToken(s) {
+			return nil, fmt.Errorf("invalid %s: %q", field, raw)
+		}
+		out = append(out, http.CanonicalHeaderKey(s))
+	}
+	return out, nil
+}
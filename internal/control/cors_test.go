@@ -0,0 +1,49 @@
+package control
+
+import "testing"
+
+func TestValidateCORSPolicy(t *testing.T) {
+	t.Parallel()
+
+	got, err := ValidateCORSPolicy(&CORSPolicy{
+		AllowOrigins:  []string{" HTTPS://App.Example.com ", "https://*.example.com:8443", "http://localhost:5173"},
+		AllowMethods:  []string{"get", "post"},
+		AllowHeaders:  []string{"content-type"},
+		ExposeHeaders: []string{"x-request-id"},
+		MaxAge:        600,
+	})
+	if err != nil {
+		t.Fatalf("ValidateCORSPolicy: %v", err)
+	}
+	if got.AllowOrigins[0] != "https://app.example.com" || got.AllowOrigins[1] != "https://*.example.com:8443" {
+		t.Fatalf("origins = %#v", got.AllowOrigins)
+	}
+	if got.AllowMethods[0] != "GET" || got.AllowHeaders[0] != "Content-Type" || got.ExposeHeaders[0] != "X-Request-Id" {
+		t.Fatalf("got %#v", got)
+	}
+
+	if got, err := ValidateCORSPolicy(nil); got != nil || err != nil {
+		t.Fatalf("nil: got %#v, %v", got, err)
+	}
+
+	for name, p := range map[string]CORSPolicy{
+		"no origins":          {},
+		"path in origin":      {AllowOrigins: []string{"https://app.test/x"}},
+		"no scheme":           {AllowOrigins: []string{"app.test"}},
+		"ftp origin":          {AllowOrigins: []string{"ftp://app.test"}},
+		"inner wildcard":      {AllowOrigins: []string{"https://a.*.test"}},
+		"bare wildcard":       {AllowOrigins: []string{"https://*"}},
+		"star + credentials":  {AllowOrigins: []string{"*"}, AllowCredentials: true},
+		"bad method":          {AllowOrigins: []string{"*"}, AllowMethods: []string{"GE T"}},
+		"bad header":          {AllowOrigins: []string{"*"}, AllowHeaders: []string{"a:b"}},
+		"negative max age":    {AllowOrigins: []string{"*"}, MaxAge: -1},
+		"max age over a day":  {AllowOrigins: []string{"*"}, MaxAge: MaxCORSMaxAge + 1},
+		"userinfo in origin":  {AllowOrigins: []string{"https://u@app.test"}},
+		"query in origin":     {AllowOrigins: []string{"https://app.test?x"}},
+		"empty host wildcard": {AllowOrigins: []string{"https://*."}},
+	} {
+		if _, err := ValidateCORSPolicy(&p); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package control
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HeaderTemplateVars lists the variables header values may reference as
+// ${name}. They are expanded per request at the edge; "$${" is a literal
+// "${".
+var HeaderTemplateVars = []string{
+	"client_ip",
+	"tunnel_id",
+	"request_id",
+	"host",
+	"time",
+	"basic_auth_user",
+}
+
+// HeaderTemplatePart is either literal text or a variable reference.
+type HeaderTemplatePart struct {
+	Literal string
+	Var     string
+}
+
+// ParseHeaderTemplate splits value into literal text and ${var} references,
+// rejecting unknown variables.
+func ParseHeaderTemplate(field, value string) ([]HeaderTemplatePart, error) {
+	var (
+		parts []HeaderTemplatePart
+		lit   strings.Builder
+	)
+	for s := value; s != ""; {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			lit.WriteString(s)
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			lit.WriteString(s[:i-1])
+			lit.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		lit.WriteString(s[:i])
+		s = s[i+2:]
+
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("invalid %s: unterminated ${ in %q", field, value)
+		}
+		name := s[:end]
+		if !isHeaderTemplateVar(name) {
+			return nil, fmt.Errorf("invalid %s: unknown variable ${%s} (known: %s)", field, name, strings.Join(HeaderTemplateVars, ", "))
+		}
+		s = s[end+1:]
+
+		if lit.Len() > 0 {
+			parts = append(parts, HeaderTemplatePart{Literal: lit.String()})
+			lit.Reset()
+		}
+		parts = append(parts, HeaderTemplatePart{Var: name})
+	}
+	if lit.Len() > 0 {
+		parts = append(parts, HeaderTemplatePart{Literal: lit.String()})
+	}
+	return parts, nil
+}
+
+// ValidateHeaderTemplate reports whether value only references known
+// variables.
+func ValidateHeaderTemplate(field, value string) error {
+	_, err := ParseHeaderTemplate(field, value)
+	return err
+}
+
+func isHeaderTemplateVar(name string) bool {
+	for _, v := range HeaderTemplateVars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
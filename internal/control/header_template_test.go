@@ -0,0 +1,38 @@
+package control
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaderTemplate(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseHeaderTemplate("x", "user=${basic_auth_user}; ip=${client_ip}")
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	want := []HeaderTemplatePart{
+		{Literal: "user="},
+		{Var: "basic_auth_user"},
+		{Literal: "; ip="},
+		{Var: "client_ip"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	got, err = ParseHeaderTemplate("x", "price $5 and $${host}")
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if want := []HeaderTemplatePart{{Literal: "price $5 and ${host}"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	for _, bad := range []string{"${nope}", "${host", "${}"} {
+		if err := ValidateHeaderTemplate("x", bad); err == nil {
+			t.Fatalf("%q: err = nil, want error", bad)
+		}
+	}
+}
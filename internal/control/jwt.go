@@ -0,0 +1,116 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	maxJWKSBytes         = 32 * 1024
+	maxJWTAudiences      = 16
+	maxJWTClaimHeaders   = 16
+	maxJWTClaimNameBytes = 128
+	maxJWTIssuerBytes    = 512
+	maxJWKSURLBytes      = 2048
+	maxJWTAudienceBytes  = 512
+)
+
+// JWTAuth makes the edge require "Authorization: Bearer <jwt>" on every
+// request. Exactly one of JWKSURL or JWKS supplies the verification keys.
+type JWTAuth struct {
+	// JWKSURL is an https URL the server fetches (and periodically
+	// refreshes) the key set from.
+	JWKSURL string `json:"jwks_url,omitempty" yaml:"jwks_url,omitempty"`
+
+	// JWKS is an inline JSON Web Key Set document (e.g. read from a file by
+	// the client).
+	JWKS string `json:"jwks,omitempty" yaml:"jwks,omitempty"`
+
+	// Issuer, when set, must equal the token's iss claim. Audience, when
+	// set, must contain one of the token's aud values.
+	Issuer   string   `json:"issuer,omitempty" yaml:"issuer,omitempty"`
+	Audience []string `json:"audience,omitempty" yaml:"audience,omitempty"`
+
+	// ClaimHeaders forwards claims of valid tokens to the upstream.
+	ClaimHeaders []JWTClaimHeader `json:"claim_headers,omitempty" yaml:"claim_headers,omitempty"`
+}
+
+// JWTClaimHeader sets request header Header to the value of claim Claim.
+type JWTClaimHeader struct {
+	Claim  string `json:"claim" yaml:"claim"`
+	Header string `json:"header" yaml:"header"`
+}
+
+// ValidateJWTAuth checks a JWT auth block and returns it with header names
+// canonicalized. Key material is parsed by the server, not here.
+func ValidateJWTAuth(a *JWTAuth) (*JWTAuth, error) {
+	if a == nil {
+		return nil, nil
+	}
+	out := *a
+	out.JWKSURL = strings.TrimSpace(a.JWKSURL)
+	out.JWKS = strings.TrimSpace(a.JWKS)
+	out.Issuer = strings.TrimSpace(a.Issuer)
+
+	switch {
+	case out.JWKSURL == "" && out.JWKS == "":
+		return nil, errors.New("jwt: one of jwks_url or jwks is required")
+	case out.JWKSURL != "" && out.JWKS != "":
+		return nil, errors.New("jwt: only one of jwks_url or jwks may be set")
+	}
+	if out.JWKSURL != "" {
+		u, err := url.Parse(out.JWKSURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || len(out.JWKSURL) > maxJWKSURLBytes {
+			return nil, fmt.Errorf("jwt: invalid jwks_url %q (must be https)", a.JWKSURL)
+		}
+	}
+	if len(out.JWKS) > maxJWKSBytes {
+		return nil, errors.New("jwt: jwks too large")
+	}
+	if len(out.Issuer) > maxJWTIssuerBytes {
+		return nil, errors.New("jwt: issuer too long")
+	}
+
+	if len(a.Audience) > maxJWTAudiences {
+		return nil, errors.New("jwt: too many audience entries")
+	}
+	out.Audience = nil
+	for _, aud := range a.Audience {
+		aud = strings.TrimSpace(aud)
+		if aud == "" || len(aud) > maxJWTAudienceBytes {
+			return nil, fmt.Errorf("jwt: invalid audience %q", aud)
+		}
+		out.Audience = append(out.Audience, aud)
+	}
+
+	if len(a.ClaimHeaders) > maxJWTClaimHeaders {
+		return nil, errors.New("jwt: too many claim_headers entries")
+	}
+	out.ClaimHeaders = nil
+	for _, ch := range a.ClaimHeaders {
+		claim := strings.TrimSpace(ch.Claim)
+		if claim == "" || len(claim) > maxJWTClaimNameBytes {
+			return nil, fmt.Errorf("jwt: invalid claim %q", ch.Claim)
+		}
+		name, err := NormalizeHeaderName("jwt claim header", ch.Header)
+		if err != nil {
+			return nil, err
+		}
+		if name == "Authorization" {
+			return nil, fmt.Errorf("jwt: invalid claim header %q", ch.Header)
+		}
+		out.ClaimHeaders = append(out.ClaimHeaders, JWTClaimHeader{Claim: claim, Header: name})
+	}
+	return &out, nil
+}
+
+// ParseJWTClaimHeader parses "claim:Header-Name".
+func ParseJWTClaimHeader(raw string) (JWTClaimHeader, error) {
+	claim, header, ok := strings.Cut(raw, ":")
+	if !ok {
+		return JWTClaimHeader{}, fmt.Errorf("invalid jwt claim header %q (want claim:Header-Name)", raw)
+	}
+	return JWTClaimHeader{Claim: strings.TrimSpace(claim), Header: strings.TrimSpace(header)}, nil
+}
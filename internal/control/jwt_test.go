@@ -0,0 +1,59 @@
+package control
+
+import "testing"
+
+func TestValidateJWTAuth(t *testing.T) {
+	t.Parallel()
+
+	got, err := ValidateJWTAuth(&JWTAuth{
+		JWKSURL:      " https://issuer.test/.well-known/jwks.json ",
+		Audience:     []string{" api "},
+		ClaimHeaders: []JWTClaimHeader{{Claim: "sub", Header: "x-user-id"}},
+	})
+	if err != nil {
+		t.Fatalf("ValidateJWTAuth: %v", err)
+	}
+	if got.JWKSURL != "https://issuer.test/.well-known/jwks.json" {
+		t.Fatalf("jwks_url = %q", got.JWKSURL)
+	}
+	if len(got.Audience) != 1 || got.Audience[0] != "api" {
+		t.Fatalf("audience = %#v", got.Audience)
+	}
+	if got.ClaimHeaders[0].Header != "X-User-Id" {
+		t.Fatalf("header = %q, want canonical", got.ClaimHeaders[0].Header)
+	}
+
+	if got, err := ValidateJWTAuth(nil); got != nil || err != nil {
+		t.Fatalf("nil: got %#v, %v", got, err)
+	}
+
+	for name, a := range map[string]JWTAuth{
+		"no keys":        {},
+		"both keys":      {JWKSURL: "https://a.test/jwks", JWKS: `{"keys":[]}`},
+		"http url":       {JWKSURL: "http://a.test/jwks"},
+		"userinfo url":   {JWKSURL: "https://u:p@a.test/jwks"},
+		"empty audience": {JWKS: "{}", Audience: []string{" "}},
+		"empty claim":    {JWKS: "{}", ClaimHeaders: []JWTClaimHeader{{Header: "X-A"}}},
+		"bad header":     {JWKS: "{}", ClaimHeaders: []JWTClaimHeader{{Claim: "sub", Header: "X A"}}},
+		"authorization":  {JWKS: "{}", ClaimHeaders: []JWTClaimHeader{{Claim: "sub", Header: "authorization"}}},
+	} {
+		if _, err := ValidateJWTAuth(&a); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseJWTClaimHeader(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseJWTClaimHeader(" email : X-User-Email ")
+	if err != nil {
+		t.Fatalf("ParseJWTClaimHeader: %v", err)
+	}
+	if got.Claim != "email" || got.Header != "X-User-Email" {
+		t.Fatalf("got %#v", got)
+	}
+	if _, err := ParseJWTClaimHeader("email"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
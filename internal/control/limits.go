@@ -0,0 +1,94 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxLimitSeconds caps the timeouts in HTTPLimits (1 day).
+const MaxLimitSeconds = 86400
+
+// HTTPLimits bounds each request proxied through an HTTP tunnel. Zero
+// fields use the server defaults; the server never lets a tunnel exceed its
+// own limits.
+type HTTPLimits struct {
+	// MaxRequestBodyBytes rejects larger request bodies with 413.
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes,omitempty" yaml:"max_request_body_bytes,omitempty"`
+
+	// ResponseHeaderTimeoutSeconds is how long the upstream may take to
+	// start responding (504 after that).
+	ResponseHeaderTimeoutSeconds int `json:"response_header_timeout_seconds,omitempty" yaml:"response_header_timeout_seconds,omitempty"`
+
+	// RequestTimeoutSeconds bounds a whole request, including the response
+	// body. WebSocket upgrades are exempt.
+	RequestTimeoutSeconds int `json:"request_timeout_seconds,omitempty" yaml:"request_timeout_seconds,omitempty"`
+
+	// WebSocketIdleTimeoutSeconds closes upgraded connections after this
+	// long without traffic in either direction.
+	WebSocketIdleTimeoutSeconds int `json:"websocket_idle_timeout_seconds,omitempty" yaml:"websocket_idle_timeout_seconds,omitempty"`
+}
+
+// ValidateHTTPLimits checks l; it returns nil when l is nil or all zero.
+func ValidateHTTPLimits(l *HTTPLimits) (*HTTPLimits, error) {
+	if l == nil || *l == (HTTPLimits{}) {
+		return nil, nil
+	}
+	if l.MaxRequestBodyBytes < 0 {
+		return nil, errors.New("invalid max_request_body_bytes: must not be negative")
+	}
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"response_header_timeout_seconds", l.ResponseHeaderTimeoutSeconds},
+		{"request_timeout_seconds", l.RequestTimeoutSeconds},
+		{"websocket_idle_timeout_seconds", l.WebSocketIdleTimeoutSeconds},
+	} {
+		if f.value < 0 || f.value > MaxLimitSeconds {
+			return nil, fmt.Errorf("invalid %s: must be between 0 and %d", f.name, MaxLimitSeconds)
+		}
+	}
+	out := *l
+	return &out, nil
+}
+
+// ParseByteSize parses a size like "512", "64KB", "10MB" or "1GiB". Decimal
+// (KB, MB, GB) and binary (KiB, MiB, GiB) units are accepted; "K", "M" and
+// "G" are binary.
+func ParseByteSize(s string) (int64, error) {
+	t := strings.TrimSpace(s)
+	i := 0
+	for i < len(t) && t[i] >= '0' && t[i] <= '9' {
+		i++
+	}
+	n, err := strconv.ParseInt(t[:i], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	var mult int64
+	switch strings.ToLower(strings.TrimSpace(t[i:])) {
+	case "", "b":
+		mult = 1
+	case "kb":
+		mult = 1000
+	case "k", "kib":
+		mult = 1 << 10
+	case "mb":
+		mult = 1000 * 1000
+	case "m", "mib":
+		mult = 1 << 20
+	case "gb":
+		mult = 1000 * 1000 * 1000
+	case "g", "gib":
+		mult = 1 << 30
+	default:
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > (1<<62)/mult {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return n * mult, nil
+}
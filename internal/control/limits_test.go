@@ -0,0 +1,47 @@
+package control
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]int64{
+		"0":      0,
+		"512":    512,
+		"64KB":   64_000,
+		"64k":    64 << 10,
+		"10MB":   10_000_000,
+		"10 MiB": 10 << 20,
+		"1g":     1 << 30,
+	} {
+		if got, err := ParseByteSize(in); err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "MB", "-1", "1.5MB", "10TB", "99999999999GB"} {
+		if _, err := ParseByteSize(bad); err == nil {
+			t.Errorf("ParseByteSize(%q) err = nil, want error", bad)
+		}
+	}
+}
+
+func TestValidateHTTPLimits(t *testing.T) {
+	t.Parallel()
+
+	if l, err := ValidateHTTPLimits(&HTTPLimits{}); err != nil || l != nil {
+		t.Fatalf("zero limits = %+v, %v; want nil", l, err)
+	}
+	in := &HTTPLimits{MaxRequestBodyBytes: 1024, RequestTimeoutSeconds: 30}
+	if l, err := ValidateHTTPLimits(in); err != nil || *l != *in {
+		t.Fatalf("ValidateHTTPLimits = %+v, %v", l, err)
+	}
+	for _, bad := range []HTTPLimits{
+		{MaxRequestBodyBytes: -1},
+		{ResponseHeaderTimeoutSeconds: -1},
+		{WebSocketIdleTimeoutSeconds: MaxLimitSeconds + 1},
+	} {
+		if _, err := ValidateHTTPLimits(&bad); err == nil {
+			t.Errorf("ValidateHTTPLimits(%+v) err = nil, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultMirrorMaxBodyBytes is how much of a request body the edge
+	// buffers for the mirror when a policy sets no max_body_bytes.
+	DefaultMirrorMaxBodyBytes = 1 << 20
+
+	// MaxMirrorMaxBodyBytes caps MirrorPolicy.MaxBodyBytes.
+	MaxMirrorMaxBodyBytes = 10 << 20
+)
+
+// MirrorPolicy makes the edge copy every request of an HTTP tunnel to a
+// second ("shadow") tunnel of the same authtoken. Shadow responses are
+// discarded; the client only ever sees the primary upstream's response.
+type MirrorPolicy struct {
+	// Tunnel is the shadow tunnel's id (its subdomain label).
+	Tunnel string `json:"tunnel" yaml:"tunnel"`
+
+	// MaxBodyBytes is the largest request body that is mirrored; requests
+	// with larger bodies are proxied but not copied. Defaults to
+	// DefaultMirrorMaxBodyBytes.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty" yaml:"max_body_bytes,omitempty"`
+}
+
+// ValidateMirrorPolicy checks p and returns a copy with the tunnel id
+// lowercased and defaults filled in; nil when p is nil.
+func ValidateMirrorPolicy(p *MirrorPolicy) (*MirrorPolicy, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	out := MirrorPolicy{Tunnel: strings.ToLower(strings.TrimSpace(p.Tunnel)), MaxBodyBytes: p.MaxBodyBytes}
+	if out.Tunnel == "" {
+		return nil, errors.New("mirror: tunnel is required")
+	}
+	if !isValidTunnelLabel(out.Tunnel) {
+		return nil, fmt.Errorf("mirror: invalid tunnel id %q", p.Tunnel)
+	}
+	if out.MaxBodyBytes < 0 || out.MaxBodyBytes > MaxMirrorMaxBodyBytes {
+		return nil, fmt.Errorf("mirror: max_body_bytes must be between 0 and %d", MaxMirrorMaxBodyBytes)
+	}
+	if out.MaxBodyBytes == 0 {
+		out.MaxBodyBytes = DefaultMirrorMaxBodyBytes
+	}
+	return &out, nil
+}
+
+// isValidTunnelLabel reports whether s is a lowercase DNS label.
+func isValidTunnelLabel(s string) bool {
+	if len(s) == 0 || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
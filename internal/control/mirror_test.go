@@ -0,0 +1,32 @@
+package control
+
+import "testing"
+
+func TestValidateMirrorPolicy(t *testing.T) {
+	t.Parallel()
+
+	if p, err := ValidateMirrorPolicy(nil); err != nil || p != nil {
+		t.Fatalf("nil policy = %+v, %v; want nil", p, err)
+	}
+
+	p, err := ValidateMirrorPolicy(&MirrorPolicy{Tunnel: " Shadow-1 "})
+	if err != nil {
+		t.Fatalf("ValidateMirrorPolicy: %v", err)
+	}
+	if p.Tunnel != "shadow-1" || p.MaxBodyBytes != DefaultMirrorMaxBodyBytes {
+		t.Fatalf("policy = %+v", p)
+	}
+
+	for _, bad := range []MirrorPolicy{
+		{},
+		{Tunnel: "a.b"},
+		{Tunnel: "-a"},
+		{Tunnel: "a_b"},
+		{Tunnel: "a", MaxBodyBytes: -1},
+		{Tunnel: "a", MaxBodyBytes: MaxMirrorMaxBodyBytes + 1},
+	} {
+		if _, err := ValidateMirrorPolicy(&bad); err == nil {
+			t.Errorf("ValidateMirrorPolicy(%+v) err = nil, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package control
+
+// TrafficPolicy is a per-tunnel edge policy: ordered rules evaluated by the
+// server for every request (on_request) and response (on_response). The first
+// terminal action (deny, custom_response, redirect, exceeded rate_limit) ends
+// request processing; header actions apply to the proxied request/response.
+type TrafficPolicy struct {
+	OnRequest  []PolicyRule `json:"on_request,omitempty" yaml:"on_request,omitempty"`
+	OnResponse []PolicyRule `json:"on_response,omitempty" yaml:"on_response,omitempty"`
+}
+
+func (p *TrafficPolicy) IsEmpty() bool {
+	return p == nil || (len(p.OnRequest) == 0 && len(p.OnResponse) == 0)
+}
+
+type PolicyRule struct {
+	Name    string         `json:"name,omitempty" yaml:"name,omitempty"`
+	Match   PolicyMatch    `json:"match,omitempty" yaml:"match,omitempty"`
+	Actions []PolicyAction `json:"actions" yaml:"actions"`
+}
+
+// PolicyMatch selects requests. Every set criterion must match; list values
+// within a criterion are alternatives. The path criteria (path, path_prefix,
+// path_glob, path_regex) together form one criterion. An empty match matches
+// everything; Not inverts the result.
+type PolicyMatch struct {
+	Method     []string `json:"method,omitempty" yaml:"method,omitempty"`
+	Host       []string `json:"host,omitempty" yaml:"host,omitempty"`
+	Path       []string `json:"path,omitempty" yaml:"path,omitempty"`
+	PathPrefix []string `json:"path_prefix,omitempty" yaml:"path_prefix,omitempty"`
+	PathGlob   []string `json:"path_glob,omitempty" yaml:"path_glob,omitempty"`
+	PathRegex  []string `json:"path_regex,omitempty" yaml:"path_regex,omitempty"`
+
+	// Header and Query entries must all match.
+	Header []PolicyValueMatch `json:"header,omitempty" yaml:"header,omitempty"`
+	Query  []PolicyValueMatch `json:"query,omitempty" yaml:"query,omitempty"`
+
+	// ClientIP entries are CIDRs or single IPs.
+	ClientIP []string `json:"client_ip,omitempty" yaml:"client_ip,omitempty"`
+
+	// Status matches upstream response codes (on_response only).
+	Status []int `json:"status,omitempty" yaml:"status,omitempty"`
+
+	Not bool `json:"not,omitempty" yaml:"not,omitempty"`
+}
+
+// PolicyValueMatch matches a named header or query parameter. With neither
+// Value nor Regex set it only requires the name to be present.
+type PolicyValueMatch struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	Regex string `json:"regex,omitempty" yaml:"regex,omitempty"`
+}
+
+// PolicyAction types:
+//   - deny: respond with Status (default 403)
+//   - custom_response: respond with Status (default 200), Headers and Body
+//   - redirect: respond with Status (default 302) and Location. With From,
+//     only requests whose path (and ?query) match the regex are redirected
+//     and Location may reference capture groups ($1, ${name}).
+//   - rewrite: replace the upstream request path with Path. With From, the
+//     regex is applied to the path and Path may reference capture groups;
+//     a "?" in the result replaces the query.
+//   - rewrite_header: replace From matches in Header's values with Value
+//   - add_header, set_header, remove_header: edit Header (Value for add/set)
+//   - rate_limit: allow Limit requests ("100/m") per client IP, or per value
+//     of Header when set; over the limit respond 429 with Retry-After
+type PolicyAction struct {
+	Type string `json:"type" yaml:"type"`
+
+	Status   int               `json:"status,omitempty" yaml:"status,omitempty"`
+	Body     string            `json:"body,omitempty" yaml:"body,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Location string            `json:"location,omitempty" yaml:"location,omitempty"`
+	From     string            `json:"from,omitempty" yaml:"from,omitempty"`
+	Path     string            `json:"path,omitempty" yaml:"path,omitempty"`
+	Header   string            `json:"header,omitempty" yaml:"header,omitempty"`
+	Value    string            `json:"value,omitempty" yaml:"value,omitempty"`
+	Limit    string            `json:"limit,omitempty" yaml:"limit,omitempty"`
+}
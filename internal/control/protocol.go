@@ -6,26 +6,74 @@ type CreateTCPTunnelRequest struct {
 	Type       string `json:"type"` // "tcp"
 	Authtoken  string `json:"authtoken,omitempty"`
 	RemotePort int    `json:"remote_port"` // 0 = auto-allocate
+
+	// Secret makes this a private tunnel: the server binds no public port and
+	// only visitors presenting the same secret for Name can connect.
+	// Name is optional; the server allocates one when empty.
+	Secret string `json:"secret,omitempty"`
+	Name   string `json:"name,omitempty"`
+
+	// CIDR-based access control, applied to both the public TCP listener and
+	// the WebSocket bridge (/tcp/<port>). deny_cidr always takes precedence.
+	AllowCIDR []string `json:"allow_cidr,omitempty"`
+	DenyCIDR  []string `json:"deny_cidr,omitempty"`
+
+	// Bandwidth paces the bytes the tunnel carries, per direction.
+	Bandwidth *BandwidthLimits `json:"bandwidth,omitempty"`
+
+	// ExpiresAt asks the server to close the tunnel at this time (unix
+	// seconds); see TunnelClosedNotice.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+
+	// Notices keeps the control stream open for a TunnelClosedNotice.
+	Notices bool `json:"notices,omitempty"`
 }
 
 type CreateTCPTunnelResponse struct {
 	Type       string `json:"type"`        // "tcp"
 	RemotePort int    `json:"remote_port"` // allocated
+	Name       string `json:"name,omitempty"`
+	ExpiresAt  int64  `json:"expires_at,omitempty"` // unix seconds; see TunnelClosedNotice
 	Error      string `json:"error,omitempty"`
 }
 
+// VisitTCPTunnelRequest connects to a secret TCP tunnel. After a successful
+// response, every yamux stream the visitor opens is spliced to the owning
+// agent.
+type VisitTCPTunnelRequest struct {
+	Type      string `json:"type"` // "visit"
+	Authtoken string `json:"authtoken,omitempty"`
+	Name      string `json:"name"`
+	Secret    string `json:"secret"`
+}
+
+type VisitTCPTunnelResponse struct {
+	Type  string `json:"type"` // "visit"
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
 type HeaderKV struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
 }
 
+// BasicAuthUser is a basic auth user with a bcrypt or argon2id (PHC) hash.
+type BasicAuthUser struct {
+	User string `json:"user"`
+	Hash string `json:"hash"`
+}
+
 type CreateHTTPTunnelRequest struct {
 	Type      string `json:"type"` // "http"
 	Authtoken string `json:"authtoken,omitempty"`
 	Subdomain string `json:"subdomain,omitempty"`
 	Domain    string `json:"domain,omitempty"`
 
-	BasicAuth string `json:"basic_auth,omitempty"` // "user:pass"
+	// BasicAuth is a single plaintext "user:pass" (sent by older clients).
+	// BasicAuthUsers carries hashed credentials instead; both may be set.
+	BasicAuth      string          `json:"basic_auth,omitempty"`
+	BasicAuthUsers []BasicAuthUser `json:"basic_auth_users,omitempty"`
 
 	// Optional allowlist-style filtering on the server edge.
 	AllowMethod     []string `json:"allow_method,omitempty"`
@@ -38,6 +86,17 @@ type CreateHTTPTunnelRequest struct {
 	AllowCIDR []string `json:"allow_cidr,omitempty"`
 	DenyCIDR  []string `json:"deny_cidr,omitempty"`
 
+	// RateLimit ("100/m") limits requests per client IP, or per value of
+	// the RateLimitHeader request header when set. Over-limit requests get
+	// a 429 with Retry-After.
+	RateLimit       string `json:"rate_limit,omitempty"`
+	RateLimitHeader string `json:"rate_limit_header,omitempty"`
+
+	// Redirect rules are answered at the edge; rewrite rules change the path
+	// sent upstream. Both run after access checks, redirects first.
+	Redirect []RedirectRule `json:"redirect,omitempty"`
+	Rewrite  []RewriteRule  `json:"rewrite,omitempty"`
+
 	// Header transforms applied at the server edge (before proxying to the
 	// client/upstream). These are applied in the following order:
 	// - request_header_remove
@@ -47,10 +106,49 @@ type CreateHTTPTunnelRequest struct {
 	// upstream, before sending it to the public client:
 	// - response_header_remove
 	// - response_header_add
-	RequestHeaderAdd    []HeaderKV `json:"request_header_add,omitempty"`
-	RequestHeaderRemove []string   `json:"request_header_remove,omitempty"`
-	ResponseHeaderAdd   []HeaderKV `json:"response_header_add,omitempty"`
-	ResponseHeaderRemove []string  `json:"response_header_remove,omitempty"`
+	//
+	// Add values may reference ${var} templates (see HeaderTemplateVars).
+	RequestHeaderAdd     []HeaderKV `json:"request_header_add,omitempty"`
+	RequestHeaderRemove  []string   `json:"request_header_remove,omitempty"`
+	ResponseHeaderAdd    []HeaderKV `json:"response_header_add,omitempty"`
+	ResponseHeaderRemove []string   `json:"response_header_remove,omitempty"`
+
+	// TrafficPolicy rules are evaluated after the options above, which the
+	// server expresses as equivalent policy rules.
+	TrafficPolicy *TrafficPolicy `json:"traffic_policy,omitempty"`
+
+	// ShareLinks requires visitors to present a signed share link (minted
+	// with ShareLinkRequest) unless they pass basic auth instead.
+	ShareLinks bool `json:"share_links,omitempty"`
+
+	// JWT requires a valid bearer token on every request.
+	JWT *JWTAuth `json:"jwt,omitempty"`
+
+	// CORS makes the edge answer preflights and set CORS response headers.
+	CORS *CORSPolicy `json:"cors,omitempty"`
+
+	// Compression makes the edge compress responses for clients that accept
+	// it.
+	Compression *CompressionPolicy `json:"compression,omitempty"`
+
+	// Cache enables the edge cache for GET responses.
+	Cache *CachePolicy `json:"cache,omitempty"`
+
+	// Limits bounds body size and timeouts of proxied requests.
+	Limits *HTTPLimits `json:"limits,omitempty"`
+
+	// Mirror copies requests to a shadow tunnel of the same authtoken.
+	Mirror *MirrorPolicy `json:"mirror,omitempty"`
+
+	// Bandwidth paces the bytes the tunnel carries, per direction.
+	Bandwidth *BandwidthLimits `json:"bandwidth,omitempty"`
+
+	// ExpiresAt asks the server to close the tunnel at this time (unix
+	// seconds); see TunnelClosedNotice.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+
+	// Notices keeps the control stream open for a TunnelClosedNotice.
+	Notices bool `json:"notices,omitempty"`
 }
 
 type CreateHTTPTunnelResponse struct {
@@ -59,5 +157,56 @@ type CreateHTTPTunnelResponse struct {
 	ID  string `json:"id,omitempty"`
 	URL string `json:"url,omitempty"`
 
+	// Routing is "path" when URL is path-based (https://<base>/t/<id>/)
+	// rather than a tunnel subdomain.
+	Routing string `json:"routing,omitempty"`
+
+	// ExpiresAt is when the server will close the tunnel (unix seconds):
+	// the requested expiry or the operator's maximum lifetime, whichever
+	// comes first. Zero when the tunnel has no deadline.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+
+	// RequestIDHeader is the header carrying the edge-assigned request id
+	// to the upstream (X-Request-Id when empty).
+	RequestIDHeader string `json:"request_id_header,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Reasons in TunnelClosedNotice.
+const (
+	TunnelClosedExpired     = "expired"      // the agent's requested expiry passed
+	TunnelClosedMaxLifetime = "max_lifetime" // the operator's lifetime limit passed
+	TunnelClosedIdle        = "idle"         // no traffic for the server's idle timeout
+)
+
+// TunnelClosedNotice is written on the control stream when the server
+// closes a tunnel on its own. The server only keeps the stream open after
+// the create response for agents that set Notices; others just see the
+// session end. Agents should not reconnect after receiving it.
+type TunnelClosedNotice struct {
+	Type   string `json:"type"` // "closed"
+	Reason string `json:"reason"`
+}
+
+// ShareLinkRequest asks the server to mint a signed, expiring link for the
+// HTTP tunnel of the current session. It is sent on a new stream once the
+// tunnel is up.
+type ShareLinkRequest struct {
+	Type string `json:"type"` // "share_link"
+
+	// TTLSeconds is how long the link stays valid (server default when 0).
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+
+	// Path limits the link to this path and everything below it.
+	Path string `json:"path,omitempty"`
+}
+
+type ShareLinkResponse struct {
+	Type string `json:"type"` // "share_link"
+
+	URL       string `json:"url,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // unix seconds
+
 	Error string `json:"error,omitempty"`
 }
@@ -0,0 +1,62 @@
+package control
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxRateLimitRequests = 1_000_000
+
+// ParseRate parses a rate like "100/m" (units s, m or h, also sec, min, hour
+// and their long forms) into a request count and window.
+func ParseRate(s string) (int, time.Duration, error) {
+	countStr, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("%q: want <n>/<s|m|h>", s)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(countStr))
+	if err != nil || n <= 0 || n > maxRateLimitRequests {
+		return 0, 0, fmt.Errorf("%q: invalid count", s)
+	}
+
+	var window time.Duration
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "s", "sec", "second":
+		window = time.Second
+	case "m", "min", "minute":
+		window = time.Minute
+	case "h", "hour":
+		window = time.Hour
+	default:
+		return 0, 0, fmt.Errorf("%q: invalid unit", s)
+	}
+
+	return n, window, nil
+}
+
+// ParseRateLimit validates a rate limit and its optional key header. It
+// returns empty strings when no limit is set.
+func ParseRateLimit(limit, keyHeader string) (string, string, error) {
+	limit = strings.TrimSpace(limit)
+	if limit == "" {
+		if strings.TrimSpace(keyHeader) != "" {
+			return "", "", fmt.Errorf("invalid rate_limit_header: requires rate_limit")
+		}
+		return "", "", nil
+	}
+	if _, _, err := ParseRate(limit); err != nil {
+		return "", "", fmt.Errorf("invalid rate_limit: %v", err)
+	}
+
+	if strings.TrimSpace(keyHeader) == "" {
+		return limit, "", nil
+	}
+	name, err := NormalizeHeaderName("rate_limit_header", keyHeader)
+	if err != nil {
+		return "", "", err
+	}
+	return limit, name, nil
+}
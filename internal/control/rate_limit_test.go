@@ -0,0 +1,39 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	t.Parallel()
+
+	n, window, err := ParseRate("100/m")
+	if err != nil || n != 100 || window != time.Minute {
+		t.Fatalf("ParseRate = %d, %v, %v", n, window, err)
+	}
+	for _, bad := range []string{"", "100", "0/s", "-1/s", "x/s", "10/d"} {
+		if _, _, err := ParseRate(bad); err == nil {
+			t.Fatalf("ParseRate(%q) err = nil, want error", bad)
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	t.Parallel()
+
+	limit, header, err := ParseRateLimit(" 10/s ", "x-api-key")
+	if err != nil || limit != "10/s" || header != "X-Api-Key" {
+		t.Fatalf("ParseRateLimit = %q, %q, %v", limit, header, err)
+	}
+
+	if limit, header, err := ParseRateLimit("", ""); err != nil || limit != "" || header != "" {
+		t.Fatalf("empty = %q, %q, %v", limit, header, err)
+	}
+	if _, _, err := ParseRateLimit("", "X-Api-Key"); err == nil {
+		t.Fatalf("header without limit: err = nil, want error")
+	}
+	if _, _, err := ParseRateLimit("10/s", "Connection"); err == nil {
+		t.Fatalf("hop-by-hop header: err = nil, want error")
+	}
+}
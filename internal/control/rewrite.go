@@ -0,0 +1,94 @@
+package control
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxRewritePatternBytes = 1024
+	maxRewriteTargetBytes  = 2048
+)
+
+// RewriteRule rewrites request paths matching From (a regex) to To, which may
+// reference capture groups ($1, ${name}). A "?" in To replaces the query.
+type RewriteRule struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+// RedirectRule redirects requests whose path (and ?query) matches From to To,
+// which may reference capture groups. Status defaults to 302.
+type RedirectRule struct {
+	From   string `json:"from" yaml:"from"`
+	To     string `json:"to" yaml:"to"`
+	Status int    `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+func ParseRewriteRules(field string, rules []RewriteRule, maxEntries int) ([]RewriteRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if maxEntries > 0 && len(rules) > maxEntries {
+		return nil, fmt.Errorf("invalid %s: too many entries", field)
+	}
+
+	out := make([]RewriteRule, 0, len(rules))
+	for _, r := range rules {
+		from, err := parseRewritePattern(field, r.From)
+		if err != nil {
+			return nil, err
+		}
+		to := strings.TrimSpace(r.To)
+		if !strings.HasPrefix(to, "/") || !isSafeRewriteTarget(to) {
+			return nil, fmt.Errorf("invalid %s: target %q must be a path", field, r.To)
+		}
+		out = append(out, RewriteRule{From: from, To: to})
+	}
+	return out, nil
+}
+
+func ParseRedirectRules(field string, rules []RedirectRule, maxEntries int) ([]RedirectRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if maxEntries > 0 && len(rules) > maxEntries {
+		return nil, fmt.Errorf("invalid %s: too many entries", field)
+	}
+
+	out := make([]RedirectRule, 0, len(rules))
+	for _, r := range rules {
+		from, err := parseRewritePattern(field, r.From)
+		if err != nil {
+			return nil, err
+		}
+		to := strings.TrimSpace(r.To)
+		if to == "" || !isSafeRewriteTarget(to) {
+			return nil, fmt.Errorf("invalid %s: target %q", field, r.To)
+		}
+		switch r.Status {
+		case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("invalid %s: status %d", field, r.Status)
+		}
+		out = append(out, RedirectRule{From: from, To: to, Status: r.Status})
+	}
+	return out, nil
+}
+
+func parseRewritePattern(field, raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" || len(s) > maxRewritePatternBytes {
+		return "", fmt.Errorf("invalid %s: pattern %q", field, raw)
+	}
+	if _, err := regexp.Compile(s); err != nil {
+		return "", fmt.Errorf("invalid %s: %v", field, err)
+	}
+	return s, nil
+}
+
+func isSafeRewriteTarget(s string) bool {
+	return len(s) <= maxRewriteTargetBytes && !strings.ContainsAny(s, " \t\r\n\x00")
+}
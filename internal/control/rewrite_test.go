@@ -0,0 +1,40 @@
+package control
+
+import "testing"
+
+func TestParseRewriteRules(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseRewriteRules("rewrite", []RewriteRule{{From: " ^/v1/(.*)$ ", To: "/api/v1/$1"}}, 64)
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if len(got) != 1 || got[0].From != "^/v1/(.*)$" || got[0].To != "/api/v1/$1" {
+		t.Fatalf("got %#v", got)
+	}
+
+	for _, bad := range []RewriteRule{
+		{From: "(", To: "/x"},
+		{From: "", To: "/x"},
+		{From: "^/a", To: "x"},
+		{From: "^/a", To: "/x y"},
+	} {
+		if _, err := ParseRewriteRules("rewrite", []RewriteRule{bad}, 64); err == nil {
+			t.Fatalf("%#v: err = nil, want error", bad)
+		}
+	}
+}
+
+func TestParseRedirectRules(t *testing.T) {
+	t.Parallel()
+
+	if _, err := ParseRedirectRules("redirect", []RedirectRule{{From: "^/old$", To: "https://example.com/new", Status: 301}}, 64); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if _, err := ParseRedirectRules("redirect", []RedirectRule{{From: "^/old$", To: "/new", Status: 200}}, 64); err == nil {
+		t.Fatalf("status 200: err = nil, want error")
+	}
+	if _, err := ParseRedirectRules("redirect", []RedirectRule{{From: "^/old$", To: "/new\r\nX: y"}}, 64); err == nil {
+		t.Fatalf("crlf target: err = nil, want error")
+	}
+}
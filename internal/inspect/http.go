@@ -3,8 +3,11 @@ package inspect
 import (
 	"context"
 	"encoding/json"
+	"io"
+	"mime"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type listRequestsResponse struct {
@@ -13,6 +16,34 @@ type listRequestsResponse struct {
 
 type HandlerOptions struct {
 	Replay func(ctx context.Context, entry Entry) (ReplayResult, error)
+
+	// Share mints a share link for a running tunnel (POST /api/share).
+	Share func(ctx context.Context, req ShareRequest) (ShareResult, error)
+}
+
+// ShareRequest identifies a tunnel by name or id; it may be empty when only
+// one tunnel is running.
+type ShareRequest struct {
+	Tunnel string
+	TTL    time.Duration
+	Path   string
+}
+
+type ShareResult struct {
+	URL       string
+	ExpiresAt time.Time
+}
+
+type shareRequestBody struct {
+	Tunnel string `json:"tunnel,omitempty"`
+	TTL    string `json:"ttl,omitempty"` // Go duration, e.g. "24h"
+	Path   string `json:"path,omitempty"`
+}
+
+type shareResponse struct {
+	URL       string     `json:"url,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
 }
 
 type ReplayResult struct {
@@ -90,6 +121,51 @@ func Handler(store *Store, opts HandlerOptions) http.Handler {
 		})
 	})
 
+	mux.HandleFunc("/api/share", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Requiring JSON keeps other sites from posting here via a plain
+		// cross-origin form.
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		if opts.Share == nil {
+			http.Error(w, "share links not configured", http.StatusNotImplemented)
+			return
+		}
+
+		writeShare := func(status int, resp shareResponse) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(resp)
+		}
+
+		var body shareRequestBody
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil {
+			writeShare(http.StatusBadRequest, shareResponse{Error: "invalid request body"})
+			return
+		}
+		req := ShareRequest{Tunnel: strings.TrimSpace(body.Tunnel), Path: strings.TrimSpace(body.Path)}
+		if ttl := strings.TrimSpace(body.TTL); ttl != "" {
+			d, err := time.ParseDuration(ttl)
+			if err != nil || d <= 0 {
+				writeShare(http.StatusBadRequest, shareResponse{Error: "invalid ttl"})
+				return
+			}
+			req.TTL = d
+		}
+
+		res, err := opts.Share(r.Context(), req)
+		if err != nil {
+			writeShare(http.StatusBadGateway, shareResponse{Error: err.Error()})
+			return
+		}
+		writeShare(http.StatusOK, shareResponse{URL: res.URL, ExpiresAt: &res.ExpiresAt})
+	})
+
 	return mux
 }
 
@@ -413,6 +489,8 @@ const inspectorIndexHTML = `<!doctype html>
           '<div class="k">Started</div><div class="v">' + esc(started) + '</div>' +
           '<div class="k">Duration</div><div class="v">' + esc(dur) + '</div>' +
           '<div class="k">Bytes</div><div class="v">' + esc(bytes) + '</div>' +
+          (entry.request_id ? '<div class="k">Request ID</div><div class="v">' + esc(entry.request_id) + '</div>' : '') +
+          (entry.trace_id ? '<div class="k">Trace</div><div class="v">' + esc(entry.trace_id) + '</div>' : '') +
         '</div>' +
         '<div class="actions">' +
           '<button id="replay">Replay</button>' +
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHandler_ListRequests(t *testing.T) {
@@ -119,3 +120,55 @@ func TestHandler_RootServesHTML(t *testing.T) {
 		t.Fatalf("body does not contain expected title")
 	}
 }
+
+func TestHandler_Share(t *testing.T) {
+	t.Parallel()
+
+	exp := time.Unix(1_700_000_000, 0).UTC()
+	got := make(chan ShareRequest, 1)
+	ts := httptest.NewServer(Handler(NewStore(StoreConfig{MaxEntries: 10}), HandlerOptions{
+		Share: func(ctx context.Context, req ShareRequest) (ShareResult, error) {
+			got <- req
+			return ShareResult{URL: "https://x.test/?eosrift_share=t", ExpiresAt: exp}, nil
+		},
+	}))
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Post(ts.URL+"/api/share", "application/json", strings.NewReader(`{"tunnel":"web","ttl":"2h","path":"/docs/"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var body shareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.URL != "https://x.test/?eosrift_share=t" || body.ExpiresAt == nil || !body.ExpiresAt.Equal(exp) {
+		t.Fatalf("body = %+v", body)
+	}
+	if req := <-got; req.Tunnel != "web" || req.TTL != 2*time.Hour || req.Path != "/docs/" {
+		t.Fatalf("request = %+v", req)
+	}
+
+	for _, tc := range []struct {
+		contentType, body string
+		want              int
+	}{
+		{"text/plain", `{}`, http.StatusUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", `ttl=1h`, http.StatusUnsupportedMediaType},
+		{"application/json", `{"ttl":"soon"}`, http.StatusBadRequest},
+	} {
+		resp, err := http.Post(ts.URL+"/api/share", tc.contentType, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Fatalf("%s %s: status = %d, want %d", tc.contentType, tc.body, resp.StatusCode, tc.want)
+		}
+	}
+}
@@ -19,7 +19,7 @@ var sensitiveHeaders = []string{
 }
 
 func redactEntry(e Entry) Entry {
-	e.Path = redactPath(e.Path)
+	e.Path = RedactPath(e.Path)
 	e.RequestHeaders = redactHeaders(e.RequestHeaders)
 	e.ResponseHeaders = redactHeaders(e.ResponseHeaders)
 	return e
@@ -39,7 +39,9 @@ func redactHeaders(h http.Header) http.Header {
 	return out
 }
 
-func redactPath(path string) string {
+// RedactPath replaces the values of secret-looking query parameters (token,
+// api_key, signature, ...) in a request URI with RedactedValue.
+func RedactPath(path string) string {
 	path = strings.TrimSpace(path)
 	if path == "" {
 		return ""
@@ -72,7 +74,8 @@ func redactPath(path string) string {
 func isSensitiveQueryKey(key string) bool {
 	k := strings.ToLower(strings.TrimSpace(key))
 	switch k {
-	case "token", "access_token", "refresh_token", "auth", "apikey", "api_key", "key", "signature", "sig", "password", "pass", "passwd", "secret":
+	case "token", "access_token", "refresh_token", "auth", "apikey", "api_key", "key", "signature", "sig", "password", "pass", "passwd", "secret",
+		"eosrift_share":
 		return true
 	default:
 		return false
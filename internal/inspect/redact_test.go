@@ -54,3 +54,11 @@ func TestRedaction_RedactsSensitiveHeadersAndQueryParams(t *testing.T) {
 		t.Fatalf("x = %q, want %q", q.Get("x"), "1")
 	}
 }
+
+func TestRedactPath_ShareLinkToken(t *testing.T) {
+	t.Parallel()
+
+	if got, want := RedactPath("/doc?eosrift_share=abc.def&page=2"), "/doc?eosrift_share="+RedactedValue+"&page=2"; got != want {
+		t.Fatalf("RedactPath = %q, want %q", got, want)
+	}
+}
@@ -26,6 +26,13 @@ type Entry struct {
 
 	RequestHeaders  http.Header `json:"request_headers,omitempty"`
 	ResponseHeaders http.Header `json:"response_headers,omitempty"`
+
+	// RequestID is the id the server's edge assigned to the request (see
+	// its X-Request-Id header); it also appears in the server's access log.
+	RequestID string `json:"request_id,omitempty"`
+
+	// TraceID is the W3C trace id the request was traced under, if any.
+	TraceID string `json:"trace_id,omitempty"`
 }
 
 type StoreConfig struct {
@@ -0,0 +1,342 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+type testKey struct {
+	kid  string
+	alg  string
+	priv crypto.Signer
+}
+
+func (k testKey) jwk() map[string]string {
+	m := map[string]string{"kid": k.kid, "use": "sig"}
+	switch pub := k.priv.Public().(type) {
+	case *rsa.PublicKey:
+		m["kty"] = "RSA"
+		m["n"] = b64.EncodeToString(pub.N.Bytes())
+		m["e"] = b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		m["kty"] = "EC"
+		m["crv"] = "P-256"
+		m["x"] = b64.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+		m["y"] = b64.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		m["kty"] = "OKP"
+		m["crv"] = "Ed25519"
+		m["x"] = b64.EncodeToString(pub)
+	}
+	return m
+}
+
+func jwks(keys ...testKey) []byte {
+	var doc struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		doc.Keys = append(doc.Keys, k.jwk())
+	}
+	b, _ := json.Marshal(doc)
+	return b
+}
+
+func (k testKey) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+
+	h, _ := json.Marshal(map[string]string{"alg": k.alg, "kid": k.kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+
+	var sig []byte
+	var err error
+	switch priv := k.priv.(type) {
+	case *rsa.PrivateKey:
+		d := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, d[:])
+	case *ecdsa.PrivateKey:
+		d := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, priv, d[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(priv, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func newTestKeys(t *testing.T) (rsaKey, ecKey, edKey testKey) {
+	t.Helper()
+
+	r, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa: %v", err)
+	}
+	e, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa: %v", err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519: %v", err)
+	}
+	return testKey{"rsa-1", "RS256", r}, testKey{"ec-1", "ES256", e}, testKey{"ed-1", "EdDSA", ed}
+}
+
+func TestVerifier(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, ecKey, edKey := newTestKeys(t)
+	keys, err := ParseJWKS(jwks(rsaKey, ecKey, edKey))
+	if err != nil {
+		t.Fatalf("parse jwks: %v", err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	v := NewVerifier(StaticKeys(keys), Options{
+		Issuer:   "https://issuer.test",
+		Audience: []string{"api"},
+		Now:      func() time.Time { return now },
+	})
+
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss": "https://issuer.test",
+			"aud": []string{"other", "api"},
+			"sub": "alice",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+	}
+
+	for _, k := range []testKey{rsaKey, ecKey, edKey} {
+		claims, err := v.Verify(context.Background(), k.sign(t, valid()))
+		if err != nil {
+			t.Fatalf("%s: verify: %v", k.alg, err)
+		}
+		if claims["sub"] != "alice" {
+			t.Fatalf("%s: sub = %v", k.alg, claims["sub"])
+		}
+	}
+
+	cases := map[string]func(map[string]any){
+		"expired":       func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() },
+		"no exp":        func(c map[string]any) { delete(c, "exp") },
+		"not yet valid": func(c map[string]any) { c["nbf"] = now.Add(5 * time.Minute).Unix() },
+		"wrong issuer":  func(c map[string]any) { c["iss"] = "https://evil.test" },
+		"wrong aud":     func(c map[string]any) { c["aud"] = "web" },
+	}
+	for name, mutate := range cases {
+		c := valid()
+		mutate(c)
+		if _, err := v.Verify(context.Background(), rsaKey.sign(t, c)); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+
+	c := valid()
+	c["exp"] = now.Add(-30 * time.Second).Unix()
+	if _, err := v.Verify(context.Background(), rsaKey.sign(t, c)); err != nil {
+		t.Fatalf("within leeway: %v", err)
+	}
+}
+
+func TestVerifier_RejectsForgedTokens(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, ecKey, _ := newTestKeys(t)
+	keys, err := ParseJWKS(jwks(rsaKey))
+	if err != nil {
+		t.Fatalf("parse jwks: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	v := NewVerifier(StaticKeys(keys), Options{Now: func() time.Time { return now }})
+	claims := map[string]any{"exp": now.Add(time.Hour).Unix()}
+
+	// Key not in the set, claiming the trusted kid.
+	ecKey.kid = rsaKey.kid
+	if _, err := v.Verify(context.Background(), ecKey.sign(t, claims)); err == nil {
+		t.Fatalf("verified token signed by unknown key")
+	}
+
+	// alg "none".
+	h, _ := json.Marshal(map[string]string{"alg": "none"})
+	c, _ := json.Marshal(claims)
+	if _, err := v.Verify(context.Background(), b64.EncodeToString(h)+"."+b64.EncodeToString(c)+"."); err == nil {
+		t.Fatalf("verified alg none")
+	}
+
+	// Tampered payload.
+	tok := rsaKey.sign(t, claims)
+	c2, _ := json.Marshal(map[string]any{"exp": now.Add(time.Hour).Unix(), "admin": true})
+	parts := splitToken(tok)
+	if _, err := v.Verify(context.Background(), parts[0]+"."+b64.EncodeToString(c2)+"."+parts[2]); err == nil {
+		t.Fatalf("verified tampered payload")
+	}
+}
+
+func splitToken(tok string) [3]string {
+	var out [3]string
+	i := 0
+	start := 0
+	for j := 0; j < len(tok); j++ {
+		if tok[j] == '.' {
+			out[i] = tok[start:j]
+			i++
+			start = j + 1
+		}
+	}
+	out[i] = tok[start:]
+	return out
+}
+
+func TestParseJWKS_Errors(t *testing.T) {
+	t.Parallel()
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa: %v", err)
+	}
+	for name, doc := range map[string]string{
+		"not json":  `nope`,
+		"empty":     `{"keys":[]}`,
+		"only oct":  `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`,
+		"bad curve": `{"keys":[{"kty":"EC","crv":"P-192","x":"AA","y":"AA"}]}`,
+		"short rsa": string(jwks(testKey{kid: "s", priv: small})),
+	} {
+		if _, err := ParseJWKS([]byte(doc)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRemoteKeys_CachesAndRefreshesOnUnknownKid(t *testing.T) {
+	t.Parallel()
+
+	k1, k2, _ := newTestKeys(t)
+
+	var served atomic.Value
+	served.Store(jwks(k1))
+	var fetches atomic.Int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(served.Load().([]byte))
+	}))
+	t.Cleanup(ts.Close)
+
+	now := time.Unix(1_700_000_000, 0)
+	clock := func() time.Time { return now }
+	remote := NewRemoteKeys(ts.URL, ts.Client(), clock)
+	v := NewVerifier(remote, Options{Now: clock})
+	claims := map[string]any{"exp": now.Add(time.Hour).Unix()}
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), k1.sign(t, claims)); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("fetches = %d, want 1 (cached)", n)
+	}
+
+	// Rotation: a token with a new kid triggers one refetch...
+	served.Store(jwks(k1, k2))
+	now = now.Add(time.Minute)
+	if _, err := v.Verify(context.Background(), k2.sign(t, claims)); err != nil {
+		t.Fatalf("verify rotated key: %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("fetches = %d, want 2", n)
+	}
+
+	// ...but unknown kids cannot force refetches more often than
+	// minRefetchInterval.
+	bogus := k2
+	bogus.kid = "unknown"
+	for i := 0; i < 5; i++ {
+		_, _ = v.Verify(context.Background(), bogus.sign(t, claims))
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("fetches = %d, want 2 (throttled)", n)
+	}
+}
+
+func TestRemoteKeys_Unavailable(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	t.Cleanup(ts.Close)
+
+	k, _, _ := newTestKeys(t)
+	v := NewVerifier(NewRemoteKeys(ts.URL, ts.Client(), nil), Options{})
+	_, err := v.Verify(context.Background(), k.sign(t, map[string]any{"exp": time.Now().Add(time.Hour).Unix()}))
+	if !errors.Is(err, ErrKeysUnavailable) {
+		t.Fatalf("err = %v, want ErrKeysUnavailable", err)
+	}
+}
+
+func TestNewHTTPClient_RefusesInternalAddresses(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(ts.Close)
+
+	if _, err := NewHTTPClient().Get(ts.URL); err == nil {
+		t.Fatalf("expected loopback fetch to be refused")
+	}
+
+	for addr, want := range map[string]bool{
+		"8.8.8.8":         true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.0.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"::1":             false,
+		"fd00::1":         false,
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Fatalf("isPublicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	t.Parallel()
+
+	if tok, ok := BearerToken("Bearer abc.def.ghi"); !ok || tok != "abc.def.ghi" {
+		t.Fatalf("got %q, %v", tok, ok)
+	}
+	if tok, ok := BearerToken("bearer  xyz "); !ok || tok != "xyz" {
+		t.Fatalf("got %q, %v", tok, ok)
+	}
+	for _, h := range []string{"", "Basic dXNlcjpwYXNz", "Bearer", "Bearer "} {
+		if _, ok := BearerToken(h); ok {
+			t.Fatalf("BearerToken(%q) ok", h)
+		}
+	}
+}
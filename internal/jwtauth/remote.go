@@ -0,0 +1,186 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultRefreshInterval is how long fetched keys are used before the
+	// JWKS URL is fetched again.
+	DefaultRefreshInterval = 10 * time.Minute
+
+	// minRefetchInterval bounds refetches triggered by unknown key ids or
+	// fetch failures, so bad tokens cannot hammer the JWKS endpoint.
+	minRefetchInterval = 30 * time.Second
+
+	maxJWKSResponseBytes = 1 << 20
+)
+
+// RemoteKeys fetches a JWKS from a URL and caches it. When a refresh fails
+// the previous keys stay in use.
+type RemoteKeys struct {
+	url      string
+	client   *http.Client
+	interval time.Duration
+	now      func() time.Time
+
+	fetchMu sync.Mutex // serializes fetches
+
+	mu        sync.Mutex
+	keys      []Key
+	fetchedAt time.Time
+	lastTry   time.Time
+}
+
+// NewRemoteKeys returns a key source for url. A nil client uses
+// NewHTTPClient().
+func NewRemoteKeys(url string, client *http.Client, now func() time.Time) *RemoteKeys {
+	if client == nil {
+		client = NewHTTPClient()
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &RemoteKeys{url: url, client: client, interval: DefaultRefreshInterval, now: now}
+}
+
+func (r *RemoteKeys) Keys(ctx context.Context) ([]Key, error) {
+	r.mu.Lock()
+	keys, fresh := r.keys, r.now().Sub(r.fetchedAt) < r.interval
+	r.mu.Unlock()
+	if keys != nil && fresh {
+		return keys, nil
+	}
+	return r.fetch(ctx, false)
+}
+
+func (r *RemoteKeys) Refresh(ctx context.Context) ([]Key, error) {
+	return r.fetch(ctx, true)
+}
+
+func (r *RemoteKeys) fetch(ctx context.Context, force bool) ([]Key, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	r.mu.Lock()
+	now := r.now()
+	keys := r.keys
+	// Another caller may have fetched while we waited, or we tried too
+	// recently.
+	if keys != nil && !force && now.Sub(r.fetchedAt) < r.interval {
+		r.mu.Unlock()
+		return keys, nil
+	}
+	if now.Sub(r.lastTry) < minRefetchInterval && !r.lastTry.IsZero() {
+		r.mu.Unlock()
+		if keys == nil {
+			return nil, ErrKeysUnavailable
+		}
+		return keys, nil
+	}
+	r.lastTry = now
+	r.mu.Unlock()
+
+	fetched, err := r.get(ctx)
+	if err != nil {
+		if keys != nil {
+			return keys, nil
+		}
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.keys = fetched
+	r.fetchedAt = now
+	r.mu.Unlock()
+	return fetched, nil
+}
+
+func (r *RemoteKeys) get(ctx context.Context) ([]Key, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: status %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	if len(b) > maxJWKSResponseBytes {
+		return nil, errors.New("fetch jwks: response too large")
+	}
+	return ParseJWKS(b)
+}
+
+// NewHTTPClient returns the client used for JWKS fetches: https only, a short
+// timeout, and no connections to loopback, private or link-local addresses
+// (tunnel owners choose the URL, so it must not reach the server's own
+// network).
+func NewHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(ip) {
+				return fmt.Errorf("jwks: refusing to connect to %s", ip)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        4,
+		IdleConnTimeout:     time.Minute,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("jwks: too many redirects")
+			}
+			if req.URL.Scheme != "https" {
+				return errors.New("jwks: redirect to non-https url")
+			}
+			return nil
+		},
+	}
+}
+
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() &&
+		!netip.MustParsePrefix("100.64.0.0/10").Contains(ip) // CGNAT
+}
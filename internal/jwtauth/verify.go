@@ -0,0 +1,279 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// leeway absorbs clock skew between the issuer and the edge.
+	leeway = 60 * time.Second
+
+	maxTokenBytes = 16 * 1024
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrKeysUnavailable means no keys could be loaded (e.g. the JWKS URL
+	// is unreachable and nothing is cached).
+	ErrKeysUnavailable = errors.New("jwks unavailable")
+)
+
+// KeySource supplies verification keys. Refresh asks a remote source to
+// reload (used when a token names an unknown key id); static sources ignore
+// it.
+type KeySource interface {
+	Keys(ctx context.Context) ([]Key, error)
+	Refresh(ctx context.Context) ([]Key, error)
+}
+
+// StaticKeys is a fixed key set.
+type StaticKeys []Key
+
+func (s StaticKeys) Keys(context.Context) ([]Key, error)    { return s, nil }
+func (s StaticKeys) Refresh(context.Context) ([]Key, error) { return s, nil }
+
+// Claims are the decoded claims of a verified token.
+type Claims map[string]any
+
+// Verifier checks signature, exp/nbf, iss and aud of bearer tokens.
+type Verifier struct {
+	keys     KeySource
+	issuer   string
+	audience []string
+	now      func() time.Time
+}
+
+type Options struct {
+	Issuer   string
+	Audience []string
+	Now      func() time.Time
+}
+
+func NewVerifier(keys KeySource, opts Options) *Verifier {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Verifier{
+		keys:     keys,
+		issuer:   opts.Issuer,
+		audience: append([]string(nil), opts.Audience...),
+		now:      opts.Now,
+	}
+}
+
+// BearerToken extracts the token from an Authorization header value.
+func BearerToken(authorization string) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Verify returns the claims of a valid token, or ErrInvalidToken /
+// ErrKeysUnavailable.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	if len(token) > maxTokenBytes {
+		return nil, ErrInvalidToken
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if _, ok := algHash(h.Alg); !ok && h.Alg != "EdDSA" {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	keys, err := v.keys.Keys(ctx)
+	if err != nil {
+		return nil, ErrKeysUnavailable
+	}
+	ok, known := verifyWithKeys(keys, h, signed, sig)
+	if !ok && !known && h.Kid != "" {
+		// Unknown key id: the issuer may have rotated keys.
+		if keys, err = v.keys.Refresh(ctx); err == nil {
+			ok, _ = verifyWithKeys(keys, h, signed, sig)
+		}
+	}
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims == nil {
+		return nil, ErrInvalidToken
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifyWithKeys reports whether any matching key verifies the signature,
+// and whether a key with the token's kid was present at all.
+func verifyWithKeys(keys []Key, h header, signed, sig []byte) (ok, known bool) {
+	for _, k := range keys {
+		if h.Kid != "" && k.ID != h.Kid {
+			continue
+		}
+		known = true
+		if k.Alg != "" && k.Alg != h.Alg {
+			continue
+		}
+		if verifySignature(k.Public, h.Alg, signed, sig) {
+			return true, true
+		}
+	}
+	return false, known
+}
+
+func algHash(alg string) (crypto.Hash, bool) {
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		return crypto.SHA256, true
+	case "RS384", "PS384", "ES384":
+		return crypto.SHA384, true
+	case "RS512", "PS512", "ES512":
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+func verifySignature(pub crypto.PublicKey, alg string, signed, sig []byte) bool {
+	if alg == "EdDSA" {
+		k, ok := pub.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, signed, sig)
+	}
+
+	hash, ok := algHash(alg)
+	if !ok {
+		return false
+	}
+	hh := hash.New()
+	hh.Write(signed)
+	digest := hh.Sum(nil)
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		bits := k.Curve.Params().BitSize
+		size := (bits + 7) / 8
+		if bits != esCurveBits(alg) || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+// esCurveBits is the curve size an ES* algorithm requires (0 otherwise).
+func esCurveBits(alg string) int {
+	switch alg {
+	case "ES256":
+		return 256
+	case "ES384":
+		return 384
+	case "ES512":
+		return 521
+	}
+	return 0
+}
+
+func (v *Verifier) checkClaims(c Claims) error {
+	now := v.now()
+
+	exp, ok := numericDate(c["exp"])
+	if !ok || !now.Before(exp.Add(leeway)) {
+		return ErrInvalidToken
+	}
+	if raw, present := c["nbf"]; present {
+		nbf, ok := numericDate(raw)
+		if !ok || now.Add(leeway).Before(nbf) {
+			return ErrInvalidToken
+		}
+	}
+
+	if v.issuer != "" {
+		if iss, _ := c["iss"].(string); iss != v.issuer {
+			return ErrInvalidToken
+		}
+	}
+	if len(v.audience) > 0 && !audienceMatches(c["aud"], v.audience) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func numericDate(v any) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func audienceMatches(raw any, want []string) bool {
+	var got []string
+	switch aud := raw.(type) {
+	case string:
+		got = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				got = append(got, s)
+			}
+		}
+	}
+	for _, g := range got {
+		for _, w := range want {
+			if g == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type RotatingFileOptions struct {
+	Path string
+
+	// MaxBytes rotates the file before a write would grow it past this
+	// size. Zero disables rotation.
+	MaxBytes int64
+
+	// MaxFiles is how many rotated files (Path.1 newest ... Path.N oldest)
+	// are kept. Zero keeps one.
+	MaxFiles int
+}
+
+// RotatingFile is an append-only log file that is renamed to Path.1 (older
+// files shifting up) once it reaches MaxBytes.
+type RotatingFile struct {
+	opts RotatingFileOptions
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func OpenRotatingFile(opts RotatingFileOptions) (*RotatingFile, error) {
+	if opts.Path == "" {
+		return nil, errors.New("missing log file path")
+	}
+	if opts.MaxBytes < 0 || opts.MaxFiles < 0 {
+		return nil, errors.New("invalid log rotation limits")
+	}
+	if opts.MaxFiles == 0 {
+		opts.MaxFiles = 1
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return nil, err
+	}
+
+	r := &RotatingFile{opts: opts}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.opts.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.opts.MaxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.opts.MaxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+
+	path := r.opts.Path
+	_ = os.Remove(fmt.Sprintf("%s.%d", path, r.opts.MaxFiles))
+	for i := r.opts.MaxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
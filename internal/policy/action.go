@@ -0,0 +1,343 @@
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+const maxLocationBytes = 2048
+
+type action struct {
+	kind string
+
+	status   int
+	body     string
+	headers  http.Header
+	location string
+	from     *regexp.Regexp
+	path     string
+	header   string
+	value    string
+	tmpl     *headerTemplate
+
+	limiter *RateLimiter
+}
+
+func compileAction(field string, a control.PolicyAction, response bool, opts Options) (action, error) {
+	kind := strings.ToLower(strings.TrimSpace(a.Type))
+	out := action{kind: kind}
+
+	switch kind {
+	case "add_header", "set_header", "remove_header":
+		name, err := control.NormalizeHeaderName(field+".header", a.Header)
+		if err != nil {
+			return action{}, err
+		}
+		out.header = name
+		if kind != "remove_header" {
+			v, err := control.ValidateHeaderValue(field+".value", a.Value, a.Value)
+			if err != nil {
+				return action{}, err
+			}
+			out.value, out.tmpl, err = compileHeaderTemplate(field+".value", v)
+			if err != nil {
+				return action{}, err
+			}
+		}
+		return out, nil
+
+	case "rewrite_header":
+		name, err := control.NormalizeHeaderName(field+".header", a.Header)
+		if err != nil {
+			return action{}, err
+		}
+		out.header = name
+		out.from, err = compileRegex(field+".from", a.From)
+		if err != nil {
+			return action{}, err
+		}
+		out.value, err = control.ValidateHeaderValue(field+".value", a.Value, a.Value)
+		if err != nil {
+			return action{}, err
+		}
+		return out, nil
+	}
+
+	if response {
+		return action{}, fmt.Errorf("invalid %s: %q is not valid in on_response", field, a.Type)
+	}
+
+	switch kind {
+	case "deny":
+		status, err := compileStatus(field, a.Status, http.StatusForbidden, 400, 599)
+		if err != nil {
+			return action{}, err
+		}
+		out.status = status
+		if len(a.Body) > MaxBodyBytes {
+			return action{}, fmt.Errorf("invalid %s.body: too large", field)
+		}
+		out.body = a.Body
+
+	case "custom_response":
+		status, err := compileStatus(field, a.Status, http.StatusOK, 200, 599)
+		if err != nil {
+			return action{}, err
+		}
+		out.status = status
+		if len(a.Body) > MaxBodyBytes {
+			return action{}, fmt.Errorf("invalid %s.body: too large", field)
+		}
+		out.body = a.Body
+		out.headers, err = compileResponseHeaders(field+".headers", a.Headers)
+		if err != nil {
+			return action{}, err
+		}
+
+	case "redirect":
+		status := a.Status
+		if status == 0 {
+			status = http.StatusFound
+		}
+		switch status {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return action{}, fmt.Errorf("invalid %s.status: %d", field, a.Status)
+		}
+		out.status = status
+		loc := strings.TrimSpace(a.Location)
+		if loc == "" || len(loc) > maxLocationBytes || strings.ContainsAny(loc, "\r\n\x00") {
+			return action{}, fmt.Errorf("invalid %s.location: %q", field, a.Location)
+		}
+		out.location = loc
+		if a.From != "" {
+			re, err := compileRegex(field+".from", a.From)
+			if err != nil {
+				return action{}, err
+			}
+			out.from = re
+		}
+
+	case "rewrite":
+		if a.From == "" {
+			paths, err := control.ParsePathList(field+".path", []string{a.Path}, 1)
+			if err != nil {
+				return action{}, err
+			}
+			out.path = paths[0]
+			break
+		}
+		re, err := compileRegex(field+".from", a.From)
+		if err != nil {
+			return action{}, err
+		}
+		out.from = re
+		p := strings.TrimSpace(a.Path)
+		if !strings.HasPrefix(p, "/") || len(p) > maxLocationBytes || strings.ContainsAny(p, " \t\r\n\x00#") {
+			return action{}, fmt.Errorf("invalid %s.path: %q", field, a.Path)
+		}
+		out.path = p
+
+	case "rate_limit":
+		var keyHeader string
+		if strings.TrimSpace(a.Header) != "" {
+			name, err := control.NormalizeHeaderName(field+".header", a.Header)
+			if err != nil {
+				return action{}, err
+			}
+			keyHeader = name
+		}
+		limiter, err := NewRateLimiter(a.Limit, keyHeader, opts.Now)
+		if err != nil {
+			return action{}, fmt.Errorf("invalid %s.limit: %v", field, err)
+		}
+		out.limiter = limiter
+
+	default:
+		return action{}, fmt.Errorf("invalid %s: unknown action type %q", field, a.Type)
+	}
+
+	return out, nil
+}
+
+func compileStatus(field string, status, def, lo, hi int) (int, error) {
+	if status == 0 {
+		return def, nil
+	}
+	if status < lo || status > hi {
+		return 0, fmt.Errorf("invalid %s.status: %d", field, status)
+	}
+	return status, nil
+}
+
+func compileResponseHeaders(field string, headers map[string]string) (http.Header, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	if len(headers) > MaxMatchEntries {
+		return nil, fmt.Errorf("invalid %s: too many entries", field)
+	}
+
+	out := make(http.Header, len(headers))
+	for k, v := range headers {
+		name, err := control.NormalizeHeaderName(field, k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := control.ValidateHeaderValue(field, k, v)
+		if err != nil {
+			return nil, err
+		}
+		out.Set(name, value)
+	}
+	return out, nil
+}
+
+func (a action) applyRequest(r *http.Request, clientIP netip.Addr, res *RequestResult) *Response {
+	switch a.kind {
+	case "deny":
+		return &Response{Status: a.status, Body: a.body, deny: true}
+	case "custom_response":
+		return &Response{Status: a.status, Header: a.headers.Clone(), Body: a.body}
+	case "redirect":
+		if a.from == nil {
+			return &Response{Status: a.status, Location: a.location}
+		}
+		subject := r.URL.Path
+		if r.URL.RawQuery != "" {
+			subject += "?" + r.URL.RawQuery
+		}
+		if loc, ok := expandMatch(a.from, a.location, subject); ok {
+			return &Response{Status: a.status, Location: loc}
+		}
+	case "rewrite":
+		if a.from == nil {
+			r.URL.Path = a.path
+			r.URL.RawPath = ""
+			break
+		}
+		if target, ok := expandMatch(a.from, a.path, r.URL.Path); ok {
+			p, q, hasQuery := strings.Cut(target, "?")
+			r.URL.Path = p
+			r.URL.RawPath = ""
+			if hasQuery {
+				r.URL.RawQuery = q
+			}
+		}
+	case "rewrite_header":
+		res.HeaderOps = append(res.HeaderOps, HeaderOp{Op: "rewrite", Name: a.header, Value: a.value, from: a.from})
+	case "add_header", "set_header", "remove_header":
+		res.HeaderOps = append(res.HeaderOps, a.headerOp())
+	case "rate_limit":
+		return a.limiter.Check(r, clientIP)
+	}
+	return nil
+}
+
+func (a action) applyResponse(h http.Header, vars Vars) {
+	switch a.kind {
+	case "add_header", "set_header", "remove_header":
+		a.headerOp().Apply(h, vars)
+	case "rewrite_header":
+		HeaderOp{Op: "rewrite", Name: a.header, Value: a.value, from: a.from}.Apply(h, vars)
+	}
+}
+
+func (a action) headerOp() HeaderOp {
+	return HeaderOp{Op: strings.TrimSuffix(a.kind, "_header"), Name: a.header, Value: a.value, tmpl: a.tmpl}
+}
+
+// expandMatch reports whether re matches s and, if so, returns template with
+// $1 / ${name} references expanded from the first match.
+func expandMatch(re *regexp.Regexp, template, s string) (string, bool) {
+	m := re.FindStringSubmatchIndex(s)
+	if m == nil {
+		return "", false
+	}
+	return string(re.ExpandString(nil, template, s, m)), true
+}
+
+// HeaderOp is a deferred header edit ("add", "set", "remove" or "rewrite").
+// Values of add/set may contain ${var} templates, expanded from vars.
+type HeaderOp struct {
+	Op    string
+	Name  string
+	Value string
+
+	tmpl *headerTemplate
+	from *regexp.Regexp
+}
+
+func (op HeaderOp) Apply(h http.Header, vars Vars) {
+	value := op.Value
+	if op.tmpl != nil {
+		value = op.tmpl.expand(vars)
+	}
+
+	switch op.Op {
+	case "add":
+		h.Add(op.Name, value)
+	case "set":
+		h.Set(op.Name, value)
+	case "remove":
+		h.Del(op.Name)
+	case "rewrite":
+		values := h.Values(op.Name)
+		if len(values) == 0 {
+			return
+		}
+		out := make([]string, 0, len(values))
+		for _, v := range values {
+			out = append(out, op.from.ReplaceAllString(v, op.Value))
+		}
+		h[http.CanonicalHeaderKey(op.Name)] = out
+	}
+}
+
+// Response is an edge-generated response that replaces proxying.
+type Response struct {
+	Status   int
+	Header   http.Header
+	Body     string
+	Location string
+
+	// deny responses are plain-text errors like http.Error.
+	deny bool
+}
+
+func (resp *Response) Write(w http.ResponseWriter, r *http.Request) {
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w.Header()[k] = append([]string(nil), resp.Header[k]...)
+	}
+
+	switch {
+	case resp.Location != "":
+		http.Redirect(w, r, resp.Location, resp.Status)
+	case resp.deny:
+		body := resp.Body
+		if body == "" {
+			body = strings.ToLower(http.StatusText(resp.Status))
+			if resp.Status == http.StatusNotFound {
+				body = "404 page not found"
+			}
+		}
+		http.Error(w, body, resp.Status)
+	default:
+		if resp.Body != "" && w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.WriteHeader(resp.Status)
+		_, _ = w.Write([]byte(resp.Body))
+	}
+}
@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"strings"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// Legacy holds the per-tunnel edge options that predate traffic policies
+// (--allow-method, --allow-path, --allow-cidr, header transforms, ...).
+type Legacy struct {
+	AllowMethods      []string
+	AllowPaths        []string
+	AllowPathPrefixes []string
+
+	AllowCIDRs []string
+	DenyCIDRs  []string
+
+	// RateLimit ("100/m") limits requests per client IP, or per value of
+	// RateLimitHeader when set.
+	RateLimit       string
+	RateLimitHeader string
+
+	Redirects []control.RedirectRule
+	Rewrites  []control.RewriteRule
+
+	RequestHeaderAdd     []control.HeaderKV
+	RequestHeaderRemove  []string
+	ResponseHeaderAdd    []control.HeaderKV
+	ResponseHeaderRemove []string
+}
+
+// LegacyAccess expresses the legacy access options as policy rules: method
+// and path allowlists (404), then CIDR deny/allow (403). A client whose IP is
+// unknown is denied when there are CIDR rules. The edge checks these before
+// anything else answers the request.
+func LegacyAccess(l Legacy) *control.TrafficPolicy {
+	p := &control.TrafficPolicy{}
+
+	if len(l.AllowMethods) > 0 {
+		p.OnRequest = append(p.OnRequest, control.PolicyRule{
+			Name:    "allow_method",
+			Match:   control.PolicyMatch{Method: l.AllowMethods, Not: true},
+			Actions: []control.PolicyAction{{Type: "deny", Status: 404}},
+		})
+	}
+	if len(l.AllowPaths) > 0 || len(l.AllowPathPrefixes) > 0 {
+		p.OnRequest = append(p.OnRequest, control.PolicyRule{
+			Name:    "allow_path",
+			Match:   control.PolicyMatch{Path: l.AllowPaths, PathPrefix: l.AllowPathPrefixes, Not: true},
+			Actions: []control.PolicyAction{{Type: "deny", Status: 404}},
+		})
+	}
+	if len(l.AllowCIDRs) > 0 || len(l.DenyCIDRs) > 0 {
+		p.OnRequest = append(p.OnRequest, control.PolicyRule{
+			Name:    "unknown_client_ip",
+			Match:   control.PolicyMatch{ClientIP: []string{"0.0.0.0/0", "::/0"}, Not: true},
+			Actions: []control.PolicyAction{{Type: "deny"}},
+		})
+	}
+	if len(l.DenyCIDRs) > 0 {
+		p.OnRequest = append(p.OnRequest, control.PolicyRule{
+			Name:    "deny_cidr",
+			Match:   control.PolicyMatch{ClientIP: l.DenyCIDRs},
+			Actions: []control.PolicyAction{{Type: "deny"}},
+		})
+	}
+	if len(l.AllowCIDRs) > 0 {
+		p.OnRequest = append(p.OnRequest, control.PolicyRule{
+			Name:    "allow_cidr",
+			Match:   control.PolicyMatch{ClientIP: l.AllowCIDRs, Not: true},
+			Actions: []control.PolicyAction{{Type: "deny"}},
+		})
+	}
+
+	return p
+}
+
+// FromLegacy expresses the remaining legacy options as policy rules, in the
+// order the edge has always applied them: the rate limit (429), redirects,
+// path rewrites, then header removes before adds (adds replace existing
+// values). See LegacyAccess for the allowlists.
+func FromLegacy(l Legacy) *control.TrafficPolicy {
+	p := &control.TrafficPolicy{}
+
+	if l.RateLimit != "" {
+		p.OnRequest = append(p.OnRequest, control.PolicyRule{
+			Name:    "rate_limit",
+			Actions: []control.PolicyAction{{Type: "rate_limit", Limit: l.RateLimit, Header: l.RateLimitHeader}},
+		})
+	}
+
+	if len(l.Redirects) > 0 {
+		actions := make([]control.PolicyAction, 0, len(l.Redirects))
+		for _, r := range l.Redirects {
+			actions = append(actions, control.PolicyAction{Type: "redirect", From: r.From, Location: r.To, Status: r.Status})
+		}
+		p.OnRequest = append(p.OnRequest, control.PolicyRule{Name: "redirect", Actions: actions})
+	}
+	if len(l.Rewrites) > 0 {
+		actions := make([]control.PolicyAction, 0, len(l.Rewrites))
+		for _, r := range l.Rewrites {
+			actions = append(actions, control.PolicyAction{Type: "rewrite", From: r.From, Path: r.To})
+		}
+		p.OnRequest = append(p.OnRequest, control.PolicyRule{Name: "rewrite", Actions: actions})
+	}
+
+	if actions := headerActions(l.RequestHeaderRemove, l.RequestHeaderAdd); len(actions) > 0 {
+		p.OnRequest = append(p.OnRequest, control.PolicyRule{Name: "request_headers", Actions: actions})
+	}
+	if actions := headerActions(l.ResponseHeaderRemove, l.ResponseHeaderAdd); len(actions) > 0 {
+		p.OnResponse = append(p.OnResponse, control.PolicyRule{Name: "response_headers", Actions: actions})
+	}
+
+	return p
+}
+
+// headerActions trims header names and skips empty ones, as the legacy
+// header transforms always have.
+func headerActions(remove []string, add []control.HeaderKV) []control.PolicyAction {
+	var out []control.PolicyAction
+	for _, name := range remove {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, control.PolicyAction{Type: "remove_header", Header: name})
+		}
+	}
+	for _, kv := range add {
+		if name := strings.TrimSpace(kv.Name); name != "" {
+			out = append(out, control.PolicyAction{Type: "set_header", Header: name, Value: kv.Value})
+		}
+	}
+	return out
+}
+
+// Merge appends extra's rules after base's. Either may be nil.
+func Merge(base, extra *control.TrafficPolicy) *control.TrafficPolicy {
+	out := &control.TrafficPolicy{}
+	for _, p := range []*control.TrafficPolicy{base, extra} {
+		if p == nil {
+			continue
+		}
+		out.OnRequest = append(out.OnRequest, p.OnRequest...)
+		out.OnResponse = append(out.OnResponse, p.OnResponse...)
+	}
+	return out
+}
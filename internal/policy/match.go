@@ -0,0 +1,302 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"regexp"
+	"strings"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+type input struct {
+	req      *http.Request
+	clientIP netip.Addr
+	status   int
+}
+
+type matcher struct {
+	methods []string
+	hosts   []string
+
+	paths        []string
+	pathPrefixes []string
+	pathGlobs    []string
+	pathRegexes  []*regexp.Regexp
+
+	headers []valueMatcher
+	query   []valueMatcher
+
+	clientIPs []netip.Prefix
+	statuses  []int
+
+	not bool
+}
+
+type valueMatcher struct {
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+func compileMatch(field string, m control.PolicyMatch, response bool) (matcher, error) {
+	var (
+		out matcher
+		err error
+	)
+
+	out.methods, err = control.ParseHTTPMethodList(field+".method", m.Method, MaxMatchEntries)
+	if err != nil {
+		return matcher{}, err
+	}
+
+	if len(m.Host) > MaxMatchEntries {
+		return matcher{}, fmt.Errorf("invalid %s.host: too many entries", field)
+	}
+	for _, h := range m.Host {
+		h = normalizeHost(h)
+		if h == "" || strings.ContainsAny(h, "/ \t\r\n") {
+			return matcher{}, fmt.Errorf("invalid %s.host: %q", field, h)
+		}
+		out.hosts = append(out.hosts, h)
+	}
+
+	out.paths, err = control.ParsePathList(field+".path", m.Path, MaxMatchEntries)
+	if err != nil {
+		return matcher{}, err
+	}
+	out.pathPrefixes, err = control.ParsePathList(field+".path_prefix", m.PathPrefix, MaxMatchEntries)
+	if err != nil {
+		return matcher{}, err
+	}
+	out.pathGlobs, err = control.ParsePathList(field+".path_glob", m.PathGlob, MaxMatchEntries)
+	if err != nil {
+		return matcher{}, err
+	}
+	for _, g := range out.pathGlobs {
+		if _, err := path.Match(g, ""); err != nil {
+			return matcher{}, fmt.Errorf("invalid %s.path_glob: %q", field, g)
+		}
+	}
+	out.pathRegexes, err = compileRegexList(field+".path_regex", m.PathRegex)
+	if err != nil {
+		return matcher{}, err
+	}
+
+	out.headers, err = compileValueMatchers(field+".header", m.Header, true)
+	if err != nil {
+		return matcher{}, err
+	}
+	out.query, err = compileValueMatchers(field+".query", m.Query, false)
+	if err != nil {
+		return matcher{}, err
+	}
+
+	out.clientIPs, err = control.ParseCIDRList(field+".client_ip", m.ClientIP, MaxMatchEntries)
+	if err != nil {
+		return matcher{}, err
+	}
+
+	if len(m.Status) > 0 && !response {
+		return matcher{}, fmt.Errorf("invalid %s.status: only valid in on_response", field)
+	}
+	if len(m.Status) > MaxMatchEntries {
+		return matcher{}, fmt.Errorf("invalid %s.status: too many entries", field)
+	}
+	for _, s := range m.Status {
+		if s < 100 || s > 999 {
+			return matcher{}, fmt.Errorf("invalid %s.status: %d", field, s)
+		}
+	}
+	out.statuses = append([]int(nil), m.Status...)
+
+	out.not = m.Not
+	return out, nil
+}
+
+func compileRegexList(field string, values []string) ([]*regexp.Regexp, error) {
+	if len(values) > MaxMatchEntries {
+		return nil, fmt.Errorf("invalid %s: too many entries", field)
+	}
+	var out []*regexp.Regexp
+	for _, v := range values {
+		re, err := compileRegex(field, v)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+func compileRegex(field, s string) (*regexp.Regexp, error) {
+	if s == "" || len(s) > MaxPatternBytes {
+		return nil, fmt.Errorf("invalid %s: %q", field, s)
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", field, err)
+	}
+	return re, nil
+}
+
+func compileValueMatchers(field string, values []control.PolicyValueMatch, header bool) ([]valueMatcher, error) {
+	if len(values) > MaxMatchEntries {
+		return nil, fmt.Errorf("invalid %s: too many entries", field)
+	}
+
+	out := make([]valueMatcher, 0, len(values))
+	for _, v := range values {
+		name := strings.TrimSpace(v.Name)
+		if name == "" || strings.ContainsAny(name, "\r\n\x00") {
+			return nil, fmt.Errorf("invalid %s: name %q", field, v.Name)
+		}
+		if header {
+			name = http.CanonicalHeaderKey(name)
+		}
+		if v.Value != "" && v.Regex != "" {
+			return nil, fmt.Errorf("invalid %s %q: only one of value or regex may be set", field, name)
+		}
+
+		vm := valueMatcher{name: name, value: v.Value}
+		if v.Regex != "" {
+			re, err := compileRegex(field+".regex", v.Regex)
+			if err != nil {
+				return nil, err
+			}
+			vm.regex = re
+		}
+		out = append(out, vm)
+	}
+	return out, nil
+}
+
+func (m matcher) matches(in input) bool {
+	return m.matchesAll(in) != m.not
+}
+
+func (m matcher) matchesAll(in input) bool {
+	r := in.req
+
+	if len(m.methods) > 0 && !containsString(m.methods, strings.ToUpper(r.Method)) {
+		return false
+	}
+	if len(m.hosts) > 0 && !containsString(m.hosts, normalizeHost(r.Host)) {
+		return false
+	}
+	if m.hasPathCriteria() && !m.matchesPath(r.URL.Path) {
+		return false
+	}
+
+	for _, vm := range m.headers {
+		if !vm.matches(r.Header.Values(vm.name)) {
+			return false
+		}
+	}
+	if len(m.query) > 0 {
+		q := r.URL.Query()
+		for _, vm := range m.query {
+			if !vm.matches(q[vm.name]) {
+				return false
+			}
+		}
+	}
+
+	if len(m.clientIPs) > 0 {
+		if !in.clientIP.IsValid() || !prefixesContain(m.clientIPs, in.clientIP) {
+			return false
+		}
+	}
+
+	if len(m.statuses) > 0 {
+		found := false
+		for _, s := range m.statuses {
+			if s == in.status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m matcher) hasPathCriteria() bool {
+	return len(m.paths) > 0 || len(m.pathPrefixes) > 0 || len(m.pathGlobs) > 0 || len(m.pathRegexes) > 0
+}
+
+func (m matcher) matchesPath(p string) bool {
+	if containsString(m.paths, p) {
+		return true
+	}
+	for _, prefix := range m.pathPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	for _, g := range m.pathGlobs {
+		if ok, _ := path.Match(g, p); ok {
+			return true
+		}
+	}
+	for _, re := range m.pathRegexes {
+		if re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (vm valueMatcher) matches(values []string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if vm.value == "" && vm.regex == nil {
+		return true
+	}
+	for _, v := range values {
+		if vm.regex != nil {
+			if vm.regex.MatchString(v) {
+				return true
+			}
+			continue
+		}
+		if v == vm.value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func prefixesContain(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHost(h string) string {
+	h = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(h), "."))
+	if host, _, err := net.SplitHostPort(h); err == nil {
+		h = host
+	}
+	return h
+}
@@ -0,0 +1,166 @@
+// Package policy compiles and evaluates per-tunnel traffic policies
+// (control.TrafficPolicy) at the HTTP edge.
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+const (
+	MaxRulesPerPhase  = 64
+	MaxActionsPerRule = 128
+	MaxMatchEntries   = 64
+	MaxPatternBytes   = 1024
+	MaxBodyBytes      = 16 * 1024
+)
+
+type Options struct {
+	// Now is used by rate_limit actions (default time.Now).
+	Now func() time.Time
+}
+
+// Engine is a compiled traffic policy. It is safe for concurrent use.
+type Engine struct {
+	onRequest  []rule
+	onResponse []rule
+}
+
+type rule struct {
+	match   matcher
+	actions []action
+}
+
+// Compile validates p and prepares it for evaluation. A nil or empty policy
+// compiles to a nil Engine, which evaluates as a no-op.
+func Compile(p *control.TrafficPolicy, opts Options) (*Engine, error) {
+	if p.IsEmpty() {
+		return nil, nil
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	onRequest, err := compileRules("on_request", p.OnRequest, false, opts)
+	if err != nil {
+		return nil, err
+	}
+	onResponse, err := compileRules("on_response", p.OnResponse, true, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Engine{onRequest: onRequest, onResponse: onResponse}, nil
+}
+
+// Join returns an Engine that runs the rules of engines in order, or nil
+// when none has rules. The rules, and the state of their rate limits, are
+// shared with the joined engines.
+func Join(engines ...*Engine) *Engine {
+	out := &Engine{}
+	for _, e := range engines {
+		if e == nil {
+			continue
+		}
+		out.onRequest = append(out.onRequest, e.onRequest...)
+		out.onResponse = append(out.onResponse, e.onResponse...)
+	}
+	if len(out.onRequest) == 0 && len(out.onResponse) == 0 {
+		return nil
+	}
+	return out
+}
+
+func compileRules(phase string, rules []control.PolicyRule, response bool, opts Options) ([]rule, error) {
+	if len(rules) > MaxRulesPerPhase {
+		return nil, fmt.Errorf("invalid %s: too many rules", phase)
+	}
+
+	out := make([]rule, 0, len(rules))
+	for i, r := range rules {
+		field := fmt.Sprintf("%s[%d]", phase, i)
+		if r.Name != "" {
+			field = fmt.Sprintf("%s[%q]", phase, r.Name)
+		}
+
+		m, err := compileMatch(field+".match", r.Match, response)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(r.Actions) == 0 {
+			return nil, fmt.Errorf("invalid %s: no actions", field)
+		}
+		if len(r.Actions) > MaxActionsPerRule {
+			return nil, fmt.Errorf("invalid %s: too many actions", field)
+		}
+		actions := make([]action, 0, len(r.Actions))
+		for j, a := range r.Actions {
+			ca, err := compileAction(fmt.Sprintf("%s.actions[%d]", field, j), a, response, opts)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, ca)
+		}
+
+		out = append(out, rule{match: m, actions: actions})
+	}
+	return out, nil
+}
+
+// RequestResult is the outcome of the on_request phase.
+type RequestResult struct {
+	// Response, when set, is sent to the client instead of proxying.
+	Response *Response
+
+	// HeaderOps edit the upstream request headers, in order. They are
+	// applied once the request is about to be proxied, so templates can see
+	// values (like the basic auth user) established after evaluation.
+	HeaderOps []HeaderOp
+}
+
+// EvalRequest runs the on_request rules. Matches see the request as received
+// (after earlier rewrite actions, which update r.URL in place); header actions
+// are returned for the proxied request.
+func (e *Engine) EvalRequest(r *http.Request, clientIP netip.Addr) RequestResult {
+	var res RequestResult
+	if e == nil {
+		return res
+	}
+
+	in := input{req: r, clientIP: clientIP}
+	for _, rl := range e.onRequest {
+		if !rl.match.matches(in) {
+			continue
+		}
+		for _, a := range rl.actions {
+			if resp := a.applyRequest(r, clientIP, &res); resp != nil {
+				res.Response = resp
+				return res
+			}
+		}
+	}
+	return res
+}
+
+// EvalResponse runs the on_response rules against an upstream response and
+// edits h in place. r is the inbound request.
+func (e *Engine) EvalResponse(r *http.Request, vars Vars, status int, h http.Header) {
+	if e == nil {
+		return
+	}
+
+	in := input{req: r, clientIP: vars.ClientIP, status: status}
+	for _, rl := range e.onResponse {
+		if !rl.match.matches(in) {
+			continue
+		}
+		for _, a := range rl.actions {
+			a.applyResponse(h, vars)
+		}
+	}
+}
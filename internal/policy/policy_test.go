@@ -0,0 +1,514 @@
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+func mustCompile(t *testing.T, p *control.TrafficPolicy, opts Options) *Engine {
+	t.Helper()
+
+	e, err := Compile(p, opts)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	return e
+}
+
+func TestCompile_Empty(t *testing.T) {
+	t.Parallel()
+
+	e, err := Compile(nil, Options{})
+	if err != nil || e != nil {
+		t.Fatalf("Compile(nil) = %v, %v; want nil, nil", e, err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	if res := e.EvalRequest(r, netip.Addr{}); res.Response != nil || len(res.HeaderOps) != 0 {
+		t.Fatalf("nil engine result = %+v, want zero", res)
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		p    control.TrafficPolicy
+		want string
+	}{
+		{
+			name: "no actions",
+			p:    control.TrafficPolicy{OnRequest: []control.PolicyRule{{Name: "x"}}},
+			want: "no actions",
+		},
+		{
+			name: "unknown action",
+			p: control.TrafficPolicy{OnRequest: []control.PolicyRule{{
+				Actions: []control.PolicyAction{{Type: "explode"}},
+			}}},
+			want: "unknown action type",
+		},
+		{
+			name: "deny in on_response",
+			p: control.TrafficPolicy{OnResponse: []control.PolicyRule{{
+				Actions: []control.PolicyAction{{Type: "deny"}},
+			}}},
+			want: "not valid in on_response",
+		},
+		{
+			name: "status match in on_request",
+			p: control.TrafficPolicy{OnRequest: []control.PolicyRule{{
+				Match:   control.PolicyMatch{Status: []int{404}},
+				Actions: []control.PolicyAction{{Type: "deny"}},
+			}}},
+			want: "only valid in on_response",
+		},
+		{
+			name: "bad regex",
+			p: control.TrafficPolicy{OnRequest: []control.PolicyRule{{
+				Match:   control.PolicyMatch{PathRegex: []string{"("}},
+				Actions: []control.PolicyAction{{Type: "deny"}},
+			}}},
+			want: "path_regex",
+		},
+		{
+			name: "bad redirect status",
+			p: control.TrafficPolicy{OnRequest: []control.PolicyRule{{
+				Actions: []control.PolicyAction{{Type: "redirect", Status: 200, Location: "/x"}},
+			}}},
+			want: "status",
+		},
+		{
+			name: "hop-by-hop header",
+			p: control.TrafficPolicy{OnRequest: []control.PolicyRule{{
+				Actions: []control.PolicyAction{{Type: "set_header", Header: "Connection", Value: "x"}},
+			}}},
+			want: "header",
+		},
+		{
+			name: "bad rate",
+			p: control.TrafficPolicy{OnRequest: []control.PolicyRule{{
+				Actions: []control.PolicyAction{{Type: "rate_limit", Limit: "10/fortnight"}},
+			}}},
+			want: "limit",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Compile(&tt.p, Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalRequest_Matching(t *testing.T) {
+	t.Parallel()
+
+	e := mustCompile(t, &control.TrafficPolicy{
+		OnRequest: []control.PolicyRule{
+			{
+				Name: "admin",
+				Match: control.PolicyMatch{
+					Method:     []string{"POST"},
+					PathPrefix: []string{"/admin/"},
+					Header:     []control.PolicyValueMatch{{Name: "x-role", Regex: "^(ops|dev)$"}},
+				},
+				Actions: []control.PolicyAction{{Type: "custom_response", Status: 202, Body: "admin"}},
+			},
+			{
+				Name:    "glob",
+				Match:   control.PolicyMatch{PathGlob: []string{"/files/*.txt"}, Query: []control.PolicyValueMatch{{Name: "dl", Value: "1"}}},
+				Actions: []control.PolicyAction{{Type: "deny", Status: 451}},
+			},
+			{
+				Name:    "office",
+				Match:   control.PolicyMatch{ClientIP: []string{"10.0.0.0/8"}, Not: true},
+				Actions: []control.PolicyAction{{Type: "deny"}},
+			},
+		},
+	}, Options{})
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header map[string]string
+		ip     string
+		status int
+	}{
+		{name: "admin match", method: "POST", url: "/admin/x", header: map[string]string{"X-Role": "ops"}, ip: "10.1.2.3", status: 202},
+		{name: "admin wrong role", method: "POST", url: "/admin/x", header: map[string]string{"X-Role": "guest"}, ip: "10.1.2.3", status: 0},
+		{name: "admin wrong method", method: "GET", url: "/admin/x", header: map[string]string{"X-Role": "ops"}, ip: "10.1.2.3", status: 0},
+		{name: "glob and query", method: "GET", url: "/files/a.txt?dl=1", ip: "10.1.2.3", status: 451},
+		{name: "glob without query", method: "GET", url: "/files/a.txt", ip: "10.1.2.3", status: 0},
+		{name: "outside office", method: "GET", url: "/", ip: "192.0.2.1", status: 403},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(tt.method, "http://example.test"+tt.url, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			res := e.EvalRequest(r, netip.MustParseAddr(tt.ip))
+
+			got := 0
+			if res.Response != nil {
+				got = res.Response.Status
+			}
+			if got != tt.status {
+				t.Fatalf("status = %d, want %d", got, tt.status)
+			}
+		})
+	}
+}
+
+func TestEvalRequest_RewriteAndHeaders(t *testing.T) {
+	t.Parallel()
+
+	e := mustCompile(t, &control.TrafficPolicy{
+		OnRequest: []control.PolicyRule{
+			{
+				Match:   control.PolicyMatch{Path: []string{"/old"}},
+				Actions: []control.PolicyAction{{Type: "rewrite", Path: "/new"}},
+			},
+			{
+				Match: control.PolicyMatch{Path: []string{"/new"}},
+				Actions: []control.PolicyAction{
+					{Type: "remove_header", Header: "X-Drop"},
+					{Type: "add_header", Header: "X-Tag", Value: "a"},
+					{Type: "add_header", Header: "X-Tag", Value: "b"},
+				},
+			},
+		},
+	}, Options{})
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.test/old", nil)
+	res := e.EvalRequest(r, netip.Addr{})
+	if res.Response != nil {
+		t.Fatalf("unexpected response: %+v", res.Response)
+	}
+	if r.URL.Path != "/new" {
+		t.Fatalf("path = %q, want %q", r.URL.Path, "/new")
+	}
+
+	h := http.Header{"X-Drop": {"1"}}
+	for _, op := range res.HeaderOps {
+		op.Apply(h, Vars{})
+	}
+	if h.Get("X-Drop") != "" {
+		t.Fatalf("X-Drop = %q, want empty", h.Get("X-Drop"))
+	}
+	if got := h.Values("X-Tag"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("X-Tag = %#v, want [a b]", got)
+	}
+}
+
+func TestResponseWrite(t *testing.T) {
+	t.Parallel()
+
+	e := mustCompile(t, &control.TrafficPolicy{
+		OnRequest: []control.PolicyRule{
+			{
+				Match:   control.PolicyMatch{Path: []string{"/moved"}},
+				Actions: []control.PolicyAction{{Type: "redirect", Status: 308, Location: "https://example.com/"}},
+			},
+			{
+				Match: control.PolicyMatch{Path: []string{"/teapot"}},
+				Actions: []control.PolicyAction{{
+					Type:    "custom_response",
+					Status:  418,
+					Body:    `{"ok":false}`,
+					Headers: map[string]string{"content-type": "application/json"},
+				}},
+			},
+			{
+				Match:   control.PolicyMatch{Path: []string{"/hidden"}},
+				Actions: []control.PolicyAction{{Type: "deny", Status: 404}},
+			},
+		},
+	}, Options{})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://example.test"+path, nil)
+		rec := httptest.NewRecorder()
+		res := e.EvalRequest(r, netip.Addr{})
+		if res.Response == nil {
+			t.Fatalf("%s: no response", path)
+		}
+		res.Response.Write(rec, r)
+		return rec
+	}
+
+	if rec := serve("/moved"); rec.Code != 308 || rec.Header().Get("Location") != "https://example.com/" {
+		t.Fatalf("redirect = %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := serve("/teapot"); rec.Code != 418 || rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != `{"ok":false}` {
+		t.Fatalf("custom_response = %d %q %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	if rec := serve("/hidden"); rec.Code != 404 || strings.TrimSpace(rec.Body.String()) != "404 page not found" {
+		t.Fatalf("deny = %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestEvalRequest_RateLimit(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	e := mustCompile(t, &control.TrafficPolicy{
+		OnRequest: []control.PolicyRule{{
+			Actions: []control.PolicyAction{{Type: "rate_limit", Limit: "2/m"}},
+		}},
+	}, Options{Now: func() time.Time { return now }})
+
+	a := netip.MustParseAddr("192.0.2.1")
+	b := netip.MustParseAddr("192.0.2.2")
+	eval := func(ip netip.Addr) *Response {
+		r := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+		return e.EvalRequest(r, ip).Response
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := eval(a); resp != nil {
+			t.Fatalf("request %d limited early", i)
+		}
+	}
+	resp := eval(a)
+	if resp == nil || resp.Status != http.StatusTooManyRequests {
+		t.Fatalf("third request = %+v, want 429", resp)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "30" {
+		t.Fatalf("Retry-After = %q, want %q", got, "30")
+	}
+	if resp := eval(b); resp != nil {
+		t.Fatalf("other client limited: %+v", resp)
+	}
+
+	now = now.Add(30 * time.Second)
+	if resp := eval(a); resp != nil {
+		t.Fatalf("request after refill limited: %+v", resp)
+	}
+}
+
+func TestEvalResponse_StatusMatch(t *testing.T) {
+	t.Parallel()
+
+	e := mustCompile(t, &control.TrafficPolicy{
+		OnResponse: []control.PolicyRule{
+			{
+				Match:   control.PolicyMatch{Status: []int{404}},
+				Actions: []control.PolicyAction{{Type: "set_header", Header: "Cache-Control", Value: "no-store"}},
+			},
+			{
+				Actions: []control.PolicyAction{{Type: "remove_header", Header: "Server"}},
+			},
+		},
+	}, Options{})
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+
+	h := http.Header{"Server": {"x"}}
+	e.EvalResponse(r, Vars{}, http.StatusOK, h)
+	if h.Get("Cache-Control") != "" || h.Get("Server") != "" {
+		t.Fatalf("200 headers = %#v", h)
+	}
+
+	h = http.Header{}
+	e.EvalResponse(r, Vars{}, http.StatusNotFound, h)
+	if h.Get("Cache-Control") != "no-store" {
+		t.Fatalf("404 Cache-Control = %q, want %q", h.Get("Cache-Control"), "no-store")
+	}
+}
+
+func TestFromLegacy(t *testing.T) {
+	t.Parallel()
+
+	l := Legacy{
+		AllowMethods:      []string{"GET"},
+		AllowPaths:        []string{"/healthz"},
+		AllowPathPrefixes: []string{"/api/"},
+		AllowCIDRs:        []string{"192.0.2.0/24"},
+		DenyCIDRs:         []string{"192.0.2.66/32"},
+		RequestHeaderAdd:  []control.HeaderKV{{Name: "X-Env", Value: "prod"}},
+	}
+	e := mustCompile(t, Merge(LegacyAccess(l), FromLegacy(l)), Options{})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		ip     string
+		status int
+	}{
+		{name: "allowed", method: "GET", path: "/api/x", ip: "192.0.2.1", status: 0},
+		{name: "method", method: "POST", path: "/api/x", ip: "192.0.2.1", status: 404},
+		{name: "path", method: "GET", path: "/other", ip: "192.0.2.1", status: 404},
+		{name: "deny cidr", method: "GET", path: "/healthz", ip: "192.0.2.66", status: 403},
+		{name: "outside allow cidr", method: "GET", path: "/healthz", ip: "198.51.100.1", status: 403},
+		{name: "unknown ip", method: "GET", path: "/healthz", ip: "", status: 403},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://example.test"+tt.path, nil)
+		ip, _ := netip.ParseAddr(tt.ip)
+		res := e.EvalRequest(r, ip)
+
+		got := 0
+		if res.Response != nil {
+			got = res.Response.Status
+		}
+		if got != tt.status {
+			t.Fatalf("%s: status = %d, want %d", tt.name, got, tt.status)
+		}
+		if got == 0 && (len(res.HeaderOps) != 1 || res.HeaderOps[0].Op != "set" || res.HeaderOps[0].Name != "X-Env" || res.HeaderOps[0].Value != "prod") {
+			t.Fatalf("%s: header ops = %#v", tt.name, res.HeaderOps)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	t.Parallel()
+
+	if Join(nil, nil) != nil {
+		t.Fatalf("Join of nil engines is not nil")
+	}
+
+	first := mustCompile(t, &control.TrafficPolicy{OnRequest: []control.PolicyRule{{
+		Actions: []control.PolicyAction{{Type: "set_header", Header: "X-Order", Value: "first"}},
+	}}}, Options{})
+	second := mustCompile(t, &control.TrafficPolicy{OnRequest: []control.PolicyRule{{
+		Match:   control.PolicyMatch{Path: []string{"/closed"}},
+		Actions: []control.PolicyAction{{Type: "deny"}},
+	}}}, Options{})
+	e := Join(first, nil, second)
+
+	res := e.EvalRequest(httptest.NewRequest(http.MethodGet, "http://example.test/open", nil), netip.Addr{})
+	if res.Response != nil || len(res.HeaderOps) != 1 || res.HeaderOps[0].Value != "first" {
+		t.Fatalf("open: %#v", res)
+	}
+	res = e.EvalRequest(httptest.NewRequest(http.MethodGet, "http://example.test/closed", nil), netip.Addr{})
+	if res.Response == nil || res.Response.Status != http.StatusForbidden {
+		t.Fatalf("closed: %#v", res)
+	}
+}
+
+func TestRegexRewriteRedirectAndHeader(t *testing.T) {
+	t.Parallel()
+
+	e := mustCompile(t, &control.TrafficPolicy{
+		OnRequest: []control.PolicyRule{
+			{Actions: []control.PolicyAction{
+				{Type: "redirect", From: `^/docs/(\d+)$`, Location: "https://docs.example.com/v$1", Status: 308},
+				{Type: "rewrite", From: `^/v1/(.*)$`, Path: "/api/v1/$1?src=v1"},
+				{Type: "rewrite_header", Header: "X-Origin", From: `^http://`, Value: "https://"},
+			}},
+		},
+		OnResponse: []control.PolicyRule{{
+			Actions: []control.PolicyAction{{Type: "rewrite_header", Header: "Location", From: `^http://internal:8080`, Value: "https://example.com"}},
+		}},
+	}, Options{})
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.test/docs/3", nil)
+	res := e.EvalRequest(r, netip.Addr{})
+	if res.Response == nil || res.Response.Status != 308 || res.Response.Location != "https://docs.example.com/v3" {
+		t.Fatalf("redirect = %+v", res.Response)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://example.test/v1/users?x=1", nil)
+	res = e.EvalRequest(r, netip.Addr{})
+	if res.Response != nil {
+		t.Fatalf("unexpected response: %+v", res.Response)
+	}
+	if r.URL.Path != "/api/v1/users" || r.URL.RawQuery != "src=v1" {
+		t.Fatalf("rewritten URL = %q ? %q", r.URL.Path, r.URL.RawQuery)
+	}
+
+	h := http.Header{"X-Origin": {"http://a.test"}}
+	for _, op := range res.HeaderOps {
+		op.Apply(h, Vars{})
+	}
+	if got := h.Get("X-Origin"); got != "https://a.test" {
+		t.Fatalf("X-Origin = %q, want %q", got, "https://a.test")
+	}
+
+	// Non-matching paths are left alone.
+	r = httptest.NewRequest(http.MethodGet, "http://example.test/other", nil)
+	if res := e.EvalRequest(r, netip.Addr{}); res.Response != nil || r.URL.Path != "/other" {
+		t.Fatalf("other = %+v %q", res.Response, r.URL.Path)
+	}
+
+	rh := http.Header{"Location": {"http://internal:8080/login"}}
+	e.EvalResponse(r, Vars{}, http.StatusFound, rh)
+	if got := rh.Get("Location"); got != "https://example.com/login" {
+		t.Fatalf("Location = %q, want %q", got, "https://example.com/login")
+	}
+}
+
+func TestHeaderTemplates(t *testing.T) {
+	t.Parallel()
+
+	e := mustCompile(t, &control.TrafficPolicy{
+		OnRequest: []control.PolicyRule{{Actions: []control.PolicyAction{
+			{Type: "set_header", Header: "X-User", Value: "${basic_auth_user}"},
+			{Type: "set_header", Header: "X-Trace", Value: "${tunnel_id}/${request_id} from ${client_ip} at ${time} via ${host}"},
+			{Type: "set_header", Header: "X-Literal", Value: "$${host} costs $5"},
+		}}},
+		OnResponse: []control.PolicyRule{{Actions: []control.PolicyAction{
+			{Type: "add_header", Header: "X-Request-Id", Value: "${request_id}"},
+		}}},
+	}, Options{})
+
+	vars := Vars{
+		ClientIP:      netip.MustParseAddr("::ffff:192.0.2.1"),
+		TunnelID:      "abcd1234",
+		RequestID:     "req-1",
+		Host:          "abcd1234.tunnel.eosrift.test",
+		BasicAuthUser: "alice\r\nX-Evil: 1",
+		Time:          time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	res := e.EvalRequest(r, vars.ClientIP)
+	h := make(http.Header)
+	for _, op := range res.HeaderOps {
+		op.Apply(h, vars)
+	}
+
+	if got := h.Get("X-User"); got != "aliceX-Evil: 1" {
+		t.Fatalf("X-User = %q, want CR/LF stripped", got)
+	}
+	if got, want := h.Get("X-Trace"), "abcd1234/req-1 from 192.0.2.1 at 2024-01-02T03:04:05Z via abcd1234.tunnel.eosrift.test"; got != want {
+		t.Fatalf("X-Trace = %q, want %q", got, want)
+	}
+	if got := h.Get("X-Literal"); got != "${host} costs $5" {
+		t.Fatalf("X-Literal = %q, want %q", got, "${host} costs $5")
+	}
+
+	rh := make(http.Header)
+	e.EvalResponse(r, vars, http.StatusOK, rh)
+	if got := rh.Get("X-Request-Id"); got != "req-1" {
+		t.Fatalf("X-Request-Id = %q, want %q", got, "req-1")
+	}
+
+	if _, err := Compile(&control.TrafficPolicy{OnRequest: []control.PolicyRule{{Actions: []control.PolicyAction{
+		{Type: "set_header", Header: "X-Bad", Value: "${password}"},
+	}}}}, Options{}); err == nil || !strings.Contains(err.Error(), "unknown variable") {
+		t.Fatalf("err = %v, want unknown variable", err)
+	}
+}
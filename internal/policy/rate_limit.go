@@ -0,0 +1,172 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// MaxRateLimitKeys bounds the buckets one limiter keeps. Once reached, new
+// keys share a single overflow bucket until the next sweep of idle keys.
+const MaxRateLimitKeys = 100_000
+
+// RateLimiter is a per-key token bucket: n requests per window, refilled
+// continuously, with a burst of the full n. Requests are keyed by client IP,
+// or by the value of a header (e.g. an API key) when one is configured.
+//
+// A bucket left idle for a full window has refilled completely and is
+// indistinguishable from a new one, so such keys are evicted. Sweeps run at
+// most once per window, so a flood of new keys can't force one per request.
+type RateLimiter struct {
+	mu sync.Mutex
+
+	limit     string
+	keyHeader string
+
+	rate   float64 // tokens per second
+	burst  float64
+	window time.Duration
+	now    func() time.Time
+
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter parses limit ("<n>/<s|m|h>") and returns a limiter keyed by
+// keyHeader when non-empty, falling back to the client IP.
+func NewRateLimiter(limit, keyHeader string, now func() time.Time) (*RateLimiter, error) {
+	n, window, err := control.ParseRate(limit)
+	if err != nil {
+		return nil, err
+	}
+	if now == nil {
+		now = time.Now
+	}
+
+	return &RateLimiter{
+		limit:     strings.TrimSpace(limit),
+		keyHeader: http.CanonicalHeaderKey(strings.TrimSpace(keyHeader)),
+		rate:      float64(n) / window.Seconds(),
+		burst:     float64(n),
+		window:    window,
+		now:       now,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: now(),
+	}, nil
+}
+
+// Limit returns the configured rate, e.g. "100/m".
+func (l *RateLimiter) Limit() string { return l.limit }
+
+// KeyHeader returns the header requests are keyed by ("" for client IP).
+func (l *RateLimiter) KeyHeader() string { return l.keyHeader }
+
+// Len reports how many keys are currently tracked.
+func (l *RateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// Check takes a token for the request's key and returns a 429 response when
+// none is left.
+func (l *RateLimiter) Check(r *http.Request, clientIP netip.Addr) *Response {
+	if l == nil {
+		return nil
+	}
+
+	ok, retryAfter := l.allow(l.key(r, clientIP))
+	if ok {
+		return nil
+	}
+
+	h := make(http.Header)
+	h.Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+	return &Response{Status: http.StatusTooManyRequests, Header: h, deny: true}
+}
+
+func (l *RateLimiter) key(r *http.Request, clientIP netip.Addr) string {
+	if l.keyHeader != "" {
+		if v := r.Header.Get(l.keyHeader); v != "" {
+			// Hash so arbitrarily long header values cost a fixed amount of
+			// memory per key.
+			sum := sha256.Sum256([]byte(v))
+			return "h:" + string(sum[:16])
+		}
+	}
+	if clientIP.IsValid() {
+		return "ip:" + clientIP.Unmap().String()
+	}
+	return "ip:unknown"
+}
+
+// allow takes a token for key. When none is available it reports how long
+// until the next one.
+func (l *RateLimiter) allow(key string) (bool, time.Duration) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= l.window {
+		l.sweepLocked(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= MaxRateLimitKeys {
+			key = ""
+			b = l.buckets[key]
+		}
+		if b == nil {
+			b = &tokenBucket{tokens: l.burst, last: now}
+			l.buckets[key] = b
+		}
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	} else if elapsed < 0 {
+		// Clock moved backwards; avoid a huge refill later.
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweepLocked drops buckets idle long enough to have refilled completely.
+func (l *RateLimiter) sweepLocked(now time.Time) {
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= l.window {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+func retryAfterSeconds(d time.Duration) int {
+	s := int(math.Ceil(d.Seconds()))
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_KeyHeader(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	l, err := NewRateLimiter("1/m", "x-api-key", func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("NewRateLimiter: %v", err)
+	}
+
+	ip := netip.MustParseAddr("192.0.2.1")
+	req := func(key string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+		if key != "" {
+			r.Header.Set("X-Api-Key", key)
+		}
+		return r
+	}
+
+	if resp := l.Check(req("a"), ip); resp != nil {
+		t.Fatalf("key a limited on first request")
+	}
+	if resp := l.Check(req("a"), ip); resp == nil || resp.Status != http.StatusTooManyRequests {
+		t.Fatalf("key a second request = %+v, want 429", resp)
+	}
+	// Same IP, different key: separate bucket.
+	if resp := l.Check(req("b"), ip); resp != nil {
+		t.Fatalf("key b limited on first request")
+	}
+	// No header: falls back to the client IP.
+	if resp := l.Check(req(""), ip); resp != nil {
+		t.Fatalf("keyless request limited on first request")
+	}
+	if resp := l.Check(req(""), ip); resp == nil {
+		t.Fatalf("keyless second request not limited")
+	}
+}
+
+func TestRateLimiter_EvictsIdleKeys(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	l, err := NewRateLimiter("10/s", "", func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("NewRateLimiter: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	for i := 1; i <= 50; i++ {
+		l.Check(r, netip.AddrFrom4([4]byte{192, 0, 2, byte(i)}))
+	}
+	if got := l.Len(); got != 50 {
+		t.Fatalf("Len = %d, want 50", got)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	l.Check(r, netip.MustParseAddr("198.51.100.1"))
+	if got := l.Len(); got != 51 {
+		t.Fatalf("Len before window = %d, want 51", got)
+	}
+
+	now = now.Add(time.Second)
+	l.Check(r, netip.MustParseAddr("198.51.100.2"))
+	if got := l.Len(); got != 1 {
+		t.Fatalf("Len after idle window = %d, want 1", got)
+	}
+}
+
+func TestRateLimiter_FullSweepsOncePerWindow(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1_700_000_000, 0)
+	now := start
+	l, err := NewRateLimiter("10/s", "", func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("NewRateLimiter: %v", err)
+	}
+
+	now = start.Add(500 * time.Millisecond)
+	for i := 0; i < MaxRateLimitKeys; i++ {
+		l.allow("k" + strconv.Itoa(i))
+	}
+
+	// Swept at 1s, when the keys had been idle for only half a window.
+	now = start.Add(time.Second)
+	l.allow("new-1")
+	if got, want := l.Len(), MaxRateLimitKeys+1; got != want {
+		t.Fatalf("Len after first sweep = %d, want %d", got, want)
+	}
+
+	// The keys are idle by now, but the next sweep isn't due: new keys
+	// share the overflow bucket.
+	now = start.Add(1600 * time.Millisecond)
+	l.allow("new-2")
+	if got, want := l.Len(), MaxRateLimitKeys+1; got != want {
+		t.Fatalf("Len between sweeps = %d, want %d", got, want)
+	}
+
+	now = start.Add(2 * time.Second)
+	l.allow("new-3")
+	if got := l.Len(); got != 2 {
+		t.Fatalf("Len after second sweep = %d, want 2", got)
+	}
+}
+
+func TestRateLimiter_Nil(t *testing.T) {
+	t.Parallel()
+
+	var l *RateLimiter
+	r := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	if resp := l.Check(r, netip.Addr{}); resp != nil {
+		t.Fatalf("nil limiter response = %+v, want nil", resp)
+	}
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// Vars are the per-request values header templates (${name}) expand to.
+type Vars struct {
+	ClientIP      netip.Addr
+	TunnelID      string
+	RequestID     string
+	Host          string
+	BasicAuthUser string
+	Time          time.Time
+}
+
+func (v Vars) lookup(name string) string {
+	switch name {
+	case "client_ip":
+		if v.ClientIP.IsValid() {
+			return v.ClientIP.Unmap().String()
+		}
+	case "tunnel_id":
+		return v.TunnelID
+	case "request_id":
+		return v.RequestID
+	case "host":
+		return v.Host
+	case "time":
+		if !v.Time.IsZero() {
+			return v.Time.UTC().Format(time.RFC3339)
+		}
+	case "basic_auth_user":
+		return v.BasicAuthUser
+	}
+	return ""
+}
+
+type headerTemplate struct {
+	parts []control.HeaderTemplatePart
+}
+
+// compileHeaderTemplate returns nil for values without variables.
+func compileHeaderTemplate(field, value string) (string, *headerTemplate, error) {
+	parts, err := control.ParseHeaderTemplate(field, value)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(parts) == 0 {
+		return "", nil, nil
+	}
+	if len(parts) == 1 && parts[0].Var == "" {
+		return parts[0].Literal, nil, nil
+	}
+	return value, &headerTemplate{parts: parts}, nil
+}
+
+func (t *headerTemplate) expand(v Vars) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.Var == "" {
+			b.WriteString(p.Literal)
+			continue
+		}
+		// Variables come from the request; drop anything that could split
+		// the header.
+		b.WriteString(strings.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == 0 {
+				return -1
+			}
+			return r
+		}, v.lookup(p.Var)))
+	}
+	return b.String()
+}
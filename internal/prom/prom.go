@@ -0,0 +1,417 @@
+// Package prom is a small metrics registry that writes the Prometheus text
+// exposition format (v0.0.4).
+package prom
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the Content-Type of WriteText output.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// DefBuckets are latency buckets in seconds, from 5ms to 10s.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and writes them in registration order.
+// The New* methods panic on invalid or duplicate names, like a failed
+// registration at startup would.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, labels []string, f family) {
+	if !metricNameRE.MatchString(name) {
+		panic(fmt.Sprintf("prom: invalid metric name %q", name))
+	}
+	for _, l := range labels {
+		if !labelNameRE.MatchString(l) || strings.HasPrefix(l, "__") {
+			panic(fmt.Sprintf("prom: invalid label name %q for %s", l, name))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic(fmt.Sprintf("prom: duplicate metric %s", name))
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteText writes every family with at least one series.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// NewCounter registers a counter. Series are created on first use, one per
+// combination of label values.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec[atomic.Int64](name, help, labels)}
+	if len(labels) == 0 {
+		c.vec.get(nil)
+	}
+	r.register(name, labels, c)
+	return c
+}
+
+// NewGauge registers a gauge.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec[atomic.Int64](name, help, labels)}
+	if len(labels) == 0 {
+		g.vec.get(nil)
+	}
+	r.register(name, labels, g)
+	return g
+}
+
+// NewGaugeFunc registers an unlabelled gauge whose value is read from fn at
+// scrape time.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() int64) {
+	r.register(name, nil, &gaugeFunc{name: name, help: help, fn: fn})
+}
+
+// NewHistogram registers a histogram with the given upper bounds (sorted
+// ascending; +Inf is implied).
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	for _, l := range labels {
+		if l == "le" {
+			panic(fmt.Sprintf("prom: histogram %s uses reserved label le", name))
+		}
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("prom: histogram %s buckets are not sorted", name))
+	}
+	h := &Histogram{
+		vec:     newVec[histogramSeries](name, help, labels),
+		buckets: append([]float64(nil), buckets...),
+	}
+	r.register(name, labels, h)
+	return h
+}
+
+// vec maps label values to series of type T.
+type vec[T any] struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.RWMutex
+	series map[string]*entry[T]
+}
+
+type entry[T any] struct {
+	values []string
+	v      T
+}
+
+func newVec[T any](name, help string, labels []string) *vec[T] {
+	return &vec[T]{
+		name:   name,
+		help:   help,
+		labels: append([]string(nil), labels...),
+		series: make(map[string]*entry[T]),
+	}
+}
+
+func (v *vec[T]) get(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("prom: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	e := v.series[key]
+	v.mu.RUnlock()
+	if e != nil {
+		return &e.v
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if e = v.series[key]; e == nil {
+		e = &entry[T]{values: append([]string(nil), values...)}
+		v.series[key] = e
+	}
+	return &e.v
+}
+
+// lookup returns the series for values without creating it.
+func (v *vec[T]) lookup(values []string) *T {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if e := v.series[strings.Join(values, "\xff")]; e != nil {
+		return &e.v
+	}
+	return nil
+}
+
+// sorted returns the series ordered by label values, for stable output.
+func (v *vec[T]) sorted() []*entry[T] {
+	v.mu.RLock()
+	out := make([]*entry[T], 0, len(v.series))
+	for _, e := range v.series {
+		out = append(out, e)
+	}
+	v.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].values, "\xff") < strings.Join(out[j].values, "\xff")
+	})
+	return out
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, helpEscaper.Replace(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, typ)
+}
+
+// Counter is a monotonically increasing integer, such as a request or byte
+// count.
+type Counter struct {
+	vec *vec[atomic.Int64]
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.vec.get(labelValues).Add(1)
+}
+
+// Add adds n (which must not be negative) to the series. Add(0, ...)
+// creates a series so it is exported before its first increment.
+func (c *Counter) Add(n int64, labelValues ...string) {
+	if n < 0 {
+		panic(fmt.Sprintf("prom: counter %s decreased", c.vec.name))
+	}
+	c.vec.get(labelValues).Add(n)
+}
+
+// Value returns the current value of a series (0 if it does not exist).
+func (c *Counter) Value(labelValues ...string) int64 {
+	if v := c.vec.lookup(labelValues); v != nil {
+		return v.Load()
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	writeInts(w, c.vec, "counter")
+}
+
+// Gauge is an integer that can go up and down.
+type Gauge struct {
+	vec *vec[atomic.Int64]
+}
+
+func (g *Gauge) Add(n int64, labelValues ...string) {
+	g.vec.get(labelValues).Add(n)
+}
+
+func (g *Gauge) Set(n int64, labelValues ...string) {
+	g.vec.get(labelValues).Store(n)
+}
+
+func (g *Gauge) Value(labelValues ...string) int64 {
+	if v := g.vec.lookup(labelValues); v != nil {
+		return v.Load()
+	}
+	return 0
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeInts(w, g.vec, "gauge")
+}
+
+func writeInts(w *bufio.Writer, v *vec[atomic.Int64], typ string) {
+	series := v.sorted()
+	if len(series) == 0 {
+		return
+	}
+	v.writeHeader(w, typ)
+	for _, e := range series {
+		fmt.Fprintf(w, "%s%s %d\n", v.name, formatLabels(v.labels, e.values, "", ""), e.v.Load())
+	}
+}
+
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() int64
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, helpEscaper.Replace(g.help))
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+	fmt.Fprintf(w, "%s %d\n", g.name, g.fn())
+}
+
+// Histogram counts observations (such as latencies) in buckets.
+type Histogram struct {
+	vec     *vec[histogramSeries]
+	buckets []float64
+}
+
+type histogramSeries struct {
+	once    sync.Once
+	counts  []atomic.Uint64 // per bucket, not cumulative; the last is +Inf
+	count   atomic.Uint64
+	sumBits atomic.Uint64
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	s := h.vec.get(labelValues)
+	s.once.Do(func() { s.counts = make([]atomic.Uint64, len(h.buckets)+1) })
+
+	s.counts[sort.SearchFloat64s(h.buckets, v)].Add(1)
+	for {
+		old := s.sumBits.Load()
+		if s.sumBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+	s.count.Add(1)
+}
+
+// Count returns how many observations a series has.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	if s := h.vec.lookup(labelValues); s != nil {
+		return s.count.Load()
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	series := h.vec.sorted()
+	if len(series) == 0 {
+		return
+	}
+	h.vec.writeHeader(w, "histogram")
+	name, labels := h.vec.name, h.vec.labels
+	for _, e := range series {
+		s := &e.v
+		s.once.Do(func() { s.counts = make([]atomic.Uint64, len(h.buckets)+1) })
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i].Load()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels, e.values, "le", formatFloat(upper)), cumulative)
+		}
+		cumulative += s.counts[len(h.buckets)].Load()
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels, e.values, "le", "+Inf"), cumulative)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(labels, e.values, "", ""), formatFloat(math.Float64frombits(s.sumBits.Load())))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(labels, e.values, "", ""), cumulative)
+	}
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// formatLabels renders {name="value",...}. Labels with empty values are
+// left out, which Prometheus treats the same as an absent label.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var sb strings.Builder
+	add := func(name, value string) {
+		if value == "" {
+			return
+		}
+		if sb.Len() == 0 {
+			sb.WriteByte('{')
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelEscaper.Replace(value))
+		sb.WriteByte('"')
+	}
+	for i, name := range names {
+		add(name, values[i])
+	}
+	if extraName != "" {
+		add(extraName, extraValue)
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Overflow is the label value LabelLimiter reports once its limit is
+// reached.
+const Overflow = "other"
+
+// LabelLimiter bounds the cardinality of a label such as a token id: the
+// first max distinct values pass through, later ones become Overflow. A nil
+// or zero-limit LabelLimiter drops the label (returns "").
+type LabelLimiter struct {
+	max int
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func NewLabelLimiter(max int) *LabelLimiter {
+	return &LabelLimiter{max: max, seen: make(map[string]bool)}
+}
+
+func (l *LabelLimiter) Value(v string) string {
+	if l == nil || l.max <= 0 || v == "" {
+		return ""
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.seen[v] {
+		return v
+	}
+	if len(l.seen) >= l.max {
+		return Overflow
+	}
+	l.seen[v] = true
+	return v
+}
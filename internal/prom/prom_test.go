@@ -0,0 +1,140 @@
+package prom
+
+import (
+	"strings"
+	"testing"
+)
+
+func writeText(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	return sb.String()
+}
+
+func TestRegistry_WriteText(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	r.NewGaugeFunc("app_uptime_seconds", "Uptime.", func() int64 { return 42 })
+	total := r.NewCounter("app_jobs_total", "Jobs run.")
+	byKind := r.NewCounter("app_errors_total", "Errors by kind.", "kind", "token")
+	active := r.NewGauge("app_active", "Active things.", "type")
+	r.NewCounter("app_unused_total", "Never incremented.", "kind")
+
+	total.Inc()
+	total.Add(2)
+	byKind.Inc("timeout", "7")
+	byKind.Add(0, "refused", "")
+	byKind.Inc("quote\"d\n", "7")
+	active.Add(3, "http")
+	active.Add(-1, "http")
+	active.Set(5, "tcp")
+
+	want := `# HELP app_uptime_seconds Uptime.
+# TYPE app_uptime_seconds gauge
+app_uptime_seconds 42
+# HELP app_jobs_total Jobs run.
+# TYPE app_jobs_total counter
+app_jobs_total 3
+# HELP app_errors_total Errors by kind.
+# TYPE app_errors_total counter
+app_errors_total{kind="quote\"d\n",token="7"} 1
+app_errors_total{kind="refused"} 0
+app_errors_total{kind="timeout",token="7"} 1
+# HELP app_active Active things.
+# TYPE app_active gauge
+app_active{type="http"} 2
+app_active{type="tcp"} 5
+`
+	if got := writeText(t, r); got != want {
+		t.Fatalf("WriteText =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := byKind.Value("timeout", "7"); got != 1 {
+		t.Fatalf("Value = %d, want 1", got)
+	}
+	if got := byKind.Value("missing", ""); got != 0 {
+		t.Fatalf("Value of a missing series = %d, want 0", got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	h := r.NewHistogram("app_latency_seconds", "Latency.", []float64{0.1, 1}, "type")
+
+	h.Observe(0.05, "http")
+	h.Observe(0.1, "http")
+	h.Observe(0.5, "http")
+	h.Observe(3, "http")
+
+	want := `# HELP app_latency_seconds Latency.
+# TYPE app_latency_seconds histogram
+app_latency_seconds_bucket{type="http",le="0.1"} 2
+app_latency_seconds_bucket{type="http",le="1"} 3
+app_latency_seconds_bucket{type="http",le="+Inf"} 4
+app_latency_seconds_sum{type="http"} 3.65
+app_latency_seconds_count{type="http"} 4
+`
+	if got := writeText(t, r); got != want {
+		t.Fatalf("WriteText =\n%s\nwant\n%s", got, want)
+	}
+	if got := h.Count("http"); got != 4 {
+		t.Fatalf("Count = %d, want 4", got)
+	}
+}
+
+func TestRegistry_PanicsOnBadRegistration(t *testing.T) {
+	t.Parallel()
+
+	for name, register := range map[string]func(r *Registry){
+		"invalid name":   func(r *Registry) { r.NewCounter("bad-name", "") },
+		"invalid label":  func(r *Registry) { r.NewCounter("ok_total", "", "bad-label") },
+		"reserved label": func(r *Registry) { r.NewHistogram("ok_seconds", "", DefBuckets, "le") },
+		"unsorted":       func(r *Registry) { r.NewHistogram("ok_seconds", "", []float64{1, 0.5}) },
+		"duplicate": func(r *Registry) {
+			r.NewCounter("dup_total", "")
+			r.NewGauge("dup_total", "")
+		},
+		"label count": func(r *Registry) { r.NewCounter("ok_total", "", "kind").Inc() },
+		"decrease":    func(r *Registry) { r.NewCounter("ok_total", "").Add(-1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", name)
+				}
+			}()
+			register(NewRegistry())
+		}()
+	}
+}
+
+func TestLabelLimiter(t *testing.T) {
+	t.Parallel()
+
+	l := NewLabelLimiter(2)
+	for _, tc := range []struct{ in, want string }{
+		{"1", "1"},
+		{"2", "2"},
+		{"3", Overflow},
+		{"1", "1"},
+		{"", ""},
+	} {
+		if got := l.Value(tc.in); got != tc.want {
+			t.Fatalf("Value(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	if got := NewLabelLimiter(0).Value("1"); got != "" {
+		t.Fatalf("zero limit Value = %q, want empty", got)
+	}
+	if got := (*LabelLimiter)(nil).Value("1"); got != "" {
+		t.Fatalf("nil limiter Value = %q, want empty", got)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+
+	"eosrift.com/eosrift/internal/inspect"
+	"eosrift.com/eosrift/internal/logging"
+)
+
+const (
+	AccessLogOff    = "off"
+	AccessLogStdout = "stdout"
+	AccessLogFile   = "file"
+)
+
+// OpenAccessLog returns the access logger selected by cfg.AccessLog (nil
+// when access logs are off) and, for AccessLogFile, the file to close on
+// shutdown.
+func OpenAccessLog(cfg Config, stdout io.Writer) (logging.Logger, io.Closer, error) {
+	format := logging.FormatJSON
+	if cfg.AccessLogFormat != "" {
+		f, ok := logging.ParseFormat(cfg.AccessLogFormat)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid access log format %q (want json or text)", cfg.AccessLogFormat)
+		}
+		format = f
+	}
+
+	var (
+		out    io.Writer
+		closer io.Closer
+	)
+	switch cfg.AccessLog {
+	case "", AccessLogOff:
+		return nil, nil, nil
+	case AccessLogStdout:
+		out = stdout
+	case AccessLogFile:
+		f, err := logging.OpenRotatingFile(logging.RotatingFileOptions{
+			Path:     cfg.AccessLogPath,
+			MaxBytes: cfg.AccessLogMaxBytes,
+			MaxFiles: cfg.AccessLogMaxFiles,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("access log: %w", err)
+		}
+		out, closer = f, f
+	default:
+		return nil, nil, fmt.Errorf("invalid access log %q (want off, stdout or file)", cfg.AccessLog)
+	}
+
+	return logging.New(logging.Options{Out: out, Format: format}), closer, nil
+}
+
+// accessLog writes one record per proxied HTTP request and per public TCP
+// connection. A nil *accessLog logs nothing.
+type accessLog struct {
+	logger            logging.Logger
+	trustProxyHeaders bool
+}
+
+func newAccessLog(cfg Config, logger logging.Logger) *accessLog {
+	if logger == nil {
+		return nil
+	}
+	return &accessLog{logger: logger, trustProxyHeaders: cfg.TrustProxyHeaders}
+}
+
+func (a *accessLog) logHTTP(rec *httpTrafficRecord, status int) {
+	a.logger.Info("http request",
+		logging.F("tunnel_id", rec.tunnelID),
+		logging.F("token_id", rec.tokenID),
+		logging.F("client_ip", addrString(rec.clientIP)),
+		logging.F("method", rec.method),
+		logging.F("host", rec.host),
+		logging.F("path", inspect.RedactPath(rec.path)),
+		logging.F("status", status),
+		logging.F("bytes_in", rec.bytesIn.Load()),
+		logging.F("bytes_out", rec.bytesOut),
+		logging.F("duration_ms", time.Since(rec.start).Milliseconds()),
+		logging.F("request_id", rec.requestID),
+	)
+}
+
+func (a *accessLog) logTCPOpen(c *tcpTrafficConn) {
+	a.logger.Info("tcp open",
+		logging.F("port", c.port),
+		logging.F("token_id", c.tokenID),
+		logging.F("client_ip", addrString(c.clientIP)),
+	)
+}
+
+func (a *accessLog) logTCPClose(c *tcpTrafficConn) {
+	a.logger.Info("tcp close",
+		logging.F("port", c.port),
+		logging.F("token_id", c.tokenID),
+		logging.F("client_ip", addrString(c.clientIP)),
+		logging.F("bytes_in", c.bytesIn.Load()),
+		logging.F("bytes_out", c.bytesOut.Load()),
+		logging.F("duration_ms", time.Since(c.start).Milliseconds()),
+	)
+}
+
+func addrString(ip netip.Addr) string {
+	if !ip.IsValid() {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"eosrift.com/eosrift/internal/logging"
+)
+
+func decodeAccessRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var out []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var m map[string]any
+		if err := dec.Decode(&m); err != nil {
+			t.Fatalf("decode access record: %v", err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// drainingUpstreamSession reads each request body before answering, so the
+// edge has sent all of it by the time the response arrives.
+type drainingUpstreamSession struct {
+	body string
+}
+
+func (s drainingUpstreamSession) OpenStream() (net.Conn, error) {
+	a, b := net.Pipe()
+	go func() {
+		defer b.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(b))
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, req.Body)
+		_, _ = io.WriteString(b, rawResponse("", s.body))
+	}()
+	return a, nil
+}
+
+func (drainingUpstreamSession) Close() error { return nil }
+
+func TestAccessLog_HTTPRequest(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", drainingUpstreamSession{body: "hello world"}, httpTunnelOptions{TokenID: 7}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	var buf bytes.Buffer
+	cfg := Config{TunnelDomain: "tunnel.eosrift.test"}
+	access := newAccessLog(cfg, logging.New(logging.Options{Out: &buf, Format: logging.FormatJSON}))
+	h := httpTunnelProxyHandler(cfg, registry, nil, access, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "http://abcd1234.tunnel.eosrift.test/hook?token=abc123&x=1", strings.NewReader("ping"))
+	req.RemoteAddr = "203.0.113.9:5555"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	unknown := httptest.NewRequest(http.MethodGet, "http://nope.tunnel.eosrift.test/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), unknown)
+
+	records := decodeAccessRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("records = %d, want 2", len(records))
+	}
+
+	got := records[0]
+	for k, want := range map[string]any{
+		"msg":       "http request",
+		"tunnel_id": "abcd1234",
+		"token_id":  float64(7),
+		"client_ip": "203.0.113.9",
+		"method":    http.MethodPost,
+		"host":      "abcd1234.tunnel.eosrift.test",
+		"path":      "/hook?token=REDACTED&x=1",
+		"status":    float64(http.StatusOK),
+		"bytes_in":  float64(len("ping")),
+		"bytes_out": float64(len("hello world")),
+	} {
+		if got[k] != want {
+			t.Errorf("%s = %v, want %v", k, got[k], want)
+		}
+	}
+	if id, _ := got["request_id"].(string); len(id) != 32 {
+		t.Errorf("request_id = %q, want a 32 char id", id)
+	}
+	if _, ok := got["duration_ms"]; !ok {
+		t.Errorf("missing duration_ms")
+	}
+
+	if got := records[1]; got["tunnel_id"] != "" || got["status"] != float64(http.StatusNotFound) {
+		t.Fatalf("unknown tunnel record = %v", got)
+	}
+}
+
+func TestAccessLog_RedactsShareLinkToken(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", drainingUpstreamSession{body: "hello"}, httpTunnelOptions{ShareLinks: true}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	var buf bytes.Buffer
+	cfg := Config{TunnelDomain: "tunnel.eosrift.test", ShareLinkSecret: "secret"}
+	access := newAccessLog(cfg, logging.New(logging.Options{Out: &buf, Format: logging.FormatJSON}))
+	h := httpTunnelProxyHandler(cfg, registry, nil, access, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://abcd1234.tunnel.eosrift.test/doc?"+shareLinkParam+"=s3cr3t-token&page=2", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	records := decodeAccessRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("records = %d, want 1", len(records))
+	}
+	if got, want := records[0]["path"], "/doc?"+shareLinkParam+"=REDACTED&page=2"; got != want {
+		t.Fatalf("path = %v, want %v", got, want)
+	}
+}
+
+func TestAccessLog_TCPConnection(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	access := newAccessLog(Config{}, logging.New(logging.Options{Out: &buf, Format: logging.FormatJSON}))
+
+	visitor, edge := net.Pipe()
+	conn := recordTCP(edge, 20005, 3, netip.MustParseAddr("198.51.100.4"), access, nil)
+
+	go func() {
+		_, _ = visitor.Write([]byte("hello"))
+		_, _ = io.ReadFull(visitor, make([]byte, 3))
+		_ = visitor.Close()
+	}()
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if _, err := conn.Write([]byte("bye")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.Close()
+	_ = conn.Close()
+
+	records := decodeAccessRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("records = %d, want open and close", len(records))
+	}
+	if records[0]["msg"] != "tcp open" || records[0]["port"] != float64(20005) || records[0]["client_ip"] != "198.51.100.4" {
+		t.Fatalf("open record = %v", records[0])
+	}
+	closed := records[1]
+	if closed["msg"] != "tcp close" || closed["token_id"] != float64(3) || closed["bytes_in"] != float64(5) || closed["bytes_out"] != float64(3) {
+		t.Fatalf("close record = %v", closed)
+	}
+
+	if got := recordTCP(edge, 1, 0, netip.Addr{}, nil, nil); got != edge {
+		t.Fatalf("conn wrapped without an access log or metrics")
+	}
+}
+
+func TestOpenAccessLog(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []string{"", AccessLogOff} {
+		logger, closer, err := OpenAccessLog(Config{AccessLog: mode}, io.Discard)
+		if err != nil || logger != nil || closer != nil {
+			t.Fatalf("%q: got %v, %v, %v; want all nil", mode, logger, closer, err)
+		}
+	}
+	if _, _, err := OpenAccessLog(Config{AccessLog: "syslog"}, io.Discard); err == nil {
+		t.Fatalf("expected error for an unknown mode")
+	}
+	if _, _, err := OpenAccessLog(Config{AccessLog: AccessLogStdout, AccessLogFormat: "xml"}, io.Discard); err == nil {
+		t.Fatalf("expected error for an unknown format")
+	}
+	if _, _, err := OpenAccessLog(Config{AccessLog: AccessLogFile}, io.Discard); err == nil {
+		t.Fatalf("expected error for a file without a path")
+	}
+
+	var stdout bytes.Buffer
+	logger, _, err := OpenAccessLog(Config{AccessLog: AccessLogStdout, AccessLogFormat: "text"}, &stdout)
+	if err != nil {
+		t.Fatalf("stdout: %v", err)
+	}
+	logger.Info("http request")
+	if !strings.Contains(stdout.String(), `msg="http request"`) {
+		t.Fatalf("stdout = %q, want a text record", stdout.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	logger, closer, err := OpenAccessLog(Config{AccessLog: AccessLogFile, AccessLogPath: path, AccessLogMaxBytes: 1 << 20}, io.Discard)
+	if err != nil {
+		t.Fatalf("file: %v", err)
+	}
+	logger.Info("tcp open", logging.F("port", 20005))
+	if err := closer.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !strings.Contains(string(data), `"port":20005`) {
+		t.Fatalf("file = %q, want a JSON record", data)
+	}
+}
@@ -9,11 +9,15 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"eosrift.com/eosrift/internal/control"
+	"eosrift.com/eosrift/internal/policy"
 )
 
 const maxAdminBodyBytes = 64 * 1024
 
-func serveAdminAPI(w http.ResponseWriter, r *http.Request, cfg Config, store AdminStore) {
+func serveAdminAPI(w http.ResponseWriter, r *http.Request, cfg Config, deps Dependencies, registry *TunnelRegistry, ports *tcpPortPool) {
+	store := deps.AdminStore
 	if store == nil {
 		http.NotFound(w, r)
 		return
@@ -48,6 +52,16 @@ func serveAdminAPI(w http.ResponseWriter, r *http.Request, cfg Config, store Adm
 		default:
 			methodNotAllowed(w)
 		}
+	case strings.HasPrefix(resource, "tokens/") && strings.HasSuffix(resource, "/class"):
+		id := strings.TrimSuffix(strings.TrimPrefix(resource, "tokens/"), "/class")
+		switch r.Method {
+		case http.MethodPut:
+			serveAdminSetTokenClass(w, r, deps.TokenClasses, id, true)
+		case http.MethodDelete:
+			serveAdminSetTokenClass(w, r, deps.TokenClasses, id, false)
+		default:
+			methodNotAllowed(w)
+		}
 	case strings.HasPrefix(resource, "tokens/"):
 		if r.Method != http.MethodDelete {
 			methodNotAllowed(w)
@@ -74,7 +88,7 @@ func serveAdminAPI(w http.ResponseWriter, r *http.Request, cfg Config, store Adm
 		case http.MethodGet:
 			serveAdminListTCPPorts(w, r, store)
 		case http.MethodPost:
-			serveAdminReserveTCPPort(w, r, store)
+			serveAdminReserveTCPPort(w, r, store, ports)
 		default:
 			methodNotAllowed(w)
 		}
@@ -83,7 +97,46 @@ func serveAdminAPI(w http.ResponseWriter, r *http.Request, cfg Config, store Adm
 			methodNotAllowed(w)
 			return
 		}
-		serveAdminUnreserveTCPPort(w, r, store, strings.TrimPrefix(resource, "tcp-ports/"))
+		serveAdminUnreserveTCPPort(w, r, store, ports, strings.TrimPrefix(resource, "tcp-ports/"))
+	case strings.HasPrefix(resource, "tunnels/") && strings.HasSuffix(resource, "/rate-limit"):
+		id := strings.TrimSuffix(strings.TrimPrefix(resource, "tunnels/"), "/rate-limit")
+		switch r.Method {
+		case http.MethodGet:
+			serveAdminGetTunnelRateLimit(w, registry, id)
+		case http.MethodPut:
+			serveAdminSetTunnelRateLimit(w, r, registry, id)
+		case http.MethodDelete:
+			serveAdminClearTunnelRateLimit(w, registry, id)
+		default:
+			methodNotAllowed(w)
+		}
+	case strings.HasPrefix(resource, "tunnels/") && strings.HasSuffix(resource, "/cache"):
+		id := strings.TrimSuffix(strings.TrimPrefix(resource, "tunnels/"), "/cache")
+		switch r.Method {
+		case http.MethodGet:
+			serveAdminTunnelCache(w, registry, id)
+		case http.MethodDelete:
+			serveAdminPurgeTunnelCache(w, r, registry, id)
+		default:
+			methodNotAllowed(w)
+		}
+	case resource == "browser-warning":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		serveAdminBrowserWarning(w, r, cfg, deps.BrowserWarnings)
+	case strings.HasPrefix(resource, "browser-warning/"):
+		var exempt bool
+		switch r.Method {
+		case http.MethodPut:
+			exempt = true
+		case http.MethodDelete:
+		default:
+			methodNotAllowed(w)
+			return
+		}
+		serveAdminSetBrowserWarningExempt(w, r, cfg, deps.BrowserWarnings, registry, strings.TrimPrefix(resource, "browser-warning/"), exempt)
 	default:
 		http.NotFound(w, r)
 	}
@@ -154,6 +207,7 @@ func serveAdminListTokens(w http.ResponseWriter, r *http.Request, store AdminSto
 			"label":      rec.Label,
 			"prefix":     rec.Prefix,
 			"status":     status,
+			"class":      rec.Class,
 			"created_at": rec.CreatedAt.UTC().Format(time.RFC3339),
 			"revoked_at": revokedAt,
 		})
@@ -162,6 +216,36 @@ func serveAdminListTokens(w http.ResponseWriter, r *http.Request, store AdminSto
 	writeAdminJSON(w, http.StatusOK, map[string]any{"tokens": items})
 }
 
+// serveAdminSetTokenClass handles PUT (body {"class": "..."}) and DELETE of
+// tokens/<id>/class. Classes select per-class limits such as
+// EOSRIFT_MAX_TUNNEL_LIFETIME_BY_CLASS for tunnels created afterwards.
+func serveAdminSetTokenClass(w http.ResponseWriter, r *http.Request, store TokenClassStore, rawID string, set bool) {
+	if store == nil {
+		writeAdminError(w, http.StatusNotImplemented, "token classes are not supported")
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(rawID), 10, 64)
+	if err != nil || id <= 0 {
+		writeAdminError(w, http.StatusBadRequest, "invalid token id")
+		return
+	}
+
+	var req struct {
+		Class string `json:"class"`
+	}
+	if set {
+		if err := decodeAdminJSON(r, &req); err != nil || strings.TrimSpace(req.Class) == "" {
+			writeAdminError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	if err := store.SetTokenClass(r.Context(), id, req.Class); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func serveAdminCreateToken(w http.ResponseWriter, r *http.Request, store AdminStore) {
 	var req struct {
 		Label string `json:"label"`
@@ -274,7 +358,7 @@ func serveAdminListTCPPorts(w http.ResponseWriter, r *http.Request, store AdminS
 	writeAdminJSON(w, http.StatusOK, map[string]any{"ports": items})
 }
 
-func serveAdminReserveTCPPort(w http.ResponseWriter, r *http.Request, store AdminStore) {
+func serveAdminReserveTCPPort(w http.ResponseWriter, r *http.Request, store AdminStore, ports *tcpPortPool) {
 	var req struct {
 		TokenID int64 `json:"token_id"`
 		Port    int   `json:"port"`
@@ -291,10 +375,11 @@ func serveAdminReserveTCPPort(w http.ResponseWriter, r *http.Request, store Admi
 		writeAdminError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	ports.InvalidateReserved()
 	writeAdminJSON(w, http.StatusCreated, map[string]any{"port": req.Port, "token_id": req.TokenID})
 }
 
-func serveAdminUnreserveTCPPort(w http.ResponseWriter, r *http.Request, store AdminStore, raw string) {
+func serveAdminUnreserveTCPPort(w http.ResponseWriter, r *http.Request, store AdminStore, ports *tcpPortPool, raw string) {
 	port, err := strconv.Atoi(strings.TrimSpace(raw))
 	if err != nil || port <= 0 {
 		writeAdminError(w, http.StatusBadRequest, "invalid port")
@@ -304,6 +389,171 @@ func serveAdminUnreserveTCPPort(w http.ResponseWriter, r *http.Request, store Ad
 		writeAdminError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	ports.InvalidateReserved()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func serveAdminGetTunnelRateLimit(w http.ResponseWriter, registry *TunnelRegistry, id string) {
+	entry, ok := registry.GetHTTPTunnel(id)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "tunnel not found")
+		return
+	}
+
+	limit, header := "", ""
+	if l := entry.rateLimitOverride; l != nil {
+		limit, header = l.Limit(), l.KeyHeader()
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{"id": id, "limit": limit, "header": header})
+}
+
+func serveAdminSetTunnelRateLimit(w http.ResponseWriter, r *http.Request, registry *TunnelRegistry, id string) {
+	var req struct {
+		Limit  string `json:"limit"`
+		Header string `json:"header"`
+	}
+	if err := decodeAdminJSON(r, &req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	limit, header, err := control.ParseRateLimit(req.Limit, req.Header)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if limit == "" {
+		writeAdminError(w, http.StatusBadRequest, "limit is required")
+		return
+	}
+	l, err := policy.NewRateLimiter(limit, header, nil)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !registry.SetHTTPTunnelRateLimit(id, l) {
+		writeAdminError(w, http.StatusNotFound, "tunnel not found")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{"id": id, "limit": limit, "header": header})
+}
+
+func serveAdminClearTunnelRateLimit(w http.ResponseWriter, registry *TunnelRegistry, id string) {
+	if !registry.SetHTTPTunnelRateLimit(id, nil) {
+		writeAdminError(w, http.StatusNotFound, "tunnel not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func serveAdminTunnelCache(w http.ResponseWriter, registry *TunnelRegistry, id string) {
+	entry, ok := registry.GetHTTPTunnel(id)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "tunnel not found")
+		return
+	}
+	if entry.cache == nil {
+		writeAdminError(w, http.StatusNotFound, "tunnel has no edge cache")
+		return
+	}
+
+	storage := "memory"
+	if entry.cache.dir != "" {
+		storage = "disk"
+	}
+	entries, size := entry.cache.stats()
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"id":        id,
+		"storage":   storage,
+		"entries":   entries,
+		"bytes":     size,
+		"max_bytes": entry.cache.maxBytes,
+	})
+}
+
+// serveAdminPurgeTunnelCache drops a tunnel's cached responses, or only
+// those under ?path=<prefix>.
+func serveAdminPurgeTunnelCache(w http.ResponseWriter, r *http.Request, registry *TunnelRegistry, id string) {
+	entry, ok := registry.GetHTTPTunnel(id)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "tunnel not found")
+		return
+	}
+	if entry.cache == nil {
+		writeAdminError(w, http.StatusNotFound, "tunnel has no edge cache")
+		return
+	}
+
+	prefix := r.URL.Query().Get("path")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		writeAdminError(w, http.StatusBadRequest, "path must start with /")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{"id": id, "purged": entry.cache.purge(prefix)})
+}
+
+func serveAdminBrowserWarning(w http.ResponseWriter, r *http.Request, cfg Config, store BrowserWarningStore) {
+	items := make([]map[string]any, 0)
+	if store != nil {
+		records, err := store.ListBrowserWarningExemptions(r.Context())
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "failed to list exemptions")
+			return
+		}
+		for _, rec := range records {
+			item := map[string]any{"created_at": rec.CreatedAt.UTC().Format(time.RFC3339)}
+			if rec.Subdomain != "" {
+				item["subdomain"] = rec.Subdomain
+			} else {
+				item["token_id"] = rec.TokenID
+				item["token_prefix"] = rec.TokenPrefix
+			}
+			items = append(items, item)
+		}
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]any{"enabled": cfg.BrowserWarning, "exemptions": items})
+}
+
+// serveAdminSetBrowserWarningExempt handles PUT/DELETE of
+// browser-warning/tokens/<id> and browser-warning/subdomains/<name>, then
+// applies the change to live tunnels.
+func serveAdminSetBrowserWarningExempt(w http.ResponseWriter, r *http.Request, cfg Config, store BrowserWarningStore, registry *TunnelRegistry, raw string, exempt bool) {
+	if store == nil {
+		writeAdminError(w, http.StatusNotImplemented, "browser warning exemptions are not supported")
+		return
+	}
+
+	kind, value, _ := strings.Cut(raw, "/")
+	var err error
+	switch kind {
+	case "tokens":
+		id, perr := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if perr != nil || id <= 0 {
+			writeAdminError(w, http.StatusBadRequest, "invalid token id")
+			return
+		}
+		err = store.SetBrowserWarningTokenExempt(r.Context(), id, exempt)
+	case "subdomains":
+		subdomain, perr := url.PathUnescape(strings.TrimSpace(value))
+		if perr != nil || subdomain == "" {
+			writeAdminError(w, http.StatusBadRequest, "invalid subdomain")
+			return
+		}
+		err = store.SetBrowserWarningSubdomainExempt(r.Context(), subdomain, exempt)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for id, tokenID := range registry.httpTunnelTokens() {
+		registry.SetHTTPTunnelBrowserWarning(id, browserWarningFor(r.Context(), cfg, store, tokenID, id))
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// bandwidthChunk caps how many bytes a shaped stream moves per read or
+// write, so large buffers are paced smoothly instead of in one burst.
+const bandwidthChunk = 16 << 10
+
+// byteBucket is a token bucket over bytes: it holds up to burst bytes and
+// refills at rate bytes per second. Takers may overdraw it and then wait
+// until the debt has refilled, so concurrent streams queue for their share.
+type byteBucket struct {
+	mu sync.Mutex
+
+	now func() time.Time
+
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newByteBucket returns nil (no limit) when rate is zero. A zero burst is
+// one second's worth of rate.
+func newByteBucket(rate, burst int64, now func() time.Time) *byteBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &byteBucket{
+		now:    now,
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now(),
+	}
+}
+
+// take reserves n bytes and returns how long the caller must wait before
+// sending them.
+func (b *byteBucket) take(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = minFloat64(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// tunnelBandwidth paces one tunnel's streams with the tunnel's own buckets
+// and those it shares with the other tunnels of its authtoken.
+type tunnelBandwidth struct {
+	buckets [numBandwidthDirections][]*byteBucket
+	chunk   int
+	metrics *metrics
+}
+
+// resolveTunnelBandwidth combines the limits a tunnel asked for with the
+// server's: per-tunnel server rates are both the defaults and the ceilings,
+// and per-token rates always apply. It returns nil when nothing is limited;
+// release must be called once the tunnel is gone.
+func resolveTunnelBandwidth(cfg Config, pool *tokenBandwidthPool, tokenID int64, req *control.BandwidthLimits, m *metrics) (tb *tunnelBandwidth, release func(), err error) {
+	l, err := control.ValidateBandwidthLimits(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if l == nil {
+		l = &control.BandwidthLimits{}
+	}
+
+	tb = &tunnelBandwidth{chunk: bandwidthChunk, metrics: m}
+	for dir, rates := range [numBandwidthDirections][2]int64{
+		bandwidthIn:  {l.InBytesPerSecond, cfg.TunnelBandwidthIn},
+		bandwidthOut: {l.OutBytesPerSecond, cfg.TunnelBandwidthOut},
+	} {
+		burst := bandwidthBurst(l.BurstBytes, cfg.BandwidthBurst, rates[1])
+		if b := newByteBucket(lowerLimit(rates[0], rates[1]), burst, time.Now); b != nil {
+			tb.add(bandwidthDirection(dir), b)
+		}
+	}
+
+	release = func() {}
+	if tokenID > 0 && pool != nil && (cfg.TokenBandwidthIn > 0 || cfg.TokenBandwidthOut > 0) {
+		var shared [numBandwidthDirections]*byteBucket
+		shared, release = pool.acquire(tokenID, cfg)
+		for dir, b := range shared {
+			if b != nil {
+				tb.add(bandwidthDirection(dir), b)
+			}
+		}
+	}
+
+	if len(tb.buckets[bandwidthIn]) == 0 && len(tb.buckets[bandwidthOut]) == 0 {
+		return nil, release, nil
+	}
+	return tb, release, nil
+}
+
+// bandwidthBurst is a tunnel's burst: what it asked for, capped at the
+// server's burst or, without one, at one second of the server's rate. Zero
+// leaves the default of one second of the tunnel's rate.
+func bandwidthBurst(tunnel, server, serverRate int64) int64 {
+	if tunnel <= 0 {
+		return server
+	}
+	if server <= 0 {
+		server = serverRate
+	}
+	return lowerLimit(tunnel, server)
+}
+
+func (tb *tunnelBandwidth) add(dir bandwidthDirection, b *byteBucket) {
+	tb.buckets[dir] = append(tb.buckets[dir], b)
+	if burst := int(b.burst); burst < tb.chunk {
+		tb.chunk = max(burst, 1)
+	}
+}
+
+// reserve takes n bytes from every bucket of dir and returns the longest
+// wait among them.
+func (tb *tunnelBandwidth) reserve(dir bandwidthDirection, n int) time.Duration {
+	var wait time.Duration
+	for _, b := range tb.buckets[dir] {
+		wait = max(wait, b.take(n))
+	}
+	return wait
+}
+
+// wrap paces the streams opened on s; it returns s when tb is nil.
+func (tb *tunnelBandwidth) wrap(s streamSession) streamSession {
+	if tb == nil {
+		return s
+	}
+	return &shapedSession{streamSession: s, bandwidth: tb}
+}
+
+type shapedSession struct {
+	streamSession
+	bandwidth *tunnelBandwidth
+}
+
+func (s *shapedSession) OpenStream() (net.Conn, error) {
+	conn, err := s.streamSession.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return &shapedConn{Conn: conn, bandwidth: s.bandwidth, closed: make(chan struct{})}, nil
+}
+
+// shapedConn is a stream to the agent: writes carry visitor traffic in,
+// reads carry upstream traffic out.
+type shapedConn struct {
+	net.Conn
+	bandwidth *tunnelBandwidth
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *shapedConn) Read(p []byte) (int, error) {
+	if len(p) > c.bandwidth.chunk {
+		p = p[:c.bandwidth.chunk]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.pace(bandwidthOut, n)
+	}
+	return n, err
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.bandwidth.chunk)]
+		c.pace(bandwidthIn, len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// pace waits until n bytes in dir fit the buckets, or the stream closes.
+func (c *shapedConn) pace(dir bandwidthDirection, n int) {
+	wait := c.bandwidth.reserve(dir, n)
+	if wait <= 0 {
+		return
+	}
+	c.bandwidth.metrics.countThrottled(dir, n)
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-c.closed:
+	}
+}
+
+func (c *shapedConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// tokenBandwidthPool holds the buckets shared by the tunnels of each
+// authtoken while at least one of them is connected.
+type tokenBandwidthPool struct {
+	mu      sync.Mutex
+	entries map[int64]*tokenBandwidthEntry
+}
+
+type tokenBandwidthEntry struct {
+	refs    int
+	buckets [numBandwidthDirections]*byteBucket
+}
+
+func newTokenBandwidthPool() *tokenBandwidthPool {
+	return &tokenBandwidthPool{entries: make(map[int64]*tokenBandwidthEntry)}
+}
+
+// acquire returns the shared buckets of tokenID (nil for an unlimited
+// direction) and a func that drops this tunnel's reference to them.
+func (p *tokenBandwidthPool) acquire(tokenID int64, cfg Config) ([numBandwidthDirections]*byteBucket, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.entries[tokenID]
+	if e == nil {
+		e = &tokenBandwidthEntry{}
+		e.buckets[bandwidthIn] = newByteBucket(cfg.TokenBandwidthIn, cfg.BandwidthBurst, time.Now)
+		e.buckets[bandwidthOut] = newByteBucket(cfg.TokenBandwidthOut, cfg.BandwidthBurst, time.Now)
+		p.entries[tokenID] = e
+	}
+	e.refs++
+
+	var once sync.Once
+	return e.buckets, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			e.refs--
+			if e.refs == 0 {
+				delete(p.entries, tokenID)
+			}
+		})
+	}
+}
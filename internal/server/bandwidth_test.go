@@ -0,0 +1,177 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+func TestByteBucket_Take(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	b := newByteBucket(1000, 500, func() time.Time { return now })
+
+	if d := b.take(500); d != 0 {
+		t.Fatalf("burst take wait = %v, want 0", d)
+	}
+	if d := b.take(250); d != 250*time.Millisecond {
+		t.Fatalf("overdraw wait = %v, want 250ms", d)
+	}
+
+	now = now.Add(time.Second)
+	// 750ms of the second paid off the debt; the rest refilled 500 bytes
+	// (capped at the burst).
+	if d := b.take(500); d != 0 {
+		t.Fatalf("after refill wait = %v, want 0", d)
+	}
+
+	if newByteBucket(0, 100, nil) != nil {
+		t.Fatalf("zero rate bucket is not nil")
+	}
+	if got := newByteBucket(2048, 0, nil).burst; got != 2048 {
+		t.Fatalf("default burst = %v, want 2048", got)
+	}
+}
+
+func TestResolveTunnelBandwidth(t *testing.T) {
+	t.Parallel()
+
+	tb, release, err := resolveTunnelBandwidth(Config{}, newTokenBandwidthPool(), 1, nil, nil)
+	if err != nil || tb != nil {
+		t.Fatalf("no limits = %+v, %v; want nil", tb, err)
+	}
+	release()
+
+	if _, _, err := resolveTunnelBandwidth(Config{}, nil, 0, &control.BandwidthLimits{InBytesPerSecond: -1}, nil); err == nil {
+		t.Fatalf("expected error for a negative rate")
+	}
+
+	cfg := Config{TunnelBandwidthOut: 1 << 20}
+	tb, _, err = resolveTunnelBandwidth(cfg, nil, 0, &control.BandwidthLimits{
+		InBytesPerSecond:  64 << 10,
+		OutBytesPerSecond: 4 << 20,
+		BurstBytes:        8 << 20,
+	}, nil)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	in, out := tb.buckets[bandwidthIn][0], tb.buckets[bandwidthOut][0]
+	if in.rate != 64<<10 || in.burst != 8<<20 {
+		t.Fatalf("in bucket = %v/%v, want the tunnel's rate and burst", in.rate, in.burst)
+	}
+	if out.rate != 1<<20 || out.burst != 1<<20 {
+		t.Fatalf("out bucket = %v/%v, want capped at the server's rate", out.rate, out.burst)
+	}
+	if tb.chunk != bandwidthChunk {
+		t.Fatalf("chunk = %d, want %d", tb.chunk, bandwidthChunk)
+	}
+}
+
+func TestResolveTunnelBandwidth_TokenBucketsAreShared(t *testing.T) {
+	t.Parallel()
+
+	pool := newTokenBandwidthPool()
+	cfg := Config{TokenBandwidthIn: 1 << 20, BandwidthBurst: 4 << 10}
+
+	a, releaseA, err := resolveTunnelBandwidth(cfg, pool, 7, nil, nil)
+	if err != nil {
+		t.Fatalf("resolve a: %v", err)
+	}
+	b, releaseB, err := resolveTunnelBandwidth(cfg, pool, 7, nil, nil)
+	if err != nil {
+		t.Fatalf("resolve b: %v", err)
+	}
+	if len(a.buckets[bandwidthIn]) != 1 || a.buckets[bandwidthIn][0] != b.buckets[bandwidthIn][0] {
+		t.Fatalf("tunnels of one token do not share the in bucket")
+	}
+	if len(a.buckets[bandwidthOut]) != 0 {
+		t.Fatalf("unexpected out buckets: %d", len(a.buckets[bandwidthOut]))
+	}
+	if a.chunk != 4<<10 {
+		t.Fatalf("chunk = %d, want the burst", a.chunk)
+	}
+
+	releaseA()
+	releaseA()
+	if len(pool.entries) != 1 {
+		t.Fatalf("entries after one release = %d, want 1", len(pool.entries))
+	}
+	releaseB()
+	if len(pool.entries) != 0 {
+		t.Fatalf("entries after all releases = %d, want 0", len(pool.entries))
+	}
+}
+
+func TestShapedConn_PacesBothDirections(t *testing.T) {
+	t.Parallel()
+
+	m := newMetrics(time.Now)
+	tb := &tunnelBandwidth{chunk: bandwidthChunk, metrics: m}
+	tb.add(bandwidthIn, newByteBucket(64<<10, 16<<10, time.Now))
+	tb.add(bandwidthOut, newByteBucket(64<<10, 16<<10, time.Now))
+
+	agent, edge := net.Pipe()
+	defer agent.Close()
+	conn := &shapedConn{Conn: edge, bandwidth: tb, closed: make(chan struct{})}
+	defer conn.Close()
+
+	payload := make([]byte, 48<<10)
+
+	// Writes (visitor to upstream): 16KiB burst, then 32KiB at 64KiB/s.
+	go func() { _, _ = io.Copy(io.Discard, io.LimitReader(agent, int64(len(payload)))) }()
+	start := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("write took %v, want paced to ~500ms", elapsed)
+	}
+
+	// Reads (upstream to visitor).
+	go func() { _, _ = agent.Write(payload) }()
+	start = time.Now()
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("read took %v, want paced to ~500ms", elapsed)
+	}
+
+	if got := m.throttled.Value("in"); got == 0 {
+		t.Fatalf("throttled in bytes = 0, want > 0")
+	}
+	if got := m.throttled.Value("out"); got == 0 {
+		t.Fatalf("throttled out bytes = 0, want > 0")
+	}
+}
+
+func TestShapedConn_CloseInterruptsPacing(t *testing.T) {
+	t.Parallel()
+
+	tb := &tunnelBandwidth{chunk: bandwidthChunk}
+	tb.add(bandwidthIn, newByteBucket(1<<10, 1<<10, time.Now))
+
+	agent, edge := net.Pipe()
+	defer agent.Close()
+	go func() { _, _ = io.Copy(io.Discard, agent) }()
+	conn := &shapedConn{Conn: edge, bandwidth: tb, closed: make(chan struct{})}
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = conn.Write(make([]byte, 64<<10))
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	_ = conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("write still paced after close")
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"eosrift.com/eosrift/internal/basicauth"
+)
+
+// basicAuthGate checks visitor credentials for one tunnel: the legacy single
+// plaintext credential, hashed users, or both. Repeated failures from one
+// client IP are throttled.
+type basicAuthGate struct {
+	legacy   *basicAuthCredential
+	users    *basicauth.Verifier
+	failures *basicauth.FailureThrottle
+}
+
+func newBasicAuthGate(legacy *basicAuthCredential, users *basicauth.Verifier) *basicAuthGate {
+	if legacy == nil && users == nil {
+		return nil
+	}
+	return &basicAuthGate{
+		legacy:   legacy,
+		users:    users,
+		failures: basicauth.NewFailureThrottle(basicauth.DefaultMaxFailures, basicauth.DefaultFailureWindow, nil),
+	}
+}
+
+// authenticate returns the authenticated user, or writes a 401/429 response
+// and returns ok=false.
+func (g *basicAuthGate) authenticate(w http.ResponseWriter, r *http.Request, ip netip.Addr) (string, bool) {
+	if blocked, retry := g.failures.Blocked(ip); blocked {
+		secs := int((retry + time.Second - 1) / time.Second)
+		if secs < 1 {
+			secs = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		http.Error(w, "too many failed login attempts", http.StatusTooManyRequests)
+		return "", false
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if ok && g.check(user, pass) {
+		return user, true
+	}
+	if ok {
+		g.failures.Fail(ip)
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="EosRift"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return "", false
+}
+
+func (g *basicAuthGate) check(user, pass string) bool {
+	if g.legacy != nil &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(g.legacy.Username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(g.legacy.Password)) == 1 {
+		return true
+	}
+	return g.users != nil && g.users.Check(user, pass)
+}
@@ -54,7 +54,7 @@ func TestHTTPTunnel_BasicAuth(t *testing.T) {
 		t.Fatalf("register: %v", err)
 	}
 
-	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry)
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
 
 	t.Run("missing auth", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "http://example.test/hello", nil)
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"eosrift.com/eosrift/internal/basicauth"
+	"eosrift.com/eosrift/internal/control"
+)
+
+func TestHTTPTunnel_BasicAuthUsers(t *testing.T) {
+	t.Parallel()
+
+	alice, err := basicauth.ParseCredential("alice:wonderland")
+	if err != nil {
+		t.Fatalf("credential: %v", err)
+	}
+	bob, err := basicauth.ParseCredential("bob:builder")
+	if err != nil {
+		t.Fatalf("credential: %v", err)
+	}
+
+	registry := NewTunnelRegistry()
+	sess := &recordingSession{authCh: make(chan string, 1)}
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{
+		BasicAuthUsers:   []control.BasicAuthUser{alice, bob},
+		RequestHeaderAdd: []headerKV{{Name: "X-User", Value: "${basic_auth_user}"}},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	do := func(user, pass, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		req.RemoteAddr = remote
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	for _, c := range [][2]string{{"alice", "wonderland"}, {"bob", "builder"}} {
+		if rr := do(c[0], c[1], "198.51.100.1:1234"); rr.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", c[0], rr.Code)
+		}
+		if got := <-sess.authCh; got != "" {
+			t.Fatalf("Authorization forwarded upstream: %q", got)
+		}
+	}
+
+	if rr := do("alice", "builder", "198.51.100.1:1234"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status = %d, want 401", rr.Code)
+	}
+}
+
+func TestHTTPTunnel_BasicAuthFailureThrottle(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	sess := &recordingSession{}
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{
+		BasicAuth: &basicAuthCredential{Username: "user", Password: "pass"},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	do := func(pass, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		req.RemoteAddr = remote
+		req.SetBasicAuth("user", pass)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	for i := 0; i < basicauth.DefaultMaxFailures; i++ {
+		if rr := do("nope", "198.51.100.7:1234"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want 401", i, rr.Code)
+		}
+	}
+
+	rr := do("pass", "198.51.100.7:1234")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("missing Retry-After")
+	}
+	if sess.openCount.Load() != 0 {
+		t.Fatalf("open count = %d, want 0", sess.openCount.Load())
+	}
+
+	if rr := do("pass", "198.51.100.8:1234"); rr.Code != http.StatusOK {
+		t.Fatalf("other IP: status = %d, want 200", rr.Code)
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// browserWarningSkipHeader lets API clients and scripts opt out of the
+	// interstitial (any value).
+	browserWarningSkipHeader = "Eosrift-Skip-Browser-Warning"
+
+	// browserWarningCookie records that a visitor has seen the warning for
+	// this tunnel. browserWarningNonceCookie ties the "Visit site" link to the
+	// rendered page, so a link crafted elsewhere cannot skip the warning.
+	browserWarningCookie      = "eosrift_bw"
+	browserWarningNonceCookie = "eosrift_bw_nonce"
+	browserWarningParam       = "eosrift_bw"
+
+	browserWarningTTL = 7 * 24 * time.Hour
+)
+
+// browserWarningFor reports whether the interstitial applies to a new tunnel
+// of tokenID at id. Lookup errors keep the warning on.
+func browserWarningFor(ctx context.Context, cfg Config, store BrowserWarningStore, tokenID int64, id string) bool {
+	if !cfg.BrowserWarning {
+		return false
+	}
+	if store == nil {
+		return true
+	}
+	exempt, err := store.BrowserWarningExempt(ctx, tokenID, id)
+	return err != nil || !exempt
+}
+
+// isBrowserNavigation is a heuristic for a person opening the URL in a
+// browser, as opposed to an API client, webhook sender or script.
+func isBrowserNavigation(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html") &&
+		strings.HasPrefix(r.Header.Get("User-Agent"), "Mozilla/")
+}
+
+func browserWarningAck(secret, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte("browser-warning:" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkBrowserWarning shows the interstitial to browser visitors that have
+// not acknowledged it for this tunnel yet. It returns true when a response
+// has been written.
+func checkBrowserWarning(w http.ResponseWriter, r *http.Request, cfg Config, id, prefix string) bool {
+	ack := browserWarningAck(cfg.ShareLinkSecret, id)
+	acked := false
+	if c, err := r.Cookie(browserWarningCookie); err == nil && hmac.Equal([]byte(c.Value), []byte(ack)) {
+		acked = true
+	}
+	if r.Header.Get(browserWarningSkipHeader) != "" {
+		r.Header.Del(browserWarningSkipHeader)
+		acked = true
+	}
+	if acked || !isBrowserNavigation(r) {
+		stripCookies(r, browserWarningCookie, browserWarningNonceCookie)
+		return false
+	}
+
+	cookiePath := "/"
+	if prefix != "" {
+		cookiePath = prefix + "/"
+	}
+
+	q := r.URL.Query()
+	if nonce := q.Get(browserWarningParam); nonce != "" {
+		if c, err := r.Cookie(browserWarningNonceCookie); err == nil && hmac.Equal([]byte(c.Value), []byte(nonce)) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     browserWarningCookie,
+				Value:    ack,
+				Path:     cookiePath,
+				MaxAge:   int(browserWarningTTL / time.Second),
+				Secure:   true,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.SetCookie(w, &http.Cookie{Name: browserWarningNonceCookie, Path: cookiePath, MaxAge: -1})
+
+			q.Del(browserWarningParam)
+			loc := prefix + r.URL.EscapedPath()
+			if enc := q.Encode(); enc != "" {
+				loc += "?" + enc
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, loc, http.StatusFound)
+			return true
+		}
+		q.Del(browserWarningParam)
+	}
+
+	nonce := newRequestID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     browserWarningNonceCookie,
+		Value:    nonce,
+		Path:     cookiePath,
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	q.Set(browserWarningParam, nonce)
+	visit := prefix + r.URL.EscapedPath() + "?" + q.Encode()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return true
+	}
+	_ = browserWarningPage.Execute(w, struct {
+		Host  string
+		Visit string
+	}{Host: r.Host, Visit: visit})
+	return true
+}
+
+// stripCookies removes the named (edge) cookies from the request sent
+// upstream. The Cookie header is left untouched when none is present.
+func stripCookies(r *http.Request, names ...string) {
+	cookies := r.Cookies()
+	kept := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		if !slices.Contains(names, c.Name) {
+			kept = append(kept, c.Name+"="+c.Value)
+		}
+	}
+	if len(kept) == len(cookies) {
+		return
+	}
+	r.Header.Del("Cookie")
+	if len(kept) > 0 {
+		r.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+var browserWarningPage = template.Must(template.New("browser-warning").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<meta name="robots" content="noindex">
+<title>You are about to visit {{.Host}}</title>
+<style>
+body{margin:0;font-family:system-ui,-apple-system,sans-serif;background:#0b0f17;color:#e6e9ef;display:flex;min-height:100vh;align-items:center;justify-content:center}
+main{max-width:34rem;padding:2rem}
+h1{font-size:1.4rem;margin:0 0 1rem}
+code{background:#1a2130;padding:.1rem .35rem;border-radius:4px;word-break:break-all}
+p{line-height:1.5;color:#b8bfcc}
+a.button{display:inline-block;margin-top:1rem;padding:.6rem 1.2rem;border-radius:6px;background:#3b82f6;color:#fff;text-decoration:none;font-weight:600}
+small{display:block;margin-top:2rem;color:#7d8594}
+</style>
+</head>
+<body>
+<main>
+<h1>You are about to visit <code>{{.Host}}</code></h1>
+<p>This site is served through an Eosrift tunnel from someone's own computer. It is not operated by the owner of this Eosrift server.</p>
+<p>Only continue if you trust whoever sent you this link. Never enter passwords, payment details or other sensitive information unless you are sure who runs this site.</p>
+<a class="button" href="{{.Visit}}">Visit site</a>
+<small>Developers: send an <code>eosrift-skip-browser-warning</code> request header, or use a non-browser User-Agent, to skip this page.</small>
+</main>
+</body>
+</html>
+`))
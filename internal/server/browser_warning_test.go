@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"eosrift.com/eosrift/internal/auth"
+)
+
+const testBrowserUA = "Mozilla/5.0 (X11; Linux x86_64) Gecko/20100101 Firefox/130.0"
+
+func TestIsBrowserNavigation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		method string
+		accept string
+		ua     string
+		want   bool
+	}{
+		{name: "browser", method: http.MethodGet, accept: "text/html,application/xhtml+xml,*/*;q=0.8", ua: testBrowserUA, want: true},
+		{name: "head", method: http.MethodHead, accept: "text/html", ua: testBrowserUA, want: true},
+		{name: "post", method: http.MethodPost, accept: "text/html", ua: testBrowserUA},
+		{name: "fetch json", method: http.MethodGet, accept: "application/json", ua: testBrowserUA},
+		{name: "curl", method: http.MethodGet, accept: "*/*", ua: "curl/8.5.0"},
+		{name: "no user agent", method: http.MethodGet, accept: "text/html"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://example.test/", nil)
+		req.Header.Set("Accept", tt.accept)
+		req.Header.Set("User-Agent", tt.ua)
+		if got := isBrowserNavigation(req); got != tt.want {
+			t.Errorf("%s: isBrowserNavigation = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPTunnel_BrowserWarning(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{TunnelDomain: "tunnel.eosrift.test", ShareLinkSecret: "k", BrowserWarning: true}
+
+	registry := NewTunnelRegistry()
+	sess := &cookieSession{cookies: make(chan string, 4)}
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{BrowserWarning: true}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(cfg, registry, nil, nil, nil)
+
+	do := func(target, ua string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("User-Agent", ua)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		req.AddCookie(&http.Cookie{Name: "app", Value: "1"})
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	if rr := do("http://example.test/", "curl/8.5.0"); rr.Code != http.StatusOK {
+		t.Fatalf("api client: status = %d, want 200", rr.Code)
+	}
+	<-sess.cookies
+
+	rr := do("http://example.test/docs?x=1", testBrowserUA)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "You are about to visit") {
+		t.Fatalf("browser: status = %d, body = %q; want interstitial", rr.Code, rr.Body.String())
+	}
+	if sess.openCount.Load() != 1 {
+		t.Fatalf("interstitial reached upstream")
+	}
+	var nonce *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == browserWarningNonceCookie {
+			nonce = c
+		}
+	}
+	if nonce == nil || nonce.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("nonce cookie = %+v, want SameSite=Strict", nonce)
+	}
+
+	visit := "/docs?" + url.Values{"x": {"1"}, browserWarningParam: {nonce.Value}}.Encode()
+	if !strings.Contains(rr.Body.String(), `href="`+strings.ReplaceAll(visit, "&", "&amp;")+`"`) {
+		t.Fatalf("interstitial missing visit link %q: %q", visit, rr.Body.String())
+	}
+
+	// A crafted link without the nonce cookie shows the page again.
+	if rr := do("http://example.test"+visit, testBrowserUA); !strings.Contains(rr.Body.String(), "You are about to visit") {
+		t.Fatalf("crafted link: status = %d, want interstitial", rr.Code)
+	}
+
+	rr = do("http://example.test"+visit, testBrowserUA, nonce)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("visit: status = %d, want 302", rr.Code)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/docs?x=1" {
+		t.Fatalf("Location = %q, want %q", loc, "/docs?x=1")
+	}
+	var ack *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == browserWarningCookie {
+			ack = c
+		}
+	}
+	if ack == nil || !ack.HttpOnly {
+		t.Fatalf("ack cookie = %+v", ack)
+	}
+
+	if rr := do("http://example.test/docs", testBrowserUA, ack); rr.Code != http.StatusOK {
+		t.Fatalf("acknowledged: status = %d, want 200", rr.Code)
+	}
+	if got := <-sess.cookies; got != "app=1" {
+		t.Fatalf("upstream Cookie = %q, want edge cookies stripped", got)
+	}
+
+	// The acknowledgement is bound to the tunnel.
+	if err := registry.RegisterHTTPTunnel("efgh5678", sess, httpTunnelOptions{BrowserWarning: true}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Host = "efgh5678.tunnel.eosrift.test"
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", testBrowserUA)
+	req.AddCookie(ack)
+	other := httptest.NewRecorder()
+	h(other, req)
+	if !strings.Contains(other.Body.String(), "You are about to visit") {
+		t.Fatalf("other tunnel: status = %d, want interstitial", other.Code)
+	}
+}
+
+func TestHTTPTunnel_BrowserWarningSkipHeader(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{TunnelDomain: "tunnel.eosrift.test", ShareLinkSecret: "k", BrowserWarning: true}
+
+	registry := NewTunnelRegistry()
+	sess := &headerSession{gotCh: make(chan http.Header, 1)}
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{BrowserWarning: true}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(cfg, registry, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", testBrowserUA)
+	req.Header.Set("eosrift-skip-browser-warning", "1")
+	rr := httptest.NewRecorder()
+	h(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	if got := (<-sess.gotCh).Get(browserWarningSkipHeader); got != "" {
+		t.Fatalf("upstream skip header = %q, want stripped", got)
+	}
+}
+
+type stubBrowserWarningStore struct {
+	mu         sync.Mutex
+	tokens     map[int64]bool
+	subdomains map[string]bool
+}
+
+func (s *stubBrowserWarningStore) SetBrowserWarningTokenExempt(_ context.Context, tokenID int64, exempt bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tokenID != 1 {
+		return errors.New("unknown token id")
+	}
+	s.tokens[tokenID] = exempt
+	return nil
+}
+
+func (s *stubBrowserWarningStore) SetBrowserWarningSubdomainExempt(_ context.Context, subdomain string, exempt bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subdomains[subdomain] = exempt
+	return nil
+}
+
+func (s *stubBrowserWarningStore) ListBrowserWarningExemptions(context.Context) ([]auth.BrowserWarningExemption, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []auth.BrowserWarningExemption
+	for id, ok := range s.tokens {
+		if ok {
+			out = append(out, auth.BrowserWarningExemption{TokenID: id, TokenPrefix: "eos_abc"})
+		}
+	}
+	for sub, ok := range s.subdomains {
+		if ok {
+			out = append(out, auth.BrowserWarningExemption{Subdomain: sub})
+		}
+	}
+	return out, nil
+}
+
+func (s *stubBrowserWarningStore) BrowserWarningExempt(_ context.Context, tokenID int64, subdomain string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[tokenID] || s.subdomains[subdomain], nil
+}
+
+func TestNewHandler_AdminAPI_BrowserWarning(t *testing.T) {
+	t.Parallel()
+
+	store := &stubBrowserWarningStore{tokens: map[int64]bool{}, subdomains: map[string]bool{}}
+	cfg := Config{
+		BaseDomain:     "eosrift.com",
+		TunnelDomain:   "tunnel.eosrift.com",
+		AdminToken:     "admin-secret",
+		BrowserWarning: true,
+	}
+	srv := New(cfg, Dependencies{AdminStore: newStubAdminStore(), BrowserWarnings: store})
+	if err := srv.registry.RegisterHTTPTunnel("demo", &headerSession{gotCh: make(chan http.Header, 1)}, httpTunnelOptions{TokenID: 1, BrowserWarning: true}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := srv.Handler()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://eosrift.com"+path, nil)
+		req.Host = "eosrift.com"
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	warning := func() bool {
+		entry, ok := srv.registry.GetHTTPTunnel("demo")
+		return ok && entry.browserWarning
+	}
+
+	if rr := do(http.MethodPut, "/api/admin/browser-warning/tokens/1"); rr.Code != http.StatusNoContent {
+		t.Fatalf("exempt token: status = %d (body=%q)", rr.Code, rr.Body.String())
+	}
+	if warning() {
+		t.Fatalf("live tunnel still shows the warning after exempting its token")
+	}
+
+	rr := do(http.MethodGet, "/api/admin/browser-warning")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"enabled":true`) || !strings.Contains(rr.Body.String(), `"token_id":1`) {
+		t.Fatalf("list: status = %d, body = %q", rr.Code, rr.Body.String())
+	}
+
+	if rr := do(http.MethodDelete, "/api/admin/browser-warning/tokens/1"); rr.Code != http.StatusNoContent {
+		t.Fatalf("unexempt token: status = %d", rr.Code)
+	}
+	if !warning() {
+		t.Fatalf("live tunnel lost the warning after removing the exemption")
+	}
+
+	if rr := do(http.MethodPut, "/api/admin/browser-warning/subdomains/demo"); rr.Code != http.StatusNoContent {
+		t.Fatalf("exempt subdomain: status = %d", rr.Code)
+	}
+	if warning() {
+		t.Fatalf("live tunnel still shows the warning after exempting its subdomain")
+	}
+
+	if rr := do(http.MethodPut, "/api/admin/browser-warning/tokens/9"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown token: status = %d, want 400", rr.Code)
+	}
+	if rr := do(http.MethodPut, "/api/admin/browser-warning/tokens/x"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad token id: status = %d, want 400", rr.Code)
+	}
+}
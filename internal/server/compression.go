@@ -0,0 +1,315 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"eosrift.com/eosrift/internal/control"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// brotliLevel trades some ratio for speed; brotli's default (6) is too slow
+// for on-the-fly compression of large responses.
+const brotliLevel = 4
+
+// compressionPolicy is a compiled control.CompressionPolicy.
+type compressionPolicy struct {
+	encodings []string
+	types     []string
+	minSize   int64
+}
+
+// newCompressionPolicy compiles a validated policy; nil when p is nil.
+func newCompressionPolicy(p *control.CompressionPolicy) *compressionPolicy {
+	if p == nil {
+		return nil
+	}
+	return &compressionPolicy{encodings: p.Encodings, types: p.ContentTypes, minSize: p.MinSize}
+}
+
+// wrap returns a writer that compresses w's response when r accepts one of
+// the policy's encodings, or nil. Callers must close it once the response
+// is complete.
+func (c *compressionPolicy) wrap(w http.ResponseWriter, r *http.Request) *compressWriter {
+	if c == nil || r.Method == http.MethodHead || isUpgradeRequest(r) {
+		return nil
+	}
+	enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), c.encodings)
+	if enc == "" {
+		return nil
+	}
+	return &compressWriter{ResponseWriter: w, policy: c, encoding: enc}
+}
+
+// compressible reports whether a response with these headers may be
+// compressed: successful, not already encoded, not a range or event stream,
+// and of an allowlisted type.
+func (c *compressionPolicy) compressible(code int, h http.Header) bool {
+	switch {
+	case code < http.StatusOK, code == http.StatusNoContent, code == http.StatusPartialContent, code == http.StatusNotModified:
+		return false
+	case h.Get("Content-Encoding") != "", h.Get("Content-Range") != "":
+		return false
+	case strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-transform"):
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	typ, sub, _ := strings.Cut(mediaType, "/")
+	for _, pattern := range c.types {
+		ptyp, psub, _ := strings.Cut(pattern, "/")
+		if ptyp != typ {
+			continue
+		}
+		if psub == "*" || psub == sub {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(psub, "*"); ok && strings.HasSuffix(sub, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks the offered encoding with the highest q-value in
+// an Accept-Encoding header, preferring earlier offers on ties.
+func negotiateEncoding(accept string, offers []string) string {
+	if accept == "" {
+		return ""
+	}
+
+	q := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				continue
+			}
+			weight = f
+		}
+		q[coding] = weight
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range offers {
+		w, ok := q[enc]
+		if !ok {
+			w = q["*"]
+		}
+		if w > bestQ {
+			best, bestQ = enc, w
+		}
+	}
+	return best
+}
+
+type compressState int
+
+const (
+	compressPending   compressState = iota // no status written yet
+	compressBuffering                      // compressible, size not yet known
+	compressPassthrough
+	compressActive
+)
+
+// compressWriter compresses a response once it is known to be compressible
+// and at least minSize bytes long. Bodies without a Content-Length are
+// buffered up to minSize. ReverseProxy flushes such bodies after every
+// write, so a flush with data pending starts compressing right away rather
+// than holding a stream back; a flush with nothing buffered waits for data.
+type compressWriter struct {
+	http.ResponseWriter
+	policy   *compressionPolicy
+	encoding string
+
+	state  compressState
+	status int
+	buf    []byte
+	enc    encoder
+}
+
+// encoder is the part of gzip.Writer, brotli.Writer and zstd.Encoder the
+// edge uses.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.state != compressPending {
+		return
+	}
+	if code < http.StatusOK {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	h := w.Header()
+	if !w.policy.compressible(code, h) {
+		w.state = compressPassthrough
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n < w.policy.minSize {
+			w.state = compressPassthrough
+			w.ResponseWriter.WriteHeader(code)
+			return
+		}
+		w.start(code)
+		return
+	}
+	w.status = code
+	w.state = compressBuffering
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.state == compressPending {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch w.state {
+	case compressBuffering:
+		w.buf = append(w.buf, p...)
+		if int64(len(w.buf)) >= w.policy.minSize {
+			if err := w.commit(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case compressActive:
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// start switches the response to the negotiated encoding and writes the
+// status line.
+func (w *compressWriter) start(code int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	h.Set("Content-Encoding", w.encoding)
+	if !headerHasToken(h, "Vary", "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	// The encoded body differs byte-for-byte from the upstream's.
+	if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("Etag", "W/"+etag)
+	}
+
+	w.state = compressActive
+	w.enc = getEncoder(w.encoding, w.ResponseWriter)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// commit ends buffering, compressed or not, and writes what was buffered.
+func (w *compressWriter) commit(compress bool) error {
+	if compress {
+		w.start(w.status)
+	} else {
+		w.state = compressPassthrough
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func (w *compressWriter) FlushError() error {
+	switch w.state {
+	case compressBuffering:
+		if len(w.buf) == 0 {
+			return nil
+		}
+		if err := w.commit(true); err != nil {
+			return err
+		}
+	case compressActive:
+		if err := w.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer for
+// anything but flushing.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close writes out a body still being buffered and finishes the encoded
+// stream.
+func (w *compressWriter) close() {
+	switch w.state {
+	case compressBuffering:
+		_ = w.commit(false)
+	case compressActive:
+		_ = w.enc.Close()
+		putEncoder(w.encoding, w.enc)
+		w.enc = nil
+	}
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t == "*" || strings.EqualFold(t, token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, brotliLevel)
+	}},
+	"zstd": {New: func() any {
+		// Browsers reject zstd windows larger than 8 MiB.
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(8<<20))
+		if err != nil {
+			panic(err)
+		}
+		return enc
+	}},
+}
+
+func getEncoder(encoding string, w io.Writer) encoder {
+	enc := encoderPools[encoding].Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+func putEncoder(encoding string, enc encoder) {
+	enc.Reset(io.Discard)
+	encoderPools[encoding].Put(enc)
+}
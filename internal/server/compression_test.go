@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"eosrift.com/eosrift/internal/control"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// rawUpstreamSession answers every request with a fixed raw HTTP response.
+type rawUpstreamSession struct {
+	response string
+}
+
+func (s rawUpstreamSession) OpenStream() (net.Conn, error) {
+	a, b := net.Pipe()
+	go func() {
+		defer b.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(b))
+		if err != nil {
+			return
+		}
+		_ = req.Body.Close()
+		_, _ = io.WriteString(b, s.response)
+	}()
+	return a, nil
+}
+
+func (rawUpstreamSession) Close() error { return nil }
+
+func rawResponse(header, body string) string {
+	return fmt.Sprintf("HTTP/1.1 200 OK\r\n%sContent-Length: %d\r\nConnection: close\r\n\r\n%s", header, len(body), body)
+}
+
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+
+	var r io.Reader
+	switch encoding {
+	case "":
+		return string(body)
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("gzip: %v", err)
+		}
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("zstd: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		t.Fatalf("unexpected encoding %q", encoding)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decode %s: %v", encoding, err)
+	}
+	return string(b)
+}
+
+func TestHTTPTunnel_Compression(t *testing.T) {
+	t.Parallel()
+
+	large := `{"items":"` + strings.Repeat("eosrift ", 400) + `"}`
+	chunked := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n" +
+		fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(large), large)
+
+	tests := []struct {
+		name     string
+		response string
+		accept   string
+		wantEnc  string
+	}{
+		{name: "preferred encoding", response: rawResponse("Content-Type: application/json\r\nEtag: \"v1\"\r\n", large), accept: "gzip, br", wantEnc: "br"},
+		{name: "zstd", response: rawResponse("Content-Type: application/json\r\n", large), accept: "zstd", wantEnc: "zstd"},
+		{name: "q-values", response: rawResponse("Content-Type: application/json\r\n", large), accept: "gzip;q=1, br;q=0.5, zstd;q=0", wantEnc: "gzip"},
+		{name: "chunked", response: chunked, accept: "gzip", wantEnc: "gzip"},
+		{name: "not accepted", response: rawResponse("Content-Type: application/json\r\n", large), accept: "identity"},
+		{name: "small", response: rawResponse("Content-Type: application/json\r\n", `{"ok":true}`), accept: "gzip"},
+		{name: "type not listed", response: rawResponse("Content-Type: image/png\r\n", large), accept: "gzip"},
+		{name: "event stream", response: rawResponse("Content-Type: text/event-stream\r\n", large), accept: "gzip"},
+		{name: "no-transform", response: rawResponse("Content-Type: text/plain\r\nCache-Control: no-transform\r\n", large), accept: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			compression, err := control.ValidateCompressionPolicy(&control.CompressionPolicy{})
+			if err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			registry := NewTunnelRegistry()
+			if err := registry.RegisterHTTPTunnel("abcd1234", rawUpstreamSession{response: tt.response}, httpTunnelOptions{
+				Compression: compression,
+			}); err != nil {
+				t.Fatalf("register: %v", err)
+			}
+			h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+			req.Host = "abcd1234.tunnel.eosrift.test"
+			req.Header.Set("Accept-Encoding", tt.accept)
+			rr := httptest.NewRecorder()
+			h(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rr.Code)
+			}
+			enc := rr.Header().Get("Content-Encoding")
+			if enc != tt.wantEnc {
+				t.Fatalf("Content-Encoding = %q, want %q", enc, tt.wantEnc)
+			}
+			if enc == "" {
+				return
+			}
+			if rr.Header().Get("Content-Length") != "" || rr.Header().Get("Vary") != "Accept-Encoding" {
+				t.Fatalf("headers = %v", rr.Header())
+			}
+			if etag := rr.Header().Get("Etag"); etag != "" && etag != `W/"v1"` {
+				t.Fatalf("Etag = %q, want weak", etag)
+			}
+			if got := decodeBody(t, enc, rr.Body.Bytes()); got != large {
+				t.Fatalf("decoded body mismatch (%d bytes)", len(got))
+			}
+		})
+	}
+}
+
+func TestHTTPTunnel_CompressionUpstreamEncoded(t *testing.T) {
+	t.Parallel()
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = io.WriteString(zw, strings.Repeat("a", 4096))
+	_ = zw.Close()
+
+	compression, _ := control.ValidateCompressionPolicy(&control.CompressionPolicy{})
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", rawUpstreamSession{
+		response: rawResponse("Content-Type: text/plain\r\nContent-Encoding: gzip\r\n", gz.String()),
+	}, httpTunnelOptions{Compression: compression}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rr := httptest.NewRecorder()
+	h(rr, req)
+
+	if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want upstream's gzip", got)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), gz.Bytes()) {
+		t.Fatalf("upstream-encoded body was modified")
+	}
+}
+
+func TestCompressionPolicy_SkipsUpgrades(t *testing.T) {
+	t.Parallel()
+
+	c := newCompressionPolicy(&control.CompressionPolicy{Encodings: []string{"gzip"}})
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if c.wrap(httptest.NewRecorder(), req) != nil {
+		t.Fatalf("upgrade request wrapped")
+	}
+}
@@ -12,9 +12,12 @@ import (
 	"strings"
 	"time"
 
+	"eosrift.com/eosrift/internal/basicauth"
 	"eosrift.com/eosrift/internal/control"
+	"eosrift.com/eosrift/internal/jwtauth"
 	"eosrift.com/eosrift/internal/logging"
 	"eosrift.com/eosrift/internal/mux"
+	"eosrift.com/eosrift/internal/policy"
 	"github.com/hashicorp/yamux"
 	"nhooyr.io/websocket"
 )
@@ -50,6 +53,21 @@ type baseRequest struct {
 	Domain     string `json:"domain,omitempty"`
 	BasicAuth  string `json:"basic_auth,omitempty"`
 
+	BasicAuthUsers []control.BasicAuthUser    `json:"basic_auth_users,omitempty"`
+	ShareLinks     bool                       `json:"share_links,omitempty"`
+	JWT            *control.JWTAuth           `json:"jwt,omitempty"`
+	CORS           *control.CORSPolicy        `json:"cors,omitempty"`
+	Compression    *control.CompressionPolicy `json:"compression,omitempty"`
+	Cache          *control.CachePolicy       `json:"cache,omitempty"`
+	Limits         *control.HTTPLimits        `json:"limits,omitempty"`
+	Mirror         *control.MirrorPolicy      `json:"mirror,omitempty"`
+	Bandwidth      *control.BandwidthLimits   `json:"bandwidth,omitempty"`
+	ExpiresAt      int64                      `json:"expires_at,omitempty"`
+	Notices        bool                       `json:"notices,omitempty"`
+
+	Name   string `json:"name,omitempty"`
+	Secret string `json:"secret,omitempty"`
+
 	AllowMethod     []string `json:"allow_method,omitempty"`
 	AllowPath       []string `json:"allow_path,omitempty"`
 	AllowPathPrefix []string `json:"allow_path_prefix,omitempty"`
@@ -57,18 +75,27 @@ type baseRequest struct {
 	AllowCIDR []string `json:"allow_cidr,omitempty"`
 	DenyCIDR  []string `json:"deny_cidr,omitempty"`
 
+	RateLimit       string `json:"rate_limit,omitempty"`
+	RateLimitHeader string `json:"rate_limit_header,omitempty"`
+
+	Redirect []control.RedirectRule `json:"redirect,omitempty"`
+	Rewrite  []control.RewriteRule  `json:"rewrite,omitempty"`
+
 	RequestHeaderAdd     []control.HeaderKV `json:"request_header_add,omitempty"`
 	RequestHeaderRemove  []string           `json:"request_header_remove,omitempty"`
 	ResponseHeaderAdd    []control.HeaderKV `json:"response_header_add,omitempty"`
 	ResponseHeaderRemove []string           `json:"response_header_remove,omitempty"`
+
+	TrafficPolicy *control.TrafficPolicy `json:"traffic_policy,omitempty"`
 }
 
-func controlHandler(cfg Config, registry *TunnelRegistry, deps Dependencies, limiter *tokenTunnelLimiter, rateLimiter *tokenRateLimiter, metrics *metrics) http.HandlerFunc {
+func controlHandler(cfg Config, registry *TunnelRegistry, ports *tcpPortPool, deps Dependencies, limiter *tokenTunnelLimiter, rateLimiter *tokenRateLimiter, bandwidthPool *tokenBandwidthPool, metrics *metrics) http.HandlerFunc {
 	logger := deps.Logger
 	if logger == nil {
 		logger = logging.New(logging.Options{})
 	}
 	logger = logger.With(logging.F("component", "control"))
+	access := newAccessLog(cfg, deps.AccessLog)
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -130,6 +157,7 @@ func controlHandler(cfg Config, registry *TunnelRegistry, deps Dependencies, lim
 				return
 			}
 			if !ok {
+				metrics.countAuthFailure(authFailureAuthtoken, 0)
 				switch reqType {
 				case "tcp":
 					_ = writeControlTCPError(ctrlStream, "unauthorized")
@@ -144,6 +172,7 @@ func controlHandler(cfg Config, registry *TunnelRegistry, deps Dependencies, lim
 		}
 
 		if validator == nil && cfg.AuthToken != "" && strings.TrimSpace(req.Authtoken) != cfg.AuthToken {
+			metrics.countAuthFailure(authFailureAuthtoken, 0)
 			switch reqType {
 			case "tcp":
 				_ = writeControlTCPError(ctrlStream, "unauthorized")
@@ -179,6 +208,7 @@ func controlHandler(cfg Config, registry *TunnelRegistry, deps Dependencies, lim
 		if cfg.MaxTunnelsPerToken > 0 && limiter != nil && tokenID > 0 {
 			release, ok := limiter.TryAcquire(tokenID, cfg.MaxTunnelsPerToken)
 			if !ok {
+				metrics.countRateLimited(rateLimitMaxTunnels, tokenID)
 				switch reqType {
 				case "tcp":
 					_ = writeControlTCPError(ctrlStream, "too many active tunnels")
@@ -195,6 +225,7 @@ func controlHandler(cfg Config, registry *TunnelRegistry, deps Dependencies, lim
 
 		if cfg.MaxTunnelCreatesPerMinute > 0 && rateLimiter != nil && tokenID > 0 {
 			if !rateLimiter.Allow(tokenID, cfg.MaxTunnelCreatesPerMinute) {
+				metrics.countRateLimited(rateLimitTunnelCreates, tokenID)
 				switch reqType {
 				case "tcp":
 					_ = writeControlTCPError(ctrlStream, "rate limit exceeded")
@@ -208,51 +239,70 @@ func controlHandler(cfg Config, registry *TunnelRegistry, deps Dependencies, lim
 			}
 		}
 
-		switch reqType {
-		case "tcp":
-			if req.RemotePort != 0 && tokenID > 0 && deps.Reservations != nil {
-				if req.RemotePort < cfg.TCPPortRangeStart || req.RemotePort > cfg.TCPPortRangeEnd {
-					_ = writeControlTCPError(ctrlStream, "requested port out of range")
-					_ = ctrlStream.Close()
-					return
+		var lifetime tunnelLifetime
+		if reqType == "tcp" || reqType == "http" {
+			lifetime, err = resolveTunnelLifetime(ctx, cfg, deps.TokenClasses, tokenID, req.ExpiresAt, time.Now())
+			if err != nil {
+				if reqType == "http" {
+					_ = writeControlHTTPError(ctrlStream, err.Error())
+				} else {
+					_ = writeControlTCPError(ctrlStream, err.Error())
 				}
+				_ = ctrlStream.Close()
+				return
+			}
+		}
 
-				reservedTokenID, reserved, err := deps.Reservations.ReservedTCPPortTokenID(ctx, req.RemotePort)
-				if err != nil {
-					_ = writeControlTCPError(ctrlStream, "invalid requested port")
-					_ = ctrlStream.Close()
-					return
-				}
-				if reserved && reservedTokenID != tokenID {
-					_ = writeControlTCPError(ctrlStream, "unauthorized")
-					_ = ctrlStream.Close()
-					return
+		var bandwidth *tunnelBandwidth
+		if reqType == "tcp" || reqType == "http" {
+			var release func()
+			bandwidth, release, err = resolveTunnelBandwidth(cfg, bandwidthPool, tokenID, req.Bandwidth, metrics)
+			if err != nil {
+				if reqType == "http" {
+					_ = writeControlHTTPError(ctrlStream, err.Error())
+				} else {
+					_ = writeControlTCPError(ctrlStream, err.Error())
 				}
+				_ = ctrlStream.Close()
+				return
+			}
+			defer release()
+		}
 
-				if !reserved {
-					if err := deps.Reservations.ReserveTCPPort(ctx, tokenID, req.RemotePort); err != nil {
-						// In case of a race, re-check ownership.
-						reservedTokenID, reserved, err2 := deps.Reservations.ReservedTCPPortTokenID(ctx, req.RemotePort)
-						if err2 == nil && reserved && reservedTokenID == tokenID {
-							// OK: claimed by us.
-						} else if err2 == nil && reserved && reservedTokenID != tokenID {
-							_ = writeControlTCPError(ctrlStream, "unauthorized")
-							_ = ctrlStream.Close()
-							return
-						} else {
-							_ = writeControlTCPError(ctrlStream, "failed to reserve port")
-							_ = ctrlStream.Close()
-							return
-						}
-					}
-				}
+		switch reqType {
+		case "tcp":
+			if req.Secret != "" {
+				handleSecretTCPControl(ctx, session, ctrlStream, control.CreateTCPTunnelRequest{
+					Type:       "tcp",
+					Authtoken:  req.Authtoken,
+					RemotePort: req.RemotePort,
+					Secret:     req.Secret,
+					Name:       req.Name,
+					AllowCIDR:  req.AllowCIDR,
+					DenyCIDR:   req.DenyCIDR,
+					Notices:    req.Notices,
+				}, registry, tokenID, lifetime, bandwidth, metrics, reqLogger)
+				return
+			}
+
+			if err := claimTCPPort(ctx, cfg, deps.Reservations, ports, tokenID, req.RemotePort); err != nil {
+				_ = writeControlTCPError(ctrlStream, err.Error())
+				_ = ctrlStream.Close()
+				return
+			}
+
+			if req.RemotePort == 0 {
+				refreshReservedTCPPorts(ctx, ports, deps.Reservations, reqLogger)
 			}
 
 			handleTCPControl(ctx, conn, session, ctrlStream, control.CreateTCPTunnelRequest{
 				Type:       "tcp",
 				Authtoken:  req.Authtoken,
 				RemotePort: req.RemotePort,
-			}, cfg, metrics, reqLogger)
+				AllowCIDR:  req.AllowCIDR,
+				DenyCIDR:   req.DenyCIDR,
+				Notices:    req.Notices,
+			}, cfg.EdgePolicy, ports, registry, tokenID, lifetime, bandwidth, access, metrics, reqLogger)
 			return
 		case "http":
 			handleHTTPControl(ctx, session, ctrlStream, control.CreateHTTPTunnelRequest{
@@ -261,16 +311,38 @@ func controlHandler(cfg Config, registry *TunnelRegistry, deps Dependencies, lim
 				Subdomain:            req.Subdomain,
 				Domain:               req.Domain,
 				BasicAuth:            req.BasicAuth,
+				BasicAuthUsers:       req.BasicAuthUsers,
+				ShareLinks:           req.ShareLinks,
+				JWT:                  req.JWT,
+				CORS:                 req.CORS,
+				Compression:          req.Compression,
+				Cache:                req.Cache,
+				Limits:               req.Limits,
+				Mirror:               req.Mirror,
 				AllowMethod:          req.AllowMethod,
 				AllowPath:            req.AllowPath,
 				AllowPathPrefix:      req.AllowPathPrefix,
 				AllowCIDR:            req.AllowCIDR,
 				DenyCIDR:             req.DenyCIDR,
+				RateLimit:            req.RateLimit,
+				RateLimitHeader:      req.RateLimitHeader,
+				Redirect:             req.Redirect,
+				Rewrite:              req.Rewrite,
 				RequestHeaderAdd:     req.RequestHeaderAdd,
 				RequestHeaderRemove:  req.RequestHeaderRemove,
 				ResponseHeaderAdd:    req.ResponseHeaderAdd,
 				ResponseHeaderRemove: req.ResponseHeaderRemove,
-			}, cfg, registry, deps, tokenID, metrics)
+				TrafficPolicy:        req.TrafficPolicy,
+				Notices:              req.Notices,
+			}, cfg, registry, deps, tokenID, lifetime, bandwidth, metrics)
+			return
+		case "visit":
+			handleVisitControl(ctx, session, ctrlStream, control.VisitTCPTunnelRequest{
+				Type:      "visit",
+				Authtoken: req.Authtoken,
+				Name:      req.Name,
+				Secret:    req.Secret,
+			}, registry, reqLogger)
 			return
 		default:
 			_ = writeControlTCPError(ctrlStream, "unsupported tunnel type")
@@ -291,8 +363,21 @@ func decodeBaseRequest(r io.Reader) (baseRequest, error) {
 	return req, nil
 }
 
-func handleTCPControl(ctx context.Context, ws *websocket.Conn, session *yamux.Session, ctrlStream *yamux.Stream, req control.CreateTCPTunnelRequest, cfg Config, metrics *metrics, logger logging.Logger) {
-	ln, port, err := allocateTCPListener(cfg, req.RemotePort)
+func handleTCPControl(ctx context.Context, ws *websocket.Conn, session *yamux.Session, ctrlStream *yamux.Stream, req control.CreateTCPTunnelRequest, edge *EdgePolicy, ports *tcpPortPool, registry *TunnelRegistry, tokenID int64, lifetime tunnelLifetime, bandwidth *tunnelBandwidth, access *accessLog, metrics *metrics, logger logging.Logger) {
+	allowCIDRs, err := control.ParseCIDRList("allow_cidr", req.AllowCIDR, maxCIDREntries)
+	if err != nil {
+		_ = writeControlTCPError(ctrlStream, err.Error())
+		_ = ctrlStream.Close()
+		return
+	}
+	denyCIDRs, err := control.ParseCIDRList("deny_cidr", req.DenyCIDR, maxCIDREntries)
+	if err != nil {
+		_ = writeControlTCPError(ctrlStream, err.Error())
+		_ = ctrlStream.Close()
+		return
+	}
+
+	ln, port, err := ports.Allocate(req.RemotePort)
 	if err != nil {
 		_ = writeControlTCPError(ctrlStream, err.Error())
 		_ = ctrlStream.Close()
@@ -300,6 +385,20 @@ func handleTCPControl(ctx context.Context, ws *websocket.Conn, session *yamux.Se
 	}
 	defer ln.Close()
 
+	entry := tcpTunnelEntry{
+		session:    lifetime.track(bandwidth.wrap(yamuxSession{s: session})),
+		tokenID:    tokenID,
+		allowCIDRs: allowCIDRs,
+		denyCIDRs:  denyCIDRs,
+		edge:       edge,
+	}
+	if err := registry.RegisterTCPTunnel(port, entry); err != nil {
+		_ = writeControlTCPError(ctrlStream, err.Error())
+		_ = ctrlStream.Close()
+		return
+	}
+	defer registry.UnregisterTCPTunnel(port)
+
 	var releaseTunnel func()
 	if metrics != nil {
 		releaseTunnel = metrics.trackTCPTunnel()
@@ -309,18 +408,15 @@ func handleTCPControl(ctx context.Context, ws *websocket.Conn, session *yamux.Se
 	if err := control.WriteJSON(ctrlStream, control.CreateTCPTunnelResponse{
 		Type:       "tcp",
 		RemotePort: port,
+		ExpiresAt:  lifetime.expiresAt(),
 	}); err != nil {
 		_ = ctrlStream.Close()
 		return
 	}
-	_ = ctrlStream.Close()
 
-	// Ensure listener is closed on websocket disconnect.
+	// Ensure listener is closed on websocket disconnect or expiry.
 	go func() {
-		select {
-		case <-ctx.Done():
-		case <-session.CloseChan():
-		}
+		lifetime.wait(ctx, session, noticeStream(ctrlStream, req.Notices))
 		_ = ln.Close()
 		_ = session.Close()
 	}()
@@ -339,10 +435,17 @@ func handleTCPControl(ctx context.Context, ws *websocket.Conn, session *yamux.Se
 		}
 
 		go func(in net.Conn) {
+			ip := addrIP(in.RemoteAddr())
+			if !entry.allows(ip) {
+				_ = in.Close()
+				return
+			}
+			in = recordTCP(in, port, tokenID, ip, access, metrics)
 			defer in.Close()
 
-			stream, err := session.OpenStream()
+			stream, err := entry.session.OpenStream()
 			if err != nil {
+				metrics.countStreamOpenFailure("tcp", tokenID)
 				return
 			}
 			defer stream.Close()
@@ -352,68 +455,91 @@ func handleTCPControl(ctx context.Context, ws *websocket.Conn, session *yamux.Se
 	}
 }
 
-func handleHTTPControl(ctx context.Context, session *yamux.Session, ctrlStream *yamux.Stream, req control.CreateHTTPTunnelRequest, cfg Config, registry *TunnelRegistry, deps Dependencies, tokenID int64, metrics *metrics) {
-	id, err := func() (string, error) {
-		domain := strings.TrimSpace(req.Domain)
-		subdomain := strings.TrimSpace(req.Subdomain)
+func handleHTTPControl(ctx context.Context, session *yamux.Session, ctrlStream *yamux.Stream, req control.CreateHTTPTunnelRequest, cfg Config, registry *TunnelRegistry, deps Dependencies, tokenID int64, lifetime tunnelLifetime, bandwidth *tunnelBandwidth, metrics *metrics) {
+	id, err := resolveHTTPTunnelID(ctx, cfg, registry, deps.Reservations, tokenID, req.Subdomain, req.Domain)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
 
-		switch {
-		case domain == "" && subdomain == "":
-			id, err := registry.AllocateID()
-			if err != nil {
-				return "", errors.New("failed to allocate id")
-			}
-			return id, nil
-		case domain != "" && subdomain != "":
-			return "", errors.New("invalid request")
-		}
+	basicAuth, err := parseBasicAuthCredential(req.BasicAuth)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
 
-		if tokenID <= 0 || deps.Reservations == nil {
-			return "", errors.New("unauthorized")
+	jwtAuth, err := control.ValidateJWTAuth(req.JWT)
+	if err == nil && jwtAuth != nil && jwtAuth.JWKS != "" {
+		if _, perr := jwtauth.ParseJWKS([]byte(jwtAuth.JWKS)); perr != nil {
+			err = errors.New("jwt: " + perr.Error())
 		}
+	}
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
 
-		desired := subdomain
-		if domain != "" {
-			host := domain
-			if strings.Contains(host, "://") {
-				u, err := url.Parse(host)
-				if err != nil {
-					return "", errors.New("invalid domain")
-				}
-				host = u.Host
-			}
+	basicAuthUsers, err := basicauth.ValidateUsers(req.BasicAuthUsers)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: "basic_auth_users: " + err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
+	if jwtAuth != nil && (basicAuth != nil || len(basicAuthUsers) > 0) {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: "jwt: cannot be combined with basic auth",
+		})
+		_ = ctrlStream.Close()
+		return
+	}
 
-			id, ok := tunnelIDFromHost(host, cfg.TunnelDomain)
-			if !ok {
-				return "", errors.New("invalid domain")
-			}
-			desired = id
-		}
+	cors, err := control.ValidateCORSPolicy(req.CORS)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
 
-		reservedTokenID, reserved, err := deps.Reservations.ReservedSubdomainTokenID(ctx, desired)
-		if err != nil {
-			return "", errors.New("invalid subdomain")
-		}
-		if reserved && reservedTokenID != tokenID {
-			return "", errors.New("unauthorized")
-		}
+	compression, err := control.ValidateCompressionPolicy(req.Compression)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
 
-		if !reserved {
-			if err := deps.Reservations.ReserveSubdomain(ctx, tokenID, desired); err != nil {
-				// In case of a race, re-check ownership.
-				reservedTokenID, reserved, err2 := deps.Reservations.ReservedSubdomainTokenID(ctx, desired)
-				if err2 == nil && reserved && reservedTokenID == tokenID {
-					return desired, nil
-				}
-				if err2 == nil && reserved && reservedTokenID != tokenID {
-					return "", errors.New("unauthorized")
-				}
-				return "", errors.New("failed to reserve subdomain")
-			}
-		}
+	cachePolicy, err := control.ValidateCachePolicy(req.Cache)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
 
-		return desired, nil
-	}()
+	limits, err := control.ValidateHTTPLimits(req.Limits)
 	if err != nil {
 		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
 			Type:  "http",
@@ -423,7 +549,10 @@ func handleHTTPControl(ctx context.Context, session *yamux.Session, ctrlStream *
 		return
 	}
 
-	basicAuth, err := parseBasicAuthCredential(req.BasicAuth)
+	mirror, err := control.ValidateMirrorPolicy(req.Mirror)
+	if err == nil && mirror != nil && mirror.Tunnel == id {
+		err = errors.New("mirror: a tunnel cannot mirror to itself")
+	}
 	if err != nil {
 		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
 			Type:  "http",
@@ -452,6 +581,35 @@ func handleHTTPControl(ctx context.Context, session *yamux.Session, ctrlStream *
 		return
 	}
 
+	rateLimit, rateLimitHeader, err := control.ParseRateLimit(req.RateLimit, req.RateLimitHeader)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
+
+	redirects, err := control.ParseRedirectRules("redirect", req.Redirect, maxAllowlistEntries)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
+	rewrites, err := control.ParseRewriteRules("rewrite", req.Rewrite, maxAllowlistEntries)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
+
 	allowMethods, err := control.ParseHTTPMethodList("allow_method", req.AllowMethod, maxAllowlistEntries)
 	if err != nil {
 		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
@@ -518,10 +676,37 @@ func handleHTTPControl(ctx context.Context, session *yamux.Session, ctrlStream *
 		return
 	}
 
-	if err := registry.RegisterHTTPTunnel(id, yamuxSession{s: session}, httpTunnelOptions{
-		BasicAuth:  basicAuth,
-		AllowCIDRs: allowCIDRs,
-		DenyCIDRs:  denyCIDRs,
+	trafficPolicy, err := policy.Compile(req.TrafficPolicy, policy.Options{})
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: "traffic_policy: " + err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
+
+	cache, err := newEdgeCache(cfg, cachePolicy, id)
+	if err != nil {
+		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
+			Type:  "http",
+			Error: err.Error(),
+		})
+		_ = ctrlStream.Close()
+		return
+	}
+
+	if err := registry.RegisterHTTPTunnel(id, lifetime.track(bandwidth.wrap(yamuxSession{s: session})), httpTunnelOptions{
+		BasicAuth:      basicAuth,
+		BasicAuthUsers: basicAuthUsers,
+		AllowCIDRs:     allowCIDRs,
+		DenyCIDRs:      denyCIDRs,
+
+		RateLimit:       rateLimit,
+		RateLimitHeader: rateLimitHeader,
+
+		Redirects: redirects,
+		Rewrites:  rewrites,
 
 		AllowMethods:      allowMethods,
 		AllowPaths:        allowPaths,
@@ -531,7 +716,22 @@ func handleHTTPControl(ctx context.Context, session *yamux.Session, ctrlStream *
 		RequestHeaderRemove:  requestHeaderRemove,
 		ResponseHeaderAdd:    responseHeaderAdd,
 		ResponseHeaderRemove: responseHeaderRemove,
+
+		Policy: trafficPolicy,
+
+		ShareLinks: req.ShareLinks,
+		JWT:        jwtAuth,
+		CORS:       cors,
+
+		Compression: compression,
+		Cache:       cache,
+
+		TokenID:        tokenID,
+		BrowserWarning: browserWarningFor(ctx, cfg, deps.BrowserWarnings, tokenID, id),
+		Limits:         resolveHTTPLimits(cfg, limits),
+		Mirror:         mirror,
 	}); err != nil {
+		cache.close()
 		_ = control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
 			Type:  "http",
 			Error: "failed to register tunnel",
@@ -547,24 +747,132 @@ func handleHTTPControl(ctx context.Context, session *yamux.Session, ctrlStream *
 		defer releaseTunnel()
 	}
 
-	url := fmt.Sprintf("https://%s.%s", id, strings.TrimSuffix(cfg.TunnelDomain, "."))
-	if err := control.WriteJSON(ctrlStream, control.CreateHTTPTunnelResponse{
-		Type: "http",
-		ID:   id,
-		URL:  url,
-	}); err != nil {
+	resp := control.CreateHTTPTunnelResponse{
+		Type:            "http",
+		ID:              id,
+		URL:             cfg.httpTunnelURL(id),
+		ExpiresAt:       lifetime.expiresAt(),
+		RequestIDHeader: cfg.requestIDHeader(),
+	}
+	if cfg.pathRouting() {
+		resp.Routing = HTTPRoutingPath
+	}
+	if err := control.WriteJSON(ctrlStream, resp); err != nil {
 		_ = ctrlStream.Close()
 		return
 	}
-	_ = ctrlStream.Close()
 
-	select {
-	case <-ctx.Done():
-	case <-session.CloseChan():
-	}
+	go serveHTTPTunnelStreams(session, cfg, id, req.ShareLinks)
+
+	lifetime.wait(ctx, session, noticeStream(ctrlStream, req.Notices))
 	_ = session.Close()
 }
 
+// resolveHTTPTunnelID picks the tunnel id for an HTTP tunnel: a random id when
+// neither subdomain nor domain is requested, otherwise the requested name after
+// checking (and, if unclaimed, creating) the token's reservation.
+func resolveHTTPTunnelID(ctx context.Context, cfg Config, registry *TunnelRegistry, reservations ReservationStore, tokenID int64, subdomain, domain string) (string, error) {
+	domain = strings.TrimSpace(domain)
+	subdomain = strings.TrimSpace(subdomain)
+
+	switch {
+	case domain == "" && subdomain == "":
+		id, err := registry.AllocateID()
+		if err != nil {
+			return "", errors.New("failed to allocate id")
+		}
+		return id, nil
+	case domain != "" && subdomain != "":
+		return "", errors.New("invalid request")
+	}
+
+	if tokenID <= 0 || reservations == nil {
+		return "", errors.New("unauthorized")
+	}
+
+	desired := subdomain
+	if domain != "" {
+		host := domain
+		if strings.Contains(host, "://") {
+			u, err := url.Parse(host)
+			if err != nil {
+				return "", errors.New("invalid domain")
+			}
+			host = u.Host
+		}
+
+		id, ok := tunnelIDFromHost(host, cfg.TunnelDomain)
+		if !ok {
+			return "", errors.New("invalid domain")
+		}
+		desired = id
+	}
+
+	reservedTokenID, reserved, err := reservations.ReservedSubdomainTokenID(ctx, desired)
+	if err != nil {
+		return "", errors.New("invalid subdomain")
+	}
+	if reserved && reservedTokenID != tokenID {
+		return "", errors.New("unauthorized")
+	}
+
+	if !reserved {
+		if err := reservations.ReserveSubdomain(ctx, tokenID, desired); err != nil {
+			// In case of a race, re-check ownership.
+			reservedTokenID, reserved, err2 := reservations.ReservedSubdomainTokenID(ctx, desired)
+			if err2 == nil && reserved && reservedTokenID == tokenID {
+				return desired, nil
+			}
+			if err2 == nil && reserved && reservedTokenID != tokenID {
+				return "", errors.New("unauthorized")
+			}
+			return "", errors.New("failed to reserve subdomain")
+		}
+	}
+
+	return desired, nil
+}
+
+// claimTCPPort checks that tokenID may use the requested TCP port, reserving it
+// for the token when it is not reserved yet (and invalidating the reserved set
+// of ports). Port 0 (auto-allocate) and deployments without reservations or
+// token ids are always allowed.
+func claimTCPPort(ctx context.Context, cfg Config, reservations ReservationStore, ports *tcpPortPool, tokenID int64, port int) error {
+	if port == 0 || tokenID <= 0 || reservations == nil {
+		return nil
+	}
+
+	if port < cfg.TCPPortRangeStart || port > cfg.TCPPortRangeEnd {
+		return errors.New("requested port out of range")
+	}
+
+	reservedTokenID, reserved, err := reservations.ReservedTCPPortTokenID(ctx, port)
+	if err != nil {
+		return errors.New("invalid requested port")
+	}
+	if reserved && reservedTokenID != tokenID {
+		return errors.New("unauthorized")
+	}
+	if reserved {
+		return nil
+	}
+
+	if err := reservations.ReserveTCPPort(ctx, tokenID, port); err != nil {
+		// In case of a race, re-check ownership.
+		reservedTokenID, reserved, err2 := reservations.ReservedTCPPortTokenID(ctx, port)
+		if err2 == nil && reserved && reservedTokenID == tokenID {
+			return nil
+		}
+		if err2 == nil && reserved && reservedTokenID != tokenID {
+			return errors.New("unauthorized")
+		}
+		return errors.New("failed to reserve port")
+	}
+	ports.InvalidateReserved()
+
+	return nil
+}
+
 func parseBasicAuthCredential(s string) (*basicAuthCredential, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
@@ -621,6 +929,9 @@ func parseHeaderKVList(field string, values []control.HeaderKV) ([]headerKV, err
 		if err != nil {
 			return nil, err
 		}
+		if err := control.ValidateHeaderTemplate(field, val); err != nil {
+			return nil, err
+		}
 		out = append(out, headerKV{
 			Name:  name,
 			Value: val,
@@ -643,35 +954,6 @@ func writeControlHTTPError(w io.Writer, msg string) error {
 	})
 }
 
-func allocateTCPListener(cfg Config, requestedPort int) (net.Listener, int, error) {
-	if requestedPort != 0 {
-		if requestedPort < cfg.TCPPortRangeStart || requestedPort > cfg.TCPPortRangeEnd {
-			return nil, 0, fmt.Errorf("requested port out of range")
-		}
-		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", requestedPort))
-		if err != nil {
-			return nil, 0, fmt.Errorf("requested port unavailable")
-		}
-		return ln, requestedPort, nil
-	}
-
-	start := cfg.TCPPortRangeStart
-	end := cfg.TCPPortRangeEnd
-	if start <= 0 || end <= 0 || end < start {
-		return nil, 0, fmt.Errorf("invalid tcp port range")
-	}
-
-	for port := start; port <= end; port++ {
-		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-		if err != nil {
-			continue
-		}
-		return ln, port, nil
-	}
-
-	return nil, 0, fmt.Errorf("no ports available")
-}
-
 func proxyBidirectional(ctx context.Context, a, b net.Conn) error {
 	errCh := make(chan error, 2)
 
@@ -94,19 +94,19 @@ func TestParseHeaderKVList(t *testing.T) {
 	}
 }
 
-func TestAllocateTCPListener(t *testing.T) {
+func TestTCPPortPool_Allocate(t *testing.T) {
 	// Avoid t.Parallel: uses actual TCP ports.
 
 	t.Run("rejects requested port out of range", func(t *testing.T) {
 		cfg := Config{TCPPortRangeStart: 20000, TCPPortRangeEnd: 20010}
-		if _, _, err := allocateTCPListener(cfg, 19999); err == nil {
+		if _, _, err := newTCPPortPool(cfg).Allocate(19999); err == nil {
 			t.Fatalf("err = nil, want non-nil")
 		}
 	})
 
 	t.Run("rejects invalid range for auto allocation", func(t *testing.T) {
 		cfg := Config{TCPPortRangeStart: 0, TCPPortRangeEnd: 0}
-		if _, _, err := allocateTCPListener(cfg, 0); err == nil {
+		if _, _, err := newTCPPortPool(cfg).Allocate(0); err == nil {
 			t.Fatalf("err = nil, want non-nil")
 		}
 	})
@@ -133,7 +133,7 @@ func TestAllocateTCPListener(t *testing.T) {
 		}
 
 		cfg := Config{TCPPortRangeStart: port, TCPPortRangeEnd: port}
-		if _, _, err := allocateTCPListener(cfg, port); err == nil {
+		if _, _, err := newTCPPortPool(cfg).Allocate(port); err == nil {
 			t.Fatalf("err = nil, want non-nil")
 		}
 	})
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+const maxCORSRequestHeadersBytes = 2048
+
+// corsResponseHeaders are replaced on upstream responses so the edge policy
+// is the only source of CORS headers.
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+}
+
+// corsPolicy is a compiled control.CORSPolicy.
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	wildcards []corsWildcard
+
+	methods     string
+	methodSet   map[string]bool
+	headers     string // empty: allow whatever a preflight requests
+	expose      string
+	credentials bool
+	maxAge      string
+}
+
+// corsWildcard matches "prefix<subdomains>suffix", e.g. "https://" +
+// "a.b" + ".example.com".
+type corsWildcard struct {
+	prefix string
+	suffix string
+}
+
+// newCORSPolicy compiles a validated policy; nil when p is nil.
+func newCORSPolicy(p *control.CORSPolicy) *corsPolicy {
+	if p == nil {
+		return nil
+	}
+
+	c := &corsPolicy{
+		origins:     make(map[string]bool),
+		methodSet:   make(map[string]bool),
+		headers:     strings.Join(p.AllowHeaders, ", "),
+		expose:      strings.Join(p.ExposeHeaders, ", "),
+		credentials: p.AllowCredentials,
+	}
+	for _, o := range p.AllowOrigins {
+		switch {
+		case o == "*":
+			c.anyOrigin = true
+		case strings.Contains(o, "://*."):
+			scheme, host, _ := strings.Cut(o, "://*")
+			c.wildcards = append(c.wildcards, corsWildcard{prefix: scheme + "://", suffix: host})
+		default:
+			c.origins[o] = true
+		}
+	}
+
+	methods := p.AllowMethods
+	if len(methods) == 0 {
+		methods = control.DefaultCORSMethods
+	}
+	for _, m := range methods {
+		c.methodSet[m] = true
+	}
+	c.methods = strings.Join(methods, ", ")
+
+	if p.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(p.MaxAge)
+	}
+	return c
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" when the origin is not allowed.
+func (c *corsPolicy) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	if c.anyOrigin {
+		return "*"
+	}
+	o := strings.ToLower(origin)
+	if c.origins[o] {
+		return origin
+	}
+	for _, w := range c.wildcards {
+		if !strings.HasPrefix(o, w.prefix) || !strings.HasSuffix(o, w.suffix) {
+			continue
+		}
+		sub := o[len(w.prefix) : len(o)-len(w.suffix)]
+		if sub != "" && isSubdomainLabels(sub) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func isSubdomainLabels(s string) bool {
+	for _, label := range strings.Split(s, ".") {
+		if label == "" {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isCORSPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// servePreflight answers a CORS preflight at the edge. Disallowed origins
+// or methods get a 204 without CORS headers, which the browser rejects.
+func (c *corsPolicy) servePreflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	origin := c.allowOrigin(r.Header.Get("Origin"))
+	method := strings.ToUpper(strings.TrimSpace(r.Header.Get("Access-Control-Request-Method")))
+	if origin == "" || !c.methodSet[method] {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h.Set("Access-Control-Allow-Origin", origin)
+	if c.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Allow-Methods", c.methods)
+	if headers := c.allowHeaders(r.Header.Get("Access-Control-Request-Headers")); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	if c.maxAge != "" {
+		h.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowHeaders returns the configured header list, or echoes the requested
+// headers when none is configured.
+func (c *corsPolicy) allowHeaders(requested string) string {
+	if c.headers != "" {
+		return c.headers
+	}
+	if len(requested) > maxCORSRequestHeadersBytes {
+		return ""
+	}
+	var names []string
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, err := control.NormalizeHeaderName("cors header", name); err != nil {
+			return ""
+		}
+		names = append(names, strings.ToLower(name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// applyResponse replaces the upstream's CORS headers with the policy's for
+// a request from origin.
+func (c *corsPolicy) applyResponse(origin string, h http.Header) {
+	for _, k := range corsResponseHeaders {
+		h.Del(k)
+	}
+	if !c.anyOrigin {
+		h.Add("Vary", "Origin")
+	}
+
+	allowed := c.allowOrigin(origin)
+	if allowed == "" {
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", allowed)
+	if c.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if c.expose != "" {
+		h.Set("Access-Control-Expose-Headers", c.expose)
+	}
+}
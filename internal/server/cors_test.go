@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// corsUpstreamSession answers like a dev server with its own (wide open)
+// CORS headers.
+type corsUpstreamSession struct {
+	opens atomic.Int32
+}
+
+func (s *corsUpstreamSession) OpenStream() (net.Conn, error) {
+	s.opens.Add(1)
+	a, b := net.Pipe()
+
+	go func() {
+		defer b.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(b))
+		if err != nil {
+			return
+		}
+		_ = req.Body.Close()
+
+		body := "ok\n"
+		_, _ = fmt.Fprintf(b, "HTTP/1.1 200 OK\r\nAccess-Control-Allow-Origin: *\r\nAccess-Control-Allow-Methods: *\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+	}()
+
+	return a, nil
+}
+
+func (s *corsUpstreamSession) Close() error { return nil }
+
+func TestHTTPTunnel_CORS(t *testing.T) {
+	t.Parallel()
+
+	cors, err := control.ValidateCORSPolicy(&control.CORSPolicy{
+		AllowOrigins:     []string{"https://app.example.com", "https://*.preview.example.com"},
+		AllowMethods:     []string{"GET", "PUT"},
+		ExposeHeaders:    []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	sess := &corsUpstreamSession{}
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{
+		CORS:      cors,
+		BasicAuth: &basicAuthCredential{Username: "user", Password: "pass"},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	do := func(method, origin string, hdr map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.test/api", nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	t.Run("preflight answered at the edge without auth", func(t *testing.T) {
+		rr := do(http.MethodOptions, "https://app.example.com", map[string]string{
+			"Access-Control-Request-Method":  "PUT",
+			"Access-Control-Request-Headers": "Content-Type, X-Api-Key",
+		})
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want 204", rr.Code)
+		}
+		got := rr.Header()
+		if v := got.Get("Access-Control-Allow-Origin"); v != "https://app.example.com" {
+			t.Fatalf("Allow-Origin = %q", v)
+		}
+		if v := got.Get("Access-Control-Allow-Methods"); v != "GET, PUT" {
+			t.Fatalf("Allow-Methods = %q", v)
+		}
+		if v := got.Get("Access-Control-Allow-Headers"); v != "content-type, x-api-key" {
+			t.Fatalf("Allow-Headers = %q", v)
+		}
+		if got.Get("Access-Control-Allow-Credentials") != "true" || got.Get("Access-Control-Max-Age") != "600" {
+			t.Fatalf("headers = %v", got)
+		}
+		if n := sess.opens.Load(); n != 0 {
+			t.Fatalf("preflight reached upstream (%d opens)", n)
+		}
+	})
+
+	t.Run("preflight for disallowed method or origin", func(t *testing.T) {
+		for _, c := range [][2]string{
+			{"https://app.example.com", "DELETE"},
+			{"https://evil.example.com", "GET"},
+			{"https://preview.example.com.evil.test", "GET"},
+		} {
+			rr := do(http.MethodOptions, c[0], map[string]string{"Access-Control-Request-Method": c[1]})
+			if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Fatalf("%v: status = %d, headers = %v", c, rr.Code, rr.Header())
+			}
+		}
+	})
+
+	t.Run("actual response headers replace the upstream's", func(t *testing.T) {
+		rr := do(http.MethodGet, "https://pr-7.preview.example.com", map[string]string{"Authorization": basicAuthHeader("user", "pass")})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rr.Code)
+		}
+		got := rr.Header()
+		if v := got.Values("Access-Control-Allow-Origin"); len(v) != 1 || v[0] != "https://pr-7.preview.example.com" {
+			t.Fatalf("Allow-Origin = %q", v)
+		}
+		if got.Get("Access-Control-Allow-Methods") != "" {
+			t.Fatalf("upstream Allow-Methods leaked: %v", got)
+		}
+		if got.Get("Access-Control-Expose-Headers") != "X-Request-Id" || got.Get("Vary") != "Origin" {
+			t.Fatalf("headers = %v", got)
+		}
+	})
+
+	t.Run("disallowed origin gets no CORS headers", func(t *testing.T) {
+		rr := do(http.MethodGet, "https://evil.test", map[string]string{"Authorization": basicAuthHeader("user", "pass")})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rr.Code)
+		}
+		if v := rr.Header().Get("Access-Control-Allow-Origin"); v != "" {
+			t.Fatalf("Allow-Origin = %q, want none", v)
+		}
+	})
+}
+
+func basicAuthHeader(user, pass string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(user, pass)
+	return req.Header.Get("Authorization")
+}
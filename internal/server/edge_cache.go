@@ -0,0 +1,643 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+const (
+	cacheStatusHeader = "X-Eosrift-Cache"
+
+	// maxCacheFreshness bounds max-age values so they cannot overflow.
+	maxCacheFreshness = 365 * 24 * time.Hour
+)
+
+// cacheableStatus are the status codes the edge cache stores: the ones RFC
+// 9110 lets caches reuse without explicit freshness information.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// conditionalHeaders are dropped from a request when the cache fetches a
+// full response to store.
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"}
+
+// edgeCache is an HTTP tunnel's shared cache for GET responses (a subset of
+// RFC 9111). Bodies live in memory, or in files under dir; the index is
+// always in memory and entries are evicted least recently used first.
+type edgeCache struct {
+	maxBytes   int64
+	defaultTTL time.Duration
+	dir        string // empty: bodies are kept in memory
+
+	mu      sync.Mutex
+	entries map[string][]*cacheEntry // variants by cache key
+	lru     list.List                // *cacheEntry, most recently used first
+	size    int64
+	closed  bool
+}
+
+// cacheEntry is one stored response. Entries are not modified once stored;
+// a revalidation replaces the entry, handing over its body.
+type cacheEntry struct {
+	key  string
+	path string
+	vary http.Header // the request's values of the headers Vary names
+
+	status     int
+	header     http.Header
+	body       []byte
+	file       string
+	size       int64
+	storedAt   time.Time
+	initialAge time.Duration
+	lifetime   time.Duration
+
+	elem *list.Element
+}
+
+func edgeCacheTunnelsDir(dir string) string {
+	return filepath.Join(dir, "tunnels")
+}
+
+// newEdgeCache creates the cache of tunnel id; nil when p is nil.
+func newEdgeCache(cfg Config, p *control.CachePolicy, id string) (*edgeCache, error) {
+	if p == nil {
+		return nil, nil
+	}
+	if cfg.HTTPCacheMaxBytes <= 0 {
+		return nil, errors.New("cache: the edge cache is disabled on this server")
+	}
+
+	c := &edgeCache{
+		maxBytes:   lowerLimit(p.MaxBytes, cfg.HTTPCacheMaxBytes),
+		defaultTTL: seconds(p.DefaultTTLSeconds),
+		entries:    make(map[string][]*cacheEntry),
+	}
+	if cfg.HTTPCacheDir != "" {
+		base := edgeCacheTunnelsDir(cfg.HTTPCacheDir)
+		if err := os.MkdirAll(base, 0o700); err != nil {
+			return nil, fmt.Errorf("cache: %v", err)
+		}
+		dir, err := os.MkdirTemp(base, id+"-")
+		if err != nil {
+			return nil, fmt.Errorf("cache: %v", err)
+		}
+		c.dir = dir
+	}
+	return c, nil
+}
+
+// maxObjectBytes keeps a single response from flushing the whole cache.
+func (c *edgeCache) maxObjectBytes() int64 {
+	return c.maxBytes / 4
+}
+
+// edgeCacheTransport answers requests of cache-enabled tunnels from their
+// edge cache when it can, so hits never open a stream to the client.
+type edgeCacheTransport struct {
+	next    http.RoundTripper
+	metrics *metrics
+}
+
+func (t *edgeCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry, ok := tunnelEntryFromContext(req.Context())
+	if !ok || entry.cache == nil {
+		return t.next.RoundTrip(req)
+	}
+	resp, err := entry.cache.roundTrip(req, t.next)
+	if err == nil {
+		switch resp.Header.Get(cacheStatusHeader) {
+		case "HIT":
+			t.metrics.countCache(true)
+		case "MISS":
+			t.metrics.countCache(false)
+		}
+	}
+	return resp, err
+}
+
+func (c *edgeCache) roundTrip(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	key := req.Host + req.URL.RequestURI()
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions, http.MethodTrace:
+		return next.RoundTrip(req)
+	default:
+		// Unsafe methods invalidate what is stored for their URL.
+		resp, err := next.RoundTrip(req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			c.invalidate(key)
+		}
+		return resp, err
+	}
+	if isUpgradeRequest(req) || req.Header.Get("Range") != "" {
+		return next.RoundTrip(req)
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if reqCC.has("no-store") {
+		return markCacheMiss(next.RoundTrip(req))
+	}
+	revalidate := reqCC.has("no-cache") || reqCC["max-age"] == "0" ||
+		(len(reqCC) == 0 && strings.Contains(strings.ToLower(req.Header.Get("Pragma")), "no-cache"))
+
+	now := time.Now()
+	if e := c.lookup(key, req.Header); e != nil {
+		if !revalidate && e.fresh(now) {
+			if resp, ok := c.serve(e, req, now); ok {
+				return resp, nil
+			}
+		} else if etag, lastModified := e.header.Get("Etag"), e.header.Get("Last-Modified"); etag != "" || lastModified != "" {
+			out := req.Clone(req.Context())
+			for _, name := range conditionalHeaders {
+				out.Header.Del(name)
+			}
+			if etag != "" {
+				out.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				out.Header.Set("If-Modified-Since", lastModified)
+			}
+			resp, err := next.RoundTrip(out)
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode != http.StatusNotModified {
+				return c.fill(key, req, resp), nil
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			if cached, ok := c.serve(c.refresh(e, resp.Header, time.Now()), req, time.Now()); ok {
+				return cached, nil
+			}
+		}
+	}
+
+	if req.Method == http.MethodHead {
+		return markCacheMiss(next.RoundTrip(req))
+	}
+	out := req
+	if req.Header.Get("Authorization") == "" && !edgeAuthenticated(req) {
+		out = withoutConditionals(req)
+	}
+	resp, err := next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	return c.fill(key, req, resp), nil
+}
+
+func markCacheMiss(resp *http.Response, err error) (*http.Response, error) {
+	if err == nil {
+		resp.Header.Set(cacheStatusHeader, "MISS")
+	}
+	return resp, err
+}
+
+// withoutConditionals returns req, or a copy of it without conditional
+// headers, so the upstream answers with a full response the cache can keep.
+func withoutConditionals(req *http.Request) *http.Request {
+	out := req
+	for _, name := range conditionalHeaders {
+		if req.Header.Get(name) == "" {
+			continue
+		}
+		if out == req {
+			out = req.Clone(req.Context())
+		}
+		out.Header.Del(name)
+	}
+	return out
+}
+
+// fill marks resp as a miss and, when it may be stored, tees its body into
+// the cache as the proxy reads it.
+func (c *edgeCache) fill(key string, req *http.Request, resp *http.Response) *http.Response {
+	lifetime, ok := c.storable(req, resp)
+	if ok && resp.ContentLength <= c.maxObjectBytes() {
+		header := resp.Header.Clone()
+		header.Del("Content-Length")
+		initialAge, _ := strconv.Atoi(resp.Header.Get("Age"))
+
+		resp.Body = &cacheFillBody{
+			ReadCloser: resp.Body,
+			cache:      c,
+			limit:      c.maxObjectBytes(),
+			entry: &cacheEntry{
+				key:        key,
+				path:       req.URL.Path,
+				vary:       varySnapshot(resp.Header, req.Header),
+				status:     resp.StatusCode,
+				header:     header,
+				storedAt:   time.Now(),
+				initialAge: time.Duration(max(initialAge, 0)) * time.Second,
+				lifetime:   lifetime,
+			},
+		}
+	}
+	resp.Header.Set(cacheStatusHeader, "MISS")
+	return resp
+}
+
+// storable reports whether the response to req may be stored, and for how
+// long it is fresh.
+func (c *edgeCache) storable(req *http.Request, resp *http.Response) (time.Duration, bool) {
+	if req.Method != http.MethodGet || !cacheableStatus[resp.StatusCode] {
+		return 0, false
+	}
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") || len(resp.Header.Values("Set-Cookie")) > 0 {
+		return 0, false
+	}
+	if req.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return 0, false
+	}
+	// Edge auth strips the credentials, but the upstream may still tailor
+	// the response to the user (e.g. via ${basic_auth_user} headers or JWT
+	// claims), so only explicitly shared responses are kept.
+	if edgeAuthenticated(req) && !cc.has("public") && !cc.has("s-maxage") {
+		return 0, false
+	}
+	if headerHasToken(resp.Header, "Vary", "*") {
+		return 0, false
+	}
+
+	lifetime := freshnessLifetime(resp.Header, cc, c.defaultTTL)
+	if cc.has("no-cache") {
+		lifetime = 0
+	}
+	if lifetime <= 0 && resp.Header.Get("Etag") == "" && resp.Header.Get("Last-Modified") == "" {
+		return 0, false
+	}
+	return lifetime, true
+}
+
+// freshnessLifetime uses s-maxage, max-age or Expires, falling back to
+// defaultTTL.
+func freshnessLifetime(h http.Header, cc cacheControl, defaultTTL time.Duration) time.Duration {
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return 0
+			}
+			return min(time.Duration(min(n, int64(maxCacheFreshness/time.Second)))*time.Second, maxCacheFreshness)
+		}
+	}
+	if v := h.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		return expires.Sub(date)
+	}
+	return defaultTTL
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.storedAt)
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return e.age(now) < e.lifetime
+}
+
+// varySnapshot records the request's values of the headers resp varies on.
+func varySnapshot(resp, req http.Header) http.Header {
+	var out http.Header
+	for _, v := range resp.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if out == nil {
+				out = make(http.Header)
+			}
+			out[name] = req.Values(name)
+		}
+	}
+	return out
+}
+
+func (e *cacheEntry) matches(req http.Header) bool {
+	for name, want := range e.vary {
+		if strings.Join(req.Values(name), ",") != strings.Join(want, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *edgeCache) lookup(key string, req http.Header) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.entries[key] {
+		if e.matches(req) {
+			c.lru.MoveToFront(e.elem)
+			return e
+		}
+	}
+	return nil
+}
+
+// serve builds the response for req from e. It returns false when the body
+// is gone (the entry was evicted meanwhile).
+func (c *edgeCache) serve(e *cacheEntry, req *http.Request, now time.Time) (*http.Response, bool) {
+	h := e.header.Clone()
+	h.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	h.Set(cacheStatusHeader, "HIT")
+
+	resp := &http.Response{
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          http.NoBody,
+		ContentLength: e.size,
+		Request:       req,
+	}
+	if e.status == http.StatusOK && notModified(req.Header, h) {
+		resp.StatusCode, resp.ContentLength = http.StatusNotModified, 0
+	} else {
+		h.Set("Content-Length", strconv.FormatInt(e.size, 10))
+		if req.Method != http.MethodHead {
+			body, err := c.openBody(e)
+			if err != nil {
+				return nil, false
+			}
+			resp.Body = body
+		}
+	}
+	resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	return resp, true
+}
+
+func (c *edgeCache) openBody(e *cacheEntry) (io.ReadCloser, error) {
+	if e.file == "" {
+		return io.NopCloser(bytes.NewReader(e.body)), nil
+	}
+	return os.Open(e.file)
+}
+
+// notModified evaluates a client's If-None-Match (or, without it,
+// If-Modified-Since) against a cached response.
+func notModified(req, resp http.Header) bool {
+	if inm := req.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(resp.Get("Etag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(req.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(resp.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ims)
+}
+
+// refresh replaces e with a copy updated from a 304 response and returns
+// it.
+func (c *edgeCache) refresh(e *cacheEntry, header http.Header, now time.Time) *cacheEntry {
+	updated := *e
+	updated.header = e.header.Clone()
+	for k, v := range header {
+		switch k {
+		case "Content-Length", "Connection", "Keep-Alive", "Transfer-Encoding":
+			continue
+		}
+		updated.header[k] = v
+	}
+	initialAge, _ := strconv.Atoi(header.Get("Age"))
+	updated.initialAge = time.Duration(max(initialAge, 0)) * time.Second
+	updated.storedAt = now
+	updated.lifetime = freshnessLifetime(updated.header, parseCacheControl(updated.header), c.defaultTTL)
+	if parseCacheControl(updated.header).has("no-cache") {
+		updated.lifetime = 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	variants := c.entries[e.key]
+	for i, v := range variants {
+		if v == e {
+			variants[i] = &updated
+			e.elem.Value = &updated
+			return &updated
+		}
+	}
+	// Evicted meanwhile: serve it if the body is still there.
+	updated.elem = nil
+	return &updated
+}
+
+// store adds e with its body, replacing the variant it matches and evicting
+// the least recently used entries beyond maxBytes.
+func (c *edgeCache) store(e *cacheEntry, body []byte) {
+	e.size = int64(len(body))
+	if c.dir == "" {
+		e.body = body
+	} else {
+		f, err := os.CreateTemp(c.dir, "body-")
+		if err != nil {
+			return
+		}
+		_, err = f.Write(body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+			return
+		}
+		e.file = f.Name()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		c.removeBody(e)
+		return
+	}
+	for _, old := range c.entries[e.key] {
+		if old.matches(e.vary) && e.matches(old.vary) {
+			c.removeLocked(old)
+			break
+		}
+	}
+	c.entries[e.key] = append(c.entries[e.key], e)
+	e.elem = c.lru.PushFront(e)
+	c.size += e.size
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry))
+	}
+}
+
+func (c *edgeCache) removeLocked(e *cacheEntry) {
+	variants := c.entries[e.key]
+	for i, v := range variants {
+		if v == e {
+			variants = append(variants[:i], variants[i+1:]...)
+			break
+		}
+	}
+	if len(variants) == 0 {
+		delete(c.entries, e.key)
+	} else {
+		c.entries[e.key] = variants
+	}
+	c.lru.Remove(e.elem)
+	c.size -= e.size
+	c.removeBody(e)
+}
+
+func (c *edgeCache) removeBody(e *cacheEntry) {
+	if e.file != "" {
+		_ = os.Remove(e.file)
+	}
+}
+
+// invalidate drops every variant stored under key.
+func (c *edgeCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range append([]*cacheEntry(nil), c.entries[key]...) {
+		c.removeLocked(e)
+	}
+}
+
+// purge drops the entries whose path starts with prefix (all of them for
+// an empty prefix) and reports how many there were.
+func (c *edgeCache) purge(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		e := el.Value.(*cacheEntry)
+		el = el.Next()
+		if strings.HasPrefix(e.path, prefix) {
+			c.removeLocked(e)
+			n++
+		}
+	}
+	return n
+}
+
+// stats returns the number of stored responses and their total size.
+func (c *edgeCache) stats() (entries int, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), c.size
+}
+
+// close drops every entry and the cache's directory. It is safe on a nil
+// cache.
+func (c *edgeCache) close() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	c.entries = make(map[string][]*cacheEntry)
+	c.lru.Init()
+	c.size = 0
+	if c.dir != "" {
+		_ = os.RemoveAll(c.dir)
+	}
+}
+
+// cacheFillBody stores a response body in the cache once it has been read
+// to the end, unless it grows beyond limit.
+type cacheFillBody struct {
+	io.ReadCloser
+	cache *edgeCache
+	entry *cacheEntry
+	limit int64
+	buf   bytes.Buffer
+	done  bool
+}
+
+func (b *cacheFillBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.done {
+		return n, err
+	}
+	if int64(b.buf.Len()+n) > b.limit {
+		b.done = true
+		b.buf = bytes.Buffer{}
+		return n, err
+	}
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.done = true
+		b.cache.store(b.entry, b.buf.Bytes())
+	}
+	return n, err
+}
+
+// cacheControl holds Cache-Control directives by lowercased name.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, v := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				cc[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
@@ -0,0 +1,388 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"eosrift.com/eosrift/internal/basicauth"
+	"eosrift.com/eosrift/internal/control"
+)
+
+// handlerSession serves every stream with h and counts the streams opened.
+type handlerSession struct {
+	h     http.Handler
+	opens atomic.Int32
+}
+
+func (s *handlerSession) OpenStream() (net.Conn, error) {
+	s.opens.Add(1)
+	a, b := net.Pipe()
+	go func() {
+		defer b.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(b))
+		if err != nil {
+			return
+		}
+		rr := httptest.NewRecorder()
+		s.h.ServeHTTP(rr, req)
+		_ = rr.Result().Write(b)
+	}()
+	return a, nil
+}
+
+func (s *handlerSession) Close() error { return nil }
+
+func newCachedTunnel(t *testing.T, cfg Config, policy *control.CachePolicy, h http.HandlerFunc) (*handlerSession, *edgeCache, http.HandlerFunc) {
+	t.Helper()
+
+	cache, err := newEdgeCache(cfg, policy, "abcd1234")
+	if err != nil {
+		t.Fatalf("newEdgeCache: %v", err)
+	}
+	t.Cleanup(cache.close)
+
+	sess := &handlerSession{h: h}
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{Cache: cache}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	cfg.TunnelDomain = "tunnel.eosrift.test"
+	return sess, cache, httpTunnelProxyHandler(cfg, registry, nil, nil, nil)
+}
+
+func doCached(h http.HandlerFunc, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://example.test"+path, nil)
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	return rr
+}
+
+func TestEdgeCache_FreshHit(t *testing.T) {
+	t.Parallel()
+
+	for _, dir := range []string{"", t.TempDir()} {
+		sess, cache, h := newCachedTunnel(t, Config{HTTPCacheMaxBytes: 1 << 20, HTTPCacheDir: dir}, &control.CachePolicy{}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Etag", `"v1"`)
+			_, _ = fmt.Fprint(w, "hello "+r.URL.Path)
+		})
+
+		rr := doCached(h, http.MethodGet, "/app.js", nil)
+		if rr.Code != http.StatusOK || rr.Header().Get(cacheStatusHeader) != "MISS" || rr.Body.String() != "hello /app.js" {
+			t.Fatalf("first: %d %q %q", rr.Code, rr.Header().Get(cacheStatusHeader), rr.Body.String())
+		}
+
+		rr = doCached(h, http.MethodGet, "/app.js", nil)
+		if rr.Header().Get(cacheStatusHeader) != "HIT" || rr.Body.String() != "hello /app.js" || rr.Header().Get("Age") == "" {
+			t.Fatalf("second: %q %q, headers %v", rr.Header().Get(cacheStatusHeader), rr.Body.String(), rr.Header())
+		}
+		if got := sess.opens.Load(); got != 1 {
+			t.Fatalf("streams opened = %d, want 1 (hits must not reach the client)", got)
+		}
+
+		rr = doCached(h, http.MethodGet, "/app.js", http.Header{"If-None-Match": {`W/"v1"`}})
+		if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+			t.Fatalf("conditional: %d %q", rr.Code, rr.Body.String())
+		}
+		rr = doCached(h, http.MethodHead, "/app.js", nil)
+		if rr.Header().Get(cacheStatusHeader) != "HIT" || rr.Body.Len() != 0 {
+			t.Fatalf("head: %q %q", rr.Header().Get(cacheStatusHeader), rr.Body.String())
+		}
+		if got := sess.opens.Load(); got != 1 {
+			t.Fatalf("streams opened = %d, want 1", got)
+		}
+
+		if dir != "" {
+			files, _ := os.ReadDir(cache.dir)
+			if len(files) != 1 {
+				t.Fatalf("disk cache files = %d, want 1", len(files))
+			}
+			cache.close()
+			if _, err := os.Stat(cache.dir); !os.IsNotExist(err) {
+				t.Fatalf("cache dir not removed on close: %v", err)
+			}
+		}
+	}
+}
+
+func TestEdgeCache_Revalidate(t *testing.T) {
+	t.Parallel()
+
+	var conditional atomic.Int32
+	sess, _, h := newCachedTunnel(t, Config{HTTPCacheMaxBytes: 1 << 20}, &control.CachePolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Etag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = fmt.Fprint(w, strings.Repeat("x", 1000))
+	})
+
+	// A browser's own conditional request must not keep the cache empty.
+	rr := doCached(h, http.MethodGet, "/", http.Header{"If-None-Match": {`"v0"`}})
+	if rr.Code != http.StatusOK || rr.Header().Get(cacheStatusHeader) != "MISS" {
+		t.Fatalf("first: %d %q", rr.Code, rr.Header().Get(cacheStatusHeader))
+	}
+	rr = doCached(h, http.MethodGet, "/", nil)
+	if rr.Header().Get(cacheStatusHeader) != "HIT" || rr.Body.Len() != 1000 {
+		t.Fatalf("revalidated: %q, %d bytes", rr.Header().Get(cacheStatusHeader), rr.Body.Len())
+	}
+	if conditional.Load() != 1 || sess.opens.Load() != 2 {
+		t.Fatalf("conditional = %d, opens = %d; want 1, 2", conditional.Load(), sess.opens.Load())
+	}
+}
+
+func TestEdgeCache_Vary(t *testing.T) {
+	t.Parallel()
+
+	sess, _, h := newCachedTunnel(t, Config{HTTPCacheMaxBytes: 1 << 20}, &control.CachePolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = fmt.Fprint(w, r.Header.Get("Accept-Language"))
+	})
+
+	for i, lang := range []string{"en", "de", "en", "de"} {
+		rr := doCached(h, http.MethodGet, "/", http.Header{"Accept-Language": {lang}})
+		if rr.Body.String() != lang {
+			t.Fatalf("request %d: body = %q, want %q", i, rr.Body.String(), lang)
+		}
+		want := "MISS"
+		if i >= 2 {
+			want = "HIT"
+		}
+		if got := rr.Header().Get(cacheStatusHeader); got != want {
+			t.Fatalf("request %d (%s): %s, want %s", i, lang, got, want)
+		}
+	}
+	if got := sess.opens.Load(); got != 2 {
+		t.Fatalf("streams opened = %d, want 2", got)
+	}
+}
+
+func TestEdgeCache_NotStored(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		header http.Header
+		req    http.Header
+		method string
+		body   string
+	}{
+		{name: "private", header: http.Header{"Cache-Control": {"private, max-age=60"}}},
+		{name: "no-store", header: http.Header{"Cache-Control": {"no-store"}}},
+		{name: "set-cookie", header: http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}},
+		{name: "no freshness or validator", header: http.Header{}},
+		{name: "vary star", header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}},
+		{name: "request no-store", header: http.Header{"Cache-Control": {"max-age=60"}}, req: http.Header{"Cache-Control": {"no-store"}}},
+		{name: "authorization", header: http.Header{"Cache-Control": {"max-age=60"}}, req: http.Header{"Authorization": {"Bearer x"}}},
+		{name: "too large", header: http.Header{"Cache-Control": {"max-age=60"}}, body: strings.Repeat("x", 300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			sess, _, h := newCachedTunnel(t, Config{HTTPCacheMaxBytes: 1000}, &control.CachePolicy{}, func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+				_, _ = fmt.Fprint(w, "ok"+tt.body)
+			})
+			for i := 0; i < 2; i++ {
+				if rr := doCached(h, http.MethodGet, "/", tt.req); rr.Header().Get(cacheStatusHeader) != "MISS" {
+					t.Fatalf("request %d: %q, want MISS", i, rr.Header().Get(cacheStatusHeader))
+				}
+			}
+			if got := sess.opens.Load(); got != 2 {
+				t.Fatalf("streams opened = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestEdgeCache_EdgeAuthenticatedUsers(t *testing.T) {
+	t.Parallel()
+
+	alice, err := basicauth.ParseCredential("alice:wonderland")
+	if err != nil {
+		t.Fatalf("credential: %v", err)
+	}
+	bob, err := basicauth.ParseCredential("bob:builder")
+	if err != nil {
+		t.Fatalf("credential: %v", err)
+	}
+
+	for _, tt := range []struct {
+		cacheControl string
+		shared       bool
+	}{
+		{cacheControl: "max-age=60"},
+		{cacheControl: "max-age=60, must-revalidate"},
+		{cacheControl: "public, max-age=60", shared: true},
+		{cacheControl: "s-maxage=60", shared: true},
+	} {
+		cache, err := newEdgeCache(Config{HTTPCacheMaxBytes: 1 << 20}, &control.CachePolicy{}, "abcd1234")
+		if err != nil {
+			t.Fatalf("newEdgeCache: %v", err)
+		}
+		defer cache.close()
+
+		registry := NewTunnelRegistry()
+		if err := registry.RegisterHTTPTunnel("abcd1234", &handlerSession{h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", tt.cacheControl)
+			_, _ = fmt.Fprint(w, "hello "+r.Header.Get("X-User"))
+		})}, httpTunnelOptions{
+			Cache:            cache,
+			BasicAuthUsers:   []control.BasicAuthUser{alice, bob},
+			RequestHeaderAdd: []headerKV{{Name: "X-User", Value: "${basic_auth_user}"}},
+		}); err != nil {
+			t.Fatalf("register: %v", err)
+		}
+		h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+		do := func(user, pass string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "http://abcd1234.tunnel.eosrift.test/me", nil)
+			req.SetBasicAuth(user, pass)
+			rr := httptest.NewRecorder()
+			h(rr, req)
+			return rr
+		}
+
+		if rr := do("alice", "wonderland"); rr.Body.String() != "hello alice" {
+			t.Fatalf("%s: alice got %q", tt.cacheControl, rr.Body.String())
+		}
+		rr := do("bob", "builder")
+		if tt.shared {
+			if rr.Header().Get(cacheStatusHeader) != "HIT" {
+				t.Fatalf("%s: bob got %q, want HIT", tt.cacheControl, rr.Header().Get(cacheStatusHeader))
+			}
+			continue
+		}
+		if rr.Header().Get(cacheStatusHeader) != "MISS" || rr.Body.String() != "hello bob" {
+			t.Fatalf("%s: bob got %q (%s), want his own response", tt.cacheControl, rr.Body.String(), rr.Header().Get(cacheStatusHeader))
+		}
+	}
+}
+
+func TestEdgeCache_DefaultTTLAndInvalidation(t *testing.T) {
+	t.Parallel()
+
+	sess, cache, h := newCachedTunnel(t, Config{HTTPCacheMaxBytes: 1 << 20}, &control.CachePolicy{DefaultTTLSeconds: 60}, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "ok")
+	})
+
+	doCached(h, http.MethodGet, "/a", nil)
+	doCached(h, http.MethodGet, "/b/c", nil)
+	if rr := doCached(h, http.MethodGet, "/a", nil); rr.Header().Get(cacheStatusHeader) != "HIT" {
+		t.Fatalf("default TTL: %q, want HIT", rr.Header().Get(cacheStatusHeader))
+	}
+
+	doCached(h, http.MethodPost, "/a", nil)
+	if rr := doCached(h, http.MethodGet, "/a", nil); rr.Header().Get(cacheStatusHeader) != "MISS" {
+		t.Fatalf("after POST: %q, want MISS", rr.Header().Get(cacheStatusHeader))
+	}
+	if got := sess.opens.Load(); got != 4 {
+		t.Fatalf("streams opened = %d, want 4", got)
+	}
+
+	if n := cache.purge("/b/"); n != 1 {
+		t.Fatalf("purge = %d, want 1", n)
+	}
+	if entries, _ := cache.stats(); entries != 1 {
+		t.Fatalf("entries after purge = %d, want 1", entries)
+	}
+}
+
+func TestEdgeCache_Eviction(t *testing.T) {
+	t.Parallel()
+
+	_, cache, h := newCachedTunnel(t, Config{HTTPCacheMaxBytes: 1000}, &control.CachePolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = fmt.Fprint(w, strings.Repeat("x", 200))
+	})
+
+	for _, p := range []string{"/1", "/2", "/3", "/4", "/5", "/6", "/1"} {
+		doCached(h, http.MethodGet, p, nil)
+	}
+	entries, size := cache.stats()
+	if size > 1000 || entries != 5 {
+		t.Fatalf("entries = %d, size = %d; want 5 within 1000 bytes", entries, size)
+	}
+	if rr := doCached(h, http.MethodGet, "/1", nil); rr.Header().Get(cacheStatusHeader) != "HIT" {
+		t.Fatalf("most recent entry evicted")
+	}
+	if rr := doCached(h, http.MethodGet, "/2", nil); rr.Header().Get(cacheStatusHeader) != "MISS" {
+		t.Fatalf("least recently used entry kept")
+	}
+}
+
+func TestNewEdgeCache_Disabled(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newEdgeCache(Config{}, &control.CachePolicy{}, "abcd1234"); err == nil {
+		t.Fatalf("expected error when the server disables the edge cache")
+	}
+	c, err := newEdgeCache(Config{HTTPCacheMaxBytes: 1000}, &control.CachePolicy{MaxBytes: 5000}, "abcd1234")
+	if err != nil || c.maxBytes != 1000 {
+		t.Fatalf("cap: %v, %v", c, err)
+	}
+}
+
+func TestNewHandler_AdminAPI_TunnelCache(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{BaseDomain: "eosrift.com", TunnelDomain: "tunnel.eosrift.com", AdminToken: "admin-secret", HTTPCacheMaxBytes: 1 << 20}
+	srv := New(cfg, Dependencies{AdminStore: newStubAdminStore()})
+	cache, err := newEdgeCache(cfg, &control.CachePolicy{}, "demo")
+	if err != nil {
+		t.Fatalf("newEdgeCache: %v", err)
+	}
+	cache.store(&cacheEntry{key: "k", path: "/x", status: http.StatusOK, header: http.Header{}}, []byte("abc"))
+	if err := srv.registry.RegisterHTTPTunnel("demo", &headerSession{gotCh: make(chan http.Header, 1)}, httpTunnelOptions{Cache: cache}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := srv.Handler()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://eosrift.com"+path, nil)
+		req.Host = "eosrift.com"
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rr := do(http.MethodGet, "/api/admin/tunnels/demo/cache")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"entries":1`) || !strings.Contains(rr.Body.String(), `"storage":"memory"`) {
+		t.Fatalf("stats: %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodDelete, "/api/admin/tunnels/demo/cache?path=x"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad path: status = %d", rr.Code)
+	}
+	rr = do(http.MethodDelete, "/api/admin/tunnels/demo/cache")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"purged":1`) {
+		t.Fatalf("purge: %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/admin/tunnels/nope/cache"); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown tunnel: status = %d", rr.Code)
+	}
+
+	srv.registry.UnregisterHTTPTunnel("demo")
+	if !cache.closed {
+		t.Fatalf("cache not closed when the tunnel was unregistered")
+	}
+}
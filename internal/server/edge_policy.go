@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"eosrift.com/eosrift/internal/basicauth"
+	"eosrift.com/eosrift/internal/control"
+	"gopkg.in/yaml.v3"
+)
+
+const maxEdgePolicyBytes = 1 << 20
+
+// EdgePolicy is the operator's server-wide policy. Tunnels cannot opt out of
+// it. It is applied in this order:
+//
+//  1. deny_cidrs, for HTTP and TCP tunnels, before any tunnel setting
+//  2. max_request_body_bytes (413)
+//  3. basic_auth for the listed tokens' HTTP tunnels; it replaces the
+//     tunnel's own basic auth and JWT checks, and share links do not bypass it
+//  4. the tunnel's own settings
+//  5. response_headers, set on every HTTP tunnel response after the tunnel's
+//     own header rules
+//
+// A nil *EdgePolicy applies nothing.
+type EdgePolicy struct {
+	denyCIDRs       []netip.Prefix
+	maxBodyBytes    int64
+	basicAuth       []edgeBasicAuth
+	responseHeaders http.Header
+}
+
+type edgeBasicAuth struct {
+	tokenIDs []int64 // empty: every tunnel
+	gate     *basicAuthGate
+}
+
+// edgePolicyFile is the YAML/JSON form of an EdgePolicy.
+type edgePolicyFile struct {
+	DenyCIDRs           []string            `yaml:"deny_cidrs"`
+	MaxRequestBodyBytes int64               `yaml:"max_request_body_bytes"`
+	BasicAuth           []edgeBasicAuthFile `yaml:"basic_auth"`
+	ResponseHeaders     map[string]string   `yaml:"response_headers"`
+}
+
+type edgeBasicAuthFile struct {
+	TokenIDs     []int64                 `yaml:"token_ids"`
+	Users        []control.BasicAuthUser `yaml:"users"`
+	HtpasswdFile string                  `yaml:"htpasswd_file"`
+}
+
+// EdgePolicyFromEnv loads the policy named by EOSRIFT_EDGE_POLICY_FILE, or
+// the inline YAML/JSON in EOSRIFT_EDGE_POLICY. It returns nil when neither is
+// set.
+func EdgePolicyFromEnv() (*EdgePolicy, error) {
+	path := strings.TrimSpace(os.Getenv("EOSRIFT_EDGE_POLICY_FILE"))
+	inline := strings.TrimSpace(os.Getenv("EOSRIFT_EDGE_POLICY"))
+	switch {
+	case path != "" && inline != "":
+		return nil, errors.New("set only one of EOSRIFT_EDGE_POLICY_FILE and EOSRIFT_EDGE_POLICY")
+	case path != "":
+		return LoadEdgePolicy(path)
+	case inline != "":
+		return ParseEdgePolicy([]byte(inline))
+	}
+	return nil, nil
+}
+
+func LoadEdgePolicy(path string) (*EdgePolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, maxEdgePolicyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxEdgePolicyBytes {
+		return nil, fmt.Errorf("edge policy %s: file too large", path)
+	}
+	p, err := ParseEdgePolicy(b)
+	if err != nil {
+		return nil, fmt.Errorf("edge policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// ParseEdgePolicy parses and validates a YAML (or JSON) edge policy.
+func ParseEdgePolicy(b []byte) (*EdgePolicy, error) {
+	var raw edgePolicyFile
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&raw); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid edge policy: %v", err)
+	}
+
+	deny, err := control.ParseCIDRList("deny_cidrs", raw.DenyCIDRs, 0)
+	if err != nil {
+		return nil, err
+	}
+	if raw.MaxRequestBodyBytes < 0 {
+		return nil, errors.New("invalid max_request_body_bytes: must not be negative")
+	}
+
+	p := &EdgePolicy{denyCIDRs: deny, maxBodyBytes: raw.MaxRequestBodyBytes}
+
+	for i, ba := range raw.BasicAuth {
+		field := fmt.Sprintf("basic_auth[%d]", i)
+		users := ba.Users
+		if ba.HtpasswdFile != "" {
+			fromFile, err := basicauth.LoadHtpasswd(ba.HtpasswdFile)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s.htpasswd_file: %v", field, err)
+			}
+			users = append(users, fromFile...)
+		}
+		if len(users) == 0 {
+			return nil, fmt.Errorf("invalid %s: no users", field)
+		}
+		users, err := basicauth.ValidateUsers(users)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", field, err)
+		}
+		for _, id := range ba.TokenIDs {
+			if id <= 0 {
+				return nil, fmt.Errorf("invalid %s.token_ids: %d", field, id)
+			}
+		}
+		p.basicAuth = append(p.basicAuth, edgeBasicAuth{
+			tokenIDs: ba.TokenIDs,
+			gate:     newBasicAuthGate(nil, basicauth.NewVerifier(users)),
+		})
+	}
+
+	if len(raw.ResponseHeaders) > 0 {
+		p.responseHeaders = make(http.Header, len(raw.ResponseHeaders))
+		for name, value := range raw.ResponseHeaders {
+			k, err := control.NormalizeHeaderName("response_headers", name)
+			if err != nil {
+				return nil, err
+			}
+			v, err := control.ValidateHeaderValue("response_headers."+k, value, value)
+			if err != nil {
+				return nil, err
+			}
+			p.responseHeaders.Set(k, v)
+		}
+	}
+	return p, nil
+}
+
+// denies reports whether ip is in deny_cidrs.
+func (p *EdgePolicy) denies(ip netip.Addr) bool {
+	return p != nil && ip.IsValid() && cidrListContains(p.denyCIDRs, ip)
+}
+
+// basicAuthFor returns the basic auth gate enforced on tunnels of tokenID,
+// or nil. The first matching entry wins.
+func (p *EdgePolicy) basicAuthFor(tokenID int64) *basicAuthGate {
+	if p == nil {
+		return nil
+	}
+	for _, ba := range p.basicAuth {
+		if len(ba.tokenIDs) == 0 || slices.Contains(ba.tokenIDs, tokenID) {
+			return ba.gate
+		}
+	}
+	return nil
+}
+
+// maxRequestBody returns max_request_body_bytes (0 = unlimited).
+func (p *EdgePolicy) maxRequestBody() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.maxBodyBytes
+}
+
+// wrapResponse makes w set response_headers on every response.
+func (p *EdgePolicy) wrapResponse(w http.ResponseWriter) http.ResponseWriter {
+	if p == nil || len(p.responseHeaders) == 0 {
+		return w
+	}
+	return &edgeHeaderWriter{ResponseWriter: w, headers: p.responseHeaders}
+}
+
+// edgeHeaderWriter sets headers right before the status line is written, so
+// they replace whatever the upstream or the tunnel's rules produced.
+type edgeHeaderWriter struct {
+	http.ResponseWriter
+	headers http.Header
+	wrote   bool
+}
+
+func (w *edgeHeaderWriter) WriteHeader(code int) {
+	if !w.wrote {
+		h := w.ResponseWriter.Header()
+		for k, v := range w.headers {
+			h[k] = slices.Clone(v)
+		}
+		w.wrote = code >= http.StatusOK || code == http.StatusSwitchingProtocols
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *edgeHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController (used by ReverseProxy to flush and
+// to hijack for upgrades) reach the underlying writer.
+func (w *edgeHeaderWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"eosrift.com/eosrift/internal/basicauth"
+	"eosrift.com/eosrift/internal/control"
+)
+
+func mustEdgePolicy(t *testing.T, yaml string) *EdgePolicy {
+	t.Helper()
+
+	p, err := ParseEdgePolicy([]byte(yaml))
+	if err != nil {
+		t.Fatalf("ParseEdgePolicy: %v", err)
+	}
+	return p
+}
+
+func TestParseEdgePolicy(t *testing.T) {
+	t.Parallel()
+
+	hash, err := basicauth.Hash("pw")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	htpasswd := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(htpasswd, []byte("ops:"+hash+"\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	p := mustEdgePolicy(t, `
+deny_cidrs: [203.0.113.0/24, 2001:db8::1]
+max_request_body_bytes: 1024
+basic_auth:
+  - token_ids: [3]
+    htpasswd_file: `+htpasswd+`
+response_headers:
+  x-robots-tag: noindex
+`)
+	if !p.denies(netip.MustParseAddr("203.0.113.9")) || p.denies(netip.MustParseAddr("198.51.100.1")) {
+		t.Fatalf("deny_cidrs not applied")
+	}
+	if p.maxBodyBytes != 1024 {
+		t.Fatalf("maxBodyBytes = %d, want 1024", p.maxBodyBytes)
+	}
+	if p.basicAuthFor(3) == nil || p.basicAuthFor(4) != nil {
+		t.Fatalf("basic auth token match wrong")
+	}
+	if got := p.responseHeaders.Get("X-Robots-Tag"); got != "noindex" {
+		t.Fatalf("X-Robots-Tag = %q, want noindex", got)
+	}
+
+	if p := mustEdgePolicy(t, `{"max_request_body_bytes": 5}`); p.maxBodyBytes != 5 {
+		t.Fatalf("JSON policy: maxBodyBytes = %d, want 5", p.maxBodyBytes)
+	}
+	if p := mustEdgePolicy(t, ""); p.denies(netip.MustParseAddr("203.0.113.9")) {
+		t.Fatalf("empty policy denies")
+	}
+
+	for _, bad := range []string{
+		`deny_cidrs: [nope]`,
+		`max_request_body_bytes: -1`,
+		`basic_auth: [{token_ids: [1]}]`,
+		`basic_auth: [{users: [{user: ops, hash: plaintext}]}]`,
+		`basic_auth: [{token_ids: [0], users: [{user: ops, hash: "` + hash + `"}]}]`,
+		`response_headers: {"Content-Length": "1"}`,
+		`response_headers: {"X-A": "a\nb"}`,
+		`unknown_field: 1`,
+	} {
+		if _, err := ParseEdgePolicy([]byte(bad)); err == nil {
+			t.Errorf("ParseEdgePolicy(%q) = nil error", bad)
+		}
+	}
+}
+
+func TestEdgePolicyFromEnv(t *testing.T) {
+	t.Setenv("EOSRIFT_EDGE_POLICY_FILE", "")
+	t.Setenv("EOSRIFT_EDGE_POLICY", "")
+	if p, err := EdgePolicyFromEnv(); err != nil || p != nil {
+		t.Fatalf("unset: %v, %v; want nil, nil", p, err)
+	}
+
+	t.Setenv("EOSRIFT_EDGE_POLICY", `deny_cidrs: ["10.0.0.0/8"]`)
+	p, err := EdgePolicyFromEnv()
+	if err != nil || !p.denies(netip.MustParseAddr("10.1.2.3")) {
+		t.Fatalf("inline: %v, %v", p, err)
+	}
+
+	t.Setenv("EOSRIFT_EDGE_POLICY_FILE", "/nonexistent")
+	if _, err := EdgePolicyFromEnv(); err == nil {
+		t.Fatalf("both set: want error")
+	}
+}
+
+func TestHTTPTunnel_EdgePolicy(t *testing.T) {
+	t.Parallel()
+
+	hash, err := basicauth.Hash("ops-pw")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	cfg := Config{
+		TunnelDomain: "tunnel.eosrift.test",
+		EdgePolicy: mustEdgePolicy(t, `
+deny_cidrs: [203.0.113.0/24]
+max_request_body_bytes: 8
+basic_auth:
+  - token_ids: [7]
+    users: [{user: ops, hash: "`+hash+`"}]
+response_headers:
+  X-Content-Type-Options: nosniff
+  X-Robots-Tag: noindex
+`),
+	}
+
+	registry := NewTunnelRegistry()
+	open := &headerSession{gotCh: make(chan http.Header, 4)}
+	if err := registry.RegisterHTTPTunnel("open1234", open, httpTunnelOptions{
+		TokenID:           1,
+		ResponseHeaderAdd: []headerKV{{Name: "X-Robots-Tag", Value: "all"}},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	locked := &headerSession{gotCh: make(chan http.Header, 4)}
+	if err := registry.RegisterHTTPTunnel("lock1234", locked, httpTunnelOptions{
+		TokenID:   7,
+		BasicAuth: &basicAuthCredential{Username: "user", Password: "pass"},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(cfg, registry, nil, nil, nil)
+
+	do := func(id, remote string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+		method := http.MethodGet
+		if body != nil {
+			method = http.MethodPost
+		}
+		req := httptest.NewRequest(method, "http://example.test/", body)
+		req.Host = id + ".tunnel.eosrift.test"
+		req.RemoteAddr = remote
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	rr := do("open1234", "198.51.100.1:1234", nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("allowed: status = %d, want 200", rr.Code)
+	}
+	<-open.gotCh
+	if got := rr.Header().Values("X-Robots-Tag"); len(got) != 1 || got[0] != "noindex" {
+		t.Fatalf("X-Robots-Tag = %q, want edge value to override the tunnel's", got)
+	}
+
+	rr = do("open1234", "203.0.113.5:1234", nil, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("denied CIDR: status = %d, want 403", rr.Code)
+	}
+	if rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("edge headers missing on edge-generated response")
+	}
+
+	if rr := do("open1234", "198.51.100.1:1234", strings.NewReader("0123456789"), nil); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large body: status = %d, want 413", rr.Code)
+	}
+	chunked := io.MultiReader(strings.NewReader("0123456789"))
+	if rr := do("open1234", "198.51.100.1:1234", chunked, http.Header{"Transfer-Encoding": {"chunked"}}); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large chunked body: status = %d, want 413", rr.Code)
+	}
+
+	// Forced basic auth replaces the tunnel's own credentials.
+	if rr := do("lock1234", "198.51.100.1:1234", nil, http.Header{"Authorization": {basicAuthHeader("user", "pass")}}); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("tunnel credentials: status = %d, want 401", rr.Code)
+	}
+	rr = do("lock1234", "198.51.100.1:1234", nil, http.Header{"Authorization": {basicAuthHeader("ops", "ops-pw")}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("edge credentials: status = %d, want 200", rr.Code)
+	}
+	if got := (<-locked.gotCh).Get("Authorization"); got != "" {
+		t.Fatalf("upstream Authorization = %q, want stripped", got)
+	}
+}
+
+func TestHTTPTunnel_EdgePolicyRunsBeforeTunnelAccess(t *testing.T) {
+	t.Parallel()
+
+	hash, err := basicauth.Hash("ops-pw")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	cfg := Config{
+		TunnelDomain: "tunnel.eosrift.test",
+		EdgePolicy: mustEdgePolicy(t, `
+max_request_body_bytes: 8
+basic_auth:
+  - token_ids: [7]
+    users: [{user: ops, hash: "`+hash+`"}]
+`),
+	}
+
+	registry := NewTunnelRegistry()
+	sess := &recordingSession{}
+	if err := registry.RegisterHTTPTunnel("lock1234", sess, httpTunnelOptions{
+		TokenID:      7,
+		AllowMethods: []string{http.MethodGet},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(cfg, registry, nil, nil, nil)
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		header http.Header
+		want   int
+	}{
+		{name: "edge body limit", body: "0123456789", want: http.StatusRequestEntityTooLarge},
+		{name: "edge basic auth", body: "0123", want: http.StatusUnauthorized},
+		{name: "tunnel method rule", body: "0123", header: http.Header{"Authorization": {basicAuthHeader("ops", "ops-pw")}}, want: http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://lock1234.tunnel.eosrift.test/", strings.NewReader(tc.body))
+		req.RemoteAddr = "198.51.100.1:1234"
+		for k, v := range tc.header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		if rr.Code != tc.want {
+			t.Fatalf("%s: status = %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+	if sess.openCount.Load() != 0 {
+		t.Fatalf("open count = %d, want 0", sess.openCount.Load())
+	}
+}
+
+func TestTCPTunnelEntry_EdgeDeny(t *testing.T) {
+	t.Parallel()
+
+	edge := mustEdgePolicy(t, `deny_cidrs: [203.0.113.0/24]`)
+	allow, err := control.ParseCIDRList("allow_cidr", []string{"203.0.113.0/24"}, 0)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	e := tcpTunnelEntry{allowCIDRs: allow, edge: edge}
+	if e.allows(netip.MustParseAddr("203.0.113.7")) {
+		t.Fatalf("edge deny did not take precedence over the tunnel allowlist")
+	}
+	if !(tcpTunnelEntry{edge: edge}).allows(netip.MustParseAddr("198.51.100.1")) {
+		t.Fatalf("address outside the deny list rejected")
+	}
+}
@@ -14,7 +14,10 @@ import (
 	"time"
 
 	"eosrift.com/eosrift/internal/auth"
+	"eosrift.com/eosrift/internal/control"
 	"eosrift.com/eosrift/internal/logging"
+	"eosrift.com/eosrift/internal/prom"
+	"eosrift.com/eosrift/internal/tracing"
 )
 
 type Config struct {
@@ -30,13 +33,27 @@ type Config struct {
 	TCPPortRangeStart int
 	TCPPortRangeEnd   int
 
+	// TCPBindAddrs are the local addresses TCP tunnel listeners bind to
+	// (IPv4 or IPv6 literals). Empty means all interfaces.
+	TCPBindAddrs []string
+
 	// MetricsToken enables /metrics when set (requires Authorization: Bearer <token>).
 	MetricsToken string
 
+	// MetricsTokenLabels caps how many authtokens get their own token label
+	// on per-token metrics; later ones share token="other". Zero drops the
+	// token label.
+	MetricsTokenLabels int
+
 	// AdminToken enables /admin and /api/admin/... when set
 	// (requires Authorization: Bearer <token> for API requests).
 	AdminToken string
 
+	// AdminDomain serves /admin and /api/admin/ on its own host instead of
+	// BaseDomain. Path routing requires it when the admin UI is enabled,
+	// since tunnels share BaseDomain's origin (see ValidateAdminDomain).
+	AdminDomain string
+
 	// MaxTunnelsPerToken caps concurrent tunnels per authtoken.
 	// Zero means unlimited.
 	MaxTunnelsPerToken int
@@ -45,6 +62,27 @@ type Config struct {
 	// Zero means unlimited.
 	MaxTunnelCreatesPerMinute int
 
+	// MaxTunnelLifetime closes agent tunnels this long after they connect;
+	// zero means unlimited. MaxTunnelLifetimeByClass overrides it for
+	// tokens of a class (zero again meaning unlimited).
+	MaxTunnelLifetime        time.Duration
+	MaxTunnelLifetimeByClass map[string]time.Duration
+
+	// TunnelIdleTimeout closes agent tunnels that have carried no traffic
+	// for this long, even with connections open. Zero disables it.
+	TunnelIdleTimeout time.Duration
+
+	// TunnelBandwidthIn and TunnelBandwidthOut (bytes per second) pace each
+	// agent tunnel and are the defaults and ceilings for the rates tunnels
+	// ask for; TokenBandwidthIn/Out pace all tunnels of one authtoken
+	// together. BandwidthBurst caps bursts (zero: one second's worth of the
+	// rate). Zero rates are unlimited.
+	TunnelBandwidthIn  int64
+	TunnelBandwidthOut int64
+	TokenBandwidthIn   int64
+	TokenBandwidthOut  int64
+	BandwidthBurst     int64
+
 	// DBPath is the path to the SQLite database.
 	DBPath string
 
@@ -55,6 +93,67 @@ type Config struct {
 	// DeployStatusPath is an optional JSON file containing the latest deployhook status.
 	// If empty, deploy status is disabled in the admin API/UI.
 	DeployStatusPath string
+
+	// SSHAddr enables the agentless SSH gateway (ssh -R) when set, e.g. ":2222".
+	SSHAddr string
+
+	// SSHHostKeyPath is the SSH gateway host key. A new ed25519 key is
+	// generated there on first start when the file does not exist.
+	SSHHostKeyPath string
+
+	// HTTPRouting selects how HTTP tunnels are addressed:
+	// "subdomain" (default, <id>.<tunnel-domain>) or "path"
+	// (<base-domain>/t/<id>/, for installs without wildcard DNS/certs).
+	HTTPRouting string
+
+	// ShareLinkSecret signs tunnel share links. When empty, New generates a
+	// random secret, so links stop working when the server restarts.
+	ShareLinkSecret string
+
+	// BrowserWarning shows browser visitors an interstitial warning page
+	// once per tunnel before proxying. Tokens and reserved subdomains can be
+	// exempted via the admin API.
+	BrowserWarning bool
+
+	// EdgePolicy holds operator rules applied to every tunnel (see
+	// EdgePolicy). It is loaded separately with EdgePolicyFromEnv.
+	EdgePolicy *EdgePolicy
+
+	// Server defaults for HTTP tunnel requests. Tunnels may ask for lower
+	// values, never higher ones. Zero disables a limit.
+	HTTPMaxRequestBodyBytes   int64
+	HTTPResponseHeaderTimeout time.Duration
+	HTTPRequestTimeout        time.Duration
+	HTTPWebSocketIdleTimeout  time.Duration
+
+	// HTTPCacheMaxBytes is the default and largest edge cache size of a
+	// tunnel; zero disables the edge cache. HTTPCacheDir keeps cached bodies
+	// on disk instead of in memory.
+	HTTPCacheMaxBytes int64
+	HTTPCacheDir      string
+
+	// AccessLog selects where access logs of tunnel traffic go: "" or
+	// "off", "stdout", or "file" (AccessLogPath, rotated once it reaches
+	// AccessLogMaxBytes, keeping AccessLogMaxFiles old files).
+	// AccessLogFormat is "json" (default) or "text". See OpenAccessLog.
+	AccessLog         string
+	AccessLogPath     string
+	AccessLogMaxBytes int64
+	AccessLogMaxFiles int
+	AccessLogFormat   string
+
+	// OTLPEndpoint is the OpenTelemetry collector (OTLP/HTTP) that tunnel
+	// HTTP request spans are exported to; empty disables tracing.
+	// TraceSampleRatio is the fraction of new traces recorded (0..1);
+	// requests that arrive with a traceparent follow its sampled flag.
+	OTLPEndpoint     string
+	TraceSampleRatio float64
+
+	// RequestIDHeader names the header that carries each tunnel request's
+	// id (default X-Request-Id). A valid id sent by the visitor is kept;
+	// otherwise the edge assigns one. It is forwarded to the upstream,
+	// echoed in the response and logged.
+	RequestIDHeader string
 }
 
 func ConfigFromEnv() Config {
@@ -66,22 +165,125 @@ func ConfigFromEnv() Config {
 
 		TCPPortRangeStart: getenvInt("EOSRIFT_TCP_PORT_RANGE_START", 20000),
 		TCPPortRangeEnd:   getenvInt("EOSRIFT_TCP_PORT_RANGE_END", 40000),
+		TCPBindAddrs:      getenvList("EOSRIFT_TCP_BIND_ADDRS"),
 
 		MetricsToken: strings.TrimSpace(os.Getenv("EOSRIFT_METRICS_TOKEN")),
 		AdminToken:   strings.TrimSpace(os.Getenv("EOSRIFT_ADMIN_TOKEN")),
+		AdminDomain:  strings.TrimSpace(os.Getenv("EOSRIFT_ADMIN_DOMAIN")),
+
+		MetricsTokenLabels: getenvInt("EOSRIFT_METRICS_TOKEN_LABELS", 100),
 
 		MaxTunnelsPerToken: getenvInt("EOSRIFT_MAX_TUNNELS_PER_TOKEN", 0),
 
 		MaxTunnelCreatesPerMinute: getenvInt("EOSRIFT_MAX_TUNNEL_CREATES_PER_MIN", 0),
 
+		MaxTunnelLifetime:        getenvDuration("EOSRIFT_MAX_TUNNEL_LIFETIME", 0),
+		MaxTunnelLifetimeByClass: getenvDurationMap("EOSRIFT_MAX_TUNNEL_LIFETIME_BY_CLASS"),
+		TunnelIdleTimeout:        getenvDuration("EOSRIFT_TUNNEL_IDLE_TIMEOUT", 0),
+
+		TunnelBandwidthIn:  getenvByteRate("EOSRIFT_TUNNEL_BANDWIDTH_IN"),
+		TunnelBandwidthOut: getenvByteRate("EOSRIFT_TUNNEL_BANDWIDTH_OUT"),
+		TokenBandwidthIn:   getenvByteRate("EOSRIFT_TOKEN_BANDWIDTH_IN"),
+		TokenBandwidthOut:  getenvByteRate("EOSRIFT_TOKEN_BANDWIDTH_OUT"),
+		BandwidthBurst:     getenvByteSize("EOSRIFT_BANDWIDTH_BURST", 0),
+
 		DBPath: strings.TrimSpace(os.Getenv("EOSRIFT_DB_PATH")),
 
 		AuthToken: strings.TrimSpace(os.Getenv("EOSRIFT_AUTH_TOKEN")),
 
 		DeployStatusPath: strings.TrimSpace(os.Getenv("EOSRIFT_DEPLOY_STATUS_PATH")),
+
+		SSHAddr:        strings.TrimSpace(os.Getenv("EOSRIFT_SSH_ADDR")),
+		SSHHostKeyPath: strings.TrimSpace(os.Getenv("EOSRIFT_SSH_HOST_KEY_PATH")),
+
+		HTTPRouting: strings.ToLower(strings.TrimSpace(os.Getenv("EOSRIFT_HTTP_ROUTING"))),
+
+		ShareLinkSecret: strings.TrimSpace(os.Getenv("EOSRIFT_SHARE_LINK_SECRET")),
+
+		BrowserWarning: getenvBool("EOSRIFT_BROWSER_WARNING", false),
+
+		HTTPMaxRequestBodyBytes:   getenvByteSize("EOSRIFT_HTTP_MAX_REQUEST_BODY", 0),
+		HTTPResponseHeaderTimeout: getenvDuration("EOSRIFT_HTTP_RESPONSE_HEADER_TIMEOUT", 0),
+		HTTPRequestTimeout:        getenvDuration("EOSRIFT_HTTP_REQUEST_TIMEOUT", 0),
+		HTTPWebSocketIdleTimeout:  getenvDuration("EOSRIFT_HTTP_WEBSOCKET_IDLE_TIMEOUT", 0),
+
+		HTTPCacheMaxBytes: getenvByteSize("EOSRIFT_HTTP_CACHE_MAX_BYTES", 64<<20),
+		HTTPCacheDir:      strings.TrimSpace(os.Getenv("EOSRIFT_HTTP_CACHE_DIR")),
+
+		AccessLog:         strings.ToLower(strings.TrimSpace(os.Getenv("EOSRIFT_ACCESS_LOG"))),
+		AccessLogPath:     strings.TrimSpace(os.Getenv("EOSRIFT_ACCESS_LOG_PATH")),
+		AccessLogMaxBytes: getenvByteSize("EOSRIFT_ACCESS_LOG_MAX_SIZE", 100<<20),
+		AccessLogMaxFiles: getenvInt("EOSRIFT_ACCESS_LOG_MAX_FILES", 5),
+		AccessLogFormat:   strings.ToLower(strings.TrimSpace(os.Getenv("EOSRIFT_ACCESS_LOG_FORMAT"))),
+
+		OTLPEndpoint:     strings.TrimSpace(os.Getenv("EOSRIFT_OTLP_ENDPOINT")),
+		TraceSampleRatio: getenvFloat("EOSRIFT_TRACE_SAMPLE_RATIO", 1),
+
+		RequestIDHeader: strings.TrimSpace(os.Getenv("EOSRIFT_REQUEST_ID_HEADER")),
+	}
+}
+
+const (
+	HTTPRoutingSubdomain = "subdomain"
+	HTTPRoutingPath      = "path"
+)
+
+// ValidateHTTPRouting checks an EOSRIFT_HTTP_ROUTING value (empty means
+// subdomain routing).
+func ValidateHTTPRouting(mode string) error {
+	switch mode {
+	case "", HTTPRoutingSubdomain, HTTPRoutingPath:
+		return nil
+	default:
+		return fmt.Errorf("invalid http routing mode %q (want subdomain or path)", mode)
 	}
 }
 
+func (c Config) pathRouting() bool {
+	return c.HTTPRouting == HTTPRoutingPath
+}
+
+// ValidateAdminDomain checks that the admin UI, which keeps the admin token
+// in the browser, does not share an origin with tunnel content: with path
+// routing every tunnel is served from BaseDomain, so an enabled admin UI
+// needs its own AdminDomain. AdminDomain must not be a tunnel host either.
+func ValidateAdminDomain(cfg Config) error {
+	if strings.TrimSpace(cfg.AdminToken) == "" {
+		return nil
+	}
+	admin := normalizeDomain(cfg.adminDomain())
+	if cfg.pathRouting() && admin == normalizeDomain(cfg.BaseDomain) {
+		return errors.New("path routing serves tunnels on the base domain, the admin UI's origin: set EOSRIFT_ADMIN_DOMAIN to a separate host or unset EOSRIFT_ADMIN_TOKEN")
+	}
+	if _, ok := tunnelIDFromHost(admin, cfg.TunnelDomain); ok {
+		return fmt.Errorf("admin domain %q is a tunnel host", cfg.AdminDomain)
+	}
+	return nil
+}
+
+// adminDomain is the host serving the admin UI and API.
+func (c Config) adminDomain() string {
+	if c.AdminDomain != "" {
+		return c.AdminDomain
+	}
+	return c.BaseDomain
+}
+
+func (c Config) requestIDHeader() string {
+	if c.RequestIDHeader == "" {
+		return "X-Request-Id"
+	}
+	return http.CanonicalHeaderKey(c.RequestIDHeader)
+}
+
+// httpTunnelURL is the public URL advertised for an HTTP tunnel.
+func (c Config) httpTunnelURL(id string) string {
+	if c.pathRouting() {
+		return fmt.Sprintf("https://%s%s/", strings.TrimSuffix(c.BaseDomain, "."), pathRoutingPrefix(id))
+	}
+	return fmt.Sprintf("https://%s.%s", id, strings.TrimSuffix(c.TunnelDomain, "."))
+}
+
 type TokenValidator interface {
 	ValidateToken(ctx context.Context, token string) (bool, error)
 }
@@ -98,21 +300,87 @@ type ReservationStore interface {
 	ReserveTCPPort(ctx context.Context, tokenID int64, port int) error
 }
 
+// SSHKeyStore resolves SSH public keys (by SHA256 fingerprint) to token ids
+// for the SSH gateway.
+type SSHKeyStore interface {
+	SSHKeyTokenID(ctx context.Context, fingerprint string) (int64, bool, error)
+}
+
+// BrowserWarningStore records which tokens and reserved subdomains skip the
+// browser warning interstitial.
+type BrowserWarningStore interface {
+	BrowserWarningExempt(ctx context.Context, tokenID int64, subdomain string) (bool, error)
+	ListBrowserWarningExemptions(ctx context.Context) ([]auth.BrowserWarningExemption, error)
+	SetBrowserWarningTokenExempt(ctx context.Context, tokenID int64, exempt bool) error
+	SetBrowserWarningSubdomainExempt(ctx context.Context, subdomain string, exempt bool) error
+}
+
 type Dependencies struct {
-	TokenValidator TokenValidator
-	TokenResolver  TokenResolver
-	Reservations   ReservationStore
-	AdminStore     AdminStore
-	Logger         logging.Logger
+	TokenValidator  TokenValidator
+	TokenResolver   TokenResolver
+	Reservations    ReservationStore
+	AdminStore      AdminStore
+	SSHKeys         SSHKeyStore
+	BrowserWarnings BrowserWarningStore
+	TokenClasses    TokenClassStore
+	Logger          logging.Logger
+
+	// AccessLog receives one record per proxied HTTP request and per TCP
+	// connection (see OpenAccessLog); nil disables access logs.
+	AccessLog logging.Logger
+
+	// Tracer records spans of tunnel HTTP requests (see OpenTracer); nil
+	// disables tracing.
+	Tracer *tracing.Tracer
+}
+
+// Server holds the tunnel state shared by the HTTP edge/control endpoint and
+// the optional SSH gateway.
+type Server struct {
+	cfg  Config
+	deps Dependencies
+
+	registry    *TunnelRegistry
+	ports       *tcpPortPool
+	limiter     *tokenTunnelLimiter
+	rateLimiter *tokenRateLimiter
+	bandwidth   *tokenBandwidthPool
+	metrics     *metrics
+}
+
+func New(cfg Config, deps Dependencies) *Server {
+	if cfg.ShareLinkSecret == "" {
+		cfg.ShareLinkSecret = newRequestID() + newRequestID()
+	}
+	if cfg.HTTPCacheDir != "" {
+		// Bodies cached by a previous run are unreachable without its index.
+		_ = os.RemoveAll(edgeCacheTunnelsDir(cfg.HTTPCacheDir))
+	}
+	metrics := newMetrics(time.Now)
+	metrics.tokenLabels = prom.NewLabelLimiter(cfg.MetricsTokenLabels)
+	return &Server{
+		cfg:         cfg,
+		deps:        deps,
+		registry:    NewTunnelRegistry(),
+		ports:       newTCPPortPool(cfg),
+		limiter:     newTokenTunnelLimiter(),
+		rateLimiter: newTokenRateLimiter(time.Now),
+		bandwidth:   newTokenBandwidthPool(),
+		metrics:     metrics,
+	}
 }
 
 func NewHandler(cfg Config, deps Dependencies) http.Handler {
+	return New(cfg, deps).Handler()
+}
+
+func (s *Server) Handler() http.Handler {
+	cfg, deps := s.cfg, s.deps
+	registry, ports, limiter, rateLimiter, metrics := s.registry, s.ports, s.limiter, s.rateLimiter, s.metrics
+
 	mux := http.NewServeMux()
-	registry := NewTunnelRegistry()
-	tunnelProxy := httpTunnelProxyHandler(cfg, registry)
-	limiter := newTokenTunnelLimiter()
-	rateLimiter := newTokenRateLimiter(time.Now)
-	metrics := newMetrics(time.Now)
+	access := newAccessLog(cfg, deps.AccessLog)
+	tunnelProxy := httpTunnelProxyHandler(cfg, registry, metrics, access, deps.Tracer)
 
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -140,6 +408,10 @@ func NewHandler(cfg Config, deps Dependencies) http.Handler {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
+		if cfg.AdminDomain != "" && domain == normalizeDomain(cfg.AdminDomain) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		if tunnel != "" && domain == tunnel {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -151,6 +423,12 @@ func NewHandler(cfg Config, deps Dependencies) http.Handler {
 		//
 		// This prevents arbitrary third parties from forcing ACME issuance for random
 		// hostnames under the tunnel domain.
+		// Path routing serves every tunnel from the base domain, so no
+		// per-tunnel certificates are needed.
+		if cfg.pathRouting() {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
 		if id, ok := tunnelIDFromHost(domain, cfg.TunnelDomain); ok {
 			if _, ok := registry.GetHTTPTunnel(id); ok {
 				w.WriteHeader(http.StatusOK)
@@ -171,16 +449,17 @@ func NewHandler(cfg Config, deps Dependencies) http.Handler {
 		mux.HandleFunc("/metrics", metricsHandler(cfg.BaseDomain, cfg.MetricsToken, metrics))
 	}
 
-	if strings.TrimSpace(cfg.AdminToken) != "" && deps.AdminStore != nil {
+	if strings.TrimSpace(cfg.AdminToken) != "" && deps.AdminStore != nil && ValidateAdminDomain(cfg) == nil {
+		adminDomain := cfg.adminDomain()
 		mux.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
-			if !isBaseDomainHost(r.Host, cfg.BaseDomain) {
+			if !isBaseDomainHost(r.Host, adminDomain) {
 				http.NotFound(w, r)
 				return
 			}
 			serveAdminIndex(w, r)
 		})
 		mux.HandleFunc("/admin/", func(w http.ResponseWriter, r *http.Request) {
-			if !isBaseDomainHost(r.Host, cfg.BaseDomain) {
+			if !isBaseDomainHost(r.Host, adminDomain) {
 				http.NotFound(w, r)
 				return
 			}
@@ -196,11 +475,11 @@ func NewHandler(cfg Config, deps Dependencies) http.Handler {
 			}
 		})
 		mux.HandleFunc("/api/admin/", requireAdminAuth(cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
-			if !isBaseDomainHost(r.Host, cfg.BaseDomain) {
+			if !isBaseDomainHost(r.Host, adminDomain) {
 				http.NotFound(w, r)
 				return
 			}
-			serveAdminAPI(w, r, cfg, deps.AdminStore)
+			serveAdminAPI(w, r, cfg, deps, registry, ports)
 		}))
 	}
 
@@ -219,7 +498,15 @@ func NewHandler(cfg Config, deps Dependencies) http.Handler {
 		tunnelProxy(w, r)
 	})
 
-	mux.HandleFunc("/control", controlHandler(cfg, registry, deps, limiter, rateLimiter, metrics))
+	mux.HandleFunc("/control", controlHandler(cfg, registry, ports, deps, limiter, rateLimiter, s.bandwidth, metrics))
+	tcpBridge := tcpBridgeHandler(cfg, registry, access, metrics, deps.Logger)
+	mux.HandleFunc("/tcp/", func(w http.ResponseWriter, r *http.Request) {
+		if isBaseDomainHost(r.Host, cfg.BaseDomain) {
+			tcpBridge(w, r)
+			return
+		}
+		tunnelProxy(w, r)
+	})
 	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
 		if isBaseDomainHost(r.Host, cfg.BaseDomain) && r.URL.Path == "/style.css" {
 			serveLandingStyle(w, r)
@@ -378,6 +665,94 @@ func getenvInt(key string, fallback int) int {
 	return n
 }
 
+func getenvFloat(key string, fallback float64) float64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fallback
+	}
+	return f
+}
+
+func getenvList(key string) []string {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return nil
+	}
+
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func getenvDuration(key string, fallback time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return fallback
+	}
+	return d
+}
+
+// getenvDurationMap parses "name=duration" pairs separated by commas,
+// skipping malformed entries.
+func getenvDurationMap(key string) map[string]time.Duration {
+	var out map[string]time.Duration
+	for _, part := range getenvList(key) {
+		name, v, ok := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d < 0 {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]time.Duration)
+		}
+		out[name] = d
+	}
+	return out
+}
+
+func getenvByteSize(key string, fallback int64) int64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	n, err := control.ParseByteSize(v)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// getenvByteRate parses a rate such as "10MB/s"; 0 (unlimited) when unset
+// or invalid.
+func getenvByteRate(key string) int64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return 0
+	}
+	n, err := control.ParseByteRate(v)
+	if err != nil || n < control.MinBandwidthBytesPerSecond {
+		return 0
+	}
+	return n
+}
+
 func getenvBool(key string, fallback bool) bool {
 	v := strings.TrimSpace(os.Getenv(key))
 	if v == "" {
@@ -6,8 +6,11 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"eosrift.com/eosrift/internal/control"
 )
 
 type captureSession struct {
@@ -71,7 +74,7 @@ func TestHTTPTunnel_HeaderTransforms(t *testing.T) {
 		t.Fatalf("register: %v", err)
 	}
 
-	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry)
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "http://example.test/hello", nil)
 	req.Host = "abcd1234.tunnel.eosrift.test"
@@ -112,3 +115,63 @@ func TestHTTPTunnel_HeaderTransforms(t *testing.T) {
 		t.Fatalf("timeout waiting for upstream request")
 	}
 }
+
+func TestHTTPTunnel_HeaderTemplates(t *testing.T) {
+	t.Parallel()
+
+	gotCh := make(chan capturedHeaders, 1)
+
+	registry := NewTunnelRegistry()
+	sess := &captureSession{gotCh: gotCh}
+
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{
+		BasicAuth: &basicAuthCredential{Username: "alice", Password: "pass"},
+		RequestHeaderAdd: []headerKV{
+			{Name: "X-Add", Value: "${basic_auth_user}@${tunnel_id}"},
+			{Name: "X-Override", Value: "${client_ip}"},
+		},
+		ResponseHeaderAdd: []headerKV{
+			{Name: "X-Edge", Value: "${host}"},
+		},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/hello", nil)
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	req.RemoteAddr = "192.0.2.10:4321"
+	req.SetBasicAuth("alice", "pass")
+
+	rr := httptest.NewRecorder()
+	h(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body=%q)", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if got, want := rr.Header().Get("X-Edge"), "abcd1234.tunnel.eosrift.test"; got != want {
+		t.Fatalf("X-Edge = %q, want %q", got, want)
+	}
+
+	select {
+	case got := <-gotCh:
+		if got.Add != "alice@abcd1234" {
+			t.Fatalf("upstream X-Add = %q, want %q", got.Add, "alice@abcd1234")
+		}
+		if got.Override != "192.0.2.10" {
+			t.Fatalf("upstream X-Override = %q, want %q", got.Override, "192.0.2.10")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting for upstream request")
+	}
+}
+
+func TestParseHeaderKVList_RejectsUnknownTemplateVar(t *testing.T) {
+	t.Parallel()
+
+	_, err := parseHeaderKVList("request_header_add", []control.HeaderKV{{Name: "X-User", Value: "${user}"}})
+	if err == nil || !strings.Contains(err.Error(), "unknown variable") {
+		t.Fatalf("err = %v, want unknown variable", err)
+	}
+}
@@ -20,7 +20,7 @@ func TestHTTPTunnel_MethodAndPathAllowlist(t *testing.T) {
 		t.Fatalf("register: %v", err)
 	}
 
-	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry)
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
 
 	t.Run("denies disallowed method", func(t *testing.T) {
 		sess.openCount.Store(0)
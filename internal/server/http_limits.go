@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+var (
+	errResponseHeaderTimeout = errors.New("upstream response header timeout")
+	errRequestTimeout        = errors.New("request timeout")
+)
+
+// httpLimits are the effective per-request limits of an HTTP tunnel. Zero
+// values disable a limit.
+type httpLimits struct {
+	maxBodyBytes          int64
+	responseHeaderTimeout time.Duration
+	requestTimeout        time.Duration
+	webSocketIdleTimeout  time.Duration
+}
+
+// resolveHTTPLimits combines the limits a tunnel asked for with the server's:
+// server values are both the defaults and the ceilings.
+func resolveHTTPLimits(cfg Config, req *control.HTTPLimits) httpLimits {
+	var l control.HTTPLimits
+	if req != nil {
+		l = *req
+	}
+	return httpLimits{
+		maxBodyBytes:          lowerLimit(l.MaxRequestBodyBytes, cfg.HTTPMaxRequestBodyBytes),
+		responseHeaderTimeout: lowerLimit(seconds(l.ResponseHeaderTimeoutSeconds), cfg.HTTPResponseHeaderTimeout),
+		requestTimeout:        lowerLimit(seconds(l.RequestTimeoutSeconds), cfg.HTTPRequestTimeout),
+		webSocketIdleTimeout:  lowerLimit(seconds(l.WebSocketIdleTimeoutSeconds), cfg.HTTPWebSocketIdleTimeout),
+	}
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// lowerLimit returns the tunnel's value capped at the server's, where zero
+// means unlimited on either side.
+func lowerLimit[T int64 | time.Duration](tunnel, server T) T {
+	if tunnel <= 0 || (server > 0 && tunnel > server) {
+		return server
+	}
+	return tunnel
+}
+
+// limitRequestBody rejects bodies declared larger than max with 413 and caps
+// the rest while they are proxied. It returns false when a response has
+// been written.
+func limitRequestBody(w http.ResponseWriter, r *http.Request, max int64, m *metrics) bool {
+	if max <= 0 {
+		return true
+	}
+	if r.ContentLength > max {
+		m.countBodyTooLarge()
+		edgeError(w, r, "request body too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+	}
+	return true
+}
+
+func isUpgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+type limitStateContextKey struct{}
+
+// limitState carries a request's timeouts to the transport and
+// ModifyResponse.
+type limitState struct {
+	headerTimer *time.Timer
+
+	webSocketIdleTimeout time.Duration
+	idleTimedOut         atomic.Bool
+}
+
+func limitStateFromContext(ctx context.Context) (*limitState, bool) {
+	st, ok := ctx.Value(limitStateContextKey{}).(*limitState)
+	return st, ok
+}
+
+// gotResponse stops the response header timeout.
+func (st *limitState) gotResponse() {
+	if st.headerTimer != nil {
+		st.headerTimer.Stop()
+	}
+}
+
+// serve runs next with the request and response header timeouts applied and
+// counts the timeouts that fired. WebSocket upgrades are exempt from the
+// request timeout and get the idle timeout instead.
+func (l httpLimits) serve(w http.ResponseWriter, r *http.Request, m *metrics, next http.Handler) {
+	requestTimeout, idleTimeout := l.requestTimeout, time.Duration(0)
+	if isUpgradeRequest(r) {
+		requestTimeout, idleTimeout = 0, l.webSocketIdleTimeout
+	}
+	if l.responseHeaderTimeout <= 0 && requestTimeout <= 0 && idleTimeout <= 0 {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	st := &limitState{webSocketIdleTimeout: idleTimeout}
+	if requestTimeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, requestTimeout, errRequestTimeout)
+		defer stop()
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	if l.responseHeaderTimeout > 0 {
+		st.headerTimer = time.AfterFunc(l.responseHeaderTimeout, func() { cancel(errResponseHeaderTimeout) })
+	}
+
+	defer func() {
+		st.gotResponse()
+		switch cause := context.Cause(ctx); {
+		case errors.Is(cause, errResponseHeaderTimeout):
+			m.countTimeout(timeoutResponseHeader)
+		case errors.Is(cause, errRequestTimeout):
+			m.countTimeout(timeoutRequest)
+		case st.idleTimedOut.Load():
+			m.countTimeout(timeoutWebSocketIdle)
+		}
+		cancel(nil)
+	}()
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, limitStateContextKey{}, st)))
+}
+
+// isLimitTimeout reports whether a proxy error was caused by serve's
+// timeouts.
+func isLimitTimeout(ctx context.Context) bool {
+	cause := context.Cause(ctx)
+	return errors.Is(cause, errResponseHeaderTimeout) || errors.Is(cause, errRequestTimeout)
+}
+
+// idleTimeoutConn closes the upstream stream of an upgraded connection once
+// no bytes have moved in either direction for timeout. It uses a timer
+// rather than deadlines because SSH forward channels do not support them.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+	timer   *time.Timer
+	closed  atomic.Bool
+}
+
+func newIdleTimeoutConn(c net.Conn, timeout time.Duration, timedOut *atomic.Bool) *idleTimeoutConn {
+	ic := &idleTimeoutConn{Conn: c, timeout: timeout}
+	ic.timer = time.AfterFunc(timeout, func() {
+		if ic.closed.CompareAndSwap(false, true) {
+			timedOut.Store(true)
+			_ = c.Close()
+		}
+	})
+	return ic
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.timer.Reset(c.timeout)
+	}
+	return n, err
+}
+
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.timer.Reset(c.timeout)
+	}
+	return n, err
+}
+
+func (c *idleTimeoutConn) Close() error {
+	c.closed.Store(true)
+	c.timer.Stop()
+	return c.Conn.Close()
+}
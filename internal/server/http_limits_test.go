@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// stallSession reads the request and then either never answers, or
+// (for upgrades) switches protocols and stays silent.
+type stallSession struct{}
+
+func (stallSession) OpenStream() (net.Conn, error) {
+	a, b := net.Pipe()
+	go func() {
+		defer b.Close()
+
+		br := bufio.NewReader(b)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if req.Header.Get("Upgrade") != "" {
+			_, _ = fmt.Fprint(b, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		}
+		_, _ = io.Copy(io.Discard, br)
+	}()
+	return a, nil
+}
+
+func (stallSession) Close() error { return nil }
+
+func TestResolveHTTPLimits(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{HTTPMaxRequestBodyBytes: 1000, HTTPResponseHeaderTimeout: time.Minute}
+
+	got := resolveHTTPLimits(cfg, nil)
+	if got != (httpLimits{maxBodyBytes: 1000, responseHeaderTimeout: time.Minute}) {
+		t.Fatalf("defaults = %+v", got)
+	}
+
+	got = resolveHTTPLimits(cfg, &control.HTTPLimits{
+		MaxRequestBodyBytes:          5000,
+		ResponseHeaderTimeoutSeconds: 10,
+		RequestTimeoutSeconds:        30,
+	})
+	want := httpLimits{maxBodyBytes: 1000, responseHeaderTimeout: 10 * time.Second, requestTimeout: 30 * time.Second}
+	if got != want {
+		t.Fatalf("resolved = %+v, want %+v", got, want)
+	}
+}
+
+func TestHTTPTunnel_BodyLimit(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", &headerSession{gotCh: make(chan http.Header, 1)}, httpTunnelOptions{
+		Limits: httpLimits{maxBodyBytes: 4},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	m := newMetrics(nil)
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, m, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.test/", strings.NewReader("too large"))
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	rr := httptest.NewRecorder()
+	h(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rr.Code)
+	}
+	if got := m.bodyTooLarge.Value(); got != 1 {
+		t.Fatalf("body too large count = %d, want 1", got)
+	}
+}
+
+func TestHTTPTunnel_Timeouts(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		limits httpLimits
+		kind   timeoutKind
+	}{
+		{name: "response header", limits: httpLimits{responseHeaderTimeout: 50 * time.Millisecond}, kind: timeoutResponseHeader},
+		{name: "request", limits: httpLimits{requestTimeout: 50 * time.Millisecond}, kind: timeoutRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			registry := NewTunnelRegistry()
+			if err := registry.RegisterHTTPTunnel("abcd1234", stallSession{}, httpTunnelOptions{Limits: tt.limits}); err != nil {
+				t.Fatalf("register: %v", err)
+			}
+			m := newMetrics(nil)
+			h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, m, nil, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+			req.Host = "abcd1234.tunnel.eosrift.test"
+			rr := httptest.NewRecorder()
+			h(rr, req)
+
+			if rr.Code != http.StatusGatewayTimeout {
+				t.Fatalf("status = %d, want 504", rr.Code)
+			}
+			if got := m.timeouts.Value(timeoutKindNames[tt.kind]); got != 1 {
+				t.Fatalf("timeout count = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestHTTPTunnel_WebSocketIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", stallSession{}, httpTunnelOptions{
+		Limits: httpLimits{requestTimeout: 20 * time.Millisecond, webSocketIdleTimeout: 200 * time.Millisecond},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	m := newMetrics(nil)
+	srv := httptest.NewServer(httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, m, nil, nil))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	_, _ = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: abcd1234.tunnel.eosrift.test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+
+	// Traffic keeps the connection open past the (exempt) request timeout.
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("read after idle: %v, want EOF", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("idle connection closed after %v", elapsed)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for m.timeouts.Value(timeoutKindNames[timeoutWebSocketIdle]) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("websocket idle timeout not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,7 +2,8 @@ package server
 
 import (
 	"context"
-	"crypto/subtle"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"net"
 	"net/http"
@@ -10,13 +11,18 @@ import (
 	"net/netip"
 	"net/url"
 	"strings"
+	"time"
+
+	"eosrift.com/eosrift/internal/policy"
+	"eosrift.com/eosrift/internal/tracing"
 )
 
-func httpTunnelProxyHandler(cfg Config, registry *TunnelRegistry) http.HandlerFunc {
+func httpTunnelProxyHandler(cfg Config, registry *TunnelRegistry, metrics *metrics, access *accessLog, tracer *tracing.Tracer) http.HandlerFunc {
 	target := &url.URL{
 		Scheme: "http",
 		Host:   "upstream",
 	}
+	requestIDHeader := cfg.requestIDHeader()
 
 	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
@@ -25,7 +31,18 @@ func httpTunnelProxyHandler(cfg Config, registry *TunnelRegistry) http.HandlerFu
 			if !ok || entry.session == nil {
 				return nil, errors.New("missing tunnel session")
 			}
-			return entry.session.OpenStream()
+			span := startSpan(ctx, tracer, "tunnel.stream_open")
+			defer span.End()
+			conn, err := entry.session.OpenStream()
+			if err != nil {
+				span.SetError(err)
+				metrics.countStreamOpenFailure("http", entry.tokenID)
+				return nil, err
+			}
+			if st, ok := limitStateFromContext(ctx); ok && st.webSocketIdleTimeout > 0 {
+				conn = newIdleTimeoutConn(conn, st.webSocketIdleTimeout, &st.idleTimedOut)
+			}
+			return conn, nil
 		},
 		ForceAttemptHTTP2:   false,
 		DisableKeepAlives:   true,
@@ -46,127 +63,234 @@ func httpTunnelProxyHandler(cfg Config, registry *TunnelRegistry) http.HandlerFu
 				pr.SetXForwarded()
 			}
 
-			entry, ok := tunnelEntryFromContext(pr.In.Context())
-			if ok {
-				applyHeaderTransforms(pr.Out.Header, entry.requestHeaderRemove, entry.requestHeaderAdd)
+			if prefix := pathPrefixFromContext(pr.In.Context()); prefix != "" {
+				pr.Out.Header.Set("X-Forwarded-Prefix", prefix)
+			}
+
+			if st, ok := pr.In.Context().Value(policyStateContextKey{}).(*policyState); ok {
+				for _, op := range st.headerOps {
+					op.Apply(pr.Out.Header, st.vars)
+				}
 			}
 		},
-		Transport: transport,
+		Transport: &mirrorTransport{
+			next:     &edgeCacheTransport{next: &tracingTransport{next: transport, tracer: tracer}, metrics: metrics},
+			shadow:   transport,
+			registry: registry,
+			metrics:  metrics,
+		},
 		ModifyResponse: func(resp *http.Response) error {
 			if resp == nil || resp.Request == nil {
 				return nil
 			}
+			if st, ok := limitStateFromContext(resp.Request.Context()); ok {
+				st.gotResponse()
+			}
+			// The edge already set its request id on the response.
+			resp.Header.Del(requestIDHeader)
+
+			if prefix := pathPrefixFromContext(resp.Request.Context()); prefix != "" {
+				rewritePathRoutingResponse(resp.Header, prefix, resp.Request.Host)
+			}
 
 			entry, ok := tunnelEntryFromContext(resp.Request.Context())
-			if ok {
-				applyHeaderTransforms(resp.Header, entry.responseHeaderRemove, entry.responseHeaderAdd)
+			st, stOK := resp.Request.Context().Value(policyStateContextKey{}).(*policyState)
+			if ok && entry.cors != nil {
+				in := resp.Request
+				if stOK {
+					in = st.in
+				}
+				entry.cors.applyResponse(in.Header.Get("Origin"), resp.Header)
+			}
+			if ok && stOK {
+				entry.policy.EvalResponse(st.in, st.vars, resp.StatusCode, resp.Header)
 			}
 			return nil
 		},
 		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
-			http.Error(rw, "bad gateway", http.StatusBadGateway)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				metrics.countBodyTooLarge()
+				edgeError(rw, req, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if isLimitTimeout(req.Context()) {
+				edgeError(rw, req, "gateway timeout", http.StatusGatewayTimeout)
+				return
+			}
+			edgeError(rw, req, "bad gateway", http.StatusBadGateway)
 		},
 	}
 
+	edge := cfg.EdgePolicy
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		id, ok := tunnelIDFromHost(r.Host, cfg.TunnelDomain)
+		requestID := requestIDFor(r, requestIDHeader)
+		r.Header.Set(requestIDHeader, requestID)
+		w.Header().Set(requestIDHeader, requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, requestID))
+		r, span := startEdgeSpan(tracer, r, requestID)
+		record := recordHTTP(w, r, requestID, access, metrics, span)
+		if record != nil {
+			defer record.finish()
+			w = record
+		}
+		w = edge.wrapResponse(w)
+
+		route := startSpan(r.Context(), tracer, "edge.route")
+		defer route.End()
+		var (
+			id     string
+			prefix string
+			ok     bool
+		)
+		if cfg.pathRouting() {
+			if !isBaseDomainHost(r.Host, cfg.BaseDomain) {
+				edgeError(w, r, "404 page not found", http.StatusNotFound)
+				return
+			}
+			id, prefix, ok = tunnelIDFromPath(r.URL.Path)
+		} else {
+			id, ok = tunnelIDFromHost(r.Host, cfg.TunnelDomain)
+		}
 		if !ok {
-			http.NotFound(w, r)
+			edgeError(w, r, "404 page not found", http.StatusNotFound)
 			return
 		}
 
 		entry, ok := registry.GetHTTPTunnel(id)
 		if !ok {
-			http.NotFound(w, r)
+			edgeError(w, r, "404 page not found", http.StatusNotFound)
 			return
 		}
+		record.setTunnel(id, entry.tokenID)
+		route.SetAttr("eosrift.tunnel_id", id)
+		route.End()
 
-		if len(entry.allowMethods) > 0 {
-			method := strings.ToUpper(strings.TrimSpace(r.Method))
-			allowed := false
-			for _, m := range entry.allowMethods {
-				if method == strings.ToUpper(strings.TrimSpace(m)) {
-					allowed = true
-					break
+		if prefix != "" {
+			if r.URL.Path == prefix {
+				target := prefix + "/"
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
 				}
-			}
-			if !allowed {
-				http.NotFound(w, r)
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
 				return
 			}
+			stripPathPrefix(r, prefix)
 		}
 
-		if len(entry.allowPaths) > 0 || len(entry.allowPathPrefixes) > 0 {
-			path := r.URL.Path
-			allowed := false
-			for _, p := range entry.allowPaths {
-				if path == strings.TrimSpace(p) {
-					allowed = true
-					break
-				}
-			}
-			if !allowed {
-				for _, p := range entry.allowPathPrefixes {
-					p = strings.TrimSpace(p)
-					if p != "" && strings.HasPrefix(path, p) {
-						allowed = true
-						break
-					}
-				}
-			}
-			if !allowed {
-				http.NotFound(w, r)
+		checks := startSpan(r.Context(), tracer, "edge.policy")
+		defer checks.End()
+		ip, _ := requestClientIP(r, cfg.TrustProxyHeaders)
+		if edge.denies(ip) {
+			edgeError(w, r, "forbidden", http.StatusForbidden)
+			return
+		}
+		if !limitRequestBody(w, r, edge.maxRequestBody(), metrics) {
+			return
+		}
+		edgeUser, edgeAuth := "", false
+		if gate := edge.basicAuthFor(entry.tokenID); gate != nil {
+			if edgeUser, edgeAuth = gate.authenticate(w, r, ip); !edgeAuth {
+				metrics.countAuthFailure(authFailureBasicAuth, entry.tokenID)
 				return
 			}
+			r = withEdgeAuthenticated(r)
+			r.Header.Del("Authorization")
 		}
 
-		if len(entry.allowCIDRs) > 0 || len(entry.denyCIDRs) > 0 {
-			ip, ok := requestClientIP(r, cfg.TrustProxyHeaders)
-			if !ok {
-				http.Error(w, "forbidden", http.StatusForbidden)
+		// The tunnel's own settings start with its method, path and CIDR
+		// rules, so nothing else the tunnel configures answers a visitor
+		// they reject.
+		if res := entry.access.EvalRequest(r, ip); res.Response != nil {
+			writePolicyResponse(w, r, res.Response, prefix)
+			return
+		}
+		if !limitRequestBody(w, r, entry.limits.maxBodyBytes, metrics) {
+			return
+		}
+		if resp := entry.rateLimitOverride.Check(r, ip); resp != nil {
+			metrics.countRateLimited(rateLimitVisitor, entry.tokenID)
+			resp.Write(w, r)
+			return
+		}
+		// Browsers send preflights without credentials, so they are
+		// answered before any auth check.
+		if entry.cors != nil && isCORSPreflight(r) {
+			entry.cors.servePreflight(w, r)
+			return
+		}
+		if entry.browserWarning && checkBrowserWarning(w, r, cfg, id, prefix) {
+			return
+		}
+		shared := false
+		if entry.shareLinks {
+			var handled bool
+			if shared, handled = checkShareLink(w, r, cfg, id, prefix); handled {
 				return
 			}
-			if cidrListContains(entry.denyCIDRs, ip) {
-				http.Error(w, "forbidden", http.StatusForbidden)
+			if !shared && entry.basicAuth == nil && entry.jwt == nil {
+				edgeError(w, r, "share link required", http.StatusForbidden)
 				return
 			}
-			if len(entry.allowCIDRs) > 0 && !cidrListContains(entry.allowCIDRs, ip) {
-				http.Error(w, "forbidden", http.StatusForbidden)
-				return
+		}
+		res := entry.policy.EvalRequest(r, ip)
+		if res.Response != nil {
+			if res.Response.Status == http.StatusTooManyRequests {
+				metrics.countRateLimited(rateLimitVisitor, entry.tokenID)
 			}
+			writePolicyResponse(w, r, res.Response, prefix)
+			return
+		}
+
+		vars := policy.Vars{
+			ClientIP:  ip,
+			TunnelID:  id,
+			RequestID: requestID,
+			Host:      r.Host,
+			Time:      time.Now(),
 		}
 
-		if entry.basicAuth != nil {
-			user, pass, ok := r.BasicAuth()
-			if !ok ||
-				subtle.ConstantTimeCompare([]byte(user), []byte(entry.basicAuth.Username)) != 1 ||
-				subtle.ConstantTimeCompare([]byte(pass), []byte(entry.basicAuth.Password)) != 1 {
-				w.Header().Set("WWW-Authenticate", `Basic realm="EosRift"`)
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+		switch {
+		case edgeAuth:
+			vars.BasicAuthUser = edgeUser
+		case entry.basicAuth != nil && !shared:
+			user, ok := entry.basicAuth.authenticate(w, r, ip)
+			if !ok {
+				metrics.countAuthFailure(authFailureBasicAuth, entry.tokenID)
 				return
 			}
+			r = withEdgeAuthenticated(r)
 			r.Header.Del("Authorization")
+			vars.BasicAuthUser = user
+		}
+		if entry.jwt != nil {
+			if shared || edgeAuth {
+				entry.jwt.stripClaimHeaders(r)
+			} else if !entry.jwt.authenticate(w, r) {
+				metrics.countAuthFailure(authFailureJWT, entry.tokenID)
+				return
+			} else {
+				r = withEdgeAuthenticated(r)
+			}
 		}
 
-		r = withTunnelEntryContext(r, entry)
-		proxy.ServeHTTP(w, r)
-	}
-}
+		checks.End()
 
-func applyHeaderTransforms(h http.Header, remove []string, add []headerKV) {
-	for _, k := range remove {
-		k = strings.TrimSpace(k)
-		if k == "" {
-			continue
+		r = withTunnelEntryContext(r, entry)
+		r = r.WithContext(context.WithValue(r.Context(), policyStateContextKey{}, &policyState{
+			in:        r,
+			vars:      vars,
+			headerOps: res.HeaderOps,
+		}))
+		if prefix != "" {
+			r = r.WithContext(context.WithValue(r.Context(), pathPrefixContextKey{}, prefix))
 		}
-		h.Del(k)
-	}
-	for _, kv := range add {
-		k := strings.TrimSpace(kv.Name)
-		if k == "" {
-			continue
+		if cw := entry.compression.wrap(w, r); cw != nil {
+			defer cw.close()
+			w = cw
 		}
-		h.Set(k, kv.Value)
+		entry.limits.serve(w, r, metrics, proxy)
 	}
 }
 
@@ -282,6 +406,78 @@ func cidrListContains(prefixes []netip.Prefix, ip netip.Addr) bool {
 
 type tunnelEntryContextKey struct{}
 
+// policyState carries on_request results to the proxied request and
+// response. in is the inbound request, which on_response rules match against.
+type policyState struct {
+	in        *http.Request
+	vars      policy.Vars
+	headerOps []policy.HeaderOp
+}
+
+// newRequestID returns a random 32 character hex id.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDFor returns the request id the visitor (or a proxy in front of
+// the edge) sent in header, or a new one when it is missing or doesn't look
+// like an id. It feeds ${request_id}, access logs and error pages.
+func requestIDFor(r *http.Request, header string) string {
+	if id := strings.TrimSpace(r.Header.Get(header)); validRequestID(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+// validRequestID accepts up to 128 characters that are safe to echo in
+// headers and logs: letters, digits and ._:/+=-.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("._:/+=-", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type requestIDContextKey struct{}
+
+type edgeAuthContextKey struct{}
+
+// withEdgeAuthenticated marks r as authenticated by the edge (basic auth or
+// JWT). The credentials are stripped before proxying, so this is how the
+// edge cache knows the response may be specific to the visitor.
+func withEdgeAuthenticated(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), edgeAuthContextKey{}, true))
+}
+
+func edgeAuthenticated(r *http.Request) bool {
+	ok, _ := r.Context().Value(edgeAuthContextKey{}).(bool)
+	return ok
+}
+
+// edgeError is http.Error for pages the edge serves itself. It adds the
+// request id, so a visitor reporting a failure can quote it.
+func edgeError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	if id, _ := r.Context().Value(requestIDContextKey{}).(string); id != "" {
+		msg += "\nrequest id: " + id
+	}
+	http.Error(w, msg, code)
+}
+
+type policyStateContextKey struct{}
+
 func withTunnelEntryContext(r *http.Request, entry httpTunnelEntry) *http.Request {
 	return r.WithContext(context.WithValue(r.Context(), tunnelEntryContextKey{}, entry))
 }
@@ -294,3 +490,134 @@ func tunnelEntryFromContext(ctx context.Context) (httpTunnelEntry, bool) {
 	entry, ok := v.(httpTunnelEntry)
 	return entry, ok
 }
+
+// Path routing (EOSRIFT_HTTP_ROUTING=path) serves tunnels at
+// https://<base-domain>/t/<id>/ for installs without wildcard DNS or certs.
+
+func pathRoutingPrefix(id string) string {
+	return "/t/" + id
+}
+
+// tunnelIDFromPath extracts the tunnel id from /t/<id>[/...] and returns the
+// matched prefix (as sent by the client) so it can be stripped.
+func tunnelIDFromPath(path string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(path, "/t/")
+	if !ok {
+		return "", "", false
+	}
+	seg, _, _ := strings.Cut(rest, "/")
+	if seg == "" {
+		return "", "", false
+	}
+	return strings.ToLower(seg), "/t/" + seg, true
+}
+
+func stripPathPrefix(r *http.Request, prefix string) {
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	if r.URL.RawPath != "" {
+		r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+	}
+}
+
+// pathRoutingSandbox is added to every path-routed response. All tunnels
+// share the base domain's origin in path mode; without allow-same-origin
+// each page gets an opaque origin, so its scripts cannot read the storage
+// or cookies of other tunnels or of the base domain.
+const pathRoutingSandbox = "sandbox allow-scripts allow-forms allow-popups allow-popups-to-escape-sandbox allow-modals allow-downloads allow-top-navigation-by-user-activation"
+
+// rewritePathRoutingResponse maps upstream-relative Location headers and
+// Set-Cookie paths back under the tunnel's path prefix and sandboxes the
+// response (see pathRoutingSandbox).
+func rewritePathRoutingResponse(h http.Header, prefix, host string) {
+	if loc := h.Get("Location"); loc != "" {
+		h.Set("Location", rewritePathRoutingLocation(loc, prefix, host))
+	}
+
+	// Added next to any upstream policy; browsers enforce all of them.
+	h.Add("Content-Security-Policy", pathRoutingSandbox)
+
+	cookies := h.Values("Set-Cookie")
+	if len(cookies) == 0 {
+		return
+	}
+	h.Del("Set-Cookie")
+	for _, c := range cookies {
+		h.Add("Set-Cookie", rewriteSetCookiePath(c, prefix))
+	}
+}
+
+func rewritePathRoutingLocation(loc, prefix, host string) string {
+	if strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		return prefixPath(loc, prefix)
+	}
+
+	u, err := url.Parse(loc)
+	if err != nil || u.Host == "" || normalizeDomain(u.Host) != normalizeDomain(host) {
+		return loc
+	}
+	u.Path = prefixPath(u.Path, prefix)
+	if u.RawPath != "" {
+		u.RawPath = prefixPath(u.RawPath, prefix)
+	}
+	return u.String()
+}
+
+// writePolicyResponse writes a response generated by a traffic policy. In
+// path routing mode a redirect is mapped under the tunnel's prefix, like
+// upstream Location headers; relative targets are first resolved against the
+// request path the tunnel sees, as http.Redirect would.
+func writePolicyResponse(w http.ResponseWriter, r *http.Request, resp *policy.Response, prefix string) {
+	if prefix != "" && resp.Location != "" {
+		loc := resp.Location
+		if u, err := url.Parse(loc); err == nil && u.Scheme == "" && u.Host == "" && !strings.HasPrefix(loc, "/") {
+			loc = (&url.URL{Path: r.URL.Path}).ResolveReference(u).String()
+		}
+		mapped := *resp
+		mapped.Location = rewritePathRoutingLocation(loc, prefix, r.Host)
+		resp = &mapped
+	}
+	resp.Write(w, r)
+}
+
+// rewriteSetCookiePath scopes a cookie to the tunnel's /t/<id>/ prefix, so
+// it is never sent to other tunnels or the base domain. Cookies without a
+// (valid) Path get one.
+func rewriteSetCookiePath(cookie, prefix string) string {
+	parts := strings.Split(cookie, ";")
+	scoped := false
+	for i := 1; i < len(parts); i++ {
+		k, v, _ := strings.Cut(strings.TrimSpace(parts[i]), "=")
+		if !strings.EqualFold(strings.TrimSpace(k), "path") {
+			continue
+		}
+		p := strings.TrimSpace(v)
+		if !strings.HasPrefix(p, "/") || p == "/" {
+			p = prefix + "/"
+		} else {
+			p = prefixPath(p, prefix)
+		}
+		parts[i] = " Path=" + p
+		scoped = true
+	}
+	if !scoped {
+		parts = append(parts, " Path="+prefix+"/")
+	}
+	return strings.Join(parts, ";")
+}
+
+func prefixPath(p, prefix string) string {
+	if p == prefix || strings.HasPrefix(p, prefix+"/") {
+		return p
+	}
+	if p == "" {
+		p = "/"
+	}
+	return prefix + p
+}
+
+type pathPrefixContextKey struct{}
+
+func pathPrefixFromContext(ctx context.Context) string {
+	prefix, _ := ctx.Value(pathPrefixContextKey{}).(string)
+	return prefix
+}
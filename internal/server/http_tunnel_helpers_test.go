@@ -5,8 +5,23 @@ import (
 	"net/http/httptest"
 	"net/netip"
 	"testing"
+
+	"eosrift.com/eosrift/internal/policy"
 )
 
+// applyHeaderTransforms runs legacy response header options through the
+// traffic policy they are converted to.
+func applyHeaderTransforms(t *testing.T, h http.Header, remove []string, add []headerKV) {
+	t.Helper()
+
+	opts := httpTunnelOptions{ResponseHeaderRemove: remove, ResponseHeaderAdd: add}
+	engine, err := policy.Compile(opts.trafficPolicy(), policy.Options{})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	engine.EvalResponse(httptest.NewRequest(http.MethodGet, "http://example.test/", nil), policy.Vars{}, http.StatusOK, h)
+}
+
 func TestApplyHeaderTransforms(t *testing.T) {
 	t.Parallel()
 
@@ -14,7 +29,7 @@ func TestApplyHeaderTransforms(t *testing.T) {
 	h.Set("X-Remove", "bye")
 	h.Set("X-Keep", "old")
 
-	applyHeaderTransforms(h,
+	applyHeaderTransforms(t, h,
 		[]string{" X-Remove ", "", "X-Does-Not-Exist"},
 		[]headerKV{
 			{Name: " X-Add ", Value: "yes"},
@@ -23,6 +38,42 @@ func TestApplyHeaderTransforms(t *testing.T) {
 		},
 	)
 
+	if got := h.Get("X-Remove"); got != "" {
+		t.Fatalf("X-Remove = %q, want empty", got)
+	}
+	if got := h.Get("X-Add"); got != "yes" {
+		t.Fatalf("X-Add = %q, want %q", got, "yes")
+	}
+	if got := h.Get("X-Keep"); got != "new" {
+		t.Fatalf("X-Keep = %q, want %q", got, "new")
+	}
+	if got := h.Values(""); len(got) != 0 {
+		t.Fatalf("empty header name set: %q", got)
+	}
+}
+
+func TestHTTPTunnelOptionsTrafficPolicy_HeaderTransforms(t *testing.T) {
+	t.Parallel()
+
+	opts := httpTunnelOptions{
+		ResponseHeaderRemove: []string{"X-Remove", "X-Does-Not-Exist"},
+		ResponseHeaderAdd: []headerKV{
+			{Name: "X-Add", Value: "yes"},
+			{Name: "X-Keep", Value: "new"},
+		},
+	}
+	engine, err := policy.Compile(opts.trafficPolicy(), policy.Options{})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	h := make(http.Header)
+	h.Set("X-Remove", "bye")
+	h.Set("X-Keep", "old")
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	engine.EvalResponse(r, policy.Vars{}, http.StatusOK, h)
+
 	if got := h.Get("X-Remove"); got != "" {
 		t.Fatalf("X-Remove = %q, want empty", got)
 	}
@@ -23,7 +23,7 @@ func TestHTTPTunnel_IPAccessControl(t *testing.T) {
 			t.Fatalf("register: %v", err)
 		}
 
-		h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry)
+		h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
 
 		req := httptest.NewRequest(http.MethodGet, "http://example.test/hello", nil)
 		req.Host = "abcd1234.tunnel.eosrift.test"
@@ -50,7 +50,7 @@ func TestHTTPTunnel_IPAccessControl(t *testing.T) {
 			t.Fatalf("register: %v", err)
 		}
 
-		h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry)
+		h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
 
 		req := httptest.NewRequest(http.MethodGet, "http://example.test/hello", nil)
 		req.Host = "abcd1234.tunnel.eosrift.test"
@@ -77,7 +77,7 @@ func TestHTTPTunnel_IPAccessControl(t *testing.T) {
 			t.Fatalf("register: %v", err)
 		}
 
-		h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test", TrustProxyHeaders: false}, registry)
+		h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test", TrustProxyHeaders: false}, registry, nil, nil, nil)
 
 		req := httptest.NewRequest(http.MethodGet, "http://example.test/hello", nil)
 		req.Host = "abcd1234.tunnel.eosrift.test"
@@ -105,7 +105,7 @@ func TestHTTPTunnel_IPAccessControl(t *testing.T) {
 			t.Fatalf("register: %v", err)
 		}
 
-		h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test", TrustProxyHeaders: true}, registry)
+		h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test", TrustProxyHeaders: true}, registry, nil, nil, nil)
 
 		req := httptest.NewRequest(http.MethodGet, "http://example.test/hello", nil)
 		req.Host = "abcd1234.tunnel.eosrift.test"
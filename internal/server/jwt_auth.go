@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"eosrift.com/eosrift/internal/control"
+	"eosrift.com/eosrift/internal/jwtauth"
+)
+
+const maxJWTClaimHeaderValueBytes = 4096
+
+// jwtGate requires a valid JWT bearer token and forwards selected claims to
+// the upstream as request headers.
+type jwtGate struct {
+	verifier     *jwtauth.Verifier
+	claimHeaders []control.JWTClaimHeader
+}
+
+// newJWTGate builds a gate from a validated JWT auth block. Inline key sets
+// are parsed here; remote ones are fetched on first use with client (nil
+// means the guarded default client).
+func newJWTGate(a *control.JWTAuth, client *http.Client) (*jwtGate, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	var keys jwtauth.KeySource
+	if a.JWKS != "" {
+		parsed, err := jwtauth.ParseJWKS([]byte(a.JWKS))
+		if err != nil {
+			return nil, errors.New("jwt: " + err.Error())
+		}
+		keys = jwtauth.StaticKeys(parsed)
+	} else {
+		keys = jwtauth.NewRemoteKeys(a.JWKSURL, client, nil)
+	}
+
+	return &jwtGate{
+		verifier: jwtauth.NewVerifier(keys, jwtauth.Options{
+			Issuer:   a.Issuer,
+			Audience: a.Audience,
+		}),
+		claimHeaders: a.ClaimHeaders,
+	}, nil
+}
+
+// authenticate verifies the request's bearer token, or writes a 401/503
+// response and returns false.
+func (g *jwtGate) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	g.stripClaimHeaders(r)
+
+	token, ok := jwtauth.BearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="EosRift"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	claims, err := g.verifier.Verify(r.Context(), token)
+	if errors.Is(err, jwtauth.ErrKeysUnavailable) {
+		http.Error(w, "token keys unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="EosRift", error="invalid_token"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	for _, ch := range g.claimHeaders {
+		if v, ok := claimHeaderValue(claims[ch.Claim]); ok {
+			r.Header.Set(ch.Header, v)
+		}
+	}
+	return true
+}
+
+// stripClaimHeaders removes client-sent copies of the configured claim
+// headers, so upstreams only ever see verified values.
+func (g *jwtGate) stripClaimHeaders(r *http.Request) {
+	for _, ch := range g.claimHeaders {
+		r.Header.Del(ch.Header)
+	}
+}
+
+// claimHeaderValue renders a claim for a header: strings as-is, numbers and
+// booleans in JSON form, string arrays comma-joined, anything else as JSON.
+func claimHeaderValue(v any) (string, bool) {
+	var s string
+	switch c := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		s = c
+	case float64:
+		s = strconv.FormatFloat(c, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(c)
+	default:
+		if strs, ok := stringSlice(c); ok {
+			s = strings.Join(strs, ", ")
+			break
+		}
+		b, err := json.Marshal(c)
+		if err != nil {
+			return "", false
+		}
+		s = string(b)
+	}
+
+	s = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > maxJWTClaimHeaderValueBytes {
+		return "", false
+	}
+	return s, true
+}
+
+func stringSlice(v any) ([]string, bool) {
+	arr, ok := v.([]any)
+	if !ok {
+		return nil, false
+	}
+	out := make([]string, 0, len(arr))
+	for _, e := range arr {
+		str, ok := e.(string)
+		if !ok {
+			return nil, false
+		}
+		out = append(out, str)
+	}
+	return out, true
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// headerSession forwards each upstream request's headers to gotCh.
+type headerSession struct {
+	gotCh chan http.Header
+}
+
+func (s *headerSession) OpenStream() (net.Conn, error) {
+	a, b := net.Pipe()
+
+	go func() {
+		defer b.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(b))
+		if err != nil {
+			return
+		}
+		_ = req.Body.Close()
+		s.gotCh <- req.Header
+
+		body := "ok\n"
+		_, _ = fmt.Fprintf(b, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+	}()
+
+	return a, nil
+}
+
+func (s *headerSession) Close() error { return nil }
+
+func signEdDSAToken(t *testing.T, priv crypto.Signer, kid string, claims map[string]any) string {
+	t.Helper()
+
+	b64 := base64.RawURLEncoding
+	h, _ := json.Marshal(map[string]string{"alg": "EdDSA", "kid": kid})
+	c, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	sig, err := priv.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestHTTPTunnel_JWTAuth(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("keygen: %v", err)
+	}
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","x":%q}]}`, base64.RawURLEncoding.EncodeToString(pub))
+
+	auth, err := control.ValidateJWTAuth(&control.JWTAuth{
+		JWKS:     jwks,
+		Issuer:   "https://issuer.test",
+		Audience: []string{"api"},
+		ClaimHeaders: []control.JWTClaimHeader{
+			{Claim: "sub", Header: "x-user"},
+			{Claim: "groups", Header: "X-Groups"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	gotCh := make(chan http.Header, 1)
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", &headerSession{gotCh: gotCh}, httpTunnelOptions{JWT: auth}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	do := func(authz string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		req.Header.Set("X-User", "spoofed")
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	claims := map[string]any{
+		"iss":    "https://issuer.test",
+		"aud":    "api",
+		"sub":    "alice",
+		"groups": []string{"eng", "ops"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+
+	t.Run("missing token", func(t *testing.T) {
+		rr := do("")
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want 401", rr.Code)
+		}
+		if rr.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("missing WWW-Authenticate")
+		}
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		rr := do("Bearer " + signEdDSAToken(t, priv, "k1", claims))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200 (body=%q)", rr.Code, rr.Body.String())
+		}
+		got := <-gotCh
+		if v := got.Get("X-User"); v != "alice" {
+			t.Fatalf("X-User = %q, want %q", v, "alice")
+		}
+		if v := got.Get("X-Groups"); v != "eng, ops" {
+			t.Fatalf("X-Groups = %q, want %q", v, "eng, ops")
+		}
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		bad := map[string]any{}
+		for k, v := range claims {
+			bad[k] = v
+		}
+		bad["aud"] = "web"
+		if rr := do("Bearer " + signEdDSAToken(t, priv, "k1", bad)); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want 401", rr.Code)
+		}
+	})
+
+	t.Run("foreign key", func(t *testing.T) {
+		_, other, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("keygen: %v", err)
+		}
+		if rr := do("Bearer " + signEdDSAToken(t, other, "k1", claims)); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want 401", rr.Code)
+		}
+	})
+}
+
+func TestHTTPTunnel_JWTAuthKeysUnavailable(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	t.Cleanup(ts.Close)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("keygen: %v", err)
+	}
+
+	registry := NewTunnelRegistry()
+	registry.jwksClient = ts.Client()
+	sess := &recordingSession{}
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{
+		JWT: &control.JWTAuth{JWKSURL: ts.URL + "/jwks.json"},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	req.Header.Set("Authorization", "Bearer "+signEdDSAToken(t, priv, "k1", map[string]any{"exp": time.Now().Add(time.Hour).Unix()}))
+	rr := httptest.NewRecorder()
+	h(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rr.Code)
+	}
+	if sess.openCount.Load() != 0 {
+		t.Fatalf("open count = %d, want 0", sess.openCount.Load())
+	}
+}
+
+func TestClaimHeaderValue(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []struct {
+		in   any
+		want string
+		ok   bool
+	}{
+		{"alice", "alice", true},
+		{float64(42), "42", true},
+		{true, "true", true},
+		{[]any{"a", "b"}, "a, b", true},
+		{map[string]any{"k": "v"}, `{"k":"v"}`, true},
+		{"a\r\nX-Evil: 1", "aX-Evil: 1", true},
+		{nil, "", false},
+	} {
+		got, ok := claimHeaderValue(c.in)
+		if got != c.want || ok != c.ok {
+			t.Fatalf("claimHeaderValue(%#v) = %q, %v; want %q, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestHTTPTunnel_JWTAuthWithShareLinks(t *testing.T) {
+	t.Parallel()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("keygen: %v", err)
+	}
+	gotCh := make(chan http.Header, 1)
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", &headerSession{gotCh: gotCh}, httpTunnelOptions{
+		ShareLinks: true,
+		JWT: &control.JWTAuth{
+			JWKS:         fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":%q}]}`, base64.RawURLEncoding.EncodeToString(pub)),
+			ClaimHeaders: []control.JWTClaimHeader{{Claim: "sub", Header: "X-User"}},
+		},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	cfg := Config{TunnelDomain: "tunnel.eosrift.test", ShareLinkSecret: "secret"}
+	h := httpTunnelProxyHandler(cfg, registry, nil, nil, nil)
+
+	// Without a share link, the JWT is still a way in.
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rr.Code)
+	}
+
+	// Share link visitors skip the JWT check, but cannot spoof claim headers.
+	token := mintShareToken(cfg.ShareLinkSecret, shareClaims{TunnelID: "abcd1234", Expires: time.Now().Add(time.Hour).Unix()})
+	req = httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	req.Header.Set("X-User", "spoofed")
+	req.AddCookie(&http.Cookie{Name: shareLinkCookie, Value: token})
+	rr = httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body=%q)", rr.Code, rr.Body.String())
+	}
+	if v := (<-gotCh).Get("X-User"); v != "" {
+		t.Fatalf("X-User = %q, want empty", v)
+	}
+}
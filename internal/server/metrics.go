@@ -3,31 +3,157 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
+
+	"eosrift.com/eosrift/internal/prom"
 )
 
+// metrics are the server's Prometheus metrics. Per-token labels go through
+// tokenLabels so their cardinality stays bounded.
 type metrics struct {
-	startedAt time.Time
-	now       func() time.Time
+	registry    *prom.Registry
+	tokenLabels *prom.LabelLimiter
+
+	activeControl *prom.Gauge
+	activeHTTP    *prom.Gauge
+	activeTCP     *prom.Gauge
 
-	activeControl atomic.Int64
-	activeHTTP    atomic.Int64
-	activeTCP     atomic.Int64
+	totalHTTP *prom.Counter
+	totalTCP  *prom.Counter
 
-	totalHTTP atomic.Int64
-	totalTCP  atomic.Int64
+	bodyTooLarge *prom.Counter
+	timeouts     *prom.Counter // kind
+	cache        *prom.Counter // result
+	mirrors      *prom.Counter // result
+	throttled    *prom.Counter // direction
+
+	httpRequests       *prom.Counter   // token, status_class
+	httpDuration       *prom.Histogram // token
+	tcpConnections     *prom.Counter   // token
+	tcpDuration        *prom.Histogram // token
+	tunnelBytes        *prom.Counter   // type, token, direction
+	streamOpenFailures *prom.Counter   // type, token
+	authFailures       *prom.Counter   // kind, token
+	rateLimited        *prom.Counter   // kind, token
 }
 
+// timeoutKind labels eosrift_http_timeouts_total.
+type timeoutKind int
+
+const (
+	timeoutResponseHeader timeoutKind = iota
+	timeoutRequest
+	timeoutWebSocketIdle
+	numTimeoutKinds
+)
+
+var timeoutKindNames = [numTimeoutKinds]string{"response_header", "request", "websocket_idle"}
+
+// mirrorResult labels eosrift_http_mirror_requests_total.
+type mirrorResult int
+
+const (
+	mirrorSuccess mirrorResult = iota // the shadow tunnel answered
+	mirrorFailure                     // unreachable, gone, or too many in flight
+	mirrorSkipped                     // body over the mirror limit
+	numMirrorResults
+)
+
+var mirrorResultNames = [numMirrorResults]string{"success", "failure", "skipped"}
+
+// bandwidthDirection labels eosrift_bandwidth_throttled_bytes_total.
+type bandwidthDirection int
+
+const (
+	bandwidthIn  bandwidthDirection = iota // visitor to upstream
+	bandwidthOut                           // upstream to visitor
+	numBandwidthDirections
+)
+
+var bandwidthDirectionNames = [numBandwidthDirections]string{"in", "out"}
+
+// authFailureKind labels eosrift_auth_failures_total.
+type authFailureKind string
+
+const (
+	authFailureAuthtoken authFailureKind = "authtoken"  // agent control connections
+	authFailureBasicAuth authFailureKind = "basic_auth" // visitors, missing or wrong credentials
+	authFailureJWT       authFailureKind = "jwt"
+)
+
+// rateLimitKind labels eosrift_rate_limited_total.
+type rateLimitKind string
+
+const (
+	rateLimitTunnelCreates rateLimitKind = "tunnel_creates" // EOSRIFT_MAX_TUNNEL_CREATES_PER_MIN
+	rateLimitMaxTunnels    rateLimitKind = "max_tunnels"    // EOSRIFT_MAX_TUNNELS_PER_TOKEN
+	rateLimitVisitor       rateLimitKind = "visitor"        // tunnel, traffic policy or admin rate limits
+)
+
+// tcpConnectionBuckets are upper bounds (seconds) for TCP connection
+// lifetimes, from a second to a day.
+var tcpConnectionBuckets = []float64{1, 10, 60, 300, 1800, 3600, 4 * 3600, 24 * 3600}
+
 func newMetrics(now func() time.Time) *metrics {
 	if now == nil {
 		now = time.Now
 	}
-	return &metrics{
-		startedAt: now(),
-		now:       now,
+	startedAt := now()
+
+	r := prom.NewRegistry()
+	r.NewGaugeFunc("eosrift_uptime_seconds", "Process uptime in seconds.", func() int64 {
+		return int64(now().Sub(startedAt).Seconds())
+	})
+	m := &metrics{
+		registry: r,
+
+		activeControl: r.NewGauge("eosrift_active_control_connections", "Active /control websocket connections."),
+		activeHTTP:    r.NewGauge("eosrift_active_http_tunnels", "Active HTTP tunnels."),
+		activeTCP:     r.NewGauge("eosrift_active_tcp_tunnels", "Active TCP tunnels."),
+
+		totalHTTP:    r.NewCounter("eosrift_http_tunnels_total", "Total HTTP tunnels created."),
+		totalTCP:     r.NewCounter("eosrift_tcp_tunnels_total", "Total TCP tunnels created."),
+		bodyTooLarge: r.NewCounter("eosrift_http_request_body_too_large_total", "HTTP tunnel requests rejected with 413 (body too large)."),
+		timeouts:     r.NewCounter("eosrift_http_timeouts_total", "HTTP tunnel requests that hit a timeout, by kind.", "kind"),
+		cache:        r.NewCounter("eosrift_http_cache_requests_total", "HTTP tunnel GET/HEAD requests on cache-enabled tunnels, by result.", "result"),
+		mirrors:      r.NewCounter("eosrift_http_mirror_requests_total", "Requests copied to shadow tunnels, by result.", "result"),
+		throttled:    r.NewCounter("eosrift_bandwidth_throttled_bytes_total", "Tunnel bytes delayed by bandwidth limits, by direction.", "direction"),
+
+		httpRequests:       r.NewCounter("eosrift_http_requests_total", "HTTP requests proxied to tunnels, by status class.", "token", "status_class"),
+		httpDuration:       r.NewHistogram("eosrift_http_request_duration_seconds", "Time to serve HTTP tunnel requests.", prom.DefBuckets, "token"),
+		tcpConnections:     r.NewCounter("eosrift_tcp_connections_total", "Visitor connections to TCP tunnels.", "token"),
+		tcpDuration:        r.NewHistogram("eosrift_tcp_connection_duration_seconds", "Lifetime of visitor connections to TCP tunnels.", tcpConnectionBuckets, "token"),
+		tunnelBytes:        r.NewCounter("eosrift_tunnel_bytes_total", "Visitor bytes through tunnels; in is visitor to upstream, out is upstream to visitor.", "type", "token", "direction"),
+		streamOpenFailures: r.NewCounter("eosrift_stream_open_failures_total", "Failures to open a stream to a tunnel's agent.", "type", "token"),
+		authFailures:       r.NewCounter("eosrift_auth_failures_total", "Requests rejected for missing or invalid credentials, by kind.", "kind", "token"),
+		rateLimited:        r.NewCounter("eosrift_rate_limited_total", "Requests and tunnel creations rejected by rate limits, by kind.", "kind", "token"),
+	}
+
+	// Export the fixed label sets before their first increment.
+	for _, name := range timeoutKindNames {
+		m.timeouts.Add(0, name)
+	}
+	for _, result := range []string{"hit", "miss"} {
+		m.cache.Add(0, result)
+	}
+	for _, name := range mirrorResultNames {
+		m.mirrors.Add(0, name)
+	}
+	for _, name := range bandwidthDirectionNames {
+		m.throttled.Add(0, name)
+	}
+	return m
+}
+
+// tokenLabel is the token label value for tokenID: empty for anonymous
+// tunnels or when token labels are off, "other" past the label limit.
+func (m *metrics) tokenLabel(tokenID int64) string {
+	if tokenID <= 0 {
+		return ""
 	}
+	return m.tokenLabels.Value(strconv.FormatInt(tokenID, 10))
 }
 
 func (m *metrics) trackControlConn() func() {
@@ -36,43 +162,104 @@ func (m *metrics) trackControlConn() func() {
 }
 
 func (m *metrics) trackHTTPTunnel() func() {
-	m.totalHTTP.Add(1)
+	m.totalHTTP.Inc()
 	m.activeHTTP.Add(1)
 	return func() { m.activeHTTP.Add(-1) }
 }
 
 func (m *metrics) trackTCPTunnel() func() {
-	m.totalTCP.Add(1)
+	m.totalTCP.Inc()
 	m.activeTCP.Add(1)
 	return func() { m.activeTCP.Add(-1) }
 }
 
-func (m *metrics) writePrometheus(w http.ResponseWriter) {
-	// Prometheus text format v0.0.4 (minimal).
-	// See: https://prometheus.io/docs/instrumenting/exposition_formats/
-	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+// The count and observe methods accept a nil receiver so the HTTP edge can
+// run without metrics (e.g. in tests).
+
+func (m *metrics) countBodyTooLarge() {
+	if m != nil {
+		m.bodyTooLarge.Inc()
+	}
+}
+
+func (m *metrics) countTimeout(kind timeoutKind) {
+	if m != nil {
+		m.timeouts.Inc(timeoutKindNames[kind])
+	}
+}
+
+func (m *metrics) countCache(hit bool) {
+	switch {
+	case m == nil:
+	case hit:
+		m.cache.Inc("hit")
+	default:
+		m.cache.Inc("miss")
+	}
+}
+
+func (m *metrics) countMirror(result mirrorResult) {
+	if m != nil {
+		m.mirrors.Inc(mirrorResultNames[result])
+	}
+}
+
+func (m *metrics) countThrottled(dir bandwidthDirection, n int) {
+	if m != nil {
+		m.throttled.Add(int64(n), bandwidthDirectionNames[dir])
+	}
+}
+
+func (m *metrics) countStreamOpenFailure(tunnelType string, tokenID int64) {
+	if m != nil {
+		m.streamOpenFailures.Inc(tunnelType, m.tokenLabel(tokenID))
+	}
+}
+
+func (m *metrics) countAuthFailure(kind authFailureKind, tokenID int64) {
+	if m != nil {
+		m.authFailures.Inc(string(kind), m.tokenLabel(tokenID))
+	}
+}
 
-	now := m.now()
-	uptime := int64(now.Sub(m.startedAt).Seconds())
+func (m *metrics) countRateLimited(kind rateLimitKind, tokenID int64) {
+	if m != nil {
+		m.rateLimited.Inc(string(kind), m.tokenLabel(tokenID))
+	}
+}
 
-	writeGauge := func(name, help string, value int64) {
-		_, _ = fmt.Fprintf(w, "# HELP %s %s\n", name, help)
-		_, _ = fmt.Fprintf(w, "# TYPE %s gauge\n", name)
-		_, _ = fmt.Fprintf(w, "%s %d\n", name, value)
+// observeHTTPRequest records a request proxied to an HTTP tunnel.
+func (m *metrics) observeHTTPRequest(tokenID int64, status int, bytesIn, bytesOut int64, d time.Duration) {
+	if m == nil {
+		return
 	}
-	writeCounter := func(name, help string, value int64) {
-		_, _ = fmt.Fprintf(w, "# HELP %s %s\n", name, help)
-		_, _ = fmt.Fprintf(w, "# TYPE %s counter\n", name)
-		_, _ = fmt.Fprintf(w, "%s %d\n", name, value)
+	token := m.tokenLabel(tokenID)
+	m.httpRequests.Inc(token, fmt.Sprintf("%dxx", status/100))
+	m.httpDuration.Observe(d.Seconds(), token)
+	m.tunnelBytes.Add(bytesIn, "http", token, "in")
+	m.tunnelBytes.Add(bytesOut, "http", token, "out")
+}
+
+func (m *metrics) countTCPConnection(tokenID int64) {
+	if m != nil {
+		m.tcpConnections.Inc(m.tokenLabel(tokenID))
 	}
+}
 
-	writeGauge("eosrift_uptime_seconds", "Process uptime in seconds.", uptime)
-	writeGauge("eosrift_active_control_connections", "Active /control websocket connections.", m.activeControl.Load())
-	writeGauge("eosrift_active_http_tunnels", "Active HTTP tunnels.", m.activeHTTP.Load())
-	writeGauge("eosrift_active_tcp_tunnels", "Active TCP tunnels.", m.activeTCP.Load())
+// observeTCPConnection records a closed visitor connection to a TCP tunnel.
+func (m *metrics) observeTCPConnection(tokenID int64, bytesIn, bytesOut int64, d time.Duration) {
+	if m == nil {
+		return
+	}
+	token := m.tokenLabel(tokenID)
+	m.tcpDuration.Observe(d.Seconds(), token)
+	m.tunnelBytes.Add(bytesIn, "tcp", token, "in")
+	m.tunnelBytes.Add(bytesOut, "tcp", token, "out")
+}
 
-	writeCounter("eosrift_http_tunnels_total", "Total HTTP tunnels created.", m.totalHTTP.Load())
-	writeCounter("eosrift_tcp_tunnels_total", "Total TCP tunnels created.", m.totalTCP.Load())
+func (m *metrics) writePrometheus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", prom.ContentType)
+	_ = m.registry.WriteText(w)
 }
 
 func metricsHandler(baseDomain, token string, m *metrics) http.HandlerFunc {
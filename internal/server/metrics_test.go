@@ -1,10 +1,16 @@
 package server
 
 import (
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"strings"
 	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/prom"
 )
 
 func TestNewHandler_metrics_disabledByDefault(t *testing.T) {
@@ -87,3 +93,132 @@ func TestNewHandler_metrics_requiresToken(t *testing.T) {
 		}
 	})
 }
+
+type failingSession struct{}
+
+func (failingSession) OpenStream() (net.Conn, error) { return nil, errors.New("session closed") }
+func (failingSession) Close() error                  { return nil }
+
+func TestMetrics_HTTPTunnelTraffic(t *testing.T) {
+	t.Parallel()
+
+	m := newMetrics(nil)
+	m.tokenLabels = prom.NewLabelLimiter(1)
+
+	registry := NewTunnelRegistry()
+	for id, reg := range map[string]struct {
+		sess streamSession
+		opts httpTunnelOptions
+	}{
+		"first":  {drainingUpstreamSession{body: "hello world"}, httpTunnelOptions{TokenID: 7}},
+		"second": {drainingUpstreamSession{body: "hi"}, httpTunnelOptions{TokenID: 8}},
+		"broken": {failingSession{}, httpTunnelOptions{TokenID: 7}},
+		"locked": {drainingUpstreamSession{}, httpTunnelOptions{TokenID: 7, BasicAuth: &basicAuthCredential{Username: "u", Password: "p"}}},
+	} {
+		if err := registry.RegisterHTTPTunnel(id, reg.sess, reg.opts); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, m, nil, nil)
+
+	for _, tc := range []struct {
+		id   string
+		want int
+	}{
+		{"first", http.StatusOK},
+		{"second", http.StatusOK},
+		{"broken", http.StatusBadGateway},
+		{"locked", http.StatusUnauthorized},
+		{"unknown", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://"+tc.id+".tunnel.eosrift.test/", strings.NewReader("ping"))
+		req.Header.Set("X-Request-Id", "req-"+tc.id)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Fatalf("%s: status = %d, want %d", tc.id, rr.Code, tc.want)
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"2xx token 7", m.httpRequests.Value("7", "2xx"), 1},
+		{"2xx over the token limit", m.httpRequests.Value(prom.Overflow, "2xx"), 1},
+		{"5xx token 7", m.httpRequests.Value("7", "5xx"), 1},
+		{"4xx token 7", m.httpRequests.Value("7", "4xx"), 1},
+		{"bytes in", m.tunnelBytes.Value("http", "7", "in"), 4},
+		// Error pages count as bytes sent to visitors too.
+		{"bytes out", m.tunnelBytes.Value("http", "7", "out"), int64(len("hello world") + len("bad gateway\nrequest id: req-broken\n") + len("unauthorized\n"))},
+		{"stream open failures", m.streamOpenFailures.Value("http", "7"), 1},
+		{"basic auth failures", m.authFailures.Value(string(authFailureBasicAuth), "7"), 1},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %d, want %d", c.name, c.got, c.want)
+		}
+	}
+	if got := m.httpDuration.Count("7"); got != 3 {
+		t.Errorf("duration observations = %d, want 3", got)
+	}
+}
+
+func TestMetrics_TCPConnection(t *testing.T) {
+	t.Parallel()
+
+	m := newMetrics(nil)
+	m.tokenLabels = prom.NewLabelLimiter(10)
+
+	visitor, edge := net.Pipe()
+	conn := recordTCP(edge, 20005, 3, netip.Addr{}, nil, m)
+	go func() {
+		_, _ = visitor.Write([]byte("hello"))
+		_ = visitor.Close()
+	}()
+	if _, err := conn.Read(make([]byte, 5)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = conn.Close()
+
+	if got := m.tcpConnections.Value("3"); got != 1 {
+		t.Fatalf("connections = %d, want 1", got)
+	}
+	if got := m.tunnelBytes.Value("tcp", "3", "in"); got != 5 {
+		t.Fatalf("bytes in = %d, want 5", got)
+	}
+	if got := m.tcpDuration.Count("3"); got != 1 {
+		t.Fatalf("duration observations = %d, want 1", got)
+	}
+}
+
+func TestMetrics_WritePrometheus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000, 0)
+	m := newMetrics(func() time.Time { return now })
+	now = now.Add(90 * time.Second)
+	m.countTimeout(timeoutRequest)
+	m.countRateLimited(rateLimitTunnelCreates, 5)
+
+	rec := httptest.NewRecorder()
+	m.writePrometheus(rec)
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"eosrift_uptime_seconds 90\n",
+		"# TYPE eosrift_http_timeouts_total counter\n",
+		`eosrift_http_timeouts_total{kind="request"} 1` + "\n",
+		`eosrift_http_timeouts_total{kind="websocket_idle"} 0` + "\n",
+		`eosrift_http_cache_requests_total{result="miss"} 0` + "\n",
+		// Token labels are off until the server sets a limit.
+		`eosrift_rate_limited_total{kind="tunnel_creates"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q", want)
+		}
+	}
+	if strings.Contains(body, "eosrift_http_requests_total") {
+		t.Errorf("body has request series before any request")
+	}
+}
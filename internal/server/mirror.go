@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+const (
+	// mirrorHeader marks copies sent to a shadow tunnel.
+	mirrorHeader = "X-Eosrift-Mirror"
+
+	// mirrorTimeout bounds a single shadow request, including reading (and
+	// discarding) its response.
+	mirrorTimeout = 30 * time.Second
+
+	// mirrorMaxInflight caps concurrent shadow requests per tunnel so a slow
+	// shadow upstream cannot pile up goroutines; excess copies are dropped.
+	mirrorMaxInflight = 64
+)
+
+// mirrorTarget is a compiled control.MirrorPolicy.
+type mirrorTarget struct {
+	tunnelID     string
+	maxBodyBytes int64
+	inflight     chan struct{}
+}
+
+// newMirrorTarget compiles a validated policy; nil when p is nil.
+func newMirrorTarget(p *control.MirrorPolicy) *mirrorTarget {
+	if p == nil {
+		return nil
+	}
+	return &mirrorTarget{
+		tunnelID:     p.Tunnel,
+		maxBodyBytes: p.MaxBodyBytes,
+		inflight:     make(chan struct{}, mirrorMaxInflight),
+	}
+}
+
+// mirrorTransport copies requests of mirroring tunnels to their shadow
+// tunnel in the background. The primary request goes to next unchanged; the
+// copy bypasses the edge cache and goes straight to the shadow's session
+// through shadow, and its response is discarded.
+type mirrorTransport struct {
+	next     http.RoundTripper
+	shadow   http.RoundTripper
+	registry *TunnelRegistry
+	metrics  *metrics
+}
+
+func (t *mirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry, ok := tunnelEntryFromContext(req.Context())
+	if !ok || entry.mirror == nil || isUpgradeRequest(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	body, complete, err := bufferMirrorBody(req, entry.mirror.maxBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		t.metrics.countMirror(mirrorSkipped)
+		return t.next.RoundTrip(req)
+	}
+
+	shadow, ok := t.registry.GetHTTPTunnel(entry.mirror.tunnelID)
+	if !ok || shadow.tokenID != entry.tokenID {
+		// Tunnels of other authtokens are treated as absent.
+		t.metrics.countMirror(mirrorFailure)
+		return t.next.RoundTrip(req)
+	}
+
+	select {
+	case entry.mirror.inflight <- struct{}{}:
+		out := shadowRequest(req, shadow, body)
+		go func() {
+			defer func() { <-entry.mirror.inflight }()
+			t.send(out)
+		}()
+	default:
+		t.metrics.countMirror(mirrorFailure)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// send delivers a shadow request and drains its response.
+func (t *mirrorTransport) send(req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), mirrorTimeout)
+	defer cancel()
+
+	resp, err := t.shadow.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		t.metrics.countMirror(mirrorFailure)
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	t.metrics.countMirror(mirrorSuccess)
+}
+
+// bufferMirrorBody reads up to limit bytes of req's body and puts them back
+// in front of the rest. complete reports whether the whole body was read;
+// err is the body's own read error (e.g. *http.MaxBytesError).
+func bufferMirrorBody(req *http.Request, limit int64) (body []byte, complete bool, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if req.ContentLength > limit {
+		return nil, false, nil
+	}
+
+	body, err = io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(body), req.Body), Closer: req.Body}
+	if int64(len(body)) > limit {
+		return nil, false, nil
+	}
+	return body, true, nil
+}
+
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// shadowRequest copies req for the shadow tunnel. The copy is detached from
+// the client's request so it outlives it, and carries only the shadow's
+// tunnel entry: none of the primary's limits or policy state apply.
+func shadowRequest(req *http.Request, shadow httpTunnelEntry, body []byte) *http.Request {
+	ctx := context.WithValue(context.Background(), tunnelEntryContextKey{}, shadow)
+	out := req.Clone(ctx)
+	out.Header.Set(mirrorHeader, "1")
+	out.ContentLength = int64(len(body))
+	out.TransferEncoding = nil
+	out.Body = http.NoBody
+	out.GetBody = nil
+	if len(body) > 0 {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+type mirroredRequest struct {
+	method, path, body, header string
+}
+
+func newMirroredTunnels(t *testing.T, mirror *control.MirrorPolicy, shadowTokenID int64, shadow http.HandlerFunc) (*metrics, http.HandlerFunc) {
+	t.Helper()
+
+	registry := NewTunnelRegistry()
+	primary := &handlerSession{h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, "primary:"+string(body))
+	})}
+	if err := registry.RegisterHTTPTunnel("main", primary, httpTunnelOptions{TokenID: 1, Mirror: mirror}); err != nil {
+		t.Fatalf("register primary: %v", err)
+	}
+	if err := registry.RegisterHTTPTunnel("shadow", &handlerSession{h: shadow}, httpTunnelOptions{TokenID: shadowTokenID}); err != nil {
+		t.Fatalf("register shadow: %v", err)
+	}
+
+	m := newMetrics(nil)
+	return m, httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, m, nil, nil)
+}
+
+func doMirrored(t *testing.T, h http.HandlerFunc, body string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "http://main.tunnel.eosrift.test/hook?x=1", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "primary:"+body {
+		t.Fatalf("primary response = %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func waitMirrorCount(t *testing.T, m *metrics, result mirrorResult, want int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.mirrors.Value(mirrorResultNames[result]) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s mirrors = %d, want %d", mirrorResultNames[result], m.mirrors.Value(mirrorResultNames[result]), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMirror_CopiesRequest(t *testing.T) {
+	t.Parallel()
+
+	got := make(chan mirroredRequest, 1)
+	m, h := newMirroredTunnels(t, &control.MirrorPolicy{Tunnel: "shadow", MaxBodyBytes: 64}, 1, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- mirroredRequest{r.Method, r.URL.RequestURI(), string(body), r.Header.Get(mirrorHeader)}
+		http.Error(w, "shadow output is discarded", http.StatusInternalServerError)
+	})
+
+	doMirrored(t, h, `{"ok":true}`)
+
+	select {
+	case req := <-got:
+		want := mirroredRequest{http.MethodPost, "/hook?x=1", `{"ok":true}`, "1"}
+		if req != want {
+			t.Fatalf("shadow got %+v, want %+v", req, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shadow tunnel got no request")
+	}
+	waitMirrorCount(t, m, mirrorSuccess, 1)
+}
+
+func TestMirror_SkipsLargeBodies(t *testing.T) {
+	t.Parallel()
+
+	m, h := newMirroredTunnels(t, &control.MirrorPolicy{Tunnel: "shadow", MaxBodyBytes: 4}, 1, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("shadow tunnel got an oversized request")
+	})
+
+	doMirrored(t, h, "0123456789")
+	waitMirrorCount(t, m, mirrorSkipped, 1)
+}
+
+func TestMirror_Failures(t *testing.T) {
+	t.Parallel()
+
+	// A shadow of another authtoken is treated as missing.
+	m, h := newMirroredTunnels(t, &control.MirrorPolicy{Tunnel: "shadow", MaxBodyBytes: 64}, 2, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("shadow tunnel of another token got a request")
+	})
+	doMirrored(t, h, "a")
+	waitMirrorCount(t, m, mirrorFailure, 1)
+
+	m, h = newMirroredTunnels(t, &control.MirrorPolicy{Tunnel: "missing", MaxBodyBytes: 64}, 1, nil)
+	doMirrored(t, h, "b")
+	waitMirrorCount(t, m, mirrorFailure, 1)
+}
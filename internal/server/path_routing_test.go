@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// pathEchoSession answers every request with the path and prefix it saw, a
+// redirect to /login and a cookie scoped to /app.
+type pathEchoSession struct{}
+
+func (pathEchoSession) OpenStream() (net.Conn, error) {
+	a, b := net.Pipe()
+
+	go func() {
+		defer b.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(b))
+		if err != nil {
+			return
+		}
+
+		body := req.URL.RequestURI() + " " + req.Header.Get("X-Forwarded-Prefix")
+		_, _ = fmt.Fprintf(b, "HTTP/1.1 302 Found\r\nLocation: /login?next=1\r\nSet-Cookie: sid=1; Path=/app; HttpOnly\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+	}()
+
+	return a, nil
+}
+
+func (pathEchoSession) Close() error { return nil }
+
+func TestHTTPTunnel_PathRouting(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", pathEchoSession{}, httpTunnelOptions{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	cfg := Config{
+		BaseDomain:   "eosrift.test",
+		TunnelDomain: "tunnel.eosrift.test",
+		HTTPRouting:  HTTPRoutingPath,
+	}
+	h := httpTunnelProxyHandler(cfg, registry, nil, nil, nil)
+
+	t.Run("strips prefix and rewrites response", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://eosrift.test/t/abcd1234/app/page?x=1", nil)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+
+		if rr.Code != http.StatusFound {
+			t.Fatalf("status = %d, want %d (body=%q)", rr.Code, http.StatusFound, rr.Body.String())
+		}
+		if got, want := rr.Body.String(), "/app/page?x=1 /t/abcd1234"; got != want {
+			t.Fatalf("upstream saw %q, want %q", got, want)
+		}
+		if got, want := rr.Header().Get("Location"), "/t/abcd1234/login?next=1"; got != want {
+			t.Fatalf("Location = %q, want %q", got, want)
+		}
+		if got, want := rr.Header().Get("Set-Cookie"), "sid=1; Path=/t/abcd1234/app; HttpOnly"; got != want {
+			t.Fatalf("Set-Cookie = %q, want %q", got, want)
+		}
+		if got := rr.Header().Get("Content-Security-Policy"); got != pathRoutingSandbox {
+			t.Fatalf("Content-Security-Policy = %q, want the sandbox", got)
+		}
+	})
+
+	t.Run("redirects bare prefix", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://eosrift.test/t/abcd1234?x=1", nil)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+
+		if rr.Code != http.StatusMovedPermanently {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusMovedPermanently)
+		}
+		if got, want := rr.Header().Get("Location"), "/t/abcd1234/?x=1"; got != want {
+			t.Fatalf("Location = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("subdomain host is not routed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://abcd1234.tunnel.eosrift.test/", nil)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("unknown tunnel", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://eosrift.test/t/nope/", nil)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestHTTPTunnel_PathRoutingPolicyRedirect(t *testing.T) {
+	t.Parallel()
+
+	redirect := func(path, location string) control.PolicyRule {
+		return control.PolicyRule{
+			Match:   control.PolicyMatch{Path: []string{path}},
+			Actions: []control.PolicyAction{{Type: "redirect", Status: 302, Location: location}},
+		}
+	}
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", pathEchoSession{}, httpTunnelOptions{
+		Policy: mustCompilePolicy(t, &control.TrafficPolicy{
+			OnRequest: []control.PolicyRule{
+				redirect("/old", "/new?x=1"),
+				redirect("/docs/old", "new"),
+				redirect("/away", "https://example.com/"),
+				redirect("/self", "http://eosrift.test/moved"),
+			},
+		}),
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	h := httpTunnelProxyHandler(Config{
+		BaseDomain:   "eosrift.test",
+		TunnelDomain: "tunnel.eosrift.test",
+		HTTPRouting:  HTTPRoutingPath,
+	}, registry, nil, nil, nil)
+
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{path: "/old", want: "/t/abcd1234/new?x=1"},
+		{path: "/docs/old", want: "/t/abcd1234/docs/new"},
+		{path: "/away", want: "https://example.com/"},
+		{path: "/self", want: "http://eosrift.test/t/abcd1234/moved"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://eosrift.test/t/abcd1234"+tc.path, nil)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+
+		if rr.Code != http.StatusFound {
+			t.Fatalf("%s: status = %d, want %d", tc.path, rr.Code, http.StatusFound)
+		}
+		if got := rr.Header().Get("Location"); got != tc.want {
+			t.Fatalf("%s: Location = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRewritePathRoutingLocation(t *testing.T) {
+	t.Parallel()
+
+	const prefix = "/t/abcd1234"
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/login", "/t/abcd1234/login"},
+		{"/t/abcd1234/login", "/t/abcd1234/login"},
+		{"https://eosrift.test/login?x=1", "https://eosrift.test/t/abcd1234/login?x=1"},
+		{"https://example.com/login", "https://example.com/login"},
+		{"//example.com/login", "//example.com/login"},
+		{"login", "login"},
+	}
+	for _, tt := range tests {
+		if got := rewritePathRoutingLocation(tt.in, prefix, "eosrift.test"); got != tt.want {
+			t.Fatalf("rewritePathRoutingLocation(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteSetCookiePath(t *testing.T) {
+	t.Parallel()
+
+	const prefix = "/t/abcd1234"
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"a=1; Path=/", "a=1; Path=/t/abcd1234/"},
+		{"a=1; path=/x; Secure", "a=1; Path=/t/abcd1234/x; Secure"},
+		{"a=1; Path=relative", "a=1; Path=/t/abcd1234/"},
+		{"a=1; HttpOnly", "a=1; HttpOnly; Path=/t/abcd1234/"},
+		{"a=1", "a=1; Path=/t/abcd1234/"},
+	}
+	for _, tt := range tests {
+		if got := rewriteSetCookiePath(tt.in, prefix); got != tt.want {
+			t.Fatalf("rewriteSetCookiePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewHandler_caddyAsk_pathRoutingOnlyBaseDomain(t *testing.T) {
+	t.Parallel()
+
+	srv := New(Config{
+		BaseDomain:   "eosrift.test",
+		TunnelDomain: "tunnel.eosrift.test",
+		HTTPRouting:  HTTPRoutingPath,
+	}, Dependencies{})
+	if err := srv.registry.RegisterHTTPTunnel("abcd1234", fakeSession{}, httpTunnelOptions{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := srv.Handler()
+
+	for domain, want := range map[string]int{
+		"eosrift.test":                 http.StatusOK,
+		"abcd1234.tunnel.eosrift.test": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/caddy/ask?domain="+domain, nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("%s: status = %d, want %d", domain, rr.Code, want)
+		}
+	}
+}
+
+func TestValidateAdminDomain(t *testing.T) {
+	t.Parallel()
+
+	base := Config{BaseDomain: "eosrift.test", TunnelDomain: "tunnel.eosrift.test", AdminToken: "secret"}
+	for name, tc := range map[string]struct {
+		mutate  func(*Config)
+		wantErr bool
+	}{
+		"subdomain routing":          {func(c *Config) {}, false},
+		"path routing without admin": {func(c *Config) { c.HTTPRouting, c.AdminToken = HTTPRoutingPath, "" }, false},
+		"path routing on base":       {func(c *Config) { c.HTTPRouting = HTTPRoutingPath }, true},
+		"path routing admin domain":  {func(c *Config) { c.HTTPRouting, c.AdminDomain = HTTPRoutingPath, "admin.eosrift.test" }, false},
+		"admin domain is a tunnel":   {func(c *Config) { c.AdminDomain = "admin.tunnel.eosrift.test" }, true},
+	} {
+		cfg := base
+		tc.mutate(&cfg)
+		if err := ValidateAdminDomain(cfg); (err != nil) != tc.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestNewHandler_pathRoutingAdminOnOwnDomain(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{
+		BaseDomain:   "eosrift.test",
+		TunnelDomain: "tunnel.eosrift.test",
+		HTTPRouting:  HTTPRoutingPath,
+		AdminToken:   "secret",
+	}
+	store := newStubAdminStore()
+
+	// Without an admin domain the admin UI stays off in path mode.
+	rr := httptest.NewRecorder()
+	NewHandler(cfg, Dependencies{AdminStore: store}).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://eosrift.test/admin/", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("admin on the base domain: status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+
+	cfg.AdminDomain = "admin.eosrift.test"
+	h := NewHandler(cfg, Dependencies{AdminStore: store})
+	for host, want := range map[string]int{
+		"eosrift.test":       http.StatusNotFound,
+		"admin.eosrift.test": http.StatusOK,
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://"+host+"/admin/", nil))
+		if rr.Code != want {
+			t.Fatalf("%s: status = %d, want %d", host, rr.Code, want)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/caddy/ask?domain=admin.eosrift.test", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("caddy ask for the admin domain: status = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
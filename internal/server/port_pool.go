@@ -0,0 +1,432 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eosrift.com/eosrift/internal/auth"
+	"eosrift.com/eosrift/internal/logging"
+)
+
+// maxPortBindAttempts bounds how many random candidates we try to bind before
+// walking the rest of the free list in order. Candidates can fail to bind when
+// something outside the pool (for example another process) holds the port.
+const maxPortBindAttempts = 16
+
+// maxAcceptDelay caps the backoff between retries of a temporary Accept
+// error such as running out of file descriptors.
+const maxAcceptDelay = time.Second
+
+// reservedTCPPortsRefreshInterval is how long the pool trusts its reserved
+// set before reloading it. Reservations this server makes reload it sooner;
+// the interval picks up ones made elsewhere (eosrift-server tcp-reserve).
+const reservedTCPPortsRefreshInterval = 30 * time.Second
+
+// tcpPortPool tracks TCP tunnel ports in memory so allocation does not need to
+// probe the whole range with net.Listen.
+//
+// Ports are in exactly one of three states:
+//   - free: eligible for random auto-allocation
+//   - allocated: bound by an active tunnel
+//   - reserved: claimed in SQLite (reserved_tcp_ports); only handed out when
+//     explicitly requested by the owning token
+type tcpPortPool struct {
+	mu sync.Mutex
+
+	start int
+	end   int
+
+	bindAddrs []string
+
+	// free holds the auto-allocatable ports; index maps port -> position in
+	// free so membership changes are O(1) (swap-remove).
+	free  []int
+	index map[int]int
+
+	allocated map[int]struct{}
+	reserved  map[int]struct{}
+
+	// reservedSynced is when reserved was last loaded from the reservation
+	// store; zero means it must be reloaded before the next auto-allocation.
+	// reservedGen counts invalidations, so a load that raced one doesn't
+	// mark the set as synced.
+	reservedSynced time.Time
+	reservedGen    uint64
+
+	randIntN func(n int) int
+	listen   func(network, addr string) (net.Listener, error)
+}
+
+func newTCPPortPool(cfg Config) *tcpPortPool {
+	p := &tcpPortPool{
+		start:     cfg.TCPPortRangeStart,
+		end:       cfg.TCPPortRangeEnd,
+		bindAddrs: normalizeBindAddrs(cfg.TCPBindAddrs),
+		index:     make(map[int]int),
+		allocated: make(map[int]struct{}),
+		reserved:  make(map[int]struct{}),
+		randIntN:  rand.IntN,
+		listen:    net.Listen,
+	}
+
+	if p.validRange() {
+		p.free = make([]int, 0, p.end-p.start+1)
+		for port := p.start; port <= p.end; port++ {
+			p.addFreeLocked(port)
+		}
+	}
+
+	return p
+}
+
+func (p *tcpPortPool) validRange() bool {
+	return p.start > 0 && p.end > 0 && p.end >= p.start && p.end <= 65535
+}
+
+func (p *tcpPortPool) inRange(port int) bool {
+	return port >= p.start && port <= p.end
+}
+
+// Allocate binds a listener on the requested port, or on a free port when
+// requestedPort is 0: a random one at first, then, once maxPortBindAttempts
+// candidates failed to bind, each remaining one in turn. The returned
+// listener releases its port back to the pool when closed.
+func (p *tcpPortPool) Allocate(requestedPort int) (net.Listener, int, error) {
+	if requestedPort != 0 {
+		return p.allocateRequested(requestedPort)
+	}
+
+	if !p.validRange() {
+		return nil, 0, fmt.Errorf("invalid tcp port range")
+	}
+
+	// Ports that failed to bind stay allocated until we are done, so no
+	// candidate is tried twice.
+	var failed []int
+	defer func() {
+		for _, port := range failed {
+			p.release(port)
+		}
+	}()
+
+	for attempt := 0; ; attempt++ {
+		port, ok := p.take(attempt < maxPortBindAttempts)
+		if !ok {
+			break
+		}
+
+		ln, err := p.bind(port)
+		if err != nil {
+			failed = append(failed, port)
+			continue
+		}
+		return ln, port, nil
+	}
+
+	return nil, 0, fmt.Errorf("no ports available")
+}
+
+func (p *tcpPortPool) allocateRequested(port int) (net.Listener, int, error) {
+	if !p.inRange(port) {
+		return nil, 0, fmt.Errorf("requested port out of range")
+	}
+
+	p.mu.Lock()
+	if _, busy := p.allocated[port]; busy {
+		p.mu.Unlock()
+		return nil, 0, fmt.Errorf("requested port unavailable")
+	}
+	p.removeFreeLocked(port)
+	p.allocated[port] = struct{}{}
+	p.mu.Unlock()
+
+	ln, err := p.bind(port)
+	if err != nil {
+		p.release(port)
+		return nil, 0, fmt.Errorf("requested port unavailable")
+	}
+	return ln, port, nil
+}
+
+// take moves a free port, random or else the last one, to allocated.
+func (p *tcpPortPool) take(random bool) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.free) == 0 {
+		return 0, false
+	}
+
+	i := len(p.free) - 1
+	if random {
+		i = p.randIntN(len(p.free))
+	}
+	port := p.free[i]
+	p.removeFreeLocked(port)
+	p.allocated[port] = struct{}{}
+	return port, true
+}
+
+func (p *tcpPortPool) release(port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.allocated[port]; !ok {
+		return
+	}
+	delete(p.allocated, port)
+
+	if _, reserved := p.reserved[port]; reserved {
+		return
+	}
+	p.addFreeLocked(port)
+}
+
+// SetReserved replaces the set of ports excluded from auto-allocation.
+// Ports outside the pool range are ignored.
+func (p *tcpPortPool) SetReserved(ports []int) {
+	next := make(map[int]struct{}, len(ports))
+	for _, port := range ports {
+		if p.inRange(port) {
+			next[port] = struct{}{}
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for port := range p.reserved {
+		if _, still := next[port]; still {
+			continue
+		}
+		if _, busy := p.allocated[port]; !busy {
+			p.addFreeLocked(port)
+		}
+	}
+	for port := range next {
+		p.removeFreeLocked(port)
+	}
+	p.reserved = next
+}
+
+// InvalidateReserved makes the next refreshReservedTCPPorts reload the
+// reserved set. Call it after changing a reservation.
+func (p *tcpPortPool) InvalidateReserved() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.reservedSynced = time.Time{}
+	p.reservedGen++
+	p.mu.Unlock()
+}
+
+// reservedStale reports whether the reserved set should be reloaded at now,
+// and the generation to pass to markReservedSynced once it has been.
+func (p *tcpPortPool) reservedStale(now time.Time) (uint64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reservedGen, p.reservedSynced.IsZero() || now.Sub(p.reservedSynced) >= reservedTCPPortsRefreshInterval
+}
+
+// markReservedSynced records a load that started at now, unless the set was
+// invalidated since reservedStale returned gen.
+func (p *tcpPortPool) markReservedSynced(now time.Time, gen uint64) {
+	p.mu.Lock()
+	if p.reservedGen == gen {
+		p.reservedSynced = now
+	}
+	p.mu.Unlock()
+}
+
+// Stats returns the number of free, allocated, and reserved ports.
+func (p *tcpPortPool) Stats() (free, allocated, reserved int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.free), len(p.allocated), len(p.reserved)
+}
+
+func (p *tcpPortPool) addFreeLocked(port int) {
+	if _, ok := p.index[port]; ok {
+		return
+	}
+	p.index[port] = len(p.free)
+	p.free = append(p.free, port)
+}
+
+func (p *tcpPortPool) removeFreeLocked(port int) {
+	i, ok := p.index[port]
+	if !ok {
+		return
+	}
+	last := len(p.free) - 1
+	if i != last {
+		moved := p.free[last]
+		p.free[i] = moved
+		p.index[moved] = i
+	}
+	p.free = p.free[:last]
+	delete(p.index, port)
+}
+
+func (p *tcpPortPool) bind(port int) (net.Listener, error) {
+	portStr := strconv.Itoa(port)
+
+	lns := make([]net.Listener, 0, len(p.bindAddrs))
+	for _, host := range p.bindAddrs {
+		ln, err := p.listen("tcp", net.JoinHostPort(host, portStr))
+		if err != nil {
+			for _, l := range lns {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+
+	return newPooledListener(lns, func() { p.release(port) }), nil
+}
+
+func normalizeBindAddrs(addrs []string) []string {
+	var out []string
+	seen := make(map[string]struct{}, len(addrs))
+	for _, a := range addrs {
+		a = strings.TrimSpace(a)
+		a = strings.TrimPrefix(a, "[")
+		a = strings.TrimSuffix(a, "]")
+		if _, dup := seen[a]; dup {
+			continue
+		}
+		seen[a] = struct{}{}
+		out = append(out, a)
+	}
+	if len(out) == 0 {
+		// Empty host binds all interfaces (dual-stack where supported).
+		return []string{""}
+	}
+	return out
+}
+
+// pooledListener fans in Accept from one listener per bind address and returns
+// its port to the pool once closed.
+type pooledListener struct {
+	lns     []net.Listener
+	conns   chan net.Conn
+	done    chan struct{}
+	release func()
+
+	closeOnce sync.Once
+	errOnce   sync.Once
+	err       error
+}
+
+func newPooledListener(lns []net.Listener, release func()) *pooledListener {
+	l := &pooledListener{
+		lns:     lns,
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+		release: release,
+	}
+	for _, ln := range lns {
+		go l.acceptLoop(ln)
+	}
+	return l
+}
+
+func (l *pooledListener) acceptLoop(ln net.Listener) {
+	var delay time.Duration
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// Back off like net/http's Server instead of tearing the
+				// tunnel down when, say, file descriptors run out.
+				delay = min(max(2*delay, 5*time.Millisecond), maxAcceptDelay)
+				select {
+				case <-time.After(delay):
+					continue
+				case <-l.done:
+					return
+				}
+			}
+			l.errOnce.Do(func() { l.err = err })
+			_ = l.Close()
+			return
+		}
+		delay = 0
+		select {
+		case l.conns <- c:
+		case <-l.done:
+			_ = c.Close()
+			return
+		}
+	}
+}
+
+func (l *pooledListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		l.errOnce.Do(func() { l.err = net.ErrClosed })
+		return nil, l.err
+	}
+}
+
+func (l *pooledListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		for _, ln := range l.lns {
+			_ = ln.Close()
+		}
+		if l.release != nil {
+			l.release()
+		}
+	})
+	return nil
+}
+
+func (l *pooledListener) Addr() net.Addr {
+	return l.lns[0].Addr()
+}
+
+type tcpPortReservationLister interface {
+	ListReservedTCPPorts(ctx context.Context) ([]auth.ReservedTCPPort, error)
+}
+
+// refreshReservedTCPPorts syncs the pool's reserved set from the reservation
+// store (when it can list reservations) so auto-allocation never hands out a
+// port another token has reserved. It only queries the store when the set
+// was invalidated or is older than reservedTCPPortsRefreshInterval.
+func refreshReservedTCPPorts(ctx context.Context, ports *tcpPortPool, store ReservationStore, logger logging.Logger) {
+	lister, ok := store.(tcpPortReservationLister)
+	if !ok || ports == nil {
+		return
+	}
+	now := time.Now()
+	gen, stale := ports.reservedStale(now)
+	if !stale {
+		return
+	}
+
+	list, err := lister.ListReservedTCPPorts(ctx)
+	if err != nil {
+		if logger != nil {
+			logger.Warn("list reserved tcp ports", logging.F("err", err))
+		}
+		return
+	}
+
+	reserved := make([]int, 0, len(list))
+	for _, r := range list {
+		reserved = append(reserved, r.Port)
+	}
+	ports.SetReserved(reserved)
+	ports.markReservedSynced(now, gen)
+}
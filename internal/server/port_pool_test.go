@@ -0,0 +1,351 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/auth"
+)
+
+type fakeListener struct {
+	addr   string
+	closed bool
+}
+
+func (l *fakeListener) Accept() (net.Conn, error) { return nil, net.ErrClosed }
+func (l *fakeListener) Close() error              { l.closed = true; return nil }
+func (l *fakeListener) Addr() net.Addr            { return &net.TCPAddr{} }
+
+func newFakePortPool(start, end int, bindAddrs []string, busy map[string]bool) (*tcpPortPool, *[]string) {
+	p := newTCPPortPool(Config{TCPPortRangeStart: start, TCPPortRangeEnd: end, TCPBindAddrs: bindAddrs})
+	var dialed []string
+	p.listen = func(network, addr string) (net.Listener, error) {
+		dialed = append(dialed, addr)
+		if busy[addr] {
+			return nil, errors.New("address already in use")
+		}
+		return &fakeListener{addr: addr}, nil
+	}
+	return p, &dialed
+}
+
+func TestTCPPortPool_RandomAllocationAndRelease(t *testing.T) {
+	t.Parallel()
+
+	p, _ := newFakePortPool(30000, 30002, nil, nil)
+	p.randIntN = func(n int) int { return n - 1 }
+
+	seen := make(map[int]net.Listener)
+	for i := 0; i < 3; i++ {
+		ln, port, err := p.Allocate(0)
+		if err != nil {
+			t.Fatalf("allocate %d: %v", i, err)
+		}
+		if port < 30000 || port > 30002 {
+			t.Fatalf("port = %d, out of range", port)
+		}
+		if _, dup := seen[port]; dup {
+			t.Fatalf("port %d allocated twice", port)
+		}
+		seen[port] = ln
+	}
+
+	if _, _, err := p.Allocate(0); err == nil || err.Error() != "no ports available" {
+		t.Fatalf("err = %v, want no ports available", err)
+	}
+
+	_ = seen[30001].Close()
+	_, port, err := p.Allocate(0)
+	if err != nil {
+		t.Fatalf("allocate after release: %v", err)
+	}
+	if port != 30001 {
+		t.Fatalf("port = %d, want %d", port, 30001)
+	}
+}
+
+func TestTCPPortPool_SkipsReservedForAutoAllocation(t *testing.T) {
+	t.Parallel()
+
+	p, _ := newFakePortPool(30000, 30001, nil, nil)
+	p.SetReserved([]int{30000, 12345})
+
+	free, allocated, reserved := p.Stats()
+	if free != 1 || allocated != 0 || reserved != 1 {
+		t.Fatalf("stats = (%d, %d, %d), want (1, 0, 1)", free, allocated, reserved)
+	}
+
+	_, port, err := p.Allocate(0)
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if port != 30001 {
+		t.Fatalf("port = %d, want %d", port, 30001)
+	}
+	if _, _, err := p.Allocate(0); err == nil {
+		t.Fatalf("err = nil, want no ports available")
+	}
+
+	// Explicit requests (the owning token) may still claim a reserved port.
+	ln, port, err := p.Allocate(30000)
+	if err != nil {
+		t.Fatalf("allocate reserved: %v", err)
+	}
+	if port != 30000 {
+		t.Fatalf("port = %d, want %d", port, 30000)
+	}
+	_ = ln.Close()
+
+	// Released reserved ports stay out of the free list.
+	if free, _, _ := p.Stats(); free != 0 {
+		t.Fatalf("free = %d, want 0", free)
+	}
+
+	p.SetReserved(nil)
+	if free, _, _ := p.Stats(); free != 1 {
+		t.Fatalf("free after unreserve = %d, want 1", free)
+	}
+}
+
+// countingPortLister lists ports as reserved and counts the queries. during,
+// when set, runs while a query is in flight.
+type countingPortLister struct {
+	ReservationStore
+	ports  []int
+	calls  int
+	during func()
+}
+
+func (s *countingPortLister) ListReservedTCPPorts(ctx context.Context) ([]auth.ReservedTCPPort, error) {
+	s.calls++
+	if s.during != nil {
+		s.during()
+	}
+	out := make([]auth.ReservedTCPPort, 0, len(s.ports))
+	for _, port := range s.ports {
+		out = append(out, auth.ReservedTCPPort{Port: port})
+	}
+	return out, nil
+}
+
+func TestRefreshReservedTCPPorts_OnlyWhenStale(t *testing.T) {
+	t.Parallel()
+
+	p, _ := newFakePortPool(30000, 30002, nil, nil)
+	store := &countingPortLister{ports: []int{30000}}
+	refresh := func() { refreshReservedTCPPorts(context.Background(), p, store, nil) }
+
+	refresh()
+	refresh()
+	if _, _, reserved := p.Stats(); store.calls != 1 || reserved != 1 {
+		t.Fatalf("calls = %d, reserved = %d, want 1 and 1", store.calls, reserved)
+	}
+
+	store.ports = append(store.ports, 30001)
+	p.InvalidateReserved()
+	refresh()
+	if _, _, reserved := p.Stats(); store.calls != 2 || reserved != 2 {
+		t.Fatalf("after invalidate: calls = %d, reserved = %d, want 2 and 2", store.calls, reserved)
+	}
+
+	gen, _ := p.reservedStale(time.Now())
+	p.markReservedSynced(time.Now().Add(-reservedTCPPortsRefreshInterval), gen)
+	refresh()
+	if store.calls != 3 {
+		t.Fatalf("after the refresh interval: calls = %d, want 3", store.calls)
+	}
+}
+
+func TestRefreshReservedTCPPorts_InvalidateDuringQuery(t *testing.T) {
+	t.Parallel()
+
+	p, _ := newFakePortPool(30000, 30002, nil, nil)
+	store := &countingPortLister{ports: []int{30000}}
+	store.during = func() {
+		// A reservation lands after the store was read.
+		store.during = nil
+		p.InvalidateReserved()
+	}
+	refresh := func() { refreshReservedTCPPorts(context.Background(), p, store, nil) }
+
+	refresh()
+	if _, stale := p.reservedStale(time.Now()); !stale {
+		t.Fatalf("reserved set marked synced despite a concurrent invalidation")
+	}
+	refresh()
+	if store.calls != 2 {
+		t.Fatalf("calls = %d, want 2", store.calls)
+	}
+	if _, stale := p.reservedStale(time.Now()); stale {
+		t.Fatalf("reserved set still stale after a clean reload")
+	}
+}
+
+func TestTCPPortPool_RequestedPortAlreadyAllocated(t *testing.T) {
+	t.Parallel()
+
+	p, _ := newFakePortPool(30000, 30000, nil, nil)
+	if _, _, err := p.Allocate(30000); err != nil {
+		t.Fatalf("first allocate: %v", err)
+	}
+	if _, _, err := p.Allocate(30000); err == nil || err.Error() != "requested port unavailable" {
+		t.Fatalf("err = %v, want requested port unavailable", err)
+	}
+}
+
+func TestTCPPortPool_RetriesPortsBusyOutsidePool(t *testing.T) {
+	t.Parallel()
+
+	p, dialed := newFakePortPool(30000, 30001, nil, map[string]bool{":30000": true})
+	p.randIntN = func(n int) int { return 0 }
+
+	_, port, err := p.Allocate(0)
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if port != 30001 {
+		t.Fatalf("port = %d, want %d (dialed=%v)", port, 30001, *dialed)
+	}
+
+	// The busy port goes back to the free list for a later attempt.
+	if free, allocated, _ := p.Stats(); free != 1 || allocated != 1 {
+		t.Fatalf("stats = (%d, %d), want (1, 1)", free, allocated)
+	}
+}
+
+func TestTCPPortPool_ScansFreePortsAfterRandomAttempts(t *testing.T) {
+	t.Parallel()
+
+	const start, end, open = 30000, 30031, 30015
+	busy := make(map[string]bool)
+	for port := start; port <= end; port++ {
+		if port != open {
+			busy[":"+strconv.Itoa(port)] = true
+		}
+	}
+	p, dialed := newFakePortPool(start, end, nil, busy)
+	p.randIntN = func(n int) int { return 0 }
+
+	_, port, err := p.Allocate(0)
+	if err != nil {
+		t.Fatalf("allocate: %v (dialed=%v)", err, *dialed)
+	}
+	if port != open {
+		t.Fatalf("port = %d, want %d", port, open)
+	}
+
+	seen := make(map[string]bool)
+	for _, addr := range *dialed {
+		if seen[addr] {
+			t.Fatalf("dialed %s twice (dialed=%v)", addr, *dialed)
+		}
+		seen[addr] = true
+	}
+	if free, allocated, _ := p.Stats(); free != end-start || allocated != 1 {
+		t.Fatalf("stats = (%d, %d), want (%d, 1)", free, allocated, end-start)
+	}
+}
+
+// flakyListener fails Accept with a temporary error a few times before
+// handing out a connection.
+type flakyListener struct {
+	fakeListener
+	failures int
+	conn     net.Conn
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+	if c := l.conn; c != nil {
+		l.conn = nil
+		return c, nil
+	}
+	return nil, net.ErrClosed
+}
+
+func TestPooledListener_RetriesTemporaryAcceptErrors(t *testing.T) {
+	t.Parallel()
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	ln := newPooledListener([]net.Listener{&flakyListener{failures: 3, conn: c1}}, nil)
+	defer ln.Close()
+
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	if c != c1 {
+		t.Fatalf("accepted %v, want the listener's connection", c)
+	}
+	_ = c.Close()
+}
+
+func TestTCPPortPool_BindsEachConfiguredAddress(t *testing.T) {
+	t.Parallel()
+
+	p, dialed := newFakePortPool(30000, 30000, []string{"127.0.0.1", "[::1]", "::1"}, nil)
+
+	ln, _, err := p.Allocate(0)
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	want := []string{"127.0.0.1:30000", "[::1]:30000"}
+	if len(*dialed) != len(want) {
+		t.Fatalf("dialed = %v, want %v", *dialed, want)
+	}
+	for i := range want {
+		if (*dialed)[i] != want[i] {
+			t.Fatalf("dialed = %v, want %v", *dialed, want)
+		}
+	}
+
+	_ = ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("accept after close err = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestTCPPortPool_PartialBindFailureClosesListeners(t *testing.T) {
+	t.Parallel()
+
+	p, _ := newFakePortPool(30000, 30000, []string{"127.0.0.1", "::1"}, map[string]bool{"[::1]:30000": true})
+
+	var opened []*fakeListener
+	listen := p.listen
+	p.listen = func(network, addr string) (net.Listener, error) {
+		ln, err := listen(network, addr)
+		if fl, ok := ln.(*fakeListener); ok {
+			opened = append(opened, fl)
+		}
+		return ln, err
+	}
+
+	if _, _, err := p.Allocate(30000); err == nil {
+		t.Fatalf("err = nil, want non-nil")
+	}
+	if len(opened) != 1 || !opened[0].closed {
+		t.Fatalf("opened = %+v, want one closed listener", opened)
+	}
+}
+
+func TestGetenvList(t *testing.T) {
+	t.Setenv("EOSRIFT_TEST_LIST", " 0.0.0.0 , ,::1,")
+	got := getenvList("EOSRIFT_TEST_LIST")
+	if len(got) != 2 || got[0] != "0.0.0.0" || got[1] != "::1" {
+		t.Fatalf("got = %#v, want [0.0.0.0 ::1]", got)
+	}
+}
@@ -5,31 +5,62 @@ import (
 	"encoding/base32"
 	"errors"
 	"net"
+	"net/http"
 	"net/netip"
 	"strings"
 	"sync"
+
+	"eosrift.com/eosrift/internal/basicauth"
+	"eosrift.com/eosrift/internal/control"
+	"eosrift.com/eosrift/internal/policy"
 )
 
 type TunnelRegistry struct {
 	mu          sync.RWMutex
 	httpTunnels map[string]httpTunnelEntry
+
+	secretTCPTunnels map[string]secretTCPTunnelEntry
+	secretTCPNames   map[string]secretTCPNameBinding
+	tcpTunnels       map[int]tcpTunnelEntry
+
+	// jwksClient fetches remote JWKS for tunnels with JWT auth; nil uses
+	// jwtauth.NewHTTPClient (tests point it at local servers).
+	jwksClient *http.Client
 }
 
 type httpTunnelEntry struct {
 	session   streamSession
-	basicAuth *basicAuthCredential
+	basicAuth *basicAuthGate
+
+	// access holds the legacy method/path allowlists and CIDR rules, which
+	// are checked before anything else answers the request. policy is the
+	// compiled traffic policy: the other legacy options followed by the
+	// tunnel's own rules. Either is nil when there are no rules.
+	access *policy.Engine
+	policy *policy.Engine
+
+	// rateLimitOverride is set by operators via the admin API and is
+	// checked before the tunnel's own policy.
+	rateLimitOverride *policy.RateLimiter
+
+	// shareLinks requires a signed share link (or basic auth, if set).
+	shareLinks bool
+
+	jwt *jwtGate
+
+	cors *corsPolicy
+
+	compression *compressionPolicy
+	cache       *edgeCache
 
-	allowCIDRs []netip.Prefix
-	denyCIDRs  []netip.Prefix
+	// tokenID is the authtoken the tunnel was created with (0 when auth is
+	// disabled). browserWarning shows the browser interstitial.
+	tokenID        int64
+	browserWarning bool
 
-	allowMethods      []string
-	allowPaths        []string
-	allowPathPrefixes []string
+	limits httpLimits
 
-	requestHeaderAdd     []headerKV
-	requestHeaderRemove  []string
-	responseHeaderAdd    []headerKV
-	responseHeaderRemove []string
+	mirror *mirrorTarget
 }
 
 type basicAuthCredential struct {
@@ -43,11 +74,18 @@ type headerKV struct {
 }
 
 type httpTunnelOptions struct {
-	BasicAuth *basicAuthCredential
+	BasicAuth      *basicAuthCredential
+	BasicAuthUsers []control.BasicAuthUser
 
 	AllowCIDRs []netip.Prefix
 	DenyCIDRs  []netip.Prefix
 
+	RateLimit       string
+	RateLimitHeader string
+
+	Redirects []control.RedirectRule
+	Rewrites  []control.RewriteRule
+
 	AllowMethods      []string
 	AllowPaths        []string
 	AllowPathPrefixes []string
@@ -56,6 +94,70 @@ type httpTunnelOptions struct {
 	RequestHeaderRemove  []string
 	ResponseHeaderAdd    []headerKV
 	ResponseHeaderRemove []string
+
+	// Policy is the tunnel's compiled traffic policy. Its rules run after
+	// the legacy options above.
+	Policy *policy.Engine
+
+	ShareLinks bool
+
+	JWT *control.JWTAuth
+
+	CORS *control.CORSPolicy
+
+	Compression *control.CompressionPolicy
+
+	// Cache is the tunnel's edge cache; the registry closes it when the
+	// tunnel is unregistered.
+	Cache *edgeCache
+
+	TokenID        int64
+	BrowserWarning bool
+
+	// Limits are the effective limits (see resolveHTTPLimits).
+	Limits httpLimits
+
+	Mirror *control.MirrorPolicy
+}
+
+func (o httpTunnelOptions) legacy() policy.Legacy {
+	return policy.Legacy{
+		AllowMethods:         o.AllowMethods,
+		AllowPaths:           o.AllowPaths,
+		AllowPathPrefixes:    o.AllowPathPrefixes,
+		AllowCIDRs:           prefixStrings(o.AllowCIDRs),
+		DenyCIDRs:            prefixStrings(o.DenyCIDRs),
+		RateLimit:            o.RateLimit,
+		RateLimitHeader:      o.RateLimitHeader,
+		Redirects:            o.Redirects,
+		Rewrites:             o.Rewrites,
+		RequestHeaderAdd:     toControlHeaderKVs(o.RequestHeaderAdd),
+		RequestHeaderRemove:  o.RequestHeaderRemove,
+		ResponseHeaderAdd:    toControlHeaderKVs(o.ResponseHeaderAdd),
+		ResponseHeaderRemove: o.ResponseHeaderRemove,
+	}
+}
+
+// trafficPolicy expresses the legacy options, other than the access rules,
+// as policy rules.
+func (o httpTunnelOptions) trafficPolicy() *control.TrafficPolicy {
+	return policy.FromLegacy(o.legacy())
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	out := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		out = append(out, p.String())
+	}
+	return out
+}
+
+func toControlHeaderKVs(values []headerKV) []control.HeaderKV {
+	out := make([]control.HeaderKV, 0, len(values))
+	for _, kv := range values {
+		out = append(out, control.HeaderKV{Name: kv.Name, Value: kv.Value})
+	}
+	return out
 }
 
 // streamSession is intentionally minimal and only supports opening a stream.
@@ -68,6 +170,10 @@ type streamSession interface {
 func NewTunnelRegistry() *TunnelRegistry {
 	return &TunnelRegistry{
 		httpTunnels: make(map[string]httpTunnelEntry),
+
+		secretTCPTunnels: make(map[string]secretTCPTunnelEntry),
+		secretTCPNames:   make(map[string]secretTCPNameBinding),
+		tcpTunnels:       make(map[int]tcpTunnelEntry),
 	}
 }
 
@@ -80,6 +186,19 @@ func (r *TunnelRegistry) RegisterHTTPTunnel(id string, session streamSession, op
 		return errors.New("nil session")
 	}
 
+	access, err := policy.Compile(policy.LegacyAccess(opts.legacy()), policy.Options{})
+	if err != nil {
+		return err
+	}
+	legacy, err := policy.Compile(opts.trafficPolicy(), policy.Options{})
+	if err != nil {
+		return err
+	}
+	jwt, err := newJWTGate(opts.JWT, r.jwksClient)
+	if err != nil {
+		return err
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -88,19 +207,24 @@ func (r *TunnelRegistry) RegisterHTTPTunnel(id string, session streamSession, op
 	}
 
 	r.httpTunnels[id] = httpTunnelEntry{
-		session:    session,
-		basicAuth:  opts.BasicAuth,
-		allowCIDRs: opts.AllowCIDRs,
-		denyCIDRs:  opts.DenyCIDRs,
+		session:   session,
+		basicAuth: newBasicAuthGate(opts.BasicAuth, basicauth.NewVerifier(opts.BasicAuthUsers)),
+		access:    access,
+		policy:    policy.Join(legacy, opts.Policy),
 
-		allowMethods:      opts.AllowMethods,
-		allowPaths:        opts.AllowPaths,
-		allowPathPrefixes: opts.AllowPathPrefixes,
+		shareLinks: opts.ShareLinks,
+		jwt:        jwt,
+		cors:       newCORSPolicy(opts.CORS),
 
-		requestHeaderAdd:     opts.RequestHeaderAdd,
-		requestHeaderRemove:  opts.RequestHeaderRemove,
-		responseHeaderAdd:    opts.ResponseHeaderAdd,
-		responseHeaderRemove: opts.ResponseHeaderRemove,
+		compression: newCompressionPolicy(opts.Compression),
+		cache:       opts.Cache,
+
+		tokenID:        opts.TokenID,
+		browserWarning: opts.BrowserWarning,
+
+		limits: opts.Limits,
+
+		mirror: newMirrorTarget(opts.Mirror),
 	}
 	return nil
 }
@@ -121,16 +245,70 @@ func (r *TunnelRegistry) GetHTTPTunnel(id string) (httpTunnelEntry, bool) {
 	return t, true
 }
 
-func (r *TunnelRegistry) UnregisterHTTPTunnel(id string) {
+// SetHTTPTunnelRateLimit replaces the operator rate limit of a live tunnel;
+// nil clears it. It reports false when the tunnel does not exist.
+func (r *TunnelRegistry) SetHTTPTunnelRateLimit(id string, l *policy.RateLimiter) bool {
 	id = strings.TrimSpace(strings.ToLower(id))
 	if id == "" {
-		return
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.httpTunnels[id]
+	if !ok {
+		return false
+	}
+	t.rateLimitOverride = l
+	r.httpTunnels[id] = t
+	return true
+}
+
+// httpTunnelTokens returns the token id of each live HTTP tunnel by id.
+func (r *TunnelRegistry) httpTunnelTokens() map[string]int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make(map[string]int64, len(r.httpTunnels))
+	for id, t := range r.httpTunnels {
+		out[id] = t.tokenID
+	}
+	return out
+}
+
+// SetHTTPTunnelBrowserWarning turns the browser interstitial of a live
+// tunnel on or off. It reports false when the tunnel does not exist.
+func (r *TunnelRegistry) SetHTTPTunnelBrowserWarning(id string, on bool) bool {
+	id = strings.TrimSpace(strings.ToLower(id))
+	if id == "" {
+		return false
 	}
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	t, ok := r.httpTunnels[id]
+	if !ok {
+		return false
+	}
+	t.browserWarning = on
+	r.httpTunnels[id] = t
+	return true
+}
+
+func (r *TunnelRegistry) UnregisterHTTPTunnel(id string) {
+	id = strings.TrimSpace(strings.ToLower(id))
+	if id == "" {
+		return
+	}
+
+	r.mu.Lock()
+	t := r.httpTunnels[id]
 	delete(r.httpTunnels, id)
+	r.mu.Unlock()
+
+	t.cache.close()
 }
 
 func (r *TunnelRegistry) AllocateID() (string, error) {
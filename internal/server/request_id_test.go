@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"eosrift.com/eosrift/internal/logging"
+)
+
+// requestIDSession reports the header value the upstream got and echoes a
+// request id of its own, as many apps do.
+type requestIDSession struct {
+	header string
+	gotCh  chan string
+}
+
+func (s requestIDSession) OpenStream() (net.Conn, error) {
+	a, b := net.Pipe()
+	go func() {
+		defer b.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(b))
+		if err != nil {
+			return
+		}
+		_ = req.Body.Close()
+		s.gotCh <- req.Header.Get(s.header)
+		_, _ = fmt.Fprint(b, rawResponse(s.header+": upstream-id\r\n", "ok"))
+	}()
+	return a, nil
+}
+
+func (requestIDSession) Close() error { return nil }
+
+func TestHTTPTunnel_RequestID(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		sent   string
+		keep   bool
+	}{
+		{name: "generated", header: "", sent: "", keep: false},
+		{name: "accepted", header: "", sent: "abc-123.xyz", keep: true},
+		{name: "invalid", header: "", sent: "bad id\x01", keep: false},
+		{name: "too long", header: "", sent: strings.Repeat("a", 129), keep: false},
+		{name: "custom header", header: "x-correlation-id", sent: "corr-1", keep: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := Config{TunnelDomain: "tunnel.eosrift.test", RequestIDHeader: tc.header}
+			header := cfg.requestIDHeader()
+
+			gotCh := make(chan string, 1)
+			registry := NewTunnelRegistry()
+			if err := registry.RegisterHTTPTunnel("abcd1234", requestIDSession{header: header, gotCh: gotCh}, httpTunnelOptions{}); err != nil {
+				t.Fatalf("register: %v", err)
+			}
+			var logs bytes.Buffer
+			access := newAccessLog(cfg, logging.New(logging.Options{Out: &logs, Format: logging.FormatJSON}))
+			h := httpTunnelProxyHandler(cfg, registry, nil, access, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "http://abcd1234.tunnel.eosrift.test/", nil)
+			if tc.sent != "" {
+				req.Header.Set(header, tc.sent)
+			}
+			rr := httptest.NewRecorder()
+			h(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+			}
+
+			echoed := rr.Header().Values(header)
+			if len(echoed) != 1 {
+				t.Fatalf("response %s = %q, want exactly the edge's id", header, echoed)
+			}
+			id := echoed[0]
+			if tc.keep && id != tc.sent {
+				t.Fatalf("request id = %q, want the visitor's %q", id, tc.sent)
+			}
+			if !tc.keep && (id == tc.sent || len(id) != 32) {
+				t.Fatalf("request id = %q, want a new 32 char id", id)
+			}
+			if got := <-gotCh; got != id {
+				t.Fatalf("upstream got %q, want %q", got, id)
+			}
+			if records := decodeAccessRecords(t, &logs); len(records) != 1 || records[0]["request_id"] != id {
+				t.Fatalf("access log = %v, want request_id %q", records, id)
+			}
+		})
+	}
+}
+
+func TestHTTPTunnel_RequestIDInErrorPages(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("broken", failingSession{}, httpTunnelOptions{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	for host, want := range map[string]int{
+		"broken.tunnel.eosrift.test":  http.StatusBadGateway,
+		"missing.tunnel.eosrift.test": http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		req.Header.Set("X-Request-Id", "report-me")
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		if rr.Code != want {
+			t.Fatalf("%s: status = %d, want %d", host, rr.Code, want)
+		}
+		if !strings.Contains(rr.Body.String(), "request id: report-me") {
+			t.Fatalf("%s: body = %q, want the request id", host, rr.Body.String())
+		}
+		if got := rr.Header().Get("X-Request-Id"); got != "report-me" {
+			t.Fatalf("%s: X-Request-Id = %q, want %q", host, got, "report-me")
+		}
+	}
+}
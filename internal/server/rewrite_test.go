@@ -0,0 +1,67 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+func TestHTTPTunnel_RewriteAndRedirect(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	sess := &recordingSession{}
+
+	if err := registry.RegisterHTTPTunnel("abcd1234", pathEchoSession{}, httpTunnelOptions{
+		Redirects: []control.RedirectRule{{From: "^/old/(.*)$", To: "/new/$1", Status: http.StatusMovedPermanently}},
+		Rewrites:  []control.RewriteRule{{From: "^/v1/(?P<rest>.*)$", To: "/api/v1/${rest}"}},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := registry.RegisterHTTPTunnel("efgh5678", sess, httpTunnelOptions{
+		Redirects: []control.RedirectRule{{From: "^/", To: "https://example.com/"}},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	serve := func(host, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.test"+target, nil)
+		req.Host = host + ".tunnel.eosrift.test"
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	t.Run("rewrite with capture group", func(t *testing.T) {
+		rr := serve("abcd1234", "/v1/users?page=2")
+		body, _ := io.ReadAll(rr.Body)
+		if got := strings.TrimSpace(string(body)); got != "/api/v1/users?page=2" {
+			t.Fatalf("upstream saw %q, want %q", got, "/api/v1/users?page=2")
+		}
+	})
+
+	t.Run("redirect with capture group", func(t *testing.T) {
+		rr := serve("abcd1234", "/old/page")
+		if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/new/page" {
+			t.Fatalf("got %d %q, want 301 /new/page", rr.Code, rr.Header().Get("Location"))
+		}
+	})
+
+	t.Run("redirect does not reach the agent", func(t *testing.T) {
+		sess.openCount.Store(0)
+
+		rr := serve("efgh5678", "/anything")
+		if rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://example.com/" {
+			t.Fatalf("got %d %q", rr.Code, rr.Header().Get("Location"))
+		}
+		if sess.openCount.Load() != 0 {
+			t.Fatalf("open count = %d, want 0", sess.openCount.Load())
+		}
+	})
+}
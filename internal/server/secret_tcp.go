@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+	"eosrift.com/eosrift/internal/logging"
+	"github.com/hashicorp/yamux"
+)
+
+const (
+	maxSecretTCPNameLen   = 63
+	maxSecretTCPSecretLen = 256
+
+	// secretTCPNameHold is how long a name stays bound to its authtoken
+	// after the owner disconnects, so a reconnecting owner gets it back
+	// instead of whoever asks first.
+	secretTCPNameHold = 10 * time.Minute
+)
+
+var errSecretTCPNameTaken = errors.New("name is in use by another authtoken")
+
+// secretTCPTunnelEntry is a private TCP tunnel: no public listener, only
+// visitors presenting the secret can open streams to the owning agent.
+type secretTCPTunnelEntry struct {
+	session    streamSession
+	secretHash [sha256.Size]byte
+}
+
+func (e secretTCPTunnelEntry) secretMatches(hash [sha256.Size]byte) bool {
+	return subtle.ConstantTimeCompare(e.secretHash[:], hash[:]) == 1
+}
+
+// secretTCPNameBinding ties a secret tunnel name to the authtoken that
+// registered it. released is zero while the tunnel is registered.
+type secretTCPNameBinding struct {
+	tokenID  int64
+	released time.Time
+}
+
+func (b secretTCPNameBinding) heldAt(now time.Time) bool {
+	return b.released.IsZero() || now.Sub(b.released) < secretTCPNameHold
+}
+
+// RegisterSecretTCPTunnel registers name for tokenID. Names are one namespace
+// for the whole server: a name registered by another authtoken is refused
+// while that tunnel is up and for secretTCPNameHold after it goes away.
+func (r *TunnelRegistry) RegisterSecretTCPTunnel(name string, tokenID int64, session streamSession, secret string) error {
+	name = strings.TrimSpace(strings.ToLower(name))
+	if name == "" {
+		return errors.New("empty tunnel name")
+	}
+	if session == nil {
+		return errors.New("nil session")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for n, b := range r.secretTCPNames {
+		if !b.heldAt(now) {
+			delete(r.secretTCPNames, n)
+		}
+	}
+	if b, ok := r.secretTCPNames[name]; ok && b.tokenID != tokenID {
+		return errSecretTCPNameTaken
+	}
+	if _, exists := r.secretTCPTunnels[name]; exists {
+		return errors.New("name already in use")
+	}
+
+	r.secretTCPTunnels[name] = secretTCPTunnelEntry{
+		session:    session,
+		secretHash: sha256.Sum256([]byte(secret)),
+	}
+	r.secretTCPNames[name] = secretTCPNameBinding{tokenID: tokenID}
+	return nil
+}
+
+func (r *TunnelRegistry) GetSecretTCPTunnel(name string) (secretTCPTunnelEntry, bool) {
+	name = strings.TrimSpace(strings.ToLower(name))
+	if name == "" {
+		return secretTCPTunnelEntry{}, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.secretTCPTunnels[name]
+	return t, ok
+}
+
+func (r *TunnelRegistry) UnregisterSecretTCPTunnel(name string) {
+	name = strings.TrimSpace(strings.ToLower(name))
+	if name == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.secretTCPTunnels, name)
+	if b, ok := r.secretTCPNames[name]; ok {
+		b.released = time.Now()
+		r.secretTCPNames[name] = b
+	}
+}
+
+func (r *TunnelRegistry) AllocateSecretTCPName() (string, error) {
+	const nameLen = 8
+
+	for i := 0; i < 10; i++ {
+		name, err := randomBase32Lower(nameLen)
+		if err != nil {
+			return "", err
+		}
+
+		r.mu.RLock()
+		_, exists := r.secretTCPNames[name]
+		r.mu.RUnlock()
+
+		if !exists {
+			return name, nil
+		}
+	}
+
+	return "", errors.New("failed to allocate unique name")
+}
+
+func normalizeSecretTCPName(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || len(s) > maxSecretTCPNameLen {
+		return "", errors.New("invalid name")
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.':
+		default:
+			return "", errors.New("invalid name")
+		}
+	}
+
+	return s, nil
+}
+
+func validateSecretTCPSecret(s string) error {
+	if s == "" {
+		return errors.New("missing secret")
+	}
+	if len(s) > maxSecretTCPSecretLen {
+		return errors.New("invalid secret")
+	}
+	return nil
+}
+
+func handleSecretTCPControl(ctx context.Context, session *yamux.Session, ctrlStream *yamux.Stream, req control.CreateTCPTunnelRequest, registry *TunnelRegistry, tokenID int64, lifetime tunnelLifetime, bandwidth *tunnelBandwidth, metrics *metrics, logger logging.Logger) {
+	if req.RemotePort != 0 {
+		_ = writeControlTCPError(ctrlStream, "remote_port is not valid for secret tunnels")
+		_ = ctrlStream.Close()
+		return
+	}
+	if len(req.AllowCIDR) != 0 || len(req.DenyCIDR) != 0 {
+		_ = writeControlTCPError(ctrlStream, "allow_cidr/deny_cidr are not valid for secret tunnels")
+		_ = ctrlStream.Close()
+		return
+	}
+	if err := validateSecretTCPSecret(req.Secret); err != nil {
+		_ = writeControlTCPError(ctrlStream, err.Error())
+		_ = ctrlStream.Close()
+		return
+	}
+
+	var (
+		name string
+		err  error
+	)
+	if strings.TrimSpace(req.Name) == "" {
+		name, err = registry.AllocateSecretTCPName()
+		if err != nil {
+			err = errors.New("failed to allocate name")
+		}
+	} else {
+		name, err = normalizeSecretTCPName(req.Name)
+	}
+	if err != nil {
+		_ = writeControlTCPError(ctrlStream, err.Error())
+		_ = ctrlStream.Close()
+		return
+	}
+
+	if err := registry.RegisterSecretTCPTunnel(name, tokenID, lifetime.track(bandwidth.wrap(yamuxSession{s: session})), req.Secret); err != nil {
+		_ = writeControlTCPError(ctrlStream, err.Error())
+		_ = ctrlStream.Close()
+		return
+	}
+	defer registry.UnregisterSecretTCPTunnel(name)
+
+	var releaseTunnel func()
+	if metrics != nil {
+		releaseTunnel = metrics.trackTCPTunnel()
+		defer releaseTunnel()
+	}
+
+	if err := control.WriteJSON(ctrlStream, control.CreateTCPTunnelResponse{
+		Type:      "tcp",
+		Name:      name,
+		ExpiresAt: lifetime.expiresAt(),
+	}); err != nil {
+		_ = ctrlStream.Close()
+		return
+	}
+
+	if logger != nil {
+		logger.Info("secret tcp tunnel", logging.F("name", name))
+	}
+
+	lifetime.wait(ctx, session, noticeStream(ctrlStream, req.Notices))
+	_ = session.Close()
+}
+
+// handleVisitControl splices every stream a visitor opens to the owning agent
+// of a secret TCP tunnel. The owner is looked up per stream so visitors keep
+// working across owner reconnects (as long as the secret still matches).
+func handleVisitControl(ctx context.Context, session *yamux.Session, ctrlStream *yamux.Stream, req control.VisitTCPTunnelRequest, registry *TunnelRegistry, logger logging.Logger) {
+	writeErr := func(msg string) {
+		_ = control.WriteJSON(ctrlStream, control.VisitTCPTunnelResponse{
+			Type:  "visit",
+			Error: msg,
+		})
+		_ = ctrlStream.Close()
+	}
+
+	name, err := normalizeSecretTCPName(req.Name)
+	if err != nil {
+		writeErr(err.Error())
+		return
+	}
+	if err := validateSecretTCPSecret(req.Secret); err != nil {
+		writeErr(err.Error())
+		return
+	}
+	secretHash := sha256.Sum256([]byte(req.Secret))
+
+	// Unknown names and wrong secrets look the same to avoid name probing.
+	if entry, ok := registry.GetSecretTCPTunnel(name); !ok || !entry.secretMatches(secretHash) {
+		writeErr("tunnel not found")
+		return
+	}
+
+	if err := control.WriteJSON(ctrlStream, control.VisitTCPTunnelResponse{
+		Type: "visit",
+		Name: name,
+	}); err != nil {
+		_ = ctrlStream.Close()
+		return
+	}
+	_ = ctrlStream.Close()
+
+	go func() {
+		<-ctx.Done()
+		_ = session.Close()
+	}()
+
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+
+		go func(st *yamux.Stream) {
+			defer st.Close()
+
+			entry, ok := registry.GetSecretTCPTunnel(name)
+			if !ok || !entry.secretMatches(secretHash) {
+				return
+			}
+
+			upstream, err := entry.session.OpenStream()
+			if err != nil {
+				if logger != nil {
+					logger.Debug("secret tcp open stream error", logging.F("name", name), logging.F("err", err))
+				}
+				return
+			}
+			defer upstream.Close()
+
+			_ = proxyBidirectional(ctx, st, upstream)
+		}(stream)
+	}
+}
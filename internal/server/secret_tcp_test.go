@@ -0,0 +1,249 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+	"github.com/hashicorp/yamux"
+	"nhooyr.io/websocket"
+)
+
+func TestControlSecretTCP_VisitorSplicesToOwner(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(NewHandler(Config{
+		TCPPortRangeStart: 20000,
+		TCPPortRangeEnd:   20000,
+	}, Dependencies{}))
+	t.Cleanup(srv.Close)
+
+	ownerWS, owner := dialTestControl(t, srv.URL)
+	t.Cleanup(func() {
+		_ = owner.Close()
+		_ = ownerWS.Close(websocket.StatusNormalClosure, "closed")
+	})
+
+	ctrl, err := owner.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	if err := control.WriteJSON(ctrl, control.CreateTCPTunnelRequest{
+		Type:   "tcp",
+		Secret: "s3cret",
+		Name:   "DB",
+	}); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	var resp control.CreateTCPTunnelResponse
+	if err := json.NewDecoder(ctrl).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Error != "" {
+		t.Fatalf("error = %q", resp.Error)
+	}
+	if resp.Name != "db" || resp.RemotePort != 0 {
+		t.Fatalf("response = %+v, want name db without remote port", resp)
+	}
+
+	// Owner: echo every stream the server opens.
+	go func() {
+		for {
+			st, err := owner.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func(st *yamux.Stream) {
+				defer st.Close()
+				_, _ = io.Copy(st, st)
+			}(st)
+		}
+	}()
+
+	t.Run("wrong secret", func(t *testing.T) {
+		if got := visitTestControl(t, srv.URL, "db", "nope"); got.Error != "tunnel not found" {
+			t.Fatalf("error = %q, want %q", got.Error, "tunnel not found")
+		}
+	})
+
+	t.Run("unknown name", func(t *testing.T) {
+		if got := visitTestControl(t, srv.URL, "nope", "s3cret"); got.Error != "tunnel not found" {
+			t.Fatalf("error = %q, want %q", got.Error, "tunnel not found")
+		}
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		ws, session := dialTestControl(t, srv.URL)
+		defer func() {
+			_ = session.Close()
+			_ = ws.Close(websocket.StatusNormalClosure, "closed")
+		}()
+
+		stream, err := session.OpenStream()
+		if err != nil {
+			t.Fatalf("open stream: %v", err)
+		}
+		_ = control.WriteJSON(stream, control.CreateTCPTunnelRequest{Type: "tcp", Secret: "other", Name: "db"})
+
+		var resp control.CreateTCPTunnelResponse
+		if err := json.NewDecoder(stream).Decode(&resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if resp.Error != "name already in use" {
+			t.Fatalf("error = %q, want %q", resp.Error, "name already in use")
+		}
+	})
+
+	t.Run("visitor echo", func(t *testing.T) {
+		ws, session := dialTestControl(t, srv.URL)
+		defer func() {
+			_ = session.Close()
+			_ = ws.Close(websocket.StatusNormalClosure, "closed")
+		}()
+
+		stream, err := session.OpenStream()
+		if err != nil {
+			t.Fatalf("open stream: %v", err)
+		}
+		_ = control.WriteJSON(stream, control.VisitTCPTunnelRequest{Type: "visit", Name: "db", Secret: "s3cret"})
+
+		var resp control.VisitTCPTunnelResponse
+		if err := json.NewDecoder(stream).Decode(&resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if resp.Error != "" || resp.Name != "db" {
+			t.Fatalf("response = %+v, want name db", resp)
+		}
+
+		data, err := session.OpenStream()
+		if err != nil {
+			t.Fatalf("open data stream: %v", err)
+		}
+		defer data.Close()
+		_ = data.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if _, err := io.WriteString(data, "ping"); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(data, buf); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(buf) != "ping" {
+			t.Fatalf("echo = %q, want %q", buf, "ping")
+		}
+	})
+}
+
+func TestControlSecretTCP_RejectsRemotePort(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(NewHandler(Config{}, Dependencies{}))
+	t.Cleanup(srv.Close)
+
+	ws, session := dialTestControl(t, srv.URL)
+	t.Cleanup(func() {
+		_ = session.Close()
+		_ = ws.Close(websocket.StatusNormalClosure, "closed")
+	})
+
+	stream, err := session.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	_ = control.WriteJSON(stream, control.CreateTCPTunnelRequest{Type: "tcp", Secret: "s3cret", RemotePort: 20005})
+
+	var resp control.CreateTCPTunnelResponse
+	if err := json.NewDecoder(stream).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Error != "remote_port is not valid for secret tunnels" {
+		t.Fatalf("error = %q", resp.Error)
+	}
+}
+
+func TestTunnelRegistry_SecretTCPNameBoundToToken(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	register := func(tokenID int64) error {
+		return registry.RegisterSecretTCPTunnel("db", tokenID, &recordingSession{}, "s3cret")
+	}
+
+	if err := register(1); err != nil {
+		t.Fatalf("owner register: %v", err)
+	}
+	if err := register(2); !errors.Is(err, errSecretTCPNameTaken) {
+		t.Fatalf("other token while connected: err = %v, want %v", err, errSecretTCPNameTaken)
+	}
+
+	// The owner keeps the name across a reconnect.
+	registry.UnregisterSecretTCPTunnel("db")
+	if err := register(2); !errors.Is(err, errSecretTCPNameTaken) {
+		t.Fatalf("other token after disconnect: err = %v, want %v", err, errSecretTCPNameTaken)
+	}
+	if err := register(1); err != nil {
+		t.Fatalf("owner reconnect: %v", err)
+	}
+
+	registry.UnregisterSecretTCPTunnel("db")
+	registry.mu.Lock()
+	b := registry.secretTCPNames["db"]
+	b.released = b.released.Add(-secretTCPNameHold)
+	registry.secretTCPNames["db"] = b
+	registry.mu.Unlock()
+	if err := register(2); err != nil {
+		t.Fatalf("other token after the hold: %v", err)
+	}
+}
+
+func TestNormalizeSecretTCPName(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"db", "db", false},
+		{" My-DB_1.prod ", "my-db_1.prod", false},
+		{"", "", true},
+		{"has space", "", true},
+		{"slash/", "", true},
+	}
+	for _, tc := range cases {
+		got, err := normalizeSecretTCPName(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Fatalf("normalizeSecretTCPName(%q) = (%q, %v), want (%q, err=%v)", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func visitTestControl(t *testing.T, baseURL, name, secret string) control.VisitTCPTunnelResponse {
+	t.Helper()
+
+	ws, session := dialTestControl(t, baseURL)
+	defer func() {
+		_ = session.Close()
+		_ = ws.Close(websocket.StatusNormalClosure, "closed")
+	}()
+
+	stream, err := session.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	if err := control.WriteJSON(stream, control.VisitTCPTunnelRequest{Type: "visit", Name: name, Secret: secret}); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	var resp control.VisitTCPTunnelResponse
+	if err := json.NewDecoder(stream).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return resp
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+	"github.com/hashicorp/yamux"
+)
+
+const (
+	// shareLinkParam carries the token on the first visit; shareLinkCookie
+	// keeps the visitor in afterwards so assets load without the token.
+	shareLinkParam  = "eosrift_share"
+	shareLinkCookie = "eosrift_share"
+
+	defaultShareLinkTTL = 24 * time.Hour
+	maxShareLinkTTL     = 30 * 24 * time.Hour
+
+	maxShareLinkPathBytes = 1024
+)
+
+// shareClaims is the signed payload of a share token.
+type shareClaims struct {
+	TunnelID string `json:"t"`
+	Expires  int64  `json:"e"`
+	Path     string `json:"p,omitempty"`
+}
+
+// mintShareToken returns "<payload>.<signature>", both base64url.
+func mintShareToken(secret string, c shareClaims) string {
+	b, _ := json.Marshal(c)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(shareSignature(secret, payload))
+}
+
+func shareSignature(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verifyShareToken checks the signature, tunnel and expiry of a token. The
+// path scope is left to the caller.
+func verifyShareToken(secret, token, tunnelID string, now time.Time) (shareClaims, bool) {
+	var c shareClaims
+	if secret == "" || len(token) > 4096 {
+		return c, false
+	}
+
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return c, false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, shareSignature(secret, payload)) {
+		return c, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return c, false
+	}
+	if c.TunnelID != tunnelID || now.Unix() >= c.Expires {
+		return c, false
+	}
+	return c, true
+}
+
+// shareScopeAllows reports whether path falls under a token's path scope.
+func shareScopeAllows(scope, path string) bool {
+	if scope == "" || scope == "/" || path == scope {
+		return true
+	}
+	if strings.HasSuffix(scope, "/") {
+		return strings.HasPrefix(path, scope)
+	}
+	return strings.HasPrefix(path, scope+"/")
+}
+
+// removeQueryParam drops every name parameter from a raw query and leaves
+// the others as they were sent: order, escaping and all.
+func removeQueryParam(rawQuery, name string) string {
+	parts := strings.Split(rawQuery, "&")
+	kept := parts[:0]
+	for _, part := range parts {
+		key, _, _ := strings.Cut(part, "=")
+		if k, err := url.QueryUnescape(key); err == nil && k == name {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, "&")
+}
+
+// checkShareLink admits visitors holding a valid share link. A token in the
+// query string is swapped for a session cookie: GET/HEAD visits are
+// redirected to the same URL without it. The token never reaches the
+// upstream. It returns handled=true when a response has been written.
+func checkShareLink(w http.ResponseWriter, r *http.Request, cfg Config, id, prefix string) (admitted, handled bool) {
+	now := time.Now()
+
+	q := r.URL.Query()
+	if token := q.Get(shareLinkParam); token != "" {
+		c, ok := verifyShareToken(cfg.ShareLinkSecret, token, id, now)
+		if !ok || !shareScopeAllows(c.Path, r.URL.Path) {
+			http.Error(w, "invalid or expired share link", http.StatusForbidden)
+			return false, true
+		}
+
+		cookiePath := "/"
+		if prefix != "" {
+			cookiePath = prefix + "/"
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareLinkCookie,
+			Value:    token,
+			Path:     cookiePath,
+			Expires:  time.Unix(c.Expires, 0),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		r.URL.RawQuery = removeQueryParam(r.URL.RawQuery, shareLinkParam)
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			loc := prefix + r.URL.EscapedPath()
+			if r.URL.RawQuery != "" {
+				loc += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, loc, http.StatusFound)
+			return true, true
+		}
+		stripShareCookie(r)
+		return true, false
+	}
+
+	for _, ck := range r.Cookies() {
+		if ck.Name != shareLinkCookie {
+			continue
+		}
+		if c, ok := verifyShareToken(cfg.ShareLinkSecret, ck.Value, id, now); ok && shareScopeAllows(c.Path, r.URL.Path) {
+			stripShareCookie(r)
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// stripShareCookie removes the share cookie from the request sent upstream.
+func stripShareCookie(r *http.Request) {
+	stripCookies(r, shareLinkCookie)
+}
+
+// parseShareLinkRequest validates a mint request and returns its TTL and
+// path scope.
+func parseShareLinkRequest(req control.ShareLinkRequest) (time.Duration, string, error) {
+	ttl := defaultShareLinkTTL
+	if req.TTLSeconds < 0 {
+		return 0, "", errors.New("invalid ttl")
+	}
+	if req.TTLSeconds > 0 {
+		if req.TTLSeconds > int64(maxShareLinkTTL/time.Second) {
+			return 0, "", errors.New("ttl exceeds 720h")
+		}
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+
+	path := strings.TrimSpace(req.Path)
+	if path != "" {
+		if !strings.HasPrefix(path, "/") || len(path) > maxShareLinkPathBytes || strings.ContainsAny(path, "?#") {
+			return 0, "", errors.New("invalid path")
+		}
+		if u, err := url.Parse(path); err != nil || u.Path != path {
+			return 0, "", errors.New("invalid path")
+		}
+	}
+	return ttl, path, nil
+}
+
+// shareLinkURL is the public URL that admits a visitor to path.
+func (c Config) shareLinkURL(id, path, token string) string {
+	u := strings.TrimSuffix(c.httpTunnelURL(id), "/")
+	if path == "" {
+		path = "/"
+	}
+	return u + path + "?" + shareLinkParam + "=" + token
+}
+
+// serveHTTPTunnelStreams answers requests the client sends on new streams
+// after the tunnel is up (currently only share link minting). It returns when
+// the session closes.
+func serveHTTPTunnelStreams(session *yamux.Session, cfg Config, id string, shareLinks bool) {
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		go func(st net.Conn) {
+			defer st.Close()
+			_ = st.SetDeadline(time.Now().Add(10 * time.Second))
+
+			var req control.ShareLinkRequest
+			if err := json.NewDecoder(io.LimitReader(st, maxControlRequestBytes)).Decode(&req); err != nil {
+				return
+			}
+			_ = control.WriteJSON(st, mintShareLink(cfg, id, shareLinks, req, time.Now()))
+		}(stream)
+	}
+}
+
+func mintShareLink(cfg Config, id string, shareLinks bool, req control.ShareLinkRequest, now time.Time) control.ShareLinkResponse {
+	resp := control.ShareLinkResponse{Type: "share_link"}
+	if req.Type != "share_link" {
+		resp.Error = "invalid request"
+		return resp
+	}
+	if !shareLinks {
+		resp.Error = "share links are not enabled for this tunnel"
+		return resp
+	}
+	if cfg.ShareLinkSecret == "" {
+		resp.Error = "share links are not configured on this server"
+		return resp
+	}
+	ttl, path, err := parseShareLinkRequest(req)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+
+	exp := now.Add(ttl).Unix()
+	token := mintShareToken(cfg.ShareLinkSecret, shareClaims{TunnelID: id, Expires: exp, Path: path})
+	resp.URL = cfg.shareLinkURL(id, path, token)
+	resp.ExpiresAt = exp
+	return resp
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+)
+
+// cookieSession answers 200 and reports the Cookie header it received.
+type cookieSession struct {
+	openCount atomic.Int32
+	cookies   chan string
+}
+
+func (s *cookieSession) OpenStream() (net.Conn, error) {
+	s.openCount.Add(1)
+
+	a, b := net.Pipe()
+	go func() {
+		defer b.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(b))
+		if err != nil {
+			return
+		}
+		s.cookies <- req.Header.Get("Cookie")
+
+		_, _ = fmt.Fprint(b, "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nConnection: close\r\n\r\nok\n")
+	}()
+	return a, nil
+}
+
+func (s *cookieSession) Close() error { return nil }
+
+func TestShareToken_Verify(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	tok := mintShareToken("k", shareClaims{TunnelID: "abcd1234", Expires: now.Add(time.Hour).Unix(), Path: "/docs/"})
+
+	if c, ok := verifyShareToken("k", tok, "abcd1234", now); !ok || c.Path != "/docs/" {
+		t.Fatalf("verify = %+v, %v; want ok", c, ok)
+	}
+	if _, ok := verifyShareToken("other", tok, "abcd1234", now); ok {
+		t.Fatalf("verified with wrong secret")
+	}
+	if _, ok := verifyShareToken("k", tok, "zzzz9999", now); ok {
+		t.Fatalf("verified for another tunnel")
+	}
+	if _, ok := verifyShareToken("k", tok, "abcd1234", now.Add(time.Hour)); ok {
+		t.Fatalf("verified after expiry")
+	}
+	if _, ok := verifyShareToken("", tok, "abcd1234", now); ok {
+		t.Fatalf("verified with empty secret")
+	}
+	payload, sig, _ := strings.Cut(tok, ".")
+	if _, ok := verifyShareToken("k", payload+"x."+sig, "abcd1234", now); ok {
+		t.Fatalf("verified tampered payload")
+	}
+}
+
+func TestShareScopeAllows(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		scope, path string
+		want        bool
+	}{
+		{"", "/anything", true},
+		{"/docs/", "/docs/", true},
+		{"/docs/", "/docs/a.css", true},
+		{"/docs/", "/docsx", false},
+		{"/docs", "/docs", true},
+		{"/docs", "/docs/a", true},
+		{"/docs", "/docsx", false},
+	}
+	for _, tc := range cases {
+		if got := shareScopeAllows(tc.scope, tc.path); got != tc.want {
+			t.Fatalf("shareScopeAllows(%q, %q) = %v, want %v", tc.scope, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestMintShareLink(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{TunnelDomain: "tunnel.eosrift.test", ShareLinkSecret: "k"}
+	now := time.Unix(1_700_000_000, 0)
+
+	resp := mintShareLink(cfg, "abcd1234", true, control.ShareLinkRequest{Type: "share_link", TTLSeconds: 3600, Path: "/docs/"}, now)
+	if resp.Error != "" {
+		t.Fatalf("error = %q", resp.Error)
+	}
+	if resp.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Fatalf("expires_at = %d", resp.ExpiresAt)
+	}
+	if !strings.HasPrefix(resp.URL, "https://abcd1234.tunnel.eosrift.test/docs/?"+shareLinkParam+"=") {
+		t.Fatalf("url = %q", resp.URL)
+	}
+
+	for name, tc := range map[string]struct {
+		shareLinks bool
+		req        control.ShareLinkRequest
+	}{
+		"not enabled":  {false, control.ShareLinkRequest{Type: "share_link"}},
+		"ttl too long": {true, control.ShareLinkRequest{Type: "share_link", TTLSeconds: int64(31 * 24 * 3600)}},
+		"bad path":     {true, control.ShareLinkRequest{Type: "share_link", Path: "docs"}},
+		"bad type":     {true, control.ShareLinkRequest{Type: "http"}},
+	} {
+		if resp := mintShareLink(cfg, "abcd1234", tc.shareLinks, tc.req, now); resp.Error == "" {
+			t.Fatalf("%s: expected error, got %+v", name, resp)
+		}
+	}
+}
+
+func TestRemoveQueryParam(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		raw  string
+		want string
+	}{
+		{raw: "eosrift_share=t", want: ""},
+		{raw: "b=2&eosrift_share=t&a=1", want: "b=2&a=1"},
+		{raw: "eosrift%5Fshare=t&q=%2F%2f&eosrift_share", want: "q=%2F%2f"},
+		{raw: "x=eosrift_share&eosrift_shared=1", want: "x=eosrift_share&eosrift_shared=1"},
+		{raw: "a=1&&b", want: "a=1&&b"},
+	} {
+		if got := removeQueryParam(tc.raw, shareLinkParam); got != tc.want {
+			t.Fatalf("removeQueryParam(%q) = %q, want %q", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestHTTPTunnel_ShareLinks(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{TunnelDomain: "tunnel.eosrift.test", ShareLinkSecret: "k"}
+
+	registry := NewTunnelRegistry()
+	sess := &cookieSession{cookies: make(chan string, 4)}
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{ShareLinks: true}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(cfg, registry, nil, nil, nil)
+
+	do := func(target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		req.AddCookie(&http.Cookie{Name: "app", Value: "1"})
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	if rr := do("http://example.test/", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("no link: status = %d, want 403", rr.Code)
+	}
+
+	link := mintShareLink(cfg, "abcd1234", true, control.ShareLinkRequest{Type: "share_link", Path: "/docs/"}, time.Now())
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	rr := do("http://example.test/docs/?x=1&"+u.RawQuery, nil)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("first visit: status = %d, want 302", rr.Code)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/docs/?x=1" {
+		t.Fatalf("Location = %q, want %q", loc, "/docs/?x=1")
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != shareLinkCookie || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+	if sess.openCount.Load() != 0 {
+		t.Fatalf("first visit reached upstream")
+	}
+
+	if rr := do("http://example.test/docs/app.css", cookies[0]); rr.Code != http.StatusOK {
+		t.Fatalf("with cookie: status = %d, want 200", rr.Code)
+	}
+	if got := <-sess.cookies; got != "app=1" {
+		t.Fatalf("upstream Cookie = %q, want share cookie stripped", got)
+	}
+
+	// Only the token is removed; the rest of the query is left as sent.
+	rr = do("http://example.test/docs/?z=2&"+u.RawQuery+"&a=%7e&x=a+b", nil)
+	if loc := rr.Header().Get("Location"); loc != "/docs/?z=2&a=%7e&x=a+b" {
+		t.Fatalf("Location = %q, want %q", loc, "/docs/?z=2&a=%7e&x=a+b")
+	}
+
+	if rr := do("http://example.test/admin", cookies[0]); rr.Code != http.StatusForbidden {
+		t.Fatalf("out of scope: status = %d, want 403", rr.Code)
+	}
+	if rr := do("http://example.test/docs/?"+shareLinkParam+"=bogus", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("bogus link: status = %d, want 403", rr.Code)
+	}
+}
+
+func TestHTTPTunnel_ShareLinksFallBackToBasicAuth(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	sess := &recordingSession{}
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{
+		ShareLinks: true,
+		BasicAuth:  &basicAuthCredential{Username: "user", Password: "pass"},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test", ShareLinkSecret: "k"}, registry, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Host = "abcd1234.tunnel.eosrift.test"
+	req.SetBasicAuth("user", "pass")
+	rr = httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("basic auth: status = %d, want 200", rr.Code)
+	}
+}
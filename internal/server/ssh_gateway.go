@@ -0,0 +1,723 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eosrift.com/eosrift/internal/logging"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// sshModeHintWait bounds how long a remote forward waits for the session
+	// command ("http" or "tcp") when the requested port alone does not decide
+	// the tunnel type. OpenSSH sends forwards before opening the session.
+	sshModeHintWait = time.Second
+
+	sshHandshakeTimeout = 10 * time.Second
+
+	sshTokenIDExtension = "eosrift-token-id"
+)
+
+type sshForwardRequest struct {
+	BindAddr string
+	BindPort uint32
+}
+
+type sshForwardReply struct {
+	Port uint32
+}
+
+type sshForwardedTCPPayload struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// ServeSSH runs the agentless SSH gateway on ln until ctx is done.
+//
+// Clients create tunnels with plain OpenSSH remote forwards:
+//
+//	ssh -R 80:localhost:3000 -p 2222 <authtoken>@eosrift.com        (HTTP)
+//	ssh -R 0:localhost:22 -p 2222 <authtoken>@eosrift.com tcp       (TCP)
+//
+// Ports 80/443 always mean HTTP. Otherwise the session command picks the
+// type; without one, port 0 is HTTP and any other port is a TCP tunnel on
+// that (reserved) port. A non-IP bind address requests a subdomain or domain,
+// e.g. -R myapp:80:localhost:3000.
+func (s *Server) ServeSSH(ctx context.Context, ln net.Listener, hostKey ssh.Signer) error {
+	if hostKey == nil {
+		return errors.New("missing ssh host key")
+	}
+
+	logger := s.deps.Logger
+	if logger == nil {
+		logger = logging.New(logging.Options{})
+	}
+	logger = logger.With(logging.F("component", "ssh"))
+
+	config := s.sshServerConfig(ctx)
+	config.AddHostKey(hostKey)
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveSSHConn(ctx, nc, config, logger)
+	}
+}
+
+func (s *Server) sshServerConfig(ctx context.Context) *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		// The username doubles as the authtoken so `ssh <token>@host` works
+		// without any key setup. When it is not a valid token, clients fall
+		// back to publickey/password auth.
+		NoClientAuth: true,
+		NoClientAuthCallback: func(meta ssh.ConnMetadata) (*ssh.Permissions, error) {
+			return s.sshTokenPermissions(ctx, meta.User())
+		},
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return s.sshTokenPermissions(ctx, string(password))
+		},
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if s.deps.SSHKeys == nil {
+				return nil, errors.New("unauthorized")
+			}
+			tokenID, ok, err := s.deps.SSHKeys.SSHKeyTokenID(ctx, ssh.FingerprintSHA256(key))
+			if err != nil {
+				return nil, errors.New("auth error")
+			}
+			if !ok {
+				return nil, errors.New("unauthorized")
+			}
+			return sshPermissions(tokenID), nil
+		},
+	}
+}
+
+// sshTokenPermissions authenticates an authtoken the same way the control
+// endpoint does.
+func (s *Server) sshTokenPermissions(ctx context.Context, token string) (*ssh.Permissions, error) {
+	token = strings.TrimSpace(token)
+
+	if validator := s.deps.TokenValidator; validator != nil {
+		ok, err := validator.ValidateToken(ctx, token)
+		if err != nil {
+			return nil, errors.New("auth error")
+		}
+		if !ok {
+			return nil, errors.New("unauthorized")
+		}
+	} else if s.cfg.AuthToken != "" && token != s.cfg.AuthToken {
+		return nil, errors.New("unauthorized")
+	}
+
+	var tokenID int64
+	if s.deps.TokenResolver != nil {
+		id, ok, err := s.deps.TokenResolver.TokenID(ctx, token)
+		if err != nil {
+			return nil, errors.New("auth error")
+		}
+		if ok {
+			tokenID = id
+		}
+	}
+
+	return sshPermissions(tokenID), nil
+}
+
+func sshPermissions(tokenID int64) *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			sshTokenIDExtension: strconv.FormatInt(tokenID, 10),
+		},
+	}
+}
+
+func (s *Server) serveSSHConn(ctx context.Context, nc net.Conn, config *ssh.ServerConfig, logger logging.Logger) {
+	defer nc.Close()
+
+	_ = nc.SetDeadline(time.Now().Add(sshHandshakeTimeout))
+	sconn, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if err != nil {
+		logger.Debug("ssh handshake error", logging.F("err", err), logging.F("remote_addr", nc.RemoteAddr().String()))
+		return
+	}
+	_ = nc.SetDeadline(time.Time{})
+	defer sconn.Close()
+
+	if s.metrics != nil {
+		release := s.metrics.trackControlConn()
+		defer release()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = sconn.Close()
+	}()
+
+	var tokenID int64
+	if sconn.Permissions != nil {
+		tokenID, _ = strconv.ParseInt(sconn.Permissions.Extensions[sshTokenIDExtension], 10, 64)
+	}
+
+	c := &sshGatewayConn{
+		s:        s,
+		ctx:      ctx,
+		conn:     sconn,
+		tokenID:  tokenID,
+		logger:   logger.With(logging.F("remote_addr", sconn.RemoteAddr().String())),
+		hintCh:   make(chan struct{}),
+		forwards: make(map[string]func()),
+	}
+	defer c.closeForwards()
+
+	go c.handleChannels(chans)
+
+	// Global requests are handled in order: replies must match request order.
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			c.handleForward(req)
+		case "cancel-tcpip-forward":
+			c.handleCancelForward(req)
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+// sshGatewayConn is the per-connection state of the SSH gateway.
+type sshGatewayConn struct {
+	s       *Server
+	ctx     context.Context
+	conn    *ssh.ServerConn
+	tokenID int64
+	logger  logging.Logger
+
+	hintOnce sync.Once
+	hintCh   chan struct{}
+	hint     string
+
+	mu       sync.Mutex
+	forwards map[string]func()
+	out      ssh.Channel
+	pending  []string
+}
+
+func (c *sshGatewayConn) handleChannels(chans <-chan ssh.NewChannel) {
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		c.attachOutput(ch)
+
+		go c.handleSessionRequests(reqs)
+		go func() {
+			// With a pty, Ctrl-C/Ctrl-D arrive as data: treat them as a request
+			// to disconnect.
+			buf := make([]byte, 256)
+			for {
+				n, err := ch.Read(buf)
+				if n > 0 && bytes.ContainsAny(buf[:n], "\x03\x04") {
+					_ = c.conn.Close()
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (c *sshGatewayConn) handleSessionRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			c.setModeHint(payload.Command)
+			_ = req.Reply(true, nil)
+		case "shell":
+			c.setModeHint("")
+			_ = req.Reply(true, nil)
+		case "pty-req", "env", "window-change":
+			_ = req.Reply(true, nil)
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+func (c *sshGatewayConn) setModeHint(command string) {
+	c.hintOnce.Do(func() {
+		fields := strings.Fields(strings.ToLower(command))
+		if len(fields) > 0 && (fields[0] == "http" || fields[0] == "tcp") {
+			c.hint = fields[0]
+		}
+		close(c.hintCh)
+	})
+}
+
+func (c *sshGatewayConn) modeHint(wait time.Duration) string {
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-c.hintCh:
+		return c.hint
+	case <-t.C:
+		return ""
+	case <-c.ctx.Done():
+		return ""
+	}
+}
+
+// attachOutput sets the session channel used for user-facing messages and
+// flushes anything printed before the session was opened.
+func (c *sshGatewayConn) attachOutput(ch ssh.Channel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.out != nil {
+		return
+	}
+	c.out = ch
+	for _, line := range c.pending {
+		_, _ = ch.Write([]byte(line))
+	}
+	c.pending = nil
+}
+
+func (c *sshGatewayConn) printf(format string, args ...any) {
+	line := fmt.Sprintf(format, args...) + "\r\n"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.out == nil {
+		c.pending = append(c.pending, line)
+		return
+	}
+	_, _ = c.out.Write([]byte(line))
+}
+
+func (c *sshGatewayConn) handleForward(req *ssh.Request) {
+	var p sshForwardRequest
+	if err := ssh.Unmarshal(req.Payload, &p); err != nil {
+		_ = req.Reply(false, nil)
+		return
+	}
+
+	key := net.JoinHostPort(p.BindAddr, strconv.FormatUint(uint64(p.BindPort), 10))
+
+	c.mu.Lock()
+	_, dup := c.forwards[key]
+	c.mu.Unlock()
+	if dup {
+		_ = req.Reply(false, nil)
+		return
+	}
+
+	mode := sshForwardMode(p.BindPort, c.modeHint)
+
+	stop, port, lifetime, err := c.startForward(mode, p)
+	if err != nil {
+		c.printf("eosrift: %s forward %s: %s", mode, key, err.Error())
+		_ = req.Reply(false, nil)
+		return
+	}
+
+	done := make(chan struct{})
+	var once sync.Once
+	c.mu.Lock()
+	c.forwards[key] = func() {
+		once.Do(func() {
+			close(done)
+			stop()
+		})
+	}
+	c.mu.Unlock()
+	if lifetime.bounded() {
+		go c.reapForward(key, lifetime, done)
+	}
+
+	var reply []byte
+	if p.BindPort == 0 {
+		reply = ssh.Marshal(sshForwardReply{Port: port})
+	}
+	_ = req.Reply(true, reply)
+}
+
+func (c *sshGatewayConn) handleCancelForward(req *ssh.Request) {
+	var p sshForwardRequest
+	if err := ssh.Unmarshal(req.Payload, &p); err != nil {
+		_ = req.Reply(false, nil)
+		return
+	}
+
+	key := net.JoinHostPort(p.BindAddr, strconv.FormatUint(uint64(p.BindPort), 10))
+
+	c.mu.Lock()
+	stop, ok := c.forwards[key]
+	delete(c.forwards, key)
+	c.mu.Unlock()
+
+	if ok {
+		stop()
+	}
+	_ = req.Reply(ok, nil)
+}
+
+// reapForward closes the forward at key once its lifetime ends, as
+// tunnelLifetime.wait does for control connection tunnels. done is closed
+// when the forward stops for any other reason.
+func (c *sshGatewayConn) reapForward(key string, lifetime tunnelLifetime, done <-chan struct{}) {
+	reason := lifetime.expired(c.ctx, done)
+	if reason == "" {
+		return
+	}
+
+	c.mu.Lock()
+	stop, ok := c.forwards[key]
+	delete(c.forwards, key)
+	c.mu.Unlock()
+
+	if ok {
+		c.printf("eosrift: forward %s closed (%s)", key, reason)
+		c.logger.Info("ssh tunnel closed", logging.F("forward", key), logging.F("reason", reason))
+		stop()
+	}
+}
+
+func (c *sshGatewayConn) closeForwards() {
+	c.mu.Lock()
+	forwards := c.forwards
+	c.forwards = make(map[string]func())
+	c.mu.Unlock()
+
+	for _, stop := range forwards {
+		stop()
+	}
+}
+
+// sshForwardMode decides whether a remote forward is an HTTP or TCP tunnel.
+func sshForwardMode(bindPort uint32, hint func(time.Duration) string) string {
+	if bindPort == 80 || bindPort == 443 {
+		return "http"
+	}
+	if mode := hint(sshModeHintWait); mode != "" {
+		return mode
+	}
+	if bindPort == 0 {
+		return "http"
+	}
+	return "tcp"
+}
+
+// sshForwardName maps an ssh -R bind address to a requested subdomain or
+// domain. Addresses that just mean "listen" (empty, localhost, IPs, *) request
+// a random id.
+func sshForwardName(bindAddr string) (subdomain, domain string) {
+	addr := strings.ToLower(strings.TrimSpace(bindAddr))
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+
+	switch {
+	case addr == "" || addr == "*" || addr == "localhost":
+		return "", ""
+	case net.ParseIP(addr) != nil:
+		return "", ""
+	case strings.Contains(addr, "."):
+		return "", addr
+	default:
+		return addr, ""
+	}
+}
+
+// startForward creates the tunnel and returns a func that tears it down, along
+// with the port to report back to the client and the tunnel's lifetime.
+func (c *sshGatewayConn) startForward(mode string, p sshForwardRequest) (func(), uint32, tunnelLifetime, error) {
+	s := c.s
+
+	var releases []func()
+	stop := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+
+	if s.cfg.MaxTunnelsPerToken > 0 && s.limiter != nil && c.tokenID > 0 {
+		release, ok := s.limiter.TryAcquire(c.tokenID, s.cfg.MaxTunnelsPerToken)
+		if !ok {
+			return nil, 0, tunnelLifetime{}, errors.New("too many active tunnels")
+		}
+		releases = append(releases, release)
+	}
+
+	if s.cfg.MaxTunnelCreatesPerMinute > 0 && s.rateLimiter != nil && c.tokenID > 0 {
+		if !s.rateLimiter.Allow(c.tokenID, s.cfg.MaxTunnelCreatesPerMinute) {
+			stop()
+			return nil, 0, tunnelLifetime{}, errors.New("rate limit exceeded")
+		}
+	}
+
+	lifetime, err := resolveTunnelLifetime(c.ctx, s.cfg, s.deps.TokenClasses, c.tokenID, 0, time.Now())
+	if err != nil {
+		stop()
+		return nil, 0, tunnelLifetime{}, err
+	}
+
+	bandwidth, release, err := resolveTunnelBandwidth(s.cfg, s.bandwidth, c.tokenID, nil, s.metrics)
+	if err != nil {
+		stop()
+		return nil, 0, tunnelLifetime{}, err
+	}
+	releases = append(releases, release)
+
+	// Streams go through the same wrappers as control connection tunnels.
+	wrap := func(f *sshForwardSession) streamSession {
+		return lifetime.track(bandwidth.wrap(f))
+	}
+
+	var port uint32
+	switch mode {
+	case "http":
+		port, err = c.startHTTPForward(p, wrap, &releases)
+	default:
+		port, err = c.startTCPForward(p, wrap, &releases)
+	}
+	if err != nil {
+		stop()
+		return nil, 0, tunnelLifetime{}, err
+	}
+
+	return stop, port, lifetime, nil
+}
+
+func (c *sshGatewayConn) startHTTPForward(p sshForwardRequest, wrap func(*sshForwardSession) streamSession, releases *[]func()) (uint32, error) {
+	s := c.s
+
+	subdomain, domain := sshForwardName(p.BindAddr)
+	id, err := resolveHTTPTunnelID(c.ctx, s.cfg, s.registry, s.deps.Reservations, c.tokenID, subdomain, domain)
+	if err != nil {
+		return 0, err
+	}
+
+	// OpenSSH needs a concrete port back for -R 0:..., and uses it to match
+	// forwarded-tcpip channels.
+	port := p.BindPort
+	if port == 0 {
+		port = 80
+	}
+
+	if err := s.registry.RegisterHTTPTunnel(id, wrap(&sshForwardSession{
+		conn: c.conn,
+		addr: p.BindAddr,
+		port: port,
+	}), httpTunnelOptions{
+		TokenID:        c.tokenID,
+		BrowserWarning: browserWarningFor(c.ctx, s.cfg, s.deps.BrowserWarnings, c.tokenID, id),
+		Limits:         resolveHTTPLimits(s.cfg, nil),
+	}); err != nil {
+		return 0, errors.New("failed to register tunnel")
+	}
+	*releases = append(*releases, func() { s.registry.UnregisterHTTPTunnel(id) })
+
+	if s.metrics != nil {
+		*releases = append(*releases, s.metrics.trackHTTPTunnel())
+	}
+
+	url := s.cfg.httpTunnelURL(id)
+	c.printf("Forwarding HTTP traffic from %s", url)
+	c.logger.Info("ssh http tunnel", logging.F("id", id))
+
+	return port, nil
+}
+
+func (c *sshGatewayConn) startTCPForward(p sshForwardRequest, wrap func(*sshForwardSession) streamSession, releases *[]func()) (uint32, error) {
+	s := c.s
+
+	requested := int(p.BindPort)
+	if err := claimTCPPort(c.ctx, s.cfg, s.deps.Reservations, s.ports, c.tokenID, requested); err != nil {
+		return 0, err
+	}
+	if requested == 0 {
+		refreshReservedTCPPorts(c.ctx, s.ports, s.deps.Reservations, c.logger)
+	}
+
+	ln, port, err := s.ports.Allocate(requested)
+	if err != nil {
+		return 0, err
+	}
+	*releases = append(*releases, func() { _ = ln.Close() })
+
+	// Each visitor connection gets its own session so the agent sees the
+	// visitor's address as the origin; the wrappers share their state.
+	forward := func(origin net.Addr) streamSession {
+		return wrap(&sshForwardSession{conn: c.conn, addr: p.BindAddr, port: uint32(port), origin: origin})
+	}
+	entry := tcpTunnelEntry{
+		session: forward(nil),
+		tokenID: c.tokenID,
+		edge:    s.cfg.EdgePolicy,
+	}
+	if err := s.registry.RegisterTCPTunnel(port, entry); err != nil {
+		return 0, err
+	}
+	*releases = append(*releases, func() { s.registry.UnregisterTCPTunnel(port) })
+
+	if s.metrics != nil {
+		*releases = append(*releases, s.metrics.trackTCPTunnel())
+	}
+
+	access := newAccessLog(s.cfg, s.deps.AccessLog)
+	go func() {
+		for {
+			inbound, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(in net.Conn) {
+				ip := addrIP(in.RemoteAddr())
+				if !entry.allows(ip) {
+					_ = in.Close()
+					return
+				}
+				in = recordTCP(in, port, c.tokenID, ip, access, s.metrics)
+				defer in.Close()
+
+				stream, err := forward(in.RemoteAddr()).OpenStream()
+				if err != nil {
+					s.metrics.countStreamOpenFailure("tcp", c.tokenID)
+					return
+				}
+				defer stream.Close()
+
+				_ = proxyBidirectional(c.ctx, in, stream)
+			}(inbound)
+		}
+	}()
+
+	host := strings.TrimSuffix(s.cfg.TunnelDomain, ".")
+	if host == "" {
+		host = strings.TrimSuffix(s.cfg.BaseDomain, ".")
+	}
+	c.printf("Forwarding TCP traffic from tcp://%s", net.JoinHostPort(host, strconv.Itoa(port)))
+	c.logger.Info("ssh tcp tunnel", logging.F("port", port))
+
+	return uint32(port), nil
+}
+
+// sshForwardSession lets the edge open streams over an SSH remote forward,
+// like yamuxSession does for the control protocol. origin is reported as the
+// connection's source; nil means the gateway's own address.
+type sshForwardSession struct {
+	conn   ssh.Conn
+	addr   string
+	port   uint32
+	origin net.Addr
+}
+
+func (f *sshForwardSession) OpenStream() (net.Conn, error) {
+	return openSSHForwardedConn(f.conn, f.addr, f.port, f.origin)
+}
+
+// Close is a no-op: the SSH connection outlives individual forwards and is
+// closed by the gateway.
+func (f *sshForwardSession) Close() error { return nil }
+
+func openSSHForwardedConn(conn ssh.Conn, addr string, port uint32, origin net.Addr) (net.Conn, error) {
+	// Without a known visitor address, report the gateway's own address: some
+	// clients reject an origin port of 0.
+	if origin == nil {
+		origin = conn.LocalAddr()
+	}
+	originAddr, originPort := "127.0.0.1", 1
+	if tcp, ok := origin.(*net.TCPAddr); ok {
+		originAddr, originPort = tcp.IP.String(), tcp.Port
+	}
+
+	ch, reqs, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(sshForwardedTCPPayload{
+		Addr:       addr,
+		Port:       port,
+		OriginAddr: originAddr,
+		OriginPort: uint32(originPort),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+
+	return &sshChannelConn{
+		Channel: ch,
+		local:   conn.LocalAddr(),
+		remote:  conn.RemoteAddr(),
+	}, nil
+}
+
+// sshChannelConn adapts an ssh.Channel to net.Conn. Channels have no
+// deadlines, so an expired deadline closes the channel instead; that is what
+// callers here use deadlines for (unblocking copies on teardown).
+type sshChannelConn struct {
+	ssh.Channel
+
+	local  net.Addr
+	remote net.Addr
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func (c *sshChannelConn) LocalAddr() net.Addr  { return c.local }
+func (c *sshChannelConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *sshChannelConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if t.IsZero() {
+		return nil
+	}
+
+	d := time.Until(t)
+	if d <= 0 {
+		go c.Close()
+		return nil
+	}
+	c.timer = time.AfterFunc(d, func() { _ = c.Close() })
+	return nil
+}
+
+func (c *sshChannelConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *sshChannelConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/auth"
+	"golang.org/x/crypto/ssh"
+)
+
+type sshTestEnv struct {
+	srv   *Server
+	store *auth.Store
+	addr  string
+}
+
+func newSSHTestEnv(t *testing.T, cfg Config) *sshTestEnv {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	store, err := auth.Open(ctx, ":memory:")
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	srv := New(cfg, Dependencies{
+		TokenValidator: store,
+		TokenResolver:  store,
+		Reservations:   store,
+		SSHKeys:        store,
+	})
+
+	hostKey, err := LoadOrCreateSSHHostKey(filepath.Join(t.TempDir(), "host_key"))
+	if err != nil {
+		t.Fatalf("host key: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = srv.ServeSSH(ctx, ln, hostKey) }()
+
+	return &sshTestEnv{srv: srv, store: store, addr: ln.Addr().String()}
+}
+
+func (e *sshTestEnv) dial(t *testing.T, user string, methods ...ssh.AuthMethod) *ssh.Client {
+	t.Helper()
+
+	client, err := ssh.Dial("tcp", e.addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("ssh dial: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// startSSHSession runs command on a new session and returns its stdout lines.
+func startSSHSession(t *testing.T, client *ssh.Client, command string) *bufio.Scanner {
+	t.Helper()
+
+	sess, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	t.Cleanup(func() { _ = sess.Close() })
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		t.Fatalf("stdout pipe: %v", err)
+	}
+	if err := sess.Start(command); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	return bufio.NewScanner(stdout)
+}
+
+func readSSHLine(t *testing.T, sc *bufio.Scanner) string {
+	t.Helper()
+
+	lineCh := make(chan string, 1)
+	go func() {
+		if sc.Scan() {
+			lineCh <- strings.TrimSpace(sc.Text())
+			return
+		}
+		lineCh <- ""
+	}()
+
+	select {
+	case line := <-lineCh:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for ssh output")
+		return ""
+	}
+}
+
+func TestSSHGateway_HTTPForward(t *testing.T) {
+	t.Parallel()
+
+	env := newSSHTestEnv(t, Config{TunnelDomain: "tunnel.eosrift.test"})
+
+	_, token, err := env.store.CreateToken(context.Background(), "ssh")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	client := env.dial(t, token)
+	out := startSSHSession(t, client, "http")
+
+	ln, err := client.Listen("tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Fatalf("remote listen: %v", err)
+	}
+	go func() {
+		_ = http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "hello via ssh "+r.URL.Path)
+		}))
+	}()
+
+	line := readSSHLine(t, out)
+	const prefix = "Forwarding HTTP traffic from "
+	if !strings.HasPrefix(line, prefix) {
+		t.Fatalf("output = %q, want prefix %q", line, prefix)
+	}
+	u, err := url.Parse(strings.TrimPrefix(line, prefix))
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/path", nil)
+	req.Host = u.Host
+	rec := httptest.NewRecorder()
+	env.srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body=%q)", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if got, want := rec.Body.String(), "hello via ssh /path"; got != want {
+		t.Fatalf("body = %q, want %q", got, want)
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("cancel forward: %v", err)
+	}
+
+	id, _ := tunnelIDFromHost(u.Host, "tunnel.eosrift.test")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := env.srv.registry.GetHTTPTunnel(id); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnel %q still registered after cancel", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSSHGateway_IdleForwardReaped(t *testing.T) {
+	t.Parallel()
+
+	env := newSSHTestEnv(t, Config{
+		TunnelDomain:      "tunnel.eosrift.test",
+		TunnelIdleTimeout: 100 * time.Millisecond,
+	})
+
+	_, token, err := env.store.CreateToken(context.Background(), "ssh")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	client := env.dial(t, token)
+	out := startSSHSession(t, client, "http")
+
+	ln, err := client.Listen("tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Fatalf("remote listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	line := readSSHLine(t, out)
+	const prefix = "Forwarding HTTP traffic from "
+	if !strings.HasPrefix(line, prefix) {
+		t.Fatalf("output = %q, want prefix %q", line, prefix)
+	}
+	u, err := url.Parse(strings.TrimPrefix(line, prefix))
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+
+	if got, want := readSSHLine(t, out), "eosrift: forward 127.0.0.1:80 closed (idle)"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	id, _ := tunnelIDFromHost(u.Host, "tunnel.eosrift.test")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := env.srv.registry.GetHTTPTunnel(id); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("idle tunnel %q still registered", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSSHGateway_ForwardBandwidthShaped(t *testing.T) {
+	t.Parallel()
+
+	env := newSSHTestEnv(t, Config{
+		TunnelDomain:       "tunnel.eosrift.test",
+		TunnelBandwidthOut: 64 << 10,
+		BandwidthBurst:     16 << 10,
+	})
+
+	_, token, err := env.store.CreateToken(context.Background(), "ssh")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	client := env.dial(t, token)
+	out := startSSHSession(t, client, "http")
+
+	ln, err := client.Listen("tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Fatalf("remote listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	payload := strings.Repeat("x", 48<<10)
+	go func() {
+		_ = http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, payload)
+		}))
+	}()
+
+	line := readSSHLine(t, out)
+	const prefix = "Forwarding HTTP traffic from "
+	if !strings.HasPrefix(line, prefix) {
+		t.Fatalf("output = %q, want prefix %q", line, prefix)
+	}
+	u, err := url.Parse(strings.TrimPrefix(line, prefix))
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+
+	// 16KiB burst, then the rest of the response at 64KiB/s.
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	req.Host = u.Host
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	env.srv.Handler().ServeHTTP(rec, req)
+	elapsed := time.Since(start)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec.Body.Len() != len(payload) {
+		t.Fatalf("body length = %d, want %d", rec.Body.Len(), len(payload))
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Fatalf("response took %v, want paced to ~500ms", elapsed)
+	}
+	if got := env.srv.metrics.throttled.Value("out"); got == 0 {
+		t.Fatalf("throttled out bytes = 0, want > 0")
+	}
+}
+
+func TestSSHGateway_HTTPForward_Subdomain(t *testing.T) {
+	t.Parallel()
+
+	env := newSSHTestEnv(t, Config{TunnelDomain: "tunnel.eosrift.test"})
+
+	_, token, err := env.store.CreateToken(context.Background(), "ssh")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	client := env.dial(t, token)
+	out := startSSHSession(t, client, "")
+
+	// client.Listen resolves the bind address, so send the forward directly.
+	ok, _, err := client.SendRequest("tcpip-forward", true, ssh.Marshal(sshForwardRequest{
+		BindAddr: "myapp",
+		BindPort: 80,
+	}))
+	if err != nil || !ok {
+		t.Fatalf("tcpip-forward = (%v, %v), want ok", ok, err)
+	}
+
+	if got, want := readSSHLine(t, out), "Forwarding HTTP traffic from https://myapp.tunnel.eosrift.test"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	tokenID, reserved, err := env.store.ReservedSubdomainTokenID(context.Background(), "myapp")
+	if err != nil || !reserved || tokenID <= 0 {
+		t.Fatalf("reservation = (%d, %v, %v), want reserved", tokenID, reserved, err)
+	}
+}
+
+func TestSSHGateway_TCPForward(t *testing.T) {
+	t.Parallel()
+
+	tmpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen temp: %v", err)
+	}
+	port := tmpLn.Addr().(*net.TCPAddr).Port
+	_ = tmpLn.Close()
+
+	env := newSSHTestEnv(t, Config{
+		TunnelDomain:      "tunnel.eosrift.test",
+		TCPPortRangeStart: port,
+		TCPPortRangeEnd:   port,
+	})
+
+	_, token, err := env.store.CreateToken(context.Background(), "ssh")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	client := env.dial(t, "someone", ssh.Password(token))
+	out := startSSHSession(t, client, "tcp")
+
+	ln, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("remote listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	if got := ln.Addr().(*net.TCPAddr).Port; got != port {
+		t.Fatalf("allocated port = %d, want %d", got, port)
+	}
+	if got, want := readSSHLine(t, out), "Forwarding TCP traffic from tcp://tunnel.eosrift.test:"+strconv.Itoa(port); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial tunnel: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("echo = %q, want %q", buf, "ping")
+	}
+}
+
+func TestSSHGateway_PublicKeyAuth(t *testing.T) {
+	t.Parallel()
+
+	env := newSSHTestEnv(t, Config{TunnelDomain: "tunnel.eosrift.test"})
+	ctx := context.Background()
+
+	rec, _, err := env.store.CreateToken(ctx, "ssh")
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("signer: %v", err)
+	}
+	if _, err := env.store.AddSSHKey(ctx, rec.ID, string(ssh.MarshalAuthorizedKey(signer.PublicKey()))); err != nil {
+		t.Fatalf("add ssh key: %v", err)
+	}
+
+	client := env.dial(t, "someone", ssh.PublicKeys(signer))
+	out := startSSHSession(t, client, "http")
+
+	ln, err := client.Listen("tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Fatalf("remote listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	if line := readSSHLine(t, out); !strings.HasPrefix(line, "Forwarding HTTP traffic from https://") {
+		t.Fatalf("output = %q", line)
+	}
+}
+
+func TestSSHGateway_RejectsInvalidToken(t *testing.T) {
+	t.Parallel()
+
+	env := newSSHTestEnv(t, Config{TunnelDomain: "tunnel.eosrift.test"})
+
+	_, err := ssh.Dial("tcp", env.addr, &ssh.ClientConfig{
+		User:            "eos_invalid",
+		Auth:            []ssh.AuthMethod{ssh.Password("eos_invalid")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err == nil {
+		t.Fatalf("expected auth error")
+	}
+}
+
+func TestSSHForwardMode(t *testing.T) {
+	t.Parallel()
+
+	hint := func(mode string) func(time.Duration) string {
+		return func(time.Duration) string { return mode }
+	}
+
+	cases := []struct {
+		port uint32
+		hint string
+		want string
+	}{
+		{80, "tcp", "http"},
+		{443, "", "http"},
+		{0, "", "http"},
+		{0, "tcp", "tcp"},
+		{20005, "", "tcp"},
+		{20005, "http", "http"},
+	}
+	for _, tc := range cases {
+		if got := sshForwardMode(tc.port, hint(tc.hint)); got != tc.want {
+			t.Fatalf("sshForwardMode(%d, %q) = %q, want %q", tc.port, tc.hint, got, tc.want)
+		}
+	}
+}
+
+func TestSSHForwardName(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		addr          string
+		wantSubdomain string
+		wantDomain    string
+	}{
+		{"", "", ""},
+		{"localhost", "", ""},
+		{"*", "", ""},
+		{"0.0.0.0", "", ""},
+		{"[::]", "", ""},
+		{"MyApp", "myapp", ""},
+		{"myapp.tunnel.eosrift.com", "", "myapp.tunnel.eosrift.com"},
+	}
+	for _, tc := range cases {
+		sub, domain := sshForwardName(tc.addr)
+		if sub != tc.wantSubdomain || domain != tc.wantDomain {
+			t.Fatalf("sshForwardName(%q) = (%q, %q), want (%q, %q)", tc.addr, sub, domain, tc.wantSubdomain, tc.wantDomain)
+		}
+	}
+}
+
+func TestLoadOrCreateSSHHostKey_Persists(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "nested", "ssh_host_ed25519_key")
+
+	first, err := LoadOrCreateSSHHostKey(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	second, err := LoadOrCreateSSHHostKey(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if a, b := ssh.FingerprintSHA256(first.PublicKey()), ssh.FingerprintSHA256(second.PublicKey()); a != b {
+		t.Fatalf("fingerprint changed: %q != %q", a, b)
+	}
+}
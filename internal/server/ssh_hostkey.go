@@ -0,0 +1,50 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadOrCreateSSHHostKey reads the SSH gateway host key from path, generating
+// and persisting a new ed25519 key when the file does not exist yet so the
+// host fingerprint stays stable across restarts.
+func LoadOrCreateSSHHostKey(path string) (ssh.Signer, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, errors.New("empty ssh host key path")
+	}
+
+	pemBytes, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(pemBytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "eosrift ssh host key")
+	if err != nil {
+		return nil, err
+	}
+	pemBytes = pem.EncodeToMemory(block)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(pemBytes)
+}
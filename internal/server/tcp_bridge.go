@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"eosrift.com/eosrift/internal/logging"
+	"nhooyr.io/websocket"
+)
+
+// tcpTunnelEntry is an active public TCP tunnel, indexed by port so the
+// WebSocket bridge can reach it without the raw port being reachable.
+type tcpTunnelEntry struct {
+	session streamSession
+	tokenID int64
+
+	allowCIDRs []netip.Prefix
+	denyCIDRs  []netip.Prefix
+
+	edge *EdgePolicy
+}
+
+// allows applies the edge policy's deny list, then the tunnel's CIDR rules;
+// deny always takes precedence.
+func (e tcpTunnelEntry) allows(ip netip.Addr) bool {
+	if e.edge.denies(ip) {
+		return false
+	}
+	if len(e.allowCIDRs) == 0 && len(e.denyCIDRs) == 0 {
+		return true
+	}
+	if !ip.IsValid() {
+		return false
+	}
+	if cidrListContains(e.denyCIDRs, ip) {
+		return false
+	}
+	if len(e.allowCIDRs) > 0 && !cidrListContains(e.allowCIDRs, ip) {
+		return false
+	}
+	return true
+}
+
+func (r *TunnelRegistry) RegisterTCPTunnel(port int, entry tcpTunnelEntry) error {
+	if port <= 0 || port > 65535 {
+		return errors.New("invalid port")
+	}
+	if entry.session == nil {
+		return errors.New("nil session")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.tcpTunnels[port]; exists {
+		return errors.New("port already registered")
+	}
+
+	r.tcpTunnels[port] = entry
+	return nil
+}
+
+func (r *TunnelRegistry) GetTCPTunnel(port int) (tcpTunnelEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.tcpTunnels[port]
+	return t, ok
+}
+
+func (r *TunnelRegistry) UnregisterTCPTunnel(port int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.tcpTunnels, port)
+}
+
+// addrIP extracts the IP of a net.Addr (for TCP listener CIDR checks).
+func addrIP(addr net.Addr) netip.Addr {
+	if addr == nil {
+		return netip.Addr{}
+	}
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		ip, _ := netip.AddrFromSlice(tcp.IP)
+		return ip.Unmap()
+	}
+	ip, _ := parseNetipAddr(addr.String())
+	if !ip.IsValid() {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			ip, _ = parseNetipAddr(host)
+		}
+	}
+	return ip
+}
+
+// tcpBridgeHandler serves GET /tcp/<port> on the base domain: a WebSocket
+// carrying a raw byte stream to the TCP tunnel on that port. It is meant for
+// visitors who can reach 443 but not the TCP tunnel port range
+// (`eosrift connect`). Access rules match the TCP listener.
+func tcpBridgeHandler(cfg Config, registry *TunnelRegistry, access *accessLog, metrics *metrics, logger logging.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		port, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/tcp/"))
+		if err != nil || port <= 0 || port > 65535 {
+			http.NotFound(w, r)
+			return
+		}
+
+		entry, ok := registry.GetTCPTunnel(port)
+		if !ok {
+			http.Error(w, "tunnel not found", http.StatusNotFound)
+			return
+		}
+
+		ip, _ := requestClientIP(r, cfg.TrustProxyHeaders)
+		if !entry.allows(ip) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+			CompressionMode: websocket.CompressionDisabled,
+		})
+		if err != nil {
+			if logger != nil {
+				logger.Debug("tcp bridge accept error", logging.F("err", err), logging.F("remote_addr", r.RemoteAddr))
+			}
+			return
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "closed")
+
+		ctx := r.Context()
+		netConn := recordTCP(websocket.NetConn(ctx, conn, websocket.MessageBinary), port, entry.tokenID, ip, access, metrics)
+		defer netConn.Close()
+
+		stream, err := entry.session.OpenStream()
+		if err != nil {
+			metrics.countStreamOpenFailure("tcp", entry.tokenID)
+			_ = conn.Close(websocket.StatusTryAgainLater, "tunnel unavailable")
+			return
+		}
+		defer stream.Close()
+
+		_ = proxyBidirectional(ctx, netConn, stream)
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+	"github.com/hashicorp/yamux"
+	"nhooyr.io/websocket"
+)
+
+// startTestTCPTunnel creates a TCP tunnel whose agent echoes every stream.
+func startTestTCPTunnel(t *testing.T, baseURL string, req control.CreateTCPTunnelRequest) int {
+	t.Helper()
+
+	ws, session := dialTestControl(t, baseURL)
+	t.Cleanup(func() {
+		_ = session.Close()
+		_ = ws.Close(websocket.StatusNormalClosure, "closed")
+	})
+
+	ctrl, err := session.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	req.Type = "tcp"
+	if err := control.WriteJSON(ctrl, req); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	var resp control.CreateTCPTunnelResponse
+	if err := json.NewDecoder(ctrl).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Error != "" {
+		t.Fatalf("error = %q", resp.Error)
+	}
+
+	go func() {
+		for {
+			st, err := session.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func(st *yamux.Stream) {
+				defer st.Close()
+				_, _ = io.Copy(st, st)
+			}(st)
+		}
+	}()
+
+	return resp.RemotePort
+}
+
+func TestTCPBridge(t *testing.T) {
+	t.Parallel()
+
+	tmpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen temp: %v", err)
+	}
+	port := tmpLn.Addr().(*net.TCPAddr).Port
+	_ = tmpLn.Close()
+
+	srv := httptest.NewServer(NewHandler(Config{
+		BaseDomain:        "127.0.0.1",
+		TunnelDomain:      "tunnel.eosrift.test",
+		TCPPortRangeStart: port,
+		TCPPortRangeEnd:   port,
+	}, Dependencies{}))
+	t.Cleanup(srv.Close)
+
+	remotePort := startTestTCPTunnel(t, srv.URL, control.CreateTCPTunnelRequest{
+		AllowCIDR: []string{"127.0.0.1"},
+	})
+	bridgeURL := fmt.Sprintf("ws%s/tcp/%d", strings.TrimPrefix(srv.URL, "http"), remotePort)
+
+	t.Run("echo", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		ws, _, err := websocket.Dial(ctx, bridgeURL, nil)
+		if err != nil {
+			t.Fatalf("dial bridge: %v", err)
+		}
+		conn := websocket.NetConn(ctx, ws, websocket.MessageBinary)
+		defer conn.Close()
+
+		if _, err := io.WriteString(conn, "ping"); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(buf) != "ping" {
+			t.Fatalf("echo = %q, want %q", buf, "ping")
+		}
+	})
+
+	t.Run("unknown port", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/tcp/%d", srv.URL, remotePort+1))
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+		}
+	})
+
+	t.Run("tunnel host is proxied", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/tcp/%d", srv.URL, remotePort), nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d (tunnel proxy, unknown tunnel)", resp.StatusCode, http.StatusNotFound)
+		}
+	})
+}
+
+func TestTCPBridge_DenyCIDR(t *testing.T) {
+	t.Parallel()
+
+	tmpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen temp: %v", err)
+	}
+	port := tmpLn.Addr().(*net.TCPAddr).Port
+	_ = tmpLn.Close()
+
+	srv := httptest.NewServer(NewHandler(Config{
+		BaseDomain:        "127.0.0.1",
+		TCPPortRangeStart: port,
+		TCPPortRangeEnd:   port,
+	}, Dependencies{}))
+	t.Cleanup(srv.Close)
+
+	remotePort := startTestTCPTunnel(t, srv.URL, control.CreateTCPTunnelRequest{
+		DenyCIDR: []string{"127.0.0.0/8"},
+	})
+
+	resp, err := http.Get(fmt.Sprintf("%s/tcp/%d", srv.URL, remotePort))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("bridge status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	// The raw listener applies the same rule: the connection is dropped.
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", remotePort))
+	if err != nil {
+		t.Fatalf("dial listener: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = io.WriteString(c, "ping")
+	if n, err := c.Read(make([]byte, 4)); err == nil {
+		t.Fatalf("read %d bytes from denied connection, want close", n)
+	}
+}
+
+func TestTCPTunnelEntryAllows(t *testing.T) {
+	t.Parallel()
+
+	entry := tcpTunnelEntry{
+		allowCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		denyCIDRs:  []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"10.0.0.1", false},
+		{"192.0.2.1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		ip, _ := parseNetipAddr(tt.ip)
+		if got := entry.allows(ip); got != tt.want {
+			t.Fatalf("allows(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if !(tcpTunnelEntry{}).allows(netip.Addr{}) {
+		t.Fatalf("entry without rules should allow any client")
+	}
+}
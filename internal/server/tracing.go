@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"eosrift.com/eosrift/internal/inspect"
+	"eosrift.com/eosrift/internal/tracing"
+)
+
+// OpenTracer returns the tracer exporting to cfg.OTLPEndpoint, or nil when
+// tracing is off. Shut it down to flush pending spans.
+func OpenTracer(cfg Config) (*tracing.Tracer, error) {
+	if cfg.OTLPEndpoint == "" {
+		return nil, nil
+	}
+	if cfg.TraceSampleRatio < 0 || cfg.TraceSampleRatio > 1 {
+		return nil, fmt.Errorf("invalid trace sample ratio %v (want 0..1)", cfg.TraceSampleRatio)
+	}
+	return tracing.New(tracing.Options{
+		Endpoint:    cfg.OTLPEndpoint,
+		ServiceName: "eosrift-server",
+		SampleRatio: cfg.TraceSampleRatio,
+	})
+}
+
+// startEdgeSpan starts the span covering a request at the HTTP edge. A
+// traceparent sent by the visitor (or Caddy) becomes its parent. The
+// returned request carries the span for the edge's child spans.
+func startEdgeSpan(tracer *tracing.Tracer, r *http.Request, requestID string) (*http.Request, *tracing.Span) {
+	if tracer == nil {
+		return r, nil
+	}
+
+	ctx := r.Context()
+	if sc, ok := tracing.Extract(r.Header); ok {
+		ctx = tracing.ContextWithRemoteParent(ctx, sc)
+	}
+	ctx, span := tracer.Start(ctx, "edge.request", tracing.KindServer)
+	span.SetAttr("http.request.method", r.Method)
+	span.SetAttr("server.address", r.Host)
+	span.SetAttr("url.path", inspect.RedactPath(r.URL.RequestURI()))
+	span.SetAttr("eosrift.request_id", requestID)
+	return r.WithContext(ctx), span
+}
+
+// startSpan starts an internal child of the span in ctx.
+func startSpan(ctx context.Context, tracer *tracing.Tracer, name string) *tracing.Span {
+	_, span := tracer.Start(ctx, name, tracing.KindInternal)
+	return span
+}
+
+// tracingTransport records the round trip over the tunnel as a client span
+// and forwards its trace context to the agent in the traceparent header.
+// Stream opens happen within it, so they become its children.
+type tracingTransport struct {
+	next   http.RoundTripper
+	tracer *tracing.Tracer
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.tracer == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, span := t.tracer.Start(req.Context(), "tunnel.round_trip", tracing.KindClient)
+	defer span.End()
+
+	req = req.Clone(ctx)
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttr("http.response.status_code", resp.StatusCode)
+	return resp, nil
+}
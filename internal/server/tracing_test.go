@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"eosrift.com/eosrift/internal/tracing"
+)
+
+// traceparentSession answers every request with 200 and reports the
+// traceparent header it arrived with.
+type traceparentSession struct {
+	gotCh chan string
+}
+
+func (s traceparentSession) OpenStream() (net.Conn, error) {
+	a, b := net.Pipe()
+	go func() {
+		defer b.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(b))
+		if err != nil {
+			return
+		}
+		_ = req.Body.Close()
+		s.gotCh <- req.Header.Get(tracing.TraceparentHeader)
+		_, _ = fmt.Fprint(b, rawResponse("", "ok"))
+	}()
+	return a, nil
+}
+
+func (traceparentSession) Close() error { return nil }
+
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+}
+
+func TestHTTPTunnel_TracesEdgeRequest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu    sync.Mutex
+		spans = map[string]exportedSpan{}
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}))
+	defer collector.Close()
+
+	tracer, err := OpenTracer(Config{OTLPEndpoint: collector.URL, TraceSampleRatio: 1})
+	if err != nil {
+		t.Fatalf("OpenTracer: %v", err)
+	}
+
+	gotCh := make(chan string, 1)
+	registry := NewTunnelRegistry()
+	if err := registry.RegisterHTTPTunnel("abcd1234", traceparentSession{gotCh: gotCh}, httpTunnelOptions{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, tracer)
+
+	req := httptest.NewRequest(http.MethodGet, "http://abcd1234.tunnel.eosrift.test/", nil)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	forwarded, ok := tracing.ParseTraceparent(<-gotCh)
+	if !ok {
+		t.Fatalf("upstream got no valid traceparent")
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	edge := spans["edge.request"]
+	if edge.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || edge.ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatalf("edge.request = %+v, want a child of the visitor's traceparent", edge)
+	}
+	for name, parent := range map[string]string{
+		"edge.route":         edge.SpanID,
+		"edge.policy":        edge.SpanID,
+		"tunnel.round_trip":  edge.SpanID,
+		"tunnel.stream_open": spans["tunnel.round_trip"].SpanID,
+	} {
+		if got := spans[name]; got.ParentSpanID != parent || got.TraceID != edge.TraceID {
+			t.Errorf("%s = %+v, want parent %s", name, got, parent)
+		}
+	}
+	if got, want := fmt.Sprintf("%x", forwarded.SpanID), spans["tunnel.round_trip"].SpanID; got != want {
+		t.Fatalf("forwarded traceparent span = %s, want tunnel.round_trip %s", got, want)
+	}
+}
+
+func TestOpenTracer(t *testing.T) {
+	t.Parallel()
+
+	if tracer, err := OpenTracer(Config{}); tracer != nil || err != nil {
+		t.Fatalf("OpenTracer without endpoint = %v, %v; want nil, nil", tracer, err)
+	}
+	if _, err := OpenTracer(Config{OTLPEndpoint: "http://127.0.0.1:4318", TraceSampleRatio: 2}); err == nil {
+		t.Fatalf("expected error for a sample ratio above 1")
+	}
+	if _, err := OpenTracer(Config{OTLPEndpoint: "127.0.0.1:4318", TraceSampleRatio: 1}); err == nil {
+		t.Fatalf("expected error for an endpoint without a scheme")
+	}
+}
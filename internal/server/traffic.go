@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eosrift.com/eosrift/internal/tracing"
+)
+
+// recordHTTP starts accounting for a request to the HTTP edge, for the
+// access log and metrics. It notes r as received (before any path routing
+// prefix is stripped) and returns a writer that captures the response
+// status and size; finish reports the request and ends span. It returns
+// nil when there is nowhere to report to.
+func recordHTTP(w http.ResponseWriter, r *http.Request, requestID string, access *accessLog, m *metrics, span *tracing.Span) *httpTrafficRecord {
+	if access == nil && m == nil && span == nil {
+		return nil
+	}
+	rec := &httpTrafficRecord{
+		ResponseWriter: w,
+		access:         access,
+		metrics:        m,
+		span:           span,
+		start:          time.Now(),
+		method:         r.Method,
+		host:           r.Host,
+		path:           r.URL.RequestURI(),
+		requestID:      requestID,
+	}
+	if access != nil {
+		rec.clientIP, _ = requestClientIP(r, access.trustProxyHeaders)
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingReadCloser{ReadCloser: r.Body, n: &rec.bytesIn}
+	}
+	return rec
+}
+
+type httpTrafficRecord struct {
+	http.ResponseWriter
+	access  *accessLog
+	metrics *metrics
+	span    *tracing.Span
+
+	start     time.Time
+	clientIP  netip.Addr
+	method    string
+	host      string
+	path      string
+	requestID string
+	tunnelID  string
+	tokenID   int64
+
+	status   int
+	bytesIn  atomic.Int64
+	bytesOut int64
+}
+
+// setTunnel notes the tunnel the request was routed to.
+func (rec *httpTrafficRecord) setTunnel(id string, tokenID int64) {
+	if rec == nil {
+		return
+	}
+	rec.tunnelID = id
+	rec.tokenID = tokenID
+}
+
+// finish logs the request and, when it reached a tunnel, counts it.
+func (rec *httpTrafficRecord) finish() {
+	if rec == nil {
+		return
+	}
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if rec.access != nil {
+		rec.access.logHTTP(rec, status)
+	}
+	if rec.tunnelID != "" {
+		rec.metrics.observeHTTPRequest(rec.tokenID, status, rec.bytesIn.Load(), rec.bytesOut, time.Since(rec.start))
+	}
+	if rec.span != nil {
+		rec.span.SetAttr("eosrift.tunnel_id", rec.tunnelID)
+		rec.span.SetAttr("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			rec.span.SetStatusError(http.StatusText(status))
+		}
+		rec.span.End()
+	}
+}
+
+func (rec *httpTrafficRecord) WriteHeader(code int) {
+	if rec.status == 0 && (code >= http.StatusOK || code == http.StatusSwitchingProtocols) {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *httpTrafficRecord) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytesOut += int64(n)
+	return n, err
+}
+
+// Hijack records the protocol switch of a proxied upgrade, which
+// ReverseProxy writes to the hijacked connection itself.
+func (rec *httpTrafficRecord) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil && rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer for
+// flushing.
+func (rec *httpTrafficRecord) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// recordTCP reports a visitor connection to the TCP tunnel on port and
+// returns conn wrapped to report its byte counts when it closes. It returns
+// conn unchanged when there is nowhere to report to.
+func recordTCP(conn net.Conn, port int, tokenID int64, clientIP netip.Addr, access *accessLog, m *metrics) net.Conn {
+	if access == nil && m == nil {
+		return conn
+	}
+	c := &tcpTrafficConn{
+		Conn:     conn,
+		access:   access,
+		metrics:  m,
+		port:     port,
+		tokenID:  tokenID,
+		clientIP: clientIP,
+		start:    time.Now(),
+	}
+	if access != nil {
+		access.logTCPOpen(c)
+	}
+	m.countTCPConnection(tokenID)
+	return c
+}
+
+// tcpTrafficConn is a visitor connection: reads carry bytes in, writes
+// bytes out.
+type tcpTrafficConn struct {
+	net.Conn
+	access  *accessLog
+	metrics *metrics
+
+	port     int
+	tokenID  int64
+	clientIP netip.Addr
+	start    time.Time
+
+	bytesIn   atomic.Int64
+	bytesOut  atomic.Int64
+	closeOnce sync.Once
+}
+
+func (c *tcpTrafficConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c *tcpTrafficConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.bytesOut.Add(int64(n))
+	return n, err
+}
+
+func (c *tcpTrafficConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.access != nil {
+			c.access.logTCPClose(c)
+		}
+		c.metrics.observeTCPConnection(c.tokenID, c.bytesIn.Load(), c.bytesOut.Load(), time.Since(c.start))
+	})
+	return err
+}
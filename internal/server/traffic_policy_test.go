@@ -0,0 +1,173 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"eosrift.com/eosrift/internal/control"
+	"eosrift.com/eosrift/internal/policy"
+)
+
+func TestHTTPTunnel_TrafficPolicy(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	sess := &recordingSession{}
+
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{
+		AllowMethods: []string{"GET"},
+		Policy: mustCompilePolicy(t, &control.TrafficPolicy{
+			OnRequest: []control.PolicyRule{
+				{
+					Match:   control.PolicyMatch{Path: []string{"/maintenance"}},
+					Actions: []control.PolicyAction{{Type: "custom_response", Status: 503, Body: "back soon"}},
+				},
+				{
+					Match:   control.PolicyMatch{PathPrefix: []string{"/old/"}},
+					Actions: []control.PolicyAction{{Type: "redirect", Status: 301, Location: "/new/"}},
+				},
+			},
+			OnResponse: []control.PolicyRule{{
+				Match:   control.PolicyMatch{Status: []int{200}},
+				Actions: []control.PolicyAction{{Type: "set_header", Header: "X-Policy", Value: "ok"}},
+			}},
+		}),
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.test"+path, nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	t.Run("legacy options run first", func(t *testing.T) {
+		sess.openCount.Store(0)
+
+		rr := serve(http.MethodPost, "/maintenance")
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotFound)
+		}
+		if sess.openCount.Load() != 0 {
+			t.Fatalf("open count = %d, want 0", sess.openCount.Load())
+		}
+	})
+
+	t.Run("custom response", func(t *testing.T) {
+		sess.openCount.Store(0)
+
+		rr := serve(http.MethodGet, "/maintenance")
+		if rr.Code != http.StatusServiceUnavailable || rr.Body.String() != "back soon" {
+			t.Fatalf("got %d %q, want %d %q", rr.Code, rr.Body.String(), http.StatusServiceUnavailable, "back soon")
+		}
+		if sess.openCount.Load() != 0 {
+			t.Fatalf("open count = %d, want 0", sess.openCount.Load())
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/old/page")
+		if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/new/" {
+			t.Fatalf("got %d %q", rr.Code, rr.Header().Get("Location"))
+		}
+	})
+
+	t.Run("response headers", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/hello")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+		}
+		if got := rr.Header().Get("X-Policy"); got != "ok" {
+			t.Fatalf("X-Policy = %q, want %q", got, "ok")
+		}
+	})
+}
+
+func TestHTTPTunnel_LegacyAccessRunsFirst(t *testing.T) {
+	t.Parallel()
+
+	cors, err := control.ValidateCORSPolicy(&control.CORSPolicy{AllowOrigins: []string{"https://app.example.com"}})
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	registry := NewTunnelRegistry()
+	sess := &recordingSession{}
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{
+		DenyCIDRs:      []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")},
+		CORS:           cors,
+		ShareLinks:     true,
+		BrowserWarning: true,
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	limiter, err := policy.NewRateLimiter("1/h", "", nil)
+	if err != nil {
+		t.Fatalf("rate limiter: %v", err)
+	}
+	if !registry.SetHTTPTunnelRateLimit("abcd1234", limiter) {
+		t.Fatalf("SetHTTPTunnelRateLimit: tunnel not found")
+	}
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	for _, tc := range []struct {
+		name   string
+		remote string
+		method string
+		header map[string]string
+	}{
+		{name: "preflight", remote: "198.51.100.7:1234", method: http.MethodOptions, header: map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET"}},
+		{name: "browser", remote: "198.51.100.7:1234", method: http.MethodGet, header: map[string]string{"User-Agent": "Mozilla/5.0", "Accept": "text/html"}},
+		{name: "rate limited", remote: "198.51.100.7:1234", method: http.MethodGet},
+		{name: "unknown client ip", remote: "not-an-ip", method: http.MethodGet},
+	} {
+		req := httptest.NewRequest(tc.method, "http://abcd1234.tunnel.eosrift.test/", nil)
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		if rr.Code != http.StatusForbidden || strings.Contains(rr.Body.String(), "share link") {
+			t.Fatalf("%s: got %d %q, want the CIDR deny", tc.name, rr.Code, rr.Body.String())
+		}
+	}
+	if sess.openCount.Load() != 0 {
+		t.Fatalf("open count = %d, want 0", sess.openCount.Load())
+	}
+}
+
+func TestRegisterHTTPTunnel_InvalidLegacyPolicy(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	err := registry.RegisterHTTPTunnel("abcd1234", &recordingSession{}, httpTunnelOptions{
+		RateLimit: "often",
+		Policy: mustCompilePolicy(t, &control.TrafficPolicy{
+			OnRequest: []control.PolicyRule{{Actions: []control.PolicyAction{{Type: "deny"}}}},
+		}),
+	})
+	if err == nil || !strings.Contains(err.Error(), "rate_limit") {
+		t.Fatalf("err = %v, want a rate_limit error", err)
+	}
+	if _, ok := registry.GetHTTPTunnel("abcd1234"); ok {
+		t.Fatalf("tunnel registered despite invalid policy")
+	}
+}
+
+func mustCompilePolicy(t *testing.T, p *control.TrafficPolicy) *policy.Engine {
+	t.Helper()
+
+	e, err := policy.Compile(p, policy.Options{})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	return e
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+	"github.com/hashicorp/yamux"
+)
+
+// tunnelClosedGrace is how long the server waits for an agent to hang up
+// after a TunnelClosedNotice before closing the session itself.
+const tunnelClosedGrace = 2 * time.Second
+
+// TokenClassStore resolves the class of a token for per-class limits.
+type TokenClassStore interface {
+	TokenClass(ctx context.Context, tokenID int64) (string, error)
+	SetTokenClass(ctx context.Context, tokenID int64, class string) error
+}
+
+// tunnelLifetime is when, and why, the server closes a tunnel on its own.
+type tunnelLifetime struct {
+	// deadline is the earlier of the agent's requested expiry and the
+	// operator's maximum lifetime; reason says which one it is.
+	deadline time.Time
+	reason   string
+
+	// idle closes the tunnel once activity has seen no traffic for this
+	// long. Zero disables the idle reaper.
+	idle     time.Duration
+	activity *tunnelActivity
+}
+
+// resolveTunnelLifetime combines a create request's expires_at (unix
+// seconds, 0 for none) with the server's lifetime and idle limits.
+func resolveTunnelLifetime(ctx context.Context, cfg Config, classes TokenClassStore, tokenID, expiresAt int64, now time.Time) (tunnelLifetime, error) {
+	lt := tunnelLifetime{idle: cfg.TunnelIdleTimeout}
+	if expiresAt != 0 {
+		at := time.Unix(expiresAt, 0)
+		if !at.After(now) {
+			return tunnelLifetime{}, errors.New("expires_at is in the past")
+		}
+		lt.deadline, lt.reason = at, control.TunnelClosedExpired
+	}
+
+	maxLifetime, err := maxTunnelLifetime(ctx, cfg, classes, tokenID)
+	if err != nil {
+		return tunnelLifetime{}, errors.New("auth error")
+	}
+	if maxLifetime > 0 {
+		if limit := now.Add(maxLifetime); lt.deadline.IsZero() || limit.Before(lt.deadline) {
+			lt.deadline, lt.reason = limit, control.TunnelClosedMaxLifetime
+		}
+	}
+
+	if lt.idle > 0 {
+		lt.activity = newTunnelActivity(now)
+	}
+	return lt, nil
+}
+
+// maxTunnelLifetime is the lifetime limit for tunnels of tokenID: its
+// class's entry in MaxTunnelLifetimeByClass, or MaxTunnelLifetime.
+func maxTunnelLifetime(ctx context.Context, cfg Config, classes TokenClassStore, tokenID int64) (time.Duration, error) {
+	if len(cfg.MaxTunnelLifetimeByClass) == 0 || classes == nil || tokenID <= 0 {
+		return cfg.MaxTunnelLifetime, nil
+	}
+	class, err := classes.TokenClass(ctx, tokenID)
+	if err != nil {
+		return 0, err
+	}
+	if d, ok := cfg.MaxTunnelLifetimeByClass[class]; ok && class != "" {
+		return d, nil
+	}
+	return cfg.MaxTunnelLifetime, nil
+}
+
+// expiresAt is the deadline in unix seconds, or 0.
+func (lt tunnelLifetime) expiresAt() int64 {
+	if lt.deadline.IsZero() {
+		return 0
+	}
+	return lt.deadline.Unix()
+}
+
+// track records the traffic on s's streams for the idle reaper.
+func (lt tunnelLifetime) track(s streamSession) streamSession {
+	if lt.activity == nil {
+		return s
+	}
+	return &activitySession{streamSession: s, activity: lt.activity}
+}
+
+// noticeStream returns the control stream when the agent asked for
+// notices on it, and otherwise closes it and returns nil.
+func noticeStream(ctrlStream net.Conn, notices bool) net.Conn {
+	if !notices {
+		_ = ctrlStream.Close()
+		return nil
+	}
+	return ctrlStream
+}
+
+// wait blocks until the agent disconnects or the tunnel's lifetime ends.
+// In the latter case it sends the agent a TunnelClosedNotice on
+// ctrlStream, if not nil, and closes the session. ctrlStream is closed
+// either way.
+func (lt tunnelLifetime) wait(ctx context.Context, session *yamux.Session, ctrlStream net.Conn) {
+	if ctrlStream != nil {
+		defer ctrlStream.Close()
+	}
+
+	if reason := lt.expired(ctx, session.CloseChan()); reason != "" {
+		closeTunnel(session, ctrlStream, reason)
+	}
+}
+
+// expired blocks until ctx is done, closed is closed or the tunnel's
+// lifetime ends. In the latter case it returns why (a TunnelClosed*
+// reason), and otherwise "".
+func (lt tunnelLifetime) expired(ctx context.Context, closed <-chan struct{}) string {
+	var deadline <-chan time.Time
+	if !lt.deadline.IsZero() {
+		t := time.NewTimer(time.Until(lt.deadline))
+		defer t.Stop()
+		deadline = t.C
+	}
+	var idleCheck <-chan time.Time
+	if lt.activity != nil {
+		t := time.NewTicker(idleCheckInterval(lt.idle))
+		defer t.Stop()
+		idleCheck = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ""
+		case <-closed:
+			return ""
+		case <-deadline:
+			return lt.reason
+		case now := <-idleCheck:
+			if lt.activity.idleFor(now) >= lt.idle {
+				return control.TunnelClosedIdle
+			}
+		}
+	}
+}
+
+// bounded reports whether the tunnel can expire or go idle at all.
+func (lt tunnelLifetime) bounded() bool {
+	return !lt.deadline.IsZero() || lt.activity != nil
+}
+
+// idleCheckInterval polls a few times per idle timeout, at least every
+// minute.
+func idleCheckInterval(idle time.Duration) time.Duration {
+	return min(max(idle/4, 10*time.Millisecond), time.Minute)
+}
+
+// closeTunnel tells the agent why its tunnel is going away and closes the
+// session once the agent hangs up (or after tunnelClosedGrace).
+func closeTunnel(session *yamux.Session, ctrlStream net.Conn, reason string) {
+	if ctrlStream == nil {
+		_ = session.Close()
+		return
+	}
+
+	_ = ctrlStream.SetWriteDeadline(time.Now().Add(tunnelClosedGrace))
+	if err := control.WriteJSON(ctrlStream, control.TunnelClosedNotice{Type: "closed", Reason: reason}); err == nil {
+		_ = ctrlStream.Close()
+		t := time.NewTimer(tunnelClosedGrace)
+		select {
+		case <-session.CloseChan():
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	_ = session.Close()
+}
+
+// tunnelActivity tracks when a tunnel last carried traffic. A stream that
+// stays open without reads or writes (an idle WebSocket, say) does not
+// keep the tunnel alive.
+type tunnelActivity struct {
+	last atomic.Int64 // unix nanoseconds
+}
+
+func newTunnelActivity(now time.Time) *tunnelActivity {
+	a := &tunnelActivity{}
+	a.touch(now)
+	return a
+}
+
+func (a *tunnelActivity) touch(now time.Time) {
+	a.last.Store(now.UnixNano())
+}
+
+// idleFor is how long the tunnel has carried no traffic at now.
+func (a *tunnelActivity) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, a.last.Load()))
+}
+
+type activitySession struct {
+	streamSession
+	activity *tunnelActivity
+}
+
+func (s *activitySession) OpenStream() (net.Conn, error) {
+	conn, err := s.streamSession.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	s.activity.touch(time.Now())
+	return &activityConn{Conn: conn, activity: s.activity}, nil
+}
+
+// activityConn records reads and writes on a stream as tunnel activity.
+type activityConn struct {
+	net.Conn
+	activity *tunnelActivity
+}
+
+func (c *activityConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.activity.touch(time.Now())
+	}
+	return n, err
+}
+
+func (c *activityConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.activity.touch(time.Now())
+	}
+	return n, err
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/control"
+	"github.com/hashicorp/yamux"
+	"nhooyr.io/websocket"
+)
+
+type staticTokenClasses map[int64]string
+
+func (c staticTokenClasses) TokenClass(ctx context.Context, tokenID int64) (string, error) {
+	if tokenID == 99 {
+		return "", errors.New("boom")
+	}
+	return c[tokenID], nil
+}
+
+func (c staticTokenClasses) SetTokenClass(ctx context.Context, tokenID int64, class string) error {
+	c[tokenID] = class
+	return nil
+}
+
+func TestResolveTunnelLifetime(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	classes := staticTokenClasses{1: "demo", 2: "partner", 3: ""}
+	cfg := Config{
+		MaxTunnelLifetime: 24 * time.Hour,
+		MaxTunnelLifetimeByClass: map[string]time.Duration{
+			"demo":    2 * time.Hour,
+			"partner": 0,
+		},
+	}
+
+	cases := []struct {
+		name       string
+		tokenID    int64
+		expiresAt  int64
+		wantAt     time.Time
+		wantReason string
+	}{
+		{"default max", 3, 0, now.Add(24 * time.Hour), control.TunnelClosedMaxLifetime},
+		{"class max", 1, 0, now.Add(2 * time.Hour), control.TunnelClosedMaxLifetime},
+		{"class unlimited", 2, 0, time.Time{}, ""},
+		{"expiry before max", 1, now.Add(time.Hour).Unix(), now.Add(time.Hour), control.TunnelClosedExpired},
+		{"expiry after max", 1, now.Add(3 * time.Hour).Unix(), now.Add(2 * time.Hour), control.TunnelClosedMaxLifetime},
+		{"expiry unlimited class", 2, now.Add(72 * time.Hour).Unix(), now.Add(72 * time.Hour), control.TunnelClosedExpired},
+	}
+	for _, tc := range cases {
+		lt, err := resolveTunnelLifetime(context.Background(), cfg, classes, tc.tokenID, tc.expiresAt, now)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !lt.deadline.Equal(tc.wantAt) || lt.reason != tc.wantReason {
+			t.Fatalf("%s: got %v/%q, want %v/%q", tc.name, lt.deadline, lt.reason, tc.wantAt, tc.wantReason)
+		}
+	}
+
+	if _, err := resolveTunnelLifetime(context.Background(), cfg, classes, 1, now.Unix(), now); err == nil {
+		t.Fatalf("expected error for expires_at in the past")
+	}
+	if _, err := resolveTunnelLifetime(context.Background(), cfg, classes, 99, 0, now); err == nil {
+		t.Fatalf("expected error when the class lookup fails")
+	}
+	if lt, err := resolveTunnelLifetime(context.Background(), Config{}, nil, 0, 0, now); err != nil || !lt.deadline.IsZero() || lt.activity != nil {
+		t.Fatalf("no limits: got %+v, %v", lt, err)
+	}
+}
+
+func TestTunnelLifetime_MaxLifetimeNotice(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(NewHandler(Config{
+		TunnelDomain:      "tunnel.example.com",
+		MaxTunnelLifetime: 200 * time.Millisecond,
+	}, Dependencies{}))
+	t.Cleanup(srv.Close)
+
+	session, dec, resp := createNoticeHTTPTunnel(t, srv.URL, control.CreateHTTPTunnelRequest{Type: "http", Notices: true})
+	if resp.ExpiresAt == 0 {
+		t.Fatalf("expires_at = 0, want the max lifetime deadline")
+	}
+
+	expectClosedNotice(t, dec, control.TunnelClosedMaxLifetime)
+	_ = session.Close()
+}
+
+func TestTunnelLifetime_IdleNotice(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(NewHandler(Config{
+		TunnelDomain:      "tunnel.example.com",
+		TunnelIdleTimeout: 100 * time.Millisecond,
+	}, Dependencies{}))
+	t.Cleanup(srv.Close)
+
+	session, dec, resp := createNoticeHTTPTunnel(t, srv.URL, control.CreateHTTPTunnelRequest{Type: "http", Notices: true})
+	if resp.ExpiresAt != 0 {
+		t.Fatalf("expires_at = %d, want 0", resp.ExpiresAt)
+	}
+
+	expectClosedNotice(t, dec, control.TunnelClosedIdle)
+	_ = session.Close()
+}
+
+// pipeSession hands out one end of a pipe per stream and keeps the other.
+type pipeSession struct{ peers chan net.Conn }
+
+func (s pipeSession) OpenStream() (net.Conn, error) {
+	a, b := net.Pipe()
+	s.peers <- b
+	return a, nil
+}
+
+func (pipeSession) Close() error { return nil }
+
+func TestTunnelActivity_TrafficNotOpenStreams(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	lt := tunnelLifetime{idle: time.Minute, activity: newTunnelActivity(start.Add(-time.Hour))}
+	sess := pipeSession{peers: make(chan net.Conn, 1)}
+
+	conn, err := lt.track(sess).OpenStream()
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer conn.Close()
+	peer := <-sess.peers
+	defer peer.Close()
+
+	// An open stream without traffic is idle.
+	if idle := lt.activity.idleFor(time.Now().Add(time.Hour)); idle < time.Hour {
+		t.Fatalf("idle with an open, quiet stream = %v, want at least 1h", idle)
+	}
+
+	lt.activity.touch(start.Add(-time.Hour))
+	go func() { _, _ = peer.Write([]byte("x")) }()
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if idle := lt.activity.idleFor(time.Now()); idle > time.Minute {
+		t.Fatalf("idle after a read = %v", idle)
+	}
+
+	lt.activity.touch(start.Add(-time.Hour))
+	go func() { _, _ = peer.Read(make([]byte, 1)) }()
+	if _, err := conn.Write([]byte("y")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if idle := lt.activity.idleFor(time.Now()); idle > time.Minute {
+		t.Fatalf("idle after a write = %v", idle)
+	}
+}
+
+func TestTunnelLifetime_WithoutNoticesClosesSession(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(NewHandler(Config{
+		TunnelDomain:      "tunnel.example.com",
+		MaxTunnelLifetime: 100 * time.Millisecond,
+	}, Dependencies{}))
+	t.Cleanup(srv.Close)
+
+	session, _, _ := createNoticeHTTPTunnel(t, srv.URL, control.CreateHTTPTunnelRequest{Type: "http"})
+
+	select {
+	case <-session.CloseChan():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session not closed after the max lifetime")
+	}
+}
+
+func TestTunnelLifetime_PastExpiryIsRejected(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(NewHandler(Config{
+		TunnelDomain: "tunnel.example.com",
+	}, Dependencies{}))
+	t.Cleanup(srv.Close)
+
+	session, _, resp := createNoticeHTTPTunnel(t, srv.URL, control.CreateHTTPTunnelRequest{
+		Type:      "http",
+		ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+	})
+	defer session.Close()
+
+	if resp.Error != "expires_at is in the past" {
+		t.Fatalf("error = %q, want %q", resp.Error, "expires_at is in the past")
+	}
+}
+
+func createNoticeHTTPTunnel(t *testing.T, baseURL string, req control.CreateHTTPTunnelRequest) (*yamux.Session, *json.Decoder, control.CreateHTTPTunnelResponse) {
+	t.Helper()
+
+	ws, session := dialTestControl(t, baseURL)
+	t.Cleanup(func() {
+		_ = session.Close()
+		_ = ws.Close(websocket.StatusNormalClosure, "closed")
+	})
+
+	stream, err := session.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	t.Cleanup(func() { _ = stream.Close() })
+
+	if err := control.WriteJSON(stream, req); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	dec := json.NewDecoder(stream)
+	var resp control.CreateHTTPTunnelResponse
+	if err := dec.Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return session, dec, resp
+}
+
+func expectClosedNotice(t *testing.T, dec *json.Decoder, reason string) {
+	t.Helper()
+
+	done := make(chan error, 1)
+	var notice control.TunnelClosedNotice
+	go func() { done <- dec.Decode(&notice) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("decode notice: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no closed notice")
+	}
+	if notice.Type != "closed" || notice.Reason != reason {
+		t.Fatalf("notice = %+v, want closed/%s", notice, reason)
+	}
+}
+
+func TestAdminAPI_TokenClass(t *testing.T) {
+	t.Parallel()
+
+	classes := staticTokenClasses{}
+	h := NewHandler(Config{
+		BaseDomain:   "eosrift.com",
+		TunnelDomain: "tunnel.eosrift.com",
+		AdminToken:   "admin-secret",
+	}, Dependencies{
+		AdminStore:   newStubAdminStore(),
+		TokenClasses: classes,
+	})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://eosrift.com"+path, strings.NewReader(body))
+		req.Host = "eosrift.com"
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rr := do(http.MethodPut, "/api/admin/tokens/1/class", `{"class":"demo"}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("put status = %d (body=%q)", rr.Code, rr.Body.String())
+	}
+	if classes[1] != "demo" {
+		t.Fatalf("class = %q, want demo", classes[1])
+	}
+	if rr := do(http.MethodPut, "/api/admin/tokens/1/class", `{}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("empty put status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if rr := do(http.MethodDelete, "/api/admin/tokens/1/class", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d (body=%q)", rr.Code, rr.Body.String())
+	}
+	if classes[1] != "" {
+		t.Fatalf("class = %q, want empty", classes[1])
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPTunnel_VisitorRateLimit(t *testing.T) {
+	t.Parallel()
+
+	registry := NewTunnelRegistry()
+	sess := &recordingSession{}
+
+	if err := registry.RegisterHTTPTunnel("abcd1234", sess, httpTunnelOptions{
+		RateLimit: "2/m",
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	h := httpTunnelProxyHandler(Config{TunnelDomain: "tunnel.eosrift.test"}, registry, nil, nil, nil)
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := serve("192.0.2.1:1234"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want %d", i, rr.Code, http.StatusOK)
+		}
+	}
+
+	sess.openCount.Store(0)
+	rr := serve("192.0.2.1:1234")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "30" {
+		t.Fatalf("Retry-After = %q, want %q", got, "30")
+	}
+	if sess.openCount.Load() != 0 {
+		t.Fatalf("open count = %d, want 0", sess.openCount.Load())
+	}
+
+	if rr := serve("192.0.2.2:1234"); rr.Code != http.StatusOK {
+		t.Fatalf("other visitor status = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestAdminAPI_TunnelRateLimit(t *testing.T) {
+	t.Parallel()
+
+	srv := New(Config{
+		BaseDomain:   "eosrift.test",
+		TunnelDomain: "tunnel.eosrift.test",
+		AdminToken:   "admin-secret",
+	}, Dependencies{
+		AdminStore: newStubAdminStore(),
+	})
+	if err := srv.registry.RegisterHTTPTunnel("abcd1234", &recordingSession{}, httpTunnelOptions{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	h := srv.Handler()
+
+	admin := func(method, path string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, "http://eosrift.test"+path, &buf)
+		req.Host = "eosrift.test"
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	visit := func() int {
+		req := httptest.NewRequest(http.MethodGet, "http://abcd1234.tunnel.eosrift.test/", nil)
+		req.Host = "abcd1234.tunnel.eosrift.test"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if rr := admin(http.MethodPut, "/api/admin/tunnels/nope/rate-limit", map[string]string{"limit": "1/m"}); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown tunnel status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+	if rr := admin(http.MethodPut, "/api/admin/tunnels/abcd1234/rate-limit", map[string]string{"limit": "lots"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid limit status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := admin(http.MethodPut, "/api/admin/tunnels/abcd1234/rate-limit", map[string]string{"limit": "1/m"}); rr.Code != http.StatusOK {
+		t.Fatalf("set status = %d, want %d (body=%q)", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if code := visit(); code != http.StatusOK {
+		t.Fatalf("first visit = %d, want %d", code, http.StatusOK)
+	}
+	if code := visit(); code != http.StatusTooManyRequests {
+		t.Fatalf("second visit = %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	rr := admin(http.MethodGet, "/api/admin/tunnels/abcd1234/rate-limit", nil)
+	var got struct {
+		Limit string `json:"limit"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil || got.Limit != "1/m" {
+		t.Fatalf("get = %q (err=%v), want limit 1/m", rr.Body.String(), err)
+	}
+
+	if rr := admin(http.MethodDelete, "/api/admin/tunnels/abcd1234/rate-limit", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("clear status = %d, want %d", rr.Code, http.StatusNoContent)
+	}
+	if code := visit(); code != http.StatusOK {
+		t.Fatalf("visit after clear = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,283 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	exportQueueSize     = 2048
+	exportBatchSize     = 512
+	exportFlushInterval = 2 * time.Second
+)
+
+type spanRecord struct {
+	sc      SpanContext
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   []attribute
+	isError bool
+	errMsg  string
+}
+
+// exporter batches finished spans and POSTs them to the collector. Spans
+// are dropped when the queue is full or an export fails; tracing never
+// slows down or fails the traffic it observes.
+type exporter struct {
+	url     string
+	service string
+	client  *http.Client
+
+	queue chan spanRecord
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newExporter(opts Options) (*exporter, error) {
+	endpoint := strings.TrimSpace(opts.Endpoint)
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q (want http(s)://host:port)", opts.Endpoint)
+	}
+
+	service := strings.TrimSpace(opts.ServiceName)
+	if service == "" {
+		service = "eosrift"
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	e := &exporter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		client:  client,
+		queue:   make(chan spanRecord, exportQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+func (e *exporter) enqueue(rec spanRecord) {
+	select {
+	case <-e.stop:
+	case e.queue <- rec:
+	default:
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(exportFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]spanRecord, 0, exportBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		_ = e.export(context.Background(), batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case rec := <-e.queue:
+			batch = append(batch, rec)
+			if len(batch) >= exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case rec := <-e.queue:
+					batch = append(batch, rec)
+					if len(batch) >= exportBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) shutdown(ctx context.Context) error {
+	e.once.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *exporter) export(ctx context.Context, batch []spanRecord) error {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("otlp export: " + resp.Status)
+	}
+	return nil
+}
+
+// OTLP/JSON request body (opentelemetry-proto ExportTraceServiceRequest).
+// Ids are hex strings and 64-bit integers are decimal strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+const otlpStatusError = 2
+
+func (e *exporter) encode(batch []spanRecord) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, rec := range batch {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(rec.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(rec.sc.SpanID[:]),
+			Name:              rec.name,
+			Kind:              rec.kind,
+			StartTimeUnixNano: strconv.FormatInt(rec.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(rec.end.UnixNano(), 10),
+		}
+		if rec.parent != ([8]byte{}) {
+			s.ParentSpanID = hex.EncodeToString(rec.parent[:])
+		}
+		for _, a := range rec.attrs {
+			s.Attributes = append(s.Attributes, otlpKeyValue{Key: a.key, Value: otlpValueOf(a.value)})
+		}
+		if rec.isError {
+			s.Status = &otlpStatus{Code: otlpStatusError, Message: rec.errMsg}
+		}
+		spans = append(spans, s)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: otlpValueOf(e.service)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "eosrift.com/eosrift/internal/tracing"},
+			Spans: spans,
+		}},
+	}}}
+}
+
+func otlpValueOf(v any) otlpValue {
+	intValue := func(n int64) otlpValue {
+		s := strconv.FormatInt(n, 10)
+		return otlpValue{IntValue: &s}
+	}
+
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		return intValue(int64(v))
+	case int64:
+		return intValue(v)
+	case int32:
+		return intValue(int64(v))
+	case uint16:
+		return intValue(int64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			s := strconv.FormatFloat(v, 'g', -1, 64)
+			return otlpValue{StringValue: &s}
+		}
+		return otlpValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
@@ -0,0 +1,346 @@
+// Package tracing records request spans and exports them to an
+// OpenTelemetry collector over OTLP/HTTP (JSON encoding). Trace context is
+// carried between processes in the W3C traceparent header.
+//
+// A nil *Tracer and a nil *Span are valid and record nothing, so callers
+// don't need to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the W3C trace context header.
+const TraceparentHeader = "traceparent"
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc has non-zero trace and span ids.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceIDString returns the hex trace id, or "" when sc is not valid.
+func (sc SpanContext) TraceIDString() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. Versions other than
+// 00 are accepted as long as they start with the version 00 fields.
+func ParseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+
+	v = strings.TrimSpace(v)
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return sc, false
+	}
+	version := v[:2]
+	if version == "ff" || !isLowerHex(version) || (version == "00" && len(v) != 55) || (len(v) > 55 && v[55] != '-') {
+		return sc, false
+	}
+	if !isLowerHex(v[3:35]) || !isLowerHex(v[36:52]) || !isLowerHex(v[53:55]) {
+		return sc, false
+	}
+	_, _ = hex.Decode(sc.TraceID[:], []byte(v[3:35]))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(v[36:52]))
+	var flags [1]byte
+	_, _ = hex.Decode(flags[:], []byte(v[53:55]))
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract returns the span context carried in h, if any.
+func Extract(h http.Header) (SpanContext, bool) {
+	return ParseTraceparent(h.Get(TraceparentHeader))
+}
+
+// Inject sets the traceparent header for the span in ctx. It leaves h
+// unchanged when ctx carries no span.
+func Inject(ctx context.Context, h http.Header) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		h.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithRemoteParent returns ctx carrying sc, typically extracted from
+// an incoming request, as the parent for spans started from it.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span in
+// ctx, or the zero SpanContext.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// Span kinds, as defined by OTLP.
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Options configures a Tracer.
+type Options struct {
+	// Endpoint is the collector's OTLP/HTTP base URL, e.g.
+	// http://localhost:4318. Spans are POSTed to <Endpoint>/v1/traces.
+	Endpoint string
+
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+
+	// SampleRatio is the fraction of new traces to record, in [0, 1].
+	// Spans with a remote parent follow the parent's sampled flag.
+	SampleRatio float64
+
+	// Client sends export requests; nil means a client with a 10s timeout.
+	Client *http.Client
+}
+
+// Tracer creates spans and exports the sampled ones in the background.
+type Tracer struct {
+	exp   *exporter
+	ratio float64
+}
+
+// New returns a Tracer exporting to opts.Endpoint. Call Shutdown to flush
+// pending spans.
+func New(opts Options) (*Tracer, error) {
+	exp, err := newExporter(opts)
+	if err != nil {
+		return nil, err
+	}
+	ratio := opts.SampleRatio
+	if ratio < 0 {
+		ratio = 0
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	return &Tracer{exp: exp, ratio: ratio}, nil
+}
+
+// Shutdown exports pending spans and stops the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exp.shutdown(ctx)
+}
+
+// Start starts a span named name. Its parent is the span in ctx (local or
+// remote); without one it starts a new trace. The returned context carries
+// the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		s.sc.TraceID = newTraceID()
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	s.sc.SpanID = newSpanID()
+
+	return context.WithValue(ctx, spanContextKey{}, s.sc), s
+}
+
+// sample keeps a trace when the low 8 bytes of its id fall under the
+// ratio, so every process sampling by ratio agrees on the same traces.
+func (t *Tracer) sample(id [16]byte) bool {
+	switch {
+	case t.ratio >= 1:
+		return true
+	case t.ratio <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:])>>11 < uint64(t.ratio*(1<<53))
+}
+
+// Span is an in-progress operation. Its methods are safe for concurrent use
+// and do nothing on a nil *Span.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent [8]byte
+	name   string
+	kind   int
+
+	mu      sync.Mutex
+	start   time.Time
+	attrs   []attribute
+	errMsg  string
+	isError bool
+	ended   bool
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+// SpanContext returns the span's ids.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetStartTime moves the span's start, for operations timed before the span
+// could be created.
+func (s *Span) SetStartTime(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.start = t
+	s.mu.Unlock()
+}
+
+// SetAttr records an attribute. Values should be strings, bools, integers or
+// floats; anything else is recorded as a string.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].value = value
+			s.mu.Unlock()
+			return
+		}
+	}
+	s.attrs = append(s.attrs, attribute{key: key, value: value})
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed with err's message. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.isError = true
+	s.errMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// SetStatusError marks the span as failed with msg.
+func (s *Span) SetStatusError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.isError = true
+	s.errMsg = msg
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export if it is sampled. Only
+// the first call has an effect.
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt is End with an explicit end time.
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	rec := spanRecord{
+		sc:      s.sc,
+		parent:  s.parent,
+		name:    s.name,
+		kind:    s.kind,
+		start:   s.start,
+		end:     end,
+		attrs:   s.attrs,
+		isError: s.isError,
+		errMsg:  s.errMsg,
+	}
+	s.mu.Unlock()
+
+	if rec.sc.Sampled {
+		s.tracer.exp.enqueue(rec)
+	}
+}
+
+func newTraceID() [16]byte {
+	var id [16]byte
+	for id == ([16]byte{}) {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() [8]byte {
+	var id [8]byte
+	for id == ([8]byte{}) {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
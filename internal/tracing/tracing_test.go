@@ -0,0 +1,169 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(valid)
+	if !ok {
+		t.Fatalf("ParseTraceparent(%q) failed", valid)
+	}
+	if !sc.Sampled || sc.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("sc = %+v", sc)
+	}
+	if got := sc.Traceparent(); got != valid {
+		t.Fatalf("Traceparent = %q, want %q", got, valid)
+	}
+
+	if sc, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); !ok || sc.Sampled {
+		t.Fatalf("future version: sc = %+v, ok = %v", sc, ok)
+	}
+
+	for _, v := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		if _, ok := ParseTraceparent(v); ok {
+			t.Errorf("ParseTraceparent(%q) succeeded, want failure", v)
+		}
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	t.Parallel()
+
+	var tr *Tracer
+	ctx, span := tr.Start(context.Background(), "noop", KindInternal)
+	span.SetAttr("k", "v")
+	span.SetError(errors.New("boom"))
+	span.End()
+	if span.SpanContext().IsValid() || SpanContextFromContext(ctx).IsValid() {
+		t.Fatalf("nil tracer produced a span context")
+	}
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestTracer_ExportsSpans(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu   sync.Mutex
+		reqs []otlpRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	tr, err := New(Options{Endpoint: collector.URL + "/", ServiceName: "test", SampleRatio: 0})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, parent := tr.Start(ContextWithRemoteParent(context.Background(), remote), "edge.request", KindServer)
+	parent.SetAttr("http.response.status_code", 502)
+	parent.SetStatusError("bad gateway")
+	_, child := tr.Start(ctx, "tunnel.stream_open", KindInternal)
+	child.SetStartTime(time.Unix(10, 0))
+	child.SetAttr("eosrift.tunnel_id", "abcd")
+	child.EndAt(time.Unix(11, 0))
+	parent.End()
+	parent.End()
+
+	h := http.Header{}
+	Inject(ctx, h)
+	if got, ok := Extract(h); !ok || got.TraceID != remote.TraceID || got.SpanID != parent.SpanContext().SpanID {
+		t.Fatalf("injected traceparent = %q", h.Get(TraceparentHeader))
+	}
+
+	// A new trace with a zero ratio is not sampled, so it is not exported.
+	_, dropped := tr.Start(context.Background(), "unsampled", KindInternal)
+	dropped.End()
+	if dropped.SpanContext().Sampled {
+		t.Fatalf("root span sampled with a zero ratio")
+	}
+
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reqs) != 1 {
+		t.Fatalf("export requests = %d, want 1", len(reqs))
+	}
+	rs := reqs[0].ResourceSpans[0]
+	if v := rs.Resource.Attributes[0].Value.StringValue; v == nil || *v != "test" {
+		t.Fatalf("service.name = %v", rs.Resource.Attributes)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("spans = %d, want 2", len(spans))
+	}
+
+	got := spans[0]
+	if got.Name != "tunnel.stream_open" || got.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || got.ParentSpanID != spans[1].SpanID {
+		t.Fatalf("child span = %+v", got)
+	}
+	if got.StartTimeUnixNano != "10000000000" || got.EndTimeUnixNano != "11000000000" || got.Status != nil {
+		t.Fatalf("child span = %+v", got)
+	}
+	got = spans[1]
+	if got.ParentSpanID != "00f067aa0ba902b7" || got.Kind != KindServer || got.Status == nil || got.Status.Message != "bad gateway" {
+		t.Fatalf("parent span = %+v", got)
+	}
+	if v := got.Attributes[0].Value.IntValue; v == nil || *v != "502" {
+		t.Fatalf("status attribute = %+v", got.Attributes)
+	}
+}
+
+func TestTracer_SampleRatio(t *testing.T) {
+	t.Parallel()
+
+	tr := &Tracer{ratio: 0.5}
+	low, high := [16]byte{}, [16]byte{}
+	high[8] = 0xff
+	if !tr.sample(low) || tr.sample(high) {
+		t.Fatalf("sample(low)=%v sample(high)=%v, want true/false", tr.sample(low), tr.sample(high))
+	}
+}
+
+func TestNew_RejectsBadEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, endpoint := range []string{"", "localhost:4318", "ftp://collector:4318"} {
+		if _, err := New(Options{Endpoint: endpoint}); err == nil {
+			t.Errorf("New(%q) succeeded, want error", endpoint)
+		}
+	}
+}
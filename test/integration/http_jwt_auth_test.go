@@ -0,0 +1,100 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/client"
+	"eosrift.com/eosrift/internal/control"
+)
+
+func TestHTTPTunnel_JWTAuth(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("keygen: %v", err)
+	}
+	b64 := base64.RawURLEncoding
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"it","x":%q}]}`, b64.EncodeToString(pub))
+
+	upstream := http.NewServeMux()
+	upstream.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-User", r.Header.Get("X-User"))
+		_, _ = w.Write([]byte("hello-from-upstream\n"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	srv := &http.Server{Handler: upstream}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tunnel, err := client.StartHTTPTunnelWithOptions(ctx, controlURL(), ln.Addr().String(), client.HTTPTunnelOptions{
+		Authtoken: getenv("EOSRIFT_AUTHTOKEN", ""),
+		JWT: &control.JWTAuth{
+			JWKS:         jwks,
+			Audience:     []string{"integration"},
+			ClaimHeaders: []control.JWTClaimHeader{{Claim: "sub", Header: "X-User"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("start http tunnel: %v", err)
+	}
+	defer tunnel.Close()
+
+	h, _ := json.Marshal(map[string]string{"alg": "EdDSA", "kid": "it"})
+	c, _ := json.Marshal(map[string]any{"sub": "alice", "aud": "integration", "exp": time.Now().Add(time.Hour).Unix()})
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	token := signed + "." + b64.EncodeToString(ed25519.Sign(priv, []byte(signed)))
+
+	clientHTTP := &http.Client{Timeout: 5 * time.Second}
+	do := func(authz string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, httpURL("/hello"), nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Host = fmt.Sprintf("%s.tunnel.eosrift.test", tunnel.ID)
+		req.Header.Set("X-User", "spoofed")
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		resp, err := clientHTTP.Do(req)
+		if err != nil {
+			t.Fatalf("do request: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if resp := do(""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("no token: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	resp := do("Bearer " + token)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d, want %d (body=%q)", resp.StatusCode, http.StatusOK, string(body))
+	}
+	if got := resp.Header.Get("X-Upstream-User"); got != "alice" {
+		t.Fatalf("upstream X-User = %q, want %q", got, "alice")
+	}
+}
@@ -0,0 +1,102 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"eosrift.com/eosrift/internal/client"
+)
+
+func TestHTTPTunnel_ShareLinks(t *testing.T) {
+	t.Parallel()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("shared\n"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	srv := &http.Server{Handler: upstream}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tunnel, err := client.StartHTTPTunnelWithOptions(ctx, controlURL(), ln.Addr().String(), client.HTTPTunnelOptions{
+		Authtoken:  getenv("EOSRIFT_AUTHTOKEN", ""),
+		ShareLinks: true,
+		HostHeader: "preserve",
+	})
+	if err != nil {
+		t.Fatalf("start http tunnel: %v", err)
+	}
+	defer tunnel.Close()
+
+	clientHTTP := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	do := func(pathAndQuery string, cookie *http.Cookie) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, httpURL("/")+pathAndQuery[1:], nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Host = fmt.Sprintf("%s.tunnel.eosrift.test", tunnel.ID)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := clientHTTP.Do(req)
+		if err != nil {
+			t.Fatalf("do request: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	if resp, body := do("/", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("without link: status = %d, want %d (body=%q)", resp.StatusCode, http.StatusForbidden, body)
+	}
+
+	link, expires, err := tunnel.ShareLink(ctx, time.Hour, "")
+	if err != nil {
+		t.Fatalf("share link: %v", err)
+	}
+	if d := time.Until(expires); d <= 0 || d > time.Hour+time.Minute {
+		t.Fatalf("expires in %v, want about 1h", d)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+
+	resp, body := do(u.RequestURI(), nil)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("first visit: status = %d, want %d (body=%q)", resp.StatusCode, http.StatusFound, body)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies = %+v, want one share cookie", cookies)
+	}
+
+	resp, body = do("/assets/app.css", cookies[0])
+	if resp.StatusCode != http.StatusOK || body != "shared\n" {
+		t.Fatalf("with cookie: status = %d body = %q", resp.StatusCode, body)
+	}
+}
@@ -237,3 +237,79 @@ func TestTCPTunnel_RequestRemotePort_Unavailable_Errors(t *testing.T) {
 
 	t.Fatalf("no available remote port in range [20000,20010]")
 }
+
+func TestTCPTunnel_Secret_Visit(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}(c)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tunnel, err := client.StartTCPTunnelWithOptions(ctx, controlURL(), ln.Addr().String(), client.TCPTunnelOptions{
+		Authtoken: getenv("EOSRIFT_AUTHTOKEN", ""),
+		Secret:    "integration-secret",
+	})
+	if err != nil {
+		t.Fatalf("start secret tcp tunnel: %v", err)
+	}
+	defer tunnel.Close()
+
+	if tunnel.Name == "" || tunnel.RemotePort != 0 {
+		t.Fatalf("tunnel = (name %q, port %d), want name without port", tunnel.Name, tunnel.RemotePort)
+	}
+
+	if _, err := client.StartTCPVisitor(ctx, controlURL(), tunnel.Name, "127.0.0.1:0", client.TCPVisitorOptions{
+		Authtoken: getenv("EOSRIFT_AUTHTOKEN", ""),
+		Secret:    "wrong",
+	}); err == nil || !strings.Contains(err.Error(), "tunnel not found") {
+		t.Fatalf("visit with wrong secret err = %v, want tunnel not found", err)
+	}
+
+	visitor, err := client.StartTCPVisitor(ctx, controlURL(), tunnel.Name, "127.0.0.1:0", client.TCPVisitorOptions{
+		Authtoken: getenv("EOSRIFT_AUTHTOKEN", ""),
+		Secret:    "integration-secret",
+	})
+	if err != nil {
+		t.Fatalf("start visitor: %v", err)
+	}
+	defer visitor.Close()
+
+	conn, err := net.DialTimeout("tcp", visitor.LocalAddr(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial visitor: %v", err)
+	}
+	defer conn.Close()
+
+	msg := []byte("hello-secret")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if string(got) != string(msg) {
+		t.Fatalf("echo = %q, want %q", string(got), string(msg))
+	}
+}